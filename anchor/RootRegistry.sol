@@ -0,0 +1,20 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.21;
+
+/// @title RootRegistry
+/// @notice Records the Merkle root of every file set it is given, once, so
+/// that anyone can check which root a set had by the time it was anchored.
+contract RootRegistry {
+    event Anchored(bytes32 indexed setId, bytes32 root, address submitter);
+
+    mapping(bytes32 => bytes32) public rootOf;
+
+    /// @notice Records the root of a set. Reverts if the set already has a
+    /// root, or if the root is empty.
+    function anchor(bytes32 setId, bytes32 root) external {
+        require(root != 0, "empty root");
+        require(rootOf[setId] == 0, "already anchored");
+        rootOf[setId] = root;
+        emit Anchored(setId, root, msg.sender);
+    }
+}
@@ -0,0 +1,184 @@
+package anchor
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+)
+
+// batchSize is how many sets are anchored per round
+const batchSize = 50
+
+// Backend is what the Anchorer needs from an Ethereum node, both
+// ethclient.Client and the simulated backend provide it
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+}
+
+type persistence interface {
+	UnanchoredSets(skip []string, limit int) ([]model.FileSet, error)
+	SaveAnchor(anchor model.Anchor) error
+}
+
+// Anchorer submits the roots of complete sets to the registry contract, so
+// that there is proof a root existed by the time of the block it landed
+// in. Every node runs its own Anchorer, but the registry only accepts the
+// first root of a set, and nodes that lose the race record the transaction
+// of the node that won it. Anyone can call the registry though, so a set
+// whose id was anchored with another root is recorded as conflicting.
+type Anchorer struct {
+	logger   zerolog.Logger
+	repo     persistence
+	backend  Backend
+	registry *Registry
+	auth     *bind.TransactOpts
+	interval time.Duration
+}
+
+func NewAnchorer(
+	logger zerolog.Logger,
+	repo persistence,
+	backend Backend,
+	registry *Registry,
+	auth *bind.TransactOpts,
+	interval time.Duration,
+) *Anchorer {
+	return &Anchorer{
+		logger:   logger,
+		repo:     repo,
+		backend:  backend,
+		registry: registry,
+		auth:     auth,
+		interval: interval,
+	}
+}
+
+// Run anchors sets as they complete, it returns a func() error in order to
+// be easily used with errgroup.Group
+func (a *Anchorer) Run(ctx context.Context) func() error {
+	return func() error {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if _, err := a.AnchorPending(ctx); err != nil && ctx.Err() == nil {
+					a.logger.Error().Err(err).Msg("failed to anchor sets")
+				}
+			}
+		}
+	}
+}
+
+// AnchorPending anchors the complete sets that have not been anchored yet,
+// and returns how many were anchored. A set that fails is left for the next
+// round.
+func (a *Anchorer) AnchorPending(ctx context.Context) (int, error) {
+	// sets that fail are skipped by the next batches, so sets that keep
+	// failing don't stop the others from being anchored
+	var skip []string
+	anchored := 0
+	for {
+		if ctx.Err() != nil {
+			return anchored, ctx.Err()
+		}
+		sets, err := a.repo.UnanchoredSets(skip, batchSize)
+		if err != nil {
+			return anchored, err
+		}
+		for _, set := range sets {
+			if err := a.anchorSet(ctx, set); err != nil {
+				if ctx.Err() != nil {
+					return anchored, ctx.Err()
+				}
+				a.logger.Error().Err(err).Str("set-id", set.SetId).Msg("failed to anchor set")
+				skip = append(skip, set.SetId)
+				continue
+			}
+			anchored++
+		}
+		if len(sets) < batchSize {
+			return anchored, nil
+		}
+	}
+}
+
+func (a *Anchorer) anchorSet(ctx context.Context, set model.FileSet) error {
+	id, err := uuid.Parse(set.SetId)
+	if err != nil {
+		return err
+	}
+	setId := setWord(id)
+
+	anchored, err := a.registry.FindAnchored(ctx, setId)
+	if errors.Is(err, ethereum.NotFound) {
+		anchored, err = a.submit(ctx, setId, set.Root)
+	}
+	if err != nil {
+		return err
+	}
+	conflicting := !bytes.Equal(anchored.Root[:], set.Root)
+	if conflicting {
+		// the anchor is still recorded so the set isn't submitted again,
+		// but marked so that it doesn't pass for an anchor of the set
+		a.logger.Warn().
+			Str("set-id", set.SetId).
+			Str("anchored-root", common.Hash(anchored.Root).Hex()).
+			Msg("registry holds a different root for the set")
+	}
+
+	header, err := a.backend.HeaderByNumber(ctx, new(big.Int).SetUint64(anchored.Block))
+	if err != nil {
+		return errors.Wrap(err, "failed to get anchor block")
+	}
+	a.logger.Info().
+		Str("set-id", set.SetId).
+		Str("transaction", anchored.Transaction.Hex()).
+		Uint64("block", anchored.Block).
+		Msg("set anchored")
+	return a.repo.SaveAnchor(
+		model.Anchor{
+			SetId:       set.SetId,
+			Root:        anchored.Root[:],
+			Contract:    a.registry.Address().Hex(),
+			Transaction: anchored.Transaction.Hex(),
+			Block:       anchored.Block,
+			AnchoredAt:  time.Unix(int64(header.Time), 0).UTC(),
+			Conflicting: conflicting,
+		},
+	)
+}
+
+// submit sends the root to the registry and waits for the transaction to
+// be mined. If it reverts, another node most likely anchored the set
+// first, so the registry is asked again.
+func (a *Anchorer) submit(ctx context.Context, setId [32]byte, root []byte) (Anchored, error) {
+	opts := *a.auth
+	opts.Context = ctx
+	tx, err := a.registry.Anchor(&opts, setId, rootWord(root))
+	if err != nil {
+		return Anchored{}, errors.Wrap(err, "failed to submit root")
+	}
+	receipt, err := bind.WaitMined(ctx, a.backend, tx)
+	if err != nil {
+		return Anchored{}, errors.Wrap(err, "failed to wait for anchor transaction")
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		a.logger.Debug().Str("transaction", tx.Hash().Hex()).Msg("anchor transaction reverted")
+	}
+	return a.registry.FindAnchored(ctx, setId)
+}
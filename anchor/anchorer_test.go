@@ -0,0 +1,289 @@
+package anchor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+type AnchorerTestSuite struct {
+	suite.Suite
+
+	backend  *autoCommit
+	auth     *bind.TransactOpts
+	registry *Registry
+}
+
+func TestAnchorer(t *testing.T) {
+	suite.Run(t, new(AnchorerTestSuite))
+}
+
+// autoCommit mines every transaction as soon as it is sent, so that
+// waiting for transactions doesn't depend on timing
+type autoCommit struct {
+	*backends.SimulatedBackend
+}
+
+func (b *autoCommit) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := b.SimulatedBackend.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	b.Commit()
+	return nil
+}
+
+func (s *AnchorerTestSuite) SetupTest() {
+	key, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	s.backend = &autoCommit{
+		backends.NewSimulatedBackend(
+			core.GenesisAlloc{crypto.PubkeyToAddress(key.PublicKey): {Balance: big.NewInt(1e18)}},
+			30_000_000,
+		),
+	}
+	s.auth, err = bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	s.Require().NoError(err)
+
+	_, tx, registry, err := DeployRegistry(s.auth, s.backend)
+	s.Require().NoError(err)
+	_, err = bind.WaitDeployed(context.Background(), s.backend, tx)
+	s.Require().NoError(err)
+	s.registry = registry
+}
+
+func (s *AnchorerTestSuite) TearDownTest() {
+	s.Require().NoError(s.backend.Close())
+}
+
+// newNode returns the repository and anchorer of a node that shares the
+// chain with every other node of the test
+func (s *AnchorerTestSuite) newNode() (*repository.Files, *Anchorer) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	s.Require().NoError(err)
+	sqlDb, err := db.DB()
+	s.Require().NoError(err)
+	sqlDb.SetMaxOpenConns(1)
+	s.T().Cleanup(func() { _ = sqlDb.Close() })
+
+	blobs, err := repository.NewDiskBlobStore(s.T().TempDir())
+	s.Require().NoError(err)
+	repo := repository.NewFiles(zerolog.New(io.Discard), db, blobs)
+	s.Require().NoError(repo.Migrate())
+	registry, err := NewRegistry(s.registry.Address(), s.backend)
+	s.Require().NoError(err)
+
+	anchorer := NewAnchorer(
+		zerolog.New(io.Discard),
+		repo,
+		s.backend,
+		registry,
+		s.auth,
+		time.Minute,
+	)
+	return repo, anchorer
+}
+
+func (s *AnchorerTestSuite) saveSet(repo *repository.Files, setId string, files [][]byte) {
+	for i, contents := range files {
+		s.Require().NoError(
+			repo.SaveFile(
+				model.File{
+					Metadata: model.FileMetadata{SetId: setId, SetCount: len(files), FileNumber: i},
+					Contents: contents,
+				},
+			),
+		)
+	}
+}
+
+func (s *AnchorerTestSuite) TestAnchorPending() {
+	t := s.T()
+	ctx := context.Background()
+	files := [][]byte{[]byte("file1"), []byte("file2"), []byte("file3")}
+
+	t.Run(
+		"it should anchor the root of a complete set", func(t *testing.T) {
+			repo, anchorer := s.newNode()
+			setId := uuid.New()
+			s.saveSet(repo, setId.String(), files)
+
+			anchored, err := anchorer.AnchorPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, anchored)
+
+			set, err := repo.FileSet(setId.String())
+			require.NoError(t, err)
+			require.NotNil(t, set.Anchor)
+			require.Equal(t, set.Root, set.Anchor.Root)
+			require.Equal(t, s.registry.Address().Hex(), set.Anchor.Contract)
+			require.False(t, set.Anchor.AnchoredAt.IsZero())
+
+			root, err := s.registry.RootOf(&bind.CallOpts{Context: ctx}, setWord(setId))
+			require.NoError(t, err)
+			require.Equal(t, set.Root, root[:])
+
+			receipt, err := s.backend.TransactionReceipt(ctx, common.HexToHash(set.Anchor.Transaction))
+			require.NoError(t, err)
+			require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+			require.Equal(t, set.Anchor.Block, receipt.BlockNumber.Uint64())
+		},
+	)
+
+	t.Run(
+		"it should not anchor incomplete or anchored sets", func(t *testing.T) {
+			repo, anchorer := s.newNode()
+			s.Require().NoError(
+				repo.SaveFile(
+					model.File{
+						Metadata: model.FileMetadata{SetId: uuid.NewString(), SetCount: 2},
+						Contents: []byte("file1"),
+					},
+				),
+			)
+			s.saveSet(repo, uuid.NewString(), files)
+
+			anchored, err := anchorer.AnchorPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, anchored)
+
+			anchored, err = anchorer.AnchorPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 0, anchored)
+		},
+	)
+
+	t.Run(
+		"it should record the anchor of a set anchored by another node", func(t *testing.T) {
+			first, firstAnchorer := s.newNode()
+			second, secondAnchorer := s.newNode()
+			setId := uuid.NewString()
+			s.saveSet(first, setId, files)
+			s.saveSet(second, setId, files)
+
+			_, err := firstAnchorer.AnchorPending(ctx)
+			require.NoError(t, err)
+			head, err := s.backend.HeaderByNumber(ctx, nil)
+			require.NoError(t, err)
+
+			_, err = secondAnchorer.AnchorPending(ctx)
+			require.NoError(t, err)
+			after, err := s.backend.HeaderByNumber(ctx, nil)
+			require.NoError(t, err)
+			require.Equal(t, head.Number, after.Number, "the second node should not send a transaction")
+
+			firstSet, err := first.FileSet(setId)
+			require.NoError(t, err)
+			secondSet, err := second.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, firstSet.Anchor, secondSet.Anchor)
+			require.False(t, firstSet.Anchor.Conflicting)
+		},
+	)
+
+	t.Run(
+		"it should not let sets that keep failing hold up the others", func(t *testing.T) {
+			repo, anchorer := s.newNode()
+			// ids the registry can't take fail every round, and being the
+			// oldest they fill the first batch
+			for i := 0; i <= batchSize; i++ {
+				s.saveSet(repo, fmt.Sprintf("not-a-uuid-%d", i), files[:1])
+			}
+			setId := uuid.NewString()
+			s.saveSet(repo, setId, files)
+
+			anchored, err := anchorer.AnchorPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, anchored)
+
+			set, err := repo.FileSet(setId)
+			require.NoError(t, err)
+			require.NotNil(t, set.Anchor)
+		},
+	)
+
+	t.Run(
+		"it should mark the anchor as conflicting when another root was anchored first", func(t *testing.T) {
+			repo, anchorer := s.newNode()
+			setId := uuid.New()
+			s.saveSet(repo, setId.String(), files)
+
+			tx, err := s.registry.Anchor(s.auth, setWord(setId), rootWord(proof.Hash([]byte("forged"))))
+			require.NoError(t, err)
+			_, err = bind.WaitMined(ctx, s.backend, tx)
+			require.NoError(t, err)
+
+			_, err = anchorer.AnchorPending(ctx)
+			require.NoError(t, err)
+
+			set, err := repo.FileSet(setId.String())
+			require.NoError(t, err)
+			require.NotNil(t, set.Anchor)
+			require.True(t, set.Anchor.Conflicting)
+			require.Equal(t, proof.Hash([]byte("forged")), set.Anchor.Root)
+		},
+	)
+}
+
+func (s *AnchorerTestSuite) TestRegistry() {
+	t := s.T()
+	ctx := context.Background()
+
+	t.Run(
+		"it should only accept the first root of a set", func(t *testing.T) {
+			setId := setWord(uuid.New())
+			first := rootWord(proof.Hash([]byte("first")))
+
+			tx, err := s.registry.Anchor(s.auth, setId, first)
+			require.NoError(t, err)
+			receipt, err := bind.WaitMined(ctx, s.backend, tx)
+			require.NoError(t, err)
+			require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+
+			// skip gas estimation, which would fail on the revert
+			opts := *s.auth
+			opts.GasLimit = 100_000
+			tx, err = s.registry.Anchor(&opts, setId, rootWord(proof.Hash([]byte("second"))))
+			require.NoError(t, err)
+			receipt, err = bind.WaitMined(ctx, s.backend, tx)
+			require.NoError(t, err)
+			require.Equal(t, types.ReceiptStatusFailed, receipt.Status)
+
+			root, err := s.registry.RootOf(&bind.CallOpts{Context: ctx}, setId)
+			require.NoError(t, err)
+			require.Equal(t, first, root)
+
+			anchored, err := s.registry.FindAnchored(ctx, setId)
+			require.NoError(t, err)
+			require.Equal(t, first, anchored.Root)
+			require.Equal(t, s.auth.From, anchored.Submitter)
+		},
+	)
+
+	t.Run(
+		"it should not find a set that was never anchored", func(t *testing.T) {
+			_, err := s.registry.FindAnchored(ctx, setWord(uuid.New()))
+			require.ErrorIs(t, err, ethereum.NotFound)
+		},
+	)
+}
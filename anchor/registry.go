@@ -0,0 +1,103 @@
+package anchor
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// The registry contract is RootRegistry.sol, root_registry.go is its binding
+// and has to be regenerated whenever the contract changes.
+//go:generate solc --evm-version paris --optimize --optimize-runs 200 --abi --bin --overwrite -o build RootRegistry.sol
+//go:generate abigen --abi build/RootRegistry.abi --bin build/RootRegistry.bin --pkg anchor --type RootRegistry --out root_registry.go
+
+// Registry is a binding to a deployed registry contract
+type Registry struct {
+	address  common.Address
+	contract *RootRegistry
+}
+
+// Anchored is a root recorded by the registry, along with the transaction
+// that recorded it
+type Anchored struct {
+	SetId       [32]byte
+	Root        [32]byte
+	Submitter   common.Address
+	Transaction common.Hash
+	Block       uint64
+}
+
+// DeployRegistry deploys a new registry contract. The contract is only
+// usable once the transaction is mined.
+func DeployRegistry(auth *bind.TransactOpts, backend bind.ContractBackend) (common.Address, *types.Transaction, *Registry, error) {
+	address, tx, contract, err := DeployRootRegistry(auth, backend)
+	if err != nil {
+		return common.Address{}, nil, nil, errors.Wrap(err, "failed to deploy registry")
+	}
+	return address, tx, &Registry{address: address, contract: contract}, nil
+}
+
+// NewRegistry binds to a registry contract that is already deployed
+func NewRegistry(address common.Address, backend bind.ContractBackend) (*Registry, error) {
+	contract, err := NewRootRegistry(address, backend)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind registry")
+	}
+	return &Registry{address: address, contract: contract}, nil
+}
+
+func (r *Registry) Address() common.Address {
+	return r.address
+}
+
+// Anchor submits the root of a set, the transaction reverts if the set
+// already has a root
+func (r *Registry) Anchor(auth *bind.TransactOpts, setId, root [32]byte) (*types.Transaction, error) {
+	return r.contract.Anchor(auth, setId, root)
+}
+
+// RootOf returns the root recorded for the set, or zeroes if there is none
+func (r *Registry) RootOf(opts *bind.CallOpts, setId [32]byte) ([32]byte, error) {
+	return r.contract.RootOf(opts, setId)
+}
+
+// FindAnchored looks up the event that recorded the root of the set, so
+// that a set anchored by any node can be traced back to its transaction.
+// It returns ethereum.NotFound if the set has not been anchored.
+func (r *Registry) FindAnchored(ctx context.Context, setId [32]byte) (Anchored, error) {
+	events, err := r.contract.FilterAnchored(&bind.FilterOpts{Context: ctx}, [][32]byte{setId})
+	if err != nil {
+		return Anchored{}, errors.Wrap(err, "failed to filter registry logs")
+	}
+	defer events.Close()
+
+	// a set can only be anchored once, so there is at most one event
+	if !events.Next() {
+		if err := events.Error(); err != nil {
+			return Anchored{}, errors.Wrap(err, "failed to unpack registry log")
+		}
+		return Anchored{}, ethereum.NotFound
+	}
+	return Anchored{
+		SetId:       events.Event.SetId,
+		Root:        events.Event.Root,
+		Submitter:   events.Event.Submitter,
+		Transaction: events.Event.Raw.TxHash,
+		Block:       events.Event.Raw.BlockNumber,
+	}, nil
+}
+
+// setWord converts a set id into the bytes32 the registry keys roots by
+func setWord(setId [16]byte) [32]byte {
+	return common.BytesToHash(setId[:])
+}
+
+// rootWord converts a root into a bytes32, roots are keccak256 hashes so
+// they always fit
+func rootWord(root []byte) [32]byte {
+	return common.BytesToHash(root)
+}
@@ -0,0 +1,401 @@
+// Code generated - DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package anchor
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = errors.New
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+	_ = abi.ConvertType
+)
+
+// RootRegistryMetaData contains all meta data concerning the RootRegistry contract.
+var RootRegistryMetaData = &bind.MetaData{
+	ABI: "[{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"internalType\":\"bytes32\",\"name\":\"setId\",\"type\":\"bytes32\"},{\"indexed\":false,\"internalType\":\"bytes32\",\"name\":\"root\",\"type\":\"bytes32\"},{\"indexed\":false,\"internalType\":\"address\",\"name\":\"submitter\",\"type\":\"address\"}],\"name\":\"Anchored\",\"type\":\"event\"},{\"inputs\":[{\"internalType\":\"bytes32\",\"name\":\"setId\",\"type\":\"bytes32\"},{\"internalType\":\"bytes32\",\"name\":\"root\",\"type\":\"bytes32\"}],\"name\":\"anchor\",\"outputs\":[],\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"bytes32\",\"name\":\"\",\"type\":\"bytes32\"}],\"name\":\"rootOf\",\"outputs\":[{\"internalType\":\"bytes32\",\"name\":\"\",\"type\":\"bytes32\"}],\"stateMutability\":\"view\",\"type\":\"function\"}]",
+	Bin: "0x608060405234801561001057600080fd5b506101d6806100206000396000f3fe608060405234801561001057600080fd5b50600436106100365760003560e01c80636d6f398e1461003b578063a21f3c6a1461006d575b600080fd5b61005b610049366004610165565b60006020819052908152604090205481565b60405190815260200160405180910390f35b61008061007b36600461017e565b610082565b005b60008190036100c55760405162461bcd60e51b815260206004820152600a602482015269195b5c1d1e481c9bdbdd60b21b60448201526064015b60405180910390fd5b600082815260208190526040902054156101145760405162461bcd60e51b815260206004820152601060248201526f185b1c9958591e48185b98da1bdc995960821b60448201526064016100bc565b600082815260208181526040918290208390558151838152339181019190915283917f840d56d6081fa4a201be5c97698b23055ee2e65deab7d5845a6ee3053b68bdf8910160405180910390a25050565b60006020828403121561017757600080fd5b5035919050565b6000806040838503121561019157600080fd5b5050803592602090910135915056fea2646970667358221220fe8230621642bc8cc6ce73447806f189a52b91f3fe4e7c559a18bebfb0d7b7ca64736f6c63430008150033",
+}
+
+// RootRegistryABI is the input ABI used to generate the binding from.
+// Deprecated: Use RootRegistryMetaData.ABI instead.
+var RootRegistryABI = RootRegistryMetaData.ABI
+
+// RootRegistryBin is the compiled bytecode used for deploying new contracts.
+// Deprecated: Use RootRegistryMetaData.Bin instead.
+var RootRegistryBin = RootRegistryMetaData.Bin
+
+// DeployRootRegistry deploys a new Ethereum contract, binding an instance of RootRegistry to it.
+func DeployRootRegistry(auth *bind.TransactOpts, backend bind.ContractBackend) (common.Address, *types.Transaction, *RootRegistry, error) {
+	parsed, err := RootRegistryMetaData.GetAbi()
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	if parsed == nil {
+		return common.Address{}, nil, nil, errors.New("GetABI returned nil")
+	}
+
+	address, tx, contract, err := bind.DeployContract(auth, *parsed, common.FromHex(RootRegistryBin), backend)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &RootRegistry{RootRegistryCaller: RootRegistryCaller{contract: contract}, RootRegistryTransactor: RootRegistryTransactor{contract: contract}, RootRegistryFilterer: RootRegistryFilterer{contract: contract}}, nil
+}
+
+// RootRegistry is an auto generated Go binding around an Ethereum contract.
+type RootRegistry struct {
+	RootRegistryCaller     // Read-only binding to the contract
+	RootRegistryTransactor // Write-only binding to the contract
+	RootRegistryFilterer   // Log filterer for contract events
+}
+
+// RootRegistryCaller is an auto generated read-only Go binding around an Ethereum contract.
+type RootRegistryCaller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// RootRegistryTransactor is an auto generated write-only Go binding around an Ethereum contract.
+type RootRegistryTransactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// RootRegistryFilterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type RootRegistryFilterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// RootRegistrySession is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type RootRegistrySession struct {
+	Contract     *RootRegistry     // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts     // Call options to use throughout this session
+	TransactOpts bind.TransactOpts // Transaction auth options to use throughout this session
+}
+
+// RootRegistryCallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type RootRegistryCallerSession struct {
+	Contract *RootRegistryCaller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts       // Call options to use throughout this session
+}
+
+// RootRegistryTransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type RootRegistryTransactorSession struct {
+	Contract     *RootRegistryTransactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts       // Transaction auth options to use throughout this session
+}
+
+// RootRegistryRaw is an auto generated low-level Go binding around an Ethereum contract.
+type RootRegistryRaw struct {
+	Contract *RootRegistry // Generic contract binding to access the raw methods on
+}
+
+// RootRegistryCallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type RootRegistryCallerRaw struct {
+	Contract *RootRegistryCaller // Generic read-only contract binding to access the raw methods on
+}
+
+// RootRegistryTransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type RootRegistryTransactorRaw struct {
+	Contract *RootRegistryTransactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewRootRegistry creates a new instance of RootRegistry, bound to a specific deployed contract.
+func NewRootRegistry(address common.Address, backend bind.ContractBackend) (*RootRegistry, error) {
+	contract, err := bindRootRegistry(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &RootRegistry{RootRegistryCaller: RootRegistryCaller{contract: contract}, RootRegistryTransactor: RootRegistryTransactor{contract: contract}, RootRegistryFilterer: RootRegistryFilterer{contract: contract}}, nil
+}
+
+// NewRootRegistryCaller creates a new read-only instance of RootRegistry, bound to a specific deployed contract.
+func NewRootRegistryCaller(address common.Address, caller bind.ContractCaller) (*RootRegistryCaller, error) {
+	contract, err := bindRootRegistry(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &RootRegistryCaller{contract: contract}, nil
+}
+
+// NewRootRegistryTransactor creates a new write-only instance of RootRegistry, bound to a specific deployed contract.
+func NewRootRegistryTransactor(address common.Address, transactor bind.ContractTransactor) (*RootRegistryTransactor, error) {
+	contract, err := bindRootRegistry(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &RootRegistryTransactor{contract: contract}, nil
+}
+
+// NewRootRegistryFilterer creates a new log filterer instance of RootRegistry, bound to a specific deployed contract.
+func NewRootRegistryFilterer(address common.Address, filterer bind.ContractFilterer) (*RootRegistryFilterer, error) {
+	contract, err := bindRootRegistry(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &RootRegistryFilterer{contract: contract}, nil
+}
+
+// bindRootRegistry binds a generic wrapper to an already deployed contract.
+func bindRootRegistry(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := RootRegistryMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, *parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_RootRegistry *RootRegistryRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _RootRegistry.Contract.RootRegistryCaller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_RootRegistry *RootRegistryRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _RootRegistry.Contract.RootRegistryTransactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_RootRegistry *RootRegistryRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _RootRegistry.Contract.RootRegistryTransactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_RootRegistry *RootRegistryCallerRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _RootRegistry.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_RootRegistry *RootRegistryTransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _RootRegistry.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_RootRegistry *RootRegistryTransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _RootRegistry.Contract.contract.Transact(opts, method, params...)
+}
+
+// RootOf is a free data retrieval call binding the contract method 0x6d6f398e.
+//
+// Solidity: function rootOf(bytes32 ) view returns(bytes32)
+func (_RootRegistry *RootRegistryCaller) RootOf(opts *bind.CallOpts, arg0 [32]byte) ([32]byte, error) {
+	var out []interface{}
+	err := _RootRegistry.contract.Call(opts, &out, "rootOf", arg0)
+
+	if err != nil {
+		return *new([32]byte), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new([32]byte)).(*[32]byte)
+
+	return out0, err
+
+}
+
+// RootOf is a free data retrieval call binding the contract method 0x6d6f398e.
+//
+// Solidity: function rootOf(bytes32 ) view returns(bytes32)
+func (_RootRegistry *RootRegistrySession) RootOf(arg0 [32]byte) ([32]byte, error) {
+	return _RootRegistry.Contract.RootOf(&_RootRegistry.CallOpts, arg0)
+}
+
+// RootOf is a free data retrieval call binding the contract method 0x6d6f398e.
+//
+// Solidity: function rootOf(bytes32 ) view returns(bytes32)
+func (_RootRegistry *RootRegistryCallerSession) RootOf(arg0 [32]byte) ([32]byte, error) {
+	return _RootRegistry.Contract.RootOf(&_RootRegistry.CallOpts, arg0)
+}
+
+// Anchor is a paid mutator transaction binding the contract method 0xa21f3c6a.
+//
+// Solidity: function anchor(bytes32 setId, bytes32 root) returns()
+func (_RootRegistry *RootRegistryTransactor) Anchor(opts *bind.TransactOpts, setId [32]byte, root [32]byte) (*types.Transaction, error) {
+	return _RootRegistry.contract.Transact(opts, "anchor", setId, root)
+}
+
+// Anchor is a paid mutator transaction binding the contract method 0xa21f3c6a.
+//
+// Solidity: function anchor(bytes32 setId, bytes32 root) returns()
+func (_RootRegistry *RootRegistrySession) Anchor(setId [32]byte, root [32]byte) (*types.Transaction, error) {
+	return _RootRegistry.Contract.Anchor(&_RootRegistry.TransactOpts, setId, root)
+}
+
+// Anchor is a paid mutator transaction binding the contract method 0xa21f3c6a.
+//
+// Solidity: function anchor(bytes32 setId, bytes32 root) returns()
+func (_RootRegistry *RootRegistryTransactorSession) Anchor(setId [32]byte, root [32]byte) (*types.Transaction, error) {
+	return _RootRegistry.Contract.Anchor(&_RootRegistry.TransactOpts, setId, root)
+}
+
+// RootRegistryAnchoredIterator is returned from FilterAnchored and is used to iterate over the raw logs and unpacked data for Anchored events raised by the RootRegistry contract.
+type RootRegistryAnchoredIterator struct {
+	Event *RootRegistryAnchored // Event containing the contract specifics and raw log
+
+	contract *bind.BoundContract // Generic contract to use for unpacking event data
+	event    string              // Event name to use for unpacking event data
+
+	logs chan types.Log        // Log channel receiving the found contract events
+	sub  ethereum.Subscription // Subscription for errors, completion and termination
+	done bool                  // Whether the subscription completed delivering logs
+	fail error                 // Occurred error to stop iteration
+}
+
+// Next advances the iterator to the subsequent event, returning whether there
+// are any more events found. In case of a retrieval or parsing error, false is
+// returned and Error() can be queried for the exact failure.
+func (it *RootRegistryAnchoredIterator) Next() bool {
+	// If the iterator failed, stop iterating
+	if it.fail != nil {
+		return false
+	}
+	// If the iterator completed, deliver directly whatever's available
+	if it.done {
+		select {
+		case log := <-it.logs:
+			it.Event = new(RootRegistryAnchored)
+			if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+				it.fail = err
+				return false
+			}
+			it.Event.Raw = log
+			return true
+
+		default:
+			return false
+		}
+	}
+	// Iterator still in progress, wait for either a data or an error event
+	select {
+	case log := <-it.logs:
+		it.Event = new(RootRegistryAnchored)
+		if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+			it.fail = err
+			return false
+		}
+		it.Event.Raw = log
+		return true
+
+	case err := <-it.sub.Err():
+		it.done = true
+		it.fail = err
+		return it.Next()
+	}
+}
+
+// Error returns any retrieval or parsing error occurred during filtering.
+func (it *RootRegistryAnchoredIterator) Error() error {
+	return it.fail
+}
+
+// Close terminates the iteration process, releasing any pending underlying
+// resources.
+func (it *RootRegistryAnchoredIterator) Close() error {
+	it.sub.Unsubscribe()
+	return nil
+}
+
+// RootRegistryAnchored represents a Anchored event raised by the RootRegistry contract.
+type RootRegistryAnchored struct {
+	SetId     [32]byte
+	Root      [32]byte
+	Submitter common.Address
+	Raw       types.Log // Blockchain specific contextual infos
+}
+
+// FilterAnchored is a free log retrieval operation binding the contract event 0x840d56d6081fa4a201be5c97698b23055ee2e65deab7d5845a6ee3053b68bdf8.
+//
+// Solidity: event Anchored(bytes32 indexed setId, bytes32 root, address submitter)
+func (_RootRegistry *RootRegistryFilterer) FilterAnchored(opts *bind.FilterOpts, setId [][32]byte) (*RootRegistryAnchoredIterator, error) {
+
+	var setIdRule []interface{}
+	for _, setIdItem := range setId {
+		setIdRule = append(setIdRule, setIdItem)
+	}
+
+	logs, sub, err := _RootRegistry.contract.FilterLogs(opts, "Anchored", setIdRule)
+	if err != nil {
+		return nil, err
+	}
+	return &RootRegistryAnchoredIterator{contract: _RootRegistry.contract, event: "Anchored", logs: logs, sub: sub}, nil
+}
+
+// WatchAnchored is a free log subscription operation binding the contract event 0x840d56d6081fa4a201be5c97698b23055ee2e65deab7d5845a6ee3053b68bdf8.
+//
+// Solidity: event Anchored(bytes32 indexed setId, bytes32 root, address submitter)
+func (_RootRegistry *RootRegistryFilterer) WatchAnchored(opts *bind.WatchOpts, sink chan<- *RootRegistryAnchored, setId [][32]byte) (event.Subscription, error) {
+
+	var setIdRule []interface{}
+	for _, setIdItem := range setId {
+		setIdRule = append(setIdRule, setIdItem)
+	}
+
+	logs, sub, err := _RootRegistry.contract.WatchLogs(opts, "Anchored", setIdRule)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case log := <-logs:
+				// New log arrived, parse the event and forward to the user
+				event := new(RootRegistryAnchored)
+				if err := _RootRegistry.contract.UnpackLog(event, "Anchored", log); err != nil {
+					return err
+				}
+				event.Raw = log
+
+				select {
+				case sink <- event:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// ParseAnchored is a log parse operation binding the contract event 0x840d56d6081fa4a201be5c97698b23055ee2e65deab7d5845a6ee3053b68bdf8.
+//
+// Solidity: event Anchored(bytes32 indexed setId, bytes32 root, address submitter)
+func (_RootRegistry *RootRegistryFilterer) ParseAnchored(log types.Log) (*RootRegistryAnchored, error) {
+	event := new(RootRegistryAnchored)
+	if err := _RootRegistry.contract.UnpackLog(event, "Anchored", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
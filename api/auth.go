@@ -0,0 +1,246 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	gostrings "strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ApiKeyHeader can carry an api key, as an alternative to a bearer token
+	ApiKeyHeader = "X-Api-Key"
+
+	// principalKey is where the authenticated principal is kept in the gin
+	// context
+	principalKey = "principal"
+	// anonymous is the principal of requests that change data without
+	// credentials on nodes that require them. They are only let through so
+	// the handlers can check their signature, and are refused unless they
+	// are signed.
+	anonymous = "\x00anonymous"
+
+	jwtAlgorithm = "HS256"
+)
+
+// ErrUnauthenticated is returned when a request that changes data has no
+// valid credentials
+var ErrUnauthenticated = errors.New("authentication required")
+
+// Authenticator checks the credentials of api requests. Callers either send
+// an api key, which maps to a fixed principal, or a JWT signed with HS256,
+// in which case the principal is the token's subject. Both are sent as a
+// bearer token, api keys can also be sent in the X-Api-Key header.
+//
+// Reads stay open to everyone, but every request that changes data has to
+// be authenticated, and the principal is passed on to the handlers. Requests
+// signed with an Ethereum key don't need any credentials, but the middleware
+// can't tell what they are signed over. Requests that change data without
+// credentials are passed on as anonymous instead, and the service refuses
+// them unless it recovered a signer from their signature. If no keys and no secret are configured,
+// authentication is disabled and every request is anonymous.
+type Authenticator struct {
+	// keys maps api keys to their principal
+	keys   map[string]string
+	secret []byte
+}
+
+func NewAuthenticator(keys map[string]string, secret []byte) *Authenticator {
+	return &Authenticator{
+		keys:   keys,
+		secret: secret,
+	}
+}
+
+// Enabled reports whether any credentials are configured
+func (a *Authenticator) Enabled() bool {
+	return len(a.keys) > 0 || len(a.secret) > 0
+}
+
+// Middleware authenticates every request that carries credentials, and
+// marks requests that change data without them as anonymous
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !a.Enabled() {
+			ctx.Next()
+			return
+		}
+
+		token := ctx.GetHeader(ApiKeyHeader)
+		if header := ctx.GetHeader("Authorization"); token == "" && header != "" {
+			var ok bool
+			if token, ok = cutPrefixFold(header, "Bearer "); !ok {
+				abortUnauthenticated(ctx, errors.New("unsupported authorization scheme"))
+				return
+			}
+		}
+
+		if token == "" {
+			if !safeMethod(ctx.Request.Method) {
+				ctx.Set(principalKey, anonymous)
+			}
+			ctx.Next()
+			return
+		}
+
+		principal, err := a.Authenticate(token)
+		if err != nil {
+			abortUnauthenticated(ctx, err)
+			return
+		}
+		ctx.Set(principalKey, principal)
+		ctx.Next()
+	}
+}
+
+// Authenticate returns the principal of an api key or a JWT
+func (a *Authenticator) Authenticate(token string) (string, error) {
+	for key, principal := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			return principal, nil
+		}
+	}
+	if len(a.secret) == 0 || gostrings.Count(token, ".") != 2 {
+		return "", errors.Wrap(ErrUnauthenticated, "invalid api key")
+	}
+	return verifyToken(a.secret, token, time.Now())
+}
+
+// Principal returns the authenticated principal of the request, it is
+// empty when authentication is disabled or the caller read data without
+// credentials, and anonymous when the caller changes data without them
+func Principal(ctx *gin.Context) string {
+	return ctx.GetString(principalKey)
+}
+
+// caller returns who a request acts for: the address that signed it, or
+// else the authenticated principal. Anonymous requests are only let through
+// by the middleware to be signed, so they are refused if they weren't.
+func caller(principal, signer string) (string, error) {
+	if signer != "" {
+		return signer, nil
+	}
+	if principal == anonymous {
+		return "", ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// jwtHeader and jwtClaims only hold the parts of a JWT we look at
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// SignToken issues a JWT for the principal, signed with HS256. It is meant
+// for tests and tooling, in production tokens would come from an identity
+// provider sharing the secret.
+func SignToken(secret []byte, principal string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(jwtHeader{Algorithm: jwtAlgorithm, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(
+		jwtClaims{
+			Subject:   principal,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(secret, signed)), nil
+}
+
+// verifyToken checks the signature and the validity period of a JWT, and
+// returns its subject
+func verifyToken(secret []byte, token string, now time.Time) (string, error) {
+	parts := gostrings.Split(token, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.Wrap(ErrUnauthenticated, "invalid token signature")
+	}
+	if !hmac.Equal(signature, sign(secret, parts[0]+"."+parts[1])) {
+		return "", errors.Wrap(ErrUnauthenticated, "invalid token signature")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	// the signature was checked with HS256, so a token claiming anything
+	// else was not issued by us
+	if header.Algorithm != jwtAlgorithm {
+		return "", errors.Wrapf(ErrUnauthenticated, "unsupported token algorithm %q", header.Algorithm)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims.ExpiresAt != 0 && !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return "", errors.Wrap(ErrUnauthenticated, "token expired")
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0)) {
+		return "", errors.Wrap(ErrUnauthenticated, "token not valid yet")
+	}
+	if claims.Subject == "" {
+		return "", errors.Wrap(ErrUnauthenticated, "token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.Wrap(ErrUnauthenticated, "invalid token encoding")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Wrap(ErrUnauthenticated, "invalid token encoding")
+	}
+	return nil
+}
+
+func sign(secret []byte, data string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func abortUnauthenticated(ctx *gin.Context, err error) {
+	if !errors.Is(err, ErrUnauthenticated) {
+		err = errors.Wrap(ErrUnauthenticated, err.Error())
+	}
+	status, body := errorResponse(err)
+	ctx.Header("WWW-Authenticate", "Bearer")
+	ctx.AbortWithStatusJSON(status, body)
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// cutPrefixFold is strings.CutPrefix, ignoring the case of the prefix
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !gostrings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return gostrings.TrimSpace(s[len(prefix):]), true
+}
@@ -1,12 +1,21 @@
 package api
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/ecdsa"
 	"fmt"
+	"io"
 	"net/url"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof"
 )
 
 // Client is intended to be strictly a client for the api. It is
@@ -15,6 +24,16 @@ import (
 type Client struct {
 	r       *resty.Client
 	baseUrl *url.URL
+
+	// signer signs uploads, deletions and grants when it is set
+	signer *ecdsa.PrivateKey
+	// tree is sent with every upload and declaration when it is set
+	tree proof.Mode
+	// ttl is sent with every upload and declaration when it is set
+	ttl string
+	// signatures keeps the signature of every signed upload in progress,
+	// which is sent along with its chunks
+	signatures sync.Map
 }
 
 func NewClient(baseUrl string) (*Client, error) {
@@ -25,15 +44,87 @@ func NewClient(baseUrl string) (*Client, error) {
 	return &Client{r: resty.New(), baseUrl: u}, nil
 }
 
+// SetToken authenticates every request with an api key or a JWT, which
+// nodes with authentication enabled require for any request that changes
+// data
+func (c *Client) SetToken(token string) {
+	c.r.SetAuthToken(token)
+}
+
+// SetSigner signs every upload, deletion and grant with the Ethereum key,
+// which makes the key's address the owner of the sets it uploads. Signed
+// requests don't need a token, and signed uploads have to declare the root
+// of their set.
+func (c *Client) SetSigner(key *ecdsa.PrivateKey) {
+	c.signer = key
+}
+
+// SetTree declares the kind of tree with every upload and set declaration,
+// so that the sets the client uploads are rooted with it. Sets are
+// positional unless told otherwise.
+func (c *Client) SetTree(tree proof.Mode) {
+	c.tree = tree
+}
+
+// SetTTL gives every set the client uploads or declares a TTL, after which
+// nodes collect the set. Zero leaves it to the retention of each node.
+func (c *Client) SetTTL(ttl time.Duration) {
+	c.ttl = ""
+	if ttl > 0 {
+		c.ttl = ttl.String()
+	}
+}
+
+// sign signs the digest if the client has a signer, the digest is only
+// computed when it is needed
+func (c *Client) sign(req *resty.Request, digest func() []byte) ([]byte, error) {
+	if c.signer == nil {
+		return nil, nil
+	}
+	signature, err := proof.Sign(digest(), c.signer)
+	if err != nil {
+		return nil, err
+	}
+	req.SetHeader(SignatureHeader, proof.Encode(signature))
+	return signature, nil
+}
+
 func (c *Client) PostFile(in *PostFileRequest) (*PostFileResponse, error) {
 	out := new(PostFileResponse)
 	path := fmt.Sprintf("%s/sets/%s/files/%s", c.baseUrl.String(), in.SetId, strconv.Itoa(in.Index))
-	res, err := c.r.R().
+	req := c.r.R()
+	if c.signer != nil {
+		content, err := proof.Decode(in.Content)
+		if err != nil {
+			return nil, err
+		}
+		root, err := proof.Decode(in.Root)
+		if err != nil {
+			return nil, errors.Wrap(err, "signed uploads have to declare the root")
+		}
+		if _, err := c.sign(req, func() []byte {
+			return proof.FileDigest(in.SetId, in.SetCount, in.Index, proof.Hash(content), root)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	tree := in.Tree
+	if tree == "" {
+		tree = string(c.tree)
+	}
+	ttl := in.TTL
+	if ttl == "" {
+		ttl = c.ttl
+	}
+	res, err := req.
 		SetHeader("Content-Type", "application/json").
 		SetBody(
 			&PostFileRequest{
 				Content:  in.Content,
 				SetCount: in.SetCount,
+				Root:     in.Root,
+				Tree:     tree,
+				TTL:      ttl,
 			},
 		).
 		SetResult(out).
@@ -42,7 +133,7 @@ func (c *Client) PostFile(in *PostFileRequest) (*PostFileResponse, error) {
 		return nil, err
 	}
 	if res.IsError() {
-		return nil, errors.Errorf("error posting file: %s", res.String())
+		return nil, errors.Wrap(responseError(res), "error posting file")
 	}
 	return out, nil
 }
@@ -57,7 +148,550 @@ func (c *Client) GetFile(setId string, index int) (*GetFileResponse, error) {
 		return nil, err
 	}
 	if res.IsError() {
-		return nil, errors.Errorf("error getting file: %s", res.String())
+		return nil, errors.Wrap(responseError(res), "error getting file")
+	}
+	return out, nil
+}
+
+func (c *Client) GetSet(setId string) (*GetSetResponse, error) {
+	out := new(GetSetResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/sets/%s", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting set")
+	}
+	return out, nil
+}
+
+// CreateUpload starts a resumable upload of a file of the given length. The
+// hash of the file is only needed to sign the upload, it can be left empty
+// if the client has no signer.
+func (c *Client) CreateUpload(setId string, index, setCount int, length int64, hash, root, keyHash []byte) (*UploadResponse, error) {
+	out := new(UploadResponse)
+	in := &CreateUploadRequest{
+		SetId:    setId,
+		Index:    index,
+		SetCount: setCount,
+		Length:   length,
+		Tree:     string(c.tree),
+		TTL:      c.ttl,
+	}
+	if len(root) > 0 {
+		in.Root = proof.Encode(root)
+	}
+	if len(keyHash) > 0 {
+		in.KeyHash = proof.Encode(keyHash)
+	}
+	req := c.r.R()
+	signature, err := c.sign(req, func() []byte {
+		return proof.FileDigest(setId, setCount, index, hash, root)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if signature != nil {
+		in.Hash = proof.Encode(hash)
+	}
+	res, err := req.
+		SetHeader("Content-Type", "application/json").
+		SetBody(in).
+		SetResult(out).
+		Post(fmt.Sprintf("%s/uploads", c.baseUrl.String()))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error creating upload")
+	}
+	if signature != nil {
+		c.signatures.Store(out.Id, signature)
+	}
+	return out, nil
+}
+
+// UploadOffset returns how many bytes of an upload the node has stored
+func (c *Client) UploadOffset(uploadId string) (int64, error) {
+	res, err := c.uploadRequest(uploadId).Head(fmt.Sprintf("%s/uploads/%s", c.baseUrl.String(), uploadId))
+	if err != nil {
+		return 0, err
+	}
+	if res.IsError() {
+		return 0, errors.Wrap(responseError(res), "error getting upload offset")
+	}
+	return strconv.ParseInt(res.Header().Get(UploadOffsetHeader), 10, 64)
+}
+
+// PatchUpload sends a chunk of an upload starting at offset, and returns the
+// new offset of the upload
+func (c *Client) PatchUpload(uploadId string, offset int64, chunk []byte) (int64, error) {
+	res, err := c.uploadRequest(uploadId).
+		SetHeader("Content-Type", offsetContentType).
+		SetHeader(UploadOffsetHeader, strconv.FormatInt(offset, 10)).
+		SetBody(chunk).
+		Patch(fmt.Sprintf("%s/uploads/%s", c.baseUrl.String(), uploadId))
+	if err != nil {
+		return 0, err
+	}
+	if res.IsError() {
+		return 0, errors.Wrap(responseError(res), "error sending upload chunk")
+	}
+	return strconv.ParseInt(res.Header().Get(UploadOffsetHeader), 10, 64)
+}
+
+// FinalizeUpload saves a complete upload into its set
+func (c *Client) FinalizeUpload(uploadId string) (*PostFileResponse, error) {
+	out := new(PostFileResponse)
+	res, err := c.uploadRequest(uploadId).
+		SetResult(out).
+		Post(fmt.Sprintf("%s/uploads/%s/finalize", c.baseUrl.String(), uploadId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error finalizing upload")
+	}
+	c.signatures.Delete(uploadId)
+	return out, nil
+}
+
+// uploadRequest sends the signature of a signed upload along with each of
+// its requests, so that they get through nodes that require credentials
+func (c *Client) uploadRequest(uploadId string) *resty.Request {
+	req := c.r.R()
+	if signature, ok := c.signatures.Load(uploadId); ok {
+		req.SetHeader(SignatureHeader, proof.Encode(signature.([]byte)))
+	}
+	return req
+}
+
+// GetManifest returns the ordered leaf hashes and the root of a complete set
+func (c *Client) GetManifest(setId string) (*ManifestResponse, error) {
+	out := new(ManifestResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/sets/%s/manifest", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting set manifest")
+	}
+	return out, nil
+}
+
+// GetAttestation returns the node's attestation of a complete set, it is
+// up to the caller to Verify it
+func (c *Client) GetAttestation(setId string) (*AttestationResponse, error) {
+	out := new(AttestationResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/sets/%s/attestation", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting set attestation")
+	}
+	return out, nil
+}
+
+// GetLogHead returns the latest head the node signed for its transparency
+// log
+func (c *Client) GetLogHead() (*TreeHeadResponse, error) {
+	out := new(TreeHeadResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/log/head", c.baseUrl.String()))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting log head")
+	}
+	return out, nil
+}
+
+// GetLogHeads returns the latest head of every node the node knows of, and
+// the equivocations it detected
+func (c *Client) GetLogHeads() (*LogHeadsResponse, error) {
+	out := new(LogHeadsResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/log/heads", c.baseUrl.String()))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting log heads")
+	}
+	return out, nil
+}
+
+// GetLogInclusion returns the proof that the set is in the node's log
+func (c *Client) GetLogInclusion(setId string) (*LogInclusionResponse, error) {
+	out := new(LogInclusionResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/log/sets/%s", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting log inclusion proof")
+	}
+	return out, nil
+}
+
+// GetLogConsistency returns the proof that the log of size from is a prefix
+// of the log of size to, a to of 0 stands for the latest head
+func (c *Client) GetLogConsistency(from, to uint64) (*LogConsistencyResponse, error) {
+	out := new(LogConsistencyResponse)
+	req := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		SetQueryParam("from", strconv.FormatUint(from, 10))
+	if to != 0 {
+		req.SetQueryParam("to", strconv.FormatUint(to, 10))
+	}
+	res, err := req.Get(fmt.Sprintf("%s/log/consistency", c.baseUrl.String()))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting log consistency proof")
+	}
+	return out, nil
+}
+
+// GetPeers returns the score of every peer the node challenged
+func (c *Client) GetPeers() (*PeersResponse, error) {
+	out := new(PeersResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/peers", c.baseUrl.String()))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting peers")
+	}
+	return out, nil
+}
+
+// GetPeerChallenges returns the latest challenges the node sent to a peer
+func (c *Client) GetPeerChallenges(peerId string) (*ChallengesResponse, error) {
+	out := new(ChallengesResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/peers/%s/challenges", c.baseUrl.String(), peerId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting peer challenges")
+	}
+	return out, nil
+}
+
+// GetCapacity returns the limits of the node and how much it stores
+func (c *Client) GetCapacity() (*CapacityResponse, error) {
+	out := new(CapacityResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/node/capacity", c.baseUrl.String()))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting capacity")
+	}
+	return out, nil
+}
+
+// GetGC returns what garbage collection reclaimed on the node
+func (c *Client) GetGC() (*GCResponse, error) {
+	out := new(GCResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/node/gc", c.baseUrl.String()))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting gc stats")
+	}
+	return out, nil
+}
+
+// GetFileByHash looks up a file by its hash, along with every set and index
+// it is stored at
+func (c *Client) GetFileByHash(hash []byte) (*GetFileByHashResponse, error) {
+	out := new(GetFileByHashResponse)
+	res, err := c.r.R().
+		SetHeader("Content-Type", "application/json").
+		SetResult(out).
+		Get(fmt.Sprintf("%s/files/%s", c.baseUrl.String(), proof.Encode(hash)))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error getting file by hash")
+	}
+	return out, nil
+}
+
+// PostFileRaw uploads the file contents as an application/octet-stream
+// body, which avoids hex encoding the contents in JSON. The root is
+// optional, and lets nodes check the set once it is complete. The key hash
+// is optional too, and allows deleting the set with the matching key.
+func (c *Client) PostFileRaw(setId string, index, setCount int, root, keyHash []byte, content []byte) (*PostFileResponse, error) {
+	out := new(PostFileResponse)
+	req := c.r.R()
+	if len(root) > 0 {
+		req.SetHeader(RootHeader, proof.Encode(root))
+	}
+	if c.tree != "" {
+		req.SetHeader(TreeHeader, string(c.tree))
+	}
+	if c.ttl != "" {
+		req.SetHeader(TTLHeader, c.ttl)
+	}
+	if len(keyHash) > 0 {
+		req.SetHeader(KeyHashHeader, proof.Encode(keyHash))
+	}
+	if _, err := c.sign(req, func() []byte {
+		return proof.FileDigest(setId, setCount, index, proof.Hash(content), root)
+	}); err != nil {
+		return nil, err
+	}
+	res, err := req.
+		SetHeader("Content-Type", "application/octet-stream").
+		SetQueryParam(setCountParam, strconv.Itoa(setCount)).
+		SetBody(content).
+		SetResult(out).
+		Post(fmt.Sprintf("%s/sets/%s/files/%s/raw", c.baseUrl.String(), setId, strconv.Itoa(index)))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error posting file")
+	}
+	return out, nil
+}
+
+// GetFileRaw downloads the raw file contents, along with the proof sent in
+// the response headers
+func (c *Client) GetFileRaw(setId string, index int) ([]byte, *ProofResponse, error) {
+	res, err := c.r.R().
+		SetHeader("Accept", "application/octet-stream").
+		Get(fmt.Sprintf("%s/sets/%s/files/%s/raw", c.baseUrl.String(), setId, strconv.Itoa(index)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.IsError() {
+		return nil, nil, errors.Wrap(responseError(res), "error getting file")
+	}
+	p, err := decodeProofHeaders(res.Header())
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Body(), &p, nil
+}
+
+// PostSet uploads a whole set in a single request as a tar stream, along
+// with the root the node should check the files against, and the optional
+// key hash
+func (c *Client) PostSet(setId string, root, keyHash []byte, files [][]byte) (*PostSetResponse, error) {
+	body := new(bytes.Buffer)
+	tw := tar.NewWriter(body)
+	for i, file := range files {
+		if err := tw.WriteHeader(
+			&tar.Header{
+				Name:     strconv.Itoa(i),
+				Mode:     0o644,
+				Size:     int64(len(file)),
+				Typeflag: tar.TypeReg,
+			},
+		); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	out := new(PostSetResponse)
+	req := c.r.R()
+	if c.tree != "" {
+		req.SetHeader(TreeHeader, string(c.tree))
+	}
+	if c.ttl != "" {
+		req.SetHeader(TTLHeader, c.ttl)
+	}
+	if len(keyHash) > 0 {
+		req.SetHeader(KeyHashHeader, proof.Encode(keyHash))
+	}
+	if _, err := c.sign(req, func() []byte {
+		return proof.SetDigest(setId, len(files), root)
+	}); err != nil {
+		return nil, err
+	}
+	res, err := req.
+		SetHeader("Content-Type", tarContentType).
+		SetHeader(RootHeader, proof.Encode(root)).
+		SetBody(body.Bytes()).
+		SetResult(out).
+		Post(fmt.Sprintf("%s/sets/%s", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error posting set")
+	}
+	return out, nil
+}
+
+// CreateSet declares a set and its root on the node, which announces it to
+// its peers
+func (c *Client) CreateSet(setId string, setCount int, root, keyHash []byte) (*CreateSetResponse, error) {
+	out := new(CreateSetResponse)
+	in := &CreateSetRequest{
+		SetCount: setCount,
+		Root:     proof.Encode(root),
+		Tree:     string(c.tree),
+		TTL:      c.ttl,
+	}
+	if len(keyHash) > 0 {
+		in.KeyHash = proof.Encode(keyHash)
+	}
+	req := c.r.R()
+	if _, err := c.sign(req, func() []byte {
+		return proof.SetDigest(setId, setCount, root)
+	}); err != nil {
+		return nil, err
+	}
+	res, err := req.
+		SetHeader("Content-Type", "application/json").
+		SetBody(in).
+		SetResult(out).
+		Put(fmt.Sprintf("%s/sets/%s", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error creating set")
+	}
+	return out, nil
+}
+
+// DeleteSet asks the node to purge a set from every node. For sets without
+// an owner, the key has to hash to the key hash the set was uploaded with,
+// sets with an owner can be deleted without a key by the owner and its
+// grants.
+func (c *Client) DeleteSet(setId string, key []byte) (*DeleteSetResponse, error) {
+	out := new(DeleteSetResponse)
+	req := c.r.R().SetResult(out)
+	if len(key) > 0 {
+		req.SetHeader(KeyHeader, proof.Encode(key))
+	}
+	if _, err := c.sign(req, func() []byte {
+		return proof.DeletionDigest(setId)
+	}); err != nil {
+		return nil, err
+	}
+	res, err := req.Delete(fmt.Sprintf("%s/sets/%s", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error deleting set")
 	}
 	return out, nil
 }
+
+// GrantSet allows another principal to add files to and delete the set, it
+// has to be called by the owner of the set
+func (c *Client) GrantSet(setId string, principal string) (*GrantSetResponse, error) {
+	out := new(GrantSetResponse)
+	req := c.r.R()
+	if _, err := c.sign(req, func() []byte {
+		return proof.GrantDigest(setId, proof.NormalizeAddress(principal))
+	}); err != nil {
+		return nil, err
+	}
+	res, err := req.
+		SetHeader("Content-Type", "application/json").
+		SetBody(&GrantSetRequest{Principal: principal}).
+		SetResult(out).
+		Post(fmt.Sprintf("%s/sets/%s/grants", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, errors.Wrap(responseError(res), "error granting set")
+	}
+	return out, nil
+}
+
+// GetSetArchive streams a complete set as a tar archive. If compression is
+// set to CompressionZstd, the archive is compressed in transit, but the
+// returned reader always yields the plain tar stream. The caller must close
+// the reader.
+func (c *Client) GetSetArchive(setId string, compression string) (io.ReadCloser, error) {
+	req := c.r.R().SetDoNotParseResponse(true)
+	if compression != "" {
+		req.SetQueryParam("compression", compression)
+	}
+	res, err := req.Get(fmt.Sprintf("%s/sets/%s/archive", c.baseUrl.String(), setId))
+	if err != nil {
+		return nil, err
+	}
+	body := res.RawBody()
+	if res.IsError() {
+		defer body.Close()
+		msg, _ := io.ReadAll(body)
+		return nil, errors.Wrap(decodeError(res.StatusCode(), msg), "error getting set archive")
+	}
+	if compression != CompressionZstd {
+		return body, nil
+	}
+
+	dec, err := zstd.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return &zstdReadCloser{dec: dec, body: body}, nil
+}
+
+// zstdReadCloser closes both the decoder and the underlying response body
+type zstdReadCloser struct {
+	dec  *zstd.Decoder
+	body io.ReadCloser
+}
+
+func (z *zstdReadCloser) Read(p []byte) (int, error) {
+	return z.dec.Read(p)
+}
+
+func (z *zstdReadCloser) Close() error {
+	z.dec.Close()
+	return z.body.Close()
+}
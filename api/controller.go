@@ -1,11 +1,15 @@
 package api
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/loopfz/gadgeto/tonic"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
+	"github.com/scottrmalley/p2p-file-sharing/model"
 	"github.com/scottrmalley/p2p-file-sharing/proof"
 )
 
@@ -24,17 +28,50 @@ func NewController(logger zerolog.Logger, service *Service) *Controller {
 	}
 }
 
-func (c *Controller) PostFile(_ *gin.Context, in *PostFileRequest) (*PostFileResponse, error) {
+func (c *Controller) PostFile(ctx *gin.Context, in *PostFileRequest) (*PostFileResponse, error) {
 	setId, err := uuid.Parse(in.SetId)
 	if err != nil {
-		return nil, err
+		return nil, invalidArgument("setId", err)
 	}
 
 	fileBytes, err := proof.Decode(in.Content)
 	if err != nil {
-		return nil, err
+		return nil, invalidArgument("content", err)
+	}
+	root, err := decodeRoot(in.Root)
+	if err != nil {
+		return nil, invalidArgument("root", err)
+	}
+	tree, err := decodeTree(in.Tree)
+	if err != nil {
+		return nil, invalidArgument("tree", err)
+	}
+	keyHash, err := decodeRoot(in.KeyHash)
+	if err != nil {
+		return nil, invalidArgument("keyHash", err)
+	}
+	signature, err := decodeRoot(in.Signature)
+	if err != nil {
+		return nil, invalidArgument("signature", err)
+	}
+	expiresAt, err := decodeTTL(in.TTL)
+	if err != nil {
+		return nil, invalidArgument("ttl", err)
 	}
-	hash, err := c.service.SaveFile(setId, in.Index, in.SetCount, fileBytes)
+	hash, err := c.service.SaveFile(
+		Principal(ctx),
+		model.FileMetadata{
+			SetId:      setId.String(),
+			SetCount:   in.SetCount,
+			FileNumber: in.Index,
+			Root:       root,
+			Tree:       tree,
+			KeyHash:    keyHash,
+			Signature:  signature,
+			ExpiresAt:  expiresAt,
+		},
+		fileBytes,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +84,7 @@ func (c *Controller) PostFile(_ *gin.Context, in *PostFileRequest) (*PostFileRes
 func (c *Controller) GetFile(_ *gin.Context, in *GetFileRequest) (*GetFileResponse, error) {
 	setId, err := uuid.Parse(in.SetId)
 	if err != nil {
-		return nil, err
+		return nil, invalidArgument("setId", err)
 	}
 	file, hashes, index, err := c.service.File(setId, in.Index)
 	if err != nil {
@@ -62,10 +99,239 @@ func (c *Controller) GetFile(_ *gin.Context, in *GetFileRequest) (*GetFileRespon
 	}, nil
 }
 
+// GetProof returns only the proof for a file, for clients that downloaded
+// the file contents through the raw route
+func (c *Controller) GetProof(_ *gin.Context, in *GetFileRequest) (*ProofResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	hashes, index, err := c.service.Proof(setId, in.Index)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofResponse{
+		Proof: strings(hashes),
+		Index: index,
+	}, nil
+}
+
+// CreateSet declares a set and its root before the files are uploaded
+func (c *Controller) CreateSet(ctx *gin.Context, in *CreateSetRequest) (*CreateSetResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	root, err := proof.Decode(in.Root)
+	if err != nil {
+		return nil, invalidArgument("root", err)
+	}
+	tree, err := decodeTree(in.Tree)
+	if err != nil {
+		return nil, invalidArgument("tree", err)
+	}
+	keyHash, err := decodeRoot(in.KeyHash)
+	if err != nil {
+		return nil, invalidArgument("keyHash", err)
+	}
+	signature, err := decodeRoot(in.Signature)
+	if err != nil {
+		return nil, invalidArgument("signature", err)
+	}
+	expiresAt, err := decodeTTL(in.TTL)
+	if err != nil {
+		return nil, invalidArgument("ttl", err)
+	}
+	declaration := model.SetDeclaration{
+		SetId:     setId.String(),
+		SetCount:  in.SetCount,
+		Root:      root,
+		Tree:      tree,
+		KeyHash:   keyHash,
+		Signature: signature,
+		ExpiresAt: expiresAt,
+	}
+	if err := c.service.CreateSet(Principal(ctx), declaration); err != nil {
+		return nil, err
+	}
+	return &CreateSetResponse{Success: true}, nil
+}
+
+func (c *Controller) GetSet(_ *gin.Context, in *GetSetRequest) (*GetSetResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	set, missing, err := c.service.FileSet(setId)
+	if err != nil {
+		return nil, err
+	}
+	out := &GetSetResponse{
+		SetId:       set.SetId,
+		SetCount:    set.SetCount,
+		Received:    set.Received,
+		Missing:     ranges(missing),
+		Complete:    set.Complete(),
+		Tree:        string(set.Tree),
+		Quarantined: set.Quarantined,
+		Owner:       set.Owner,
+		Grants:      set.Grants,
+		ExpiresAt:   set.ExpiresAt,
+	}
+	if set.Complete() {
+		out.Root = proof.Encode(set.Root)
+	}
+	if len(set.DeclaredRoot) > 0 {
+		out.DeclaredRoot = proof.Encode(set.DeclaredRoot)
+	}
+	if set.Anchor != nil {
+		out.Anchor = &AnchorResponse{
+			Root:        proof.Encode(set.Anchor.Root),
+			Contract:    set.Anchor.Contract,
+			Transaction: set.Anchor.Transaction,
+			Block:       set.Anchor.Block,
+			AnchoredAt:  set.Anchor.AnchoredAt,
+			Conflicting: set.Anchor.Conflicting,
+		}
+	}
+	return out, nil
+}
+
+// DeleteSet purges a set from every node. Sets with an owner can be deleted
+// by the owner and its grants, or with the owner's signature in the
+// X-Signature header. For other sets the key in the X-Set-Key header has to
+// match the key hash the set was uploaded with.
+func (c *Controller) DeleteSet(ctx *gin.Context, in *DeleteSetRequest) (*DeleteSetResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	key, err := decodeRoot(in.Key)
+	if err != nil {
+		return nil, invalidArgument("key", err)
+	}
+	signature, err := decodeRoot(in.Signature)
+	if err != nil {
+		return nil, invalidArgument("signature", err)
+	}
+	if err := c.service.DeleteSet(Principal(ctx), setId, key, signature); err != nil {
+		return nil, err
+	}
+	return &DeleteSetResponse{Success: true}, nil
+}
+
+// GrantSet allows another principal to add files to and delete the set, it
+// can only be called by the owner of the set
+func (c *Controller) GrantSet(ctx *gin.Context, in *GrantSetRequest) (*GrantSetResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	signature, err := decodeRoot(in.Signature)
+	if err != nil {
+		return nil, invalidArgument("signature", err)
+	}
+	if err := c.service.GrantSet(Principal(ctx), setId, in.Principal, signature); err != nil {
+		return nil, err
+	}
+	return &GrantSetResponse{Success: true}, nil
+}
+
+// GetManifest returns the ordered leaf hashes of a complete set, so clients
+// can rebuild the root without downloading any of the files
+func (c *Controller) GetManifest(_ *gin.Context, in *GetSetRequest) (*ManifestResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	set, hashes, err := c.service.Manifest(setId)
+	if err != nil {
+		return nil, err
+	}
+	return manifestResponse(set, hashes), nil
+}
+
+// GetAttestation returns this node's signed attestation of a complete set
+func (c *Controller) GetAttestation(_ *gin.Context, in *GetSetRequest) (*AttestationResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	attestation, err := c.service.Attestation(setId)
+	if err != nil {
+		return nil, err
+	}
+	return &AttestationResponse{
+		SetId:     attestation.SetId,
+		SetCount:  attestation.SetCount,
+		Root:      proof.Encode(attestation.Root),
+		Tree:      string(attestation.Tree),
+		Node:      attestation.Node,
+		PublicKey: proof.Encode(attestation.PublicKey),
+		Signature: proof.Encode(attestation.Signature),
+	}, nil
+}
+
+// GetFileByHash looks up a file by its hash across all sets
+func (c *Controller) GetFileByHash(_ *gin.Context, in *GetFileByHashRequest) (*GetFileByHashResponse, error) {
+	hash, err := proof.Decode(in.Hash)
+	if err != nil {
+		return nil, invalidArgument("hash", err)
+	}
+	file, locations, err := c.service.FileByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &GetFileByHashResponse{
+		Hash: proof.Encode(hash),
+		File: proof.Encode(file),
+		Sets: make([]FileLocationResponse, len(locations)),
+	}
+	for i, location := range locations {
+		out.Sets[i] = FileLocationResponse{
+			SetId: location.SetId,
+			Index: location.Index,
+		}
+		if location.Root != nil {
+			out.Sets[i].Root = proof.Encode(location.Root)
+			out.Sets[i].Proof = &ProofResponse{
+				Proof: strings(location.Proof),
+				Index: uint64(location.Index),
+			}
+		}
+	}
+	return out, nil
+}
+
 // RegisterRoutes registers the routes on the given router group
 func (c *Controller) RegisterRoutes(router *gin.RouterGroup) error {
 	router.POST("/sets/:setId/files/:index", tonic.Handler(c.PostFile, 200))
 	router.GET("/sets/:setId/files/:index", tonic.Handler(c.GetFile, 200))
+	router.GET("/sets/:setId", tonic.Handler(c.GetSet, 200))
+	router.POST("/sets/:setId", c.PostSet)
+	router.PUT("/sets/:setId", tonic.Handler(c.CreateSet, 200))
+	router.DELETE("/sets/:setId", tonic.Handler(c.DeleteSet, 200))
+	router.POST("/sets/:setId/grants", tonic.Handler(c.GrantSet, 200))
+	router.GET("/sets/:setId/manifest", tonic.Handler(c.GetManifest, 200))
+	router.GET("/sets/:setId/attestation", tonic.Handler(c.GetAttestation, 200))
+	router.GET("/sets/:setId/archive", c.GetArchive)
+
+	// raw binary routes, which avoid hex encoding file contents in JSON
+	router.POST("/sets/:setId/files/:index/raw", c.PostFileRaw)
+	router.POST("/sets/:setId/files/:index/form", c.PostFileMultipart)
+	router.GET("/sets/:setId/files/:index/raw", c.GetFileRaw)
+	router.HEAD("/sets/:setId/files/:index/raw", c.GetFileRaw)
+	router.GET("/sets/:setId/files/:index/proof", tonic.Handler(c.GetProof, 200))
+
+	// resumable uploads
+	router.POST("/uploads", tonic.Handler(c.CreateUpload, 201))
+	router.HEAD("/uploads/:uploadId", c.HeadUpload)
+	router.PATCH("/uploads/:uploadId", c.PatchUpload)
+	router.POST("/uploads/:uploadId/finalize", tonic.Handler(c.FinalizeUpload, 200))
+
+	// content addressed lookups across sets
+	router.GET("/files/:hash", tonic.Handler(c.GetFileByHash, 200))
 	return nil
 }
 
@@ -78,3 +344,61 @@ func strings(in [][]byte) []string {
 	}
 	return out
 }
+
+// ranges compacts sorted indices into inclusive ranges, so that a mostly
+// missing set doesn't produce a huge response
+func ranges(indices []int) []IndexRange {
+	out := make([]IndexRange, 0)
+	for _, i := range indices {
+		if n := len(out); n > 0 && out[n-1].To == i-1 {
+			out[n-1].To = i
+			continue
+		}
+		out = append(out, IndexRange{From: i, To: i})
+	}
+	return out
+}
+
+func manifestResponse(set model.FileSet, hashes [][]byte) *ManifestResponse {
+	return &ManifestResponse{
+		SetId:    set.SetId,
+		SetCount: set.SetCount,
+		Root:     proof.Encode(set.Root),
+		Tree:     string(set.Tree),
+		Leaves:   strings(hashes),
+	}
+}
+
+// decodeRoot decodes an optional declared root, or any other optional hash
+func decodeRoot(root string) ([]byte, error) {
+	if root == "" {
+		return nil, nil
+	}
+	return proof.Decode(root)
+}
+
+// decodeTTL turns an optional TTL into the time the set expires, which is
+// what travels with the set so that every node expires it at the same time
+func decodeTTL(ttl string) (*time.Time, error) {
+	if ttl == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return nil, err
+	}
+	if d <= 0 {
+		return nil, errors.New("ttl has to be positive")
+	}
+	expiresAt := time.Now().Add(d).UTC()
+	return &expiresAt, nil
+}
+
+// decodeTree decodes an optional tree mode, leaving it empty if the client
+// didn't choose one
+func decodeTree(tree string) (proof.Mode, error) {
+	if tree == "" {
+		return "", nil
+	}
+	return proof.ParseMode(tree)
+}
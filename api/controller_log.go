@@ -0,0 +1,118 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/transparency"
+)
+
+// LogController serves the node's transparency log, so clients can check
+// that a set is in the log and that the log only ever grew
+type LogController struct {
+	logger zerolog.Logger
+	log    *transparency.Log
+}
+
+func NewLogController(logger zerolog.Logger, log *transparency.Log) *LogController {
+	return &LogController{
+		logger: logger,
+		log:    log,
+	}
+}
+
+// GetHead returns the latest head this node signed
+func (c *LogController) GetHead(_ *gin.Context) (*TreeHeadResponse, error) {
+	head, err := c.log.Head()
+	if err != nil {
+		return nil, err
+	}
+	return treeHeadResponse(head), nil
+}
+
+// GetInclusion returns the proof that a set is in the log at the latest head
+func (c *LogController) GetInclusion(_ *gin.Context, in *GetSetRequest) (*LogInclusionResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	entry, head, hashes, err := c.log.Inclusion(setId.String())
+	if err != nil {
+		return nil, err
+	}
+	return &LogInclusionResponse{
+		SetId:    entry.SetId,
+		SetCount: entry.SetCount,
+		Root:     proof.Encode(entry.Root),
+		Index:    entry.Index,
+		Leaf:     proof.Encode(transparency.LeafHash(entry)),
+		Proof:    strings(hashes),
+		Head:     *treeHeadResponse(head),
+	}, nil
+}
+
+// GetConsistency returns the proof that the log of one size is a prefix of
+// the log of a larger one
+func (c *LogController) GetConsistency(_ *gin.Context, in *LogConsistencyRequest) (*LogConsistencyResponse, error) {
+	consistency, err := c.log.Consistency(in.From, in.To)
+	if err != nil {
+		return nil, err
+	}
+	return &LogConsistencyResponse{
+		From:     consistency.From,
+		To:       consistency.To,
+		FromRoot: proof.Encode(consistency.FromRoot),
+		ToRoot:   proof.Encode(consistency.ToRoot),
+		Proof:    strings(consistency.Proof),
+	}, nil
+}
+
+// GetHeads returns the latest head of every node, and the equivocations
+// this node detected
+func (c *LogController) GetHeads(_ *gin.Context) (*LogHeadsResponse, error) {
+	heads, equivocations, err := c.log.PeerHeads()
+	if err != nil {
+		return nil, err
+	}
+	out := &LogHeadsResponse{
+		Heads:         make([]TreeHeadResponse, len(heads)),
+		Equivocations: make([]EquivocationResponse, len(equivocations)),
+	}
+	for i, head := range heads {
+		out.Heads[i] = *treeHeadResponse(head)
+	}
+	for i, equivocation := range equivocations {
+		out.Equivocations[i] = EquivocationResponse{
+			Node:       equivocation.Node,
+			Reason:     equivocation.Reason,
+			First:      *treeHeadResponse(equivocation.First),
+			Second:     *treeHeadResponse(equivocation.Second),
+			DetectedAt: equivocation.DetectedAt,
+		}
+	}
+	return out, nil
+}
+
+// RegisterRoutes registers the routes on the given router group
+func (c *LogController) RegisterRoutes(router *gin.RouterGroup) error {
+	router.GET("/log/head", tonic.Handler(c.GetHead, 200))
+	router.GET("/log/heads", tonic.Handler(c.GetHeads, 200))
+	router.GET("/log/sets/:setId", tonic.Handler(c.GetInclusion, 200))
+	router.GET("/log/consistency", tonic.Handler(c.GetConsistency, 200))
+	return nil
+}
+
+func treeHeadResponse(head model.TreeHead) *TreeHeadResponse {
+	return &TreeHeadResponse{
+		Node:      head.Node,
+		Size:      head.Size,
+		Root:      proof.Encode(head.Root),
+		Timestamp: head.Timestamp,
+		PublicKey: proof.Encode(head.PublicKey),
+		Signature: proof.Encode(head.Signature),
+	}
+}
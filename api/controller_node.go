@@ -0,0 +1,73 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+// NodeController serves how this node looks after its storage
+type NodeController struct {
+	logger    zerolog.Logger
+	files     *repository.Files
+	collector *repository.Collector
+}
+
+func NewNodeController(logger zerolog.Logger, files *repository.Files, collector *repository.Collector) *NodeController {
+	return &NodeController{
+		logger:    logger,
+		files:     files,
+		collector: collector,
+	}
+}
+
+// GetCapacity returns the limits of the node and how much it stores, so
+// that clients and peers can tell whether a set will fit before sending it
+func (c *NodeController) GetCapacity(_ *gin.Context) (*CapacityResponse, error) {
+	capacity, err := c.files.Capacity()
+	if err != nil {
+		return nil, err
+	}
+	out := &CapacityResponse{
+		Capacity:    capacity.Limits.Capacity,
+		Used:        capacity.Used,
+		MaxFileSize: capacity.Limits.MaxFileSize,
+		MaxSetCount: capacity.Limits.MaxSetCount,
+		MaxSetBytes: capacity.Limits.MaxSetBytes,
+	}
+	if capacity.Limits.Capacity > 0 {
+		out.Free = &capacity.Free
+	}
+	return out, nil
+}
+
+// GetGC returns what garbage collection reclaimed since the node started
+func (c *NodeController) GetGC(_ *gin.Context) (*GCResponse, error) {
+	stats := c.collector.Stats()
+	return &GCResponse{
+		Runs:    stats.Runs,
+		LastRun: stats.LastRun,
+		Last:    gcReportResponse(stats.Last),
+		Total:   gcReportResponse(stats.Total),
+	}, nil
+}
+
+// RegisterRoutes registers the routes on the given router group
+func (c *NodeController) RegisterRoutes(router *gin.RouterGroup) error {
+	router.GET("/node/capacity", tonic.Handler(c.GetCapacity, 200))
+	router.GET("/node/gc", tonic.Handler(c.GetGC, 200))
+	return nil
+}
+
+func gcReportResponse(report repository.GCReport) GCReportResponse {
+	return GCReportResponse{
+		Expired:    report.Expired,
+		Incomplete: report.Incomplete,
+		Uploads:    report.Uploads,
+		Files:      report.Files,
+		Blobs:      report.Blobs,
+		Bytes:      report.Bytes,
+	}
+}
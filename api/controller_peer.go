@@ -0,0 +1,75 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/challenge"
+)
+
+// PeerController serves the results of the storage challenges this node
+// sent to its peers
+type PeerController struct {
+	logger     zerolog.Logger
+	challenger *challenge.Challenger
+}
+
+func NewPeerController(logger zerolog.Logger, challenger *challenge.Challenger) *PeerController {
+	return &PeerController{
+		logger:     logger,
+		challenger: challenger,
+	}
+}
+
+// GetPeers returns the score of every peer this node challenged, lowest
+// first
+func (c *PeerController) GetPeers(_ *gin.Context) (*PeersResponse, error) {
+	scores, err := c.challenger.Scores()
+	if err != nil {
+		return nil, err
+	}
+	out := &PeersResponse{Peers: make([]PeerScoreResponse, len(scores))}
+	for i, score := range scores {
+		out.Peers[i] = PeerScoreResponse{
+			Peer:             score.Peer,
+			Score:            score.Score,
+			Passed:           score.Passed,
+			Failed:           score.Failed,
+			LastChallengedAt: score.LastChallengedAt,
+		}
+	}
+	return out, nil
+}
+
+// GetChallenges returns the latest challenges this node sent to a peer
+func (c *PeerController) GetChallenges(_ *gin.Context, in *GetPeerRequest) (*ChallengesResponse, error) {
+	id, err := peer.Decode(in.PeerId)
+	if err != nil {
+		return nil, invalidArgument("peerId", err)
+	}
+	results, err := c.challenger.History(id.String())
+	if err != nil {
+		return nil, err
+	}
+	out := &ChallengesResponse{Challenges: make([]ChallengeResultResponse, len(results))}
+	for i, result := range results {
+		out.Challenges[i] = ChallengeResultResponse{
+			SetId:        result.SetId,
+			Index:        result.Index,
+			Passed:       result.Passed,
+			Reason:       result.Reason,
+			DurationMs:   result.Duration.Milliseconds(),
+			ChallengedAt: result.ChallengedAt,
+		}
+	}
+	return out, nil
+}
+
+// RegisterRoutes registers the routes on the given router group
+func (c *PeerController) RegisterRoutes(router *gin.RouterGroup) error {
+	router.GET("/peers", tonic.Handler(c.GetPeers, 200))
+	router.GET("/peers/:peerId/challenges", tonic.Handler(c.GetChallenges, 200))
+	return nil
+}
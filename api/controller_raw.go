@@ -0,0 +1,254 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	gostrings "strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+const (
+	// ProofHeader carries the comma separated, hex encoded proof hashes
+	// on raw downloads
+	ProofHeader = "X-Proof"
+	// ProofIndexHeader carries the index of the file in the proof. Sorted
+	// proofs don't depend on the index, so for sorted sets it is only what
+	// the node says and isn't covered by the proof.
+	ProofIndexHeader = "X-Proof-Index"
+
+	// setCountParam is the query parameter / form field carrying the set
+	// count on raw and multipart uploads
+	setCountParam = "setCount"
+	// fileField is the multipart form field carrying the file
+	fileField = "file"
+
+	// framingBytes is the room each file of a multipart form or tar stream
+	// gets on top of its contents, for the headers and fields around it
+	framingBytes = 4 << 10
+	// unboundedSetCount is how many files set bodies get framing room for
+	// on nodes that don't bound the set count
+	unboundedSetCount = 1024
+)
+
+// The raw handlers don't go through tonic, as they need direct access to
+// the request and response bodies. They still report errors with the same
+// Error body as the tonic handlers, so errors look the same on every route.
+
+// PostFileRaw accepts the file as an application/octet-stream body, with
+// the set count passed as a query parameter and the optional declared root,
+// tree, key hash, TTL and signature in the X-Set-Root, X-Set-Tree,
+// X-Set-Key-Hash, X-Set-TTL and X-Signature headers
+func (c *Controller) PostFileRaw(ctx *gin.Context) {
+	setId, index, err := fileParams(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	setCount, err := strconv.Atoi(ctx.Query(setCountParam))
+	if err != nil {
+		c.abort(ctx, invalidArgument(setCountParam, err))
+		return
+	}
+
+	limitBody(ctx, c.service.Limits().MaxFileSize)
+	fileBytes, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		c.abort(ctx, bodyError("body", err, repository.ErrFileTooLarge))
+		return
+	}
+	c.saveFile(ctx, setId, index, setCount, fileBytes)
+}
+
+// PostFileMultipart accepts the file as a multipart/form-data upload, with
+// the set count, optional declared root, tree, key hash and TTL passed as
+// form fields
+func (c *Controller) PostFileMultipart(ctx *gin.Context) {
+	setId, index, err := fileParams(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	limitBody(ctx, bodyLimit(c.service.Limits().MaxFileSize, 1))
+	if _, err := ctx.MultipartForm(); err != nil {
+		c.abort(ctx, bodyError("body", err, repository.ErrFileTooLarge))
+		return
+	}
+	setCount, err := strconv.Atoi(ctx.PostForm(setCountParam))
+	if err != nil {
+		c.abort(ctx, invalidArgument(setCountParam, err))
+		return
+	}
+
+	header, err := ctx.FormFile(fileField)
+	if err != nil {
+		c.abort(ctx, invalidArgument(fileField, err))
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	defer f.Close()
+
+	fileBytes, err := io.ReadAll(f)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	c.saveFile(ctx, setId, index, setCount, fileBytes)
+}
+
+// GetFileRaw streams the file contents back as they are stored, with the
+// proof in the response headers. Since files never change once stored, the
+// file hash doubles as a strong ETag, and http.ServeContent takes care of
+// Range requests and conditional requests (If-None-Match, If-Range).
+func (c *Controller) GetFileRaw(ctx *gin.Context) {
+	setId, index, err := fileParams(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	file, err := c.service.OpenFile(setId, index)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	defer file.Close()
+
+	ctx.Header(ProofHeader, gostrings.Join(strings(file.Proof), ","))
+	ctx.Header(ProofIndexHeader, strconv.FormatUint(file.Index, 10))
+	ctx.Header("Content-Type", "application/octet-stream")
+	ctx.Header("ETag", etag(file.Hash))
+	ctx.Header("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(ctx.Writer, ctx.Request, "", time.Time{}, file)
+}
+
+func (c *Controller) saveFile(ctx *gin.Context, setId uuid.UUID, index, setCount int, fileBytes []byte) {
+	rootHex := ctx.GetHeader(RootHeader)
+	if rootHex == "" {
+		rootHex = ctx.PostForm(rootField)
+	}
+	root, err := decodeRoot(rootHex)
+	if err != nil {
+		c.abort(ctx, invalidArgument(rootField, err))
+		return
+	}
+	tree, err := treeParam(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	keyHash, err := keyHashParam(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	signature, err := signatureParam(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	expiresAt, err := ttlParam(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+
+	hash, err := c.service.SaveFile(
+		Principal(ctx),
+		model.FileMetadata{
+			SetId:      setId.String(),
+			SetCount:   setCount,
+			FileNumber: index,
+			Root:       root,
+			Tree:       tree,
+			KeyHash:    keyHash,
+			Signature:  signature,
+			ExpiresAt:  expiresAt,
+		},
+		fileBytes,
+	)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	ctx.JSON(
+		http.StatusOK, &PostFileResponse{
+			Success: true,
+			Hash:    hash,
+		},
+	)
+}
+
+func (c *Controller) abort(ctx *gin.Context, err error) {
+	status, body := ErrorHook(c.logger)(ctx, err)
+	ctx.AbortWithStatusJSON(status, body)
+}
+
+// limitBody bounds how much of the request body can be read, so that a
+// body over the limits of the node is refused before it is held in memory.
+// Zero means no limit.
+func limitBody(ctx *gin.Context, limit int64) {
+	if limit > 0 {
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+	}
+}
+
+// bodyLimit is how large a form or archive holding size bytes of contents
+// in count files can get, zero if the contents are not bounded
+func bodyLimit(size int64, count int) int64 {
+	if size == 0 {
+		return 0
+	}
+	return size + int64(count)*framingBytes
+}
+
+// bodyError reports a body cut off by limitBody as the given kind, and any
+// other failure to read it as an invalid field
+func bodyError(field string, err error, kind error) error {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return errors.Wrapf(kind, "request body over %d bytes", maxBytes.Limit)
+	}
+	return invalidArgument(field, err)
+}
+
+func fileParams(ctx *gin.Context) (uuid.UUID, int, error) {
+	setId, err := uuid.Parse(ctx.Param("setId"))
+	if err != nil {
+		return uuid.UUID{}, 0, invalidArgument("setId", err)
+	}
+	index, err := strconv.Atoi(ctx.Param("index"))
+	if err != nil {
+		return uuid.UUID{}, 0, invalidArgument("index", err)
+	}
+	return setId, index, nil
+}
+
+// etag builds a strong entity tag from a file hash
+func etag(hash []byte) string {
+	return strconv.Quote(proof.Encode(hash))
+}
+
+// decodeProofHeaders is the client side counterpart of GetFileRaw
+func decodeProofHeaders(header http.Header) (ProofResponse, error) {
+	index, err := strconv.ParseUint(header.Get(ProofIndexHeader), 10, 64)
+	if err != nil {
+		return ProofResponse{}, errors.Wrap(err, "invalid proof index header")
+	}
+	out := ProofResponse{Proof: []string{}, Index: index}
+	if h := header.Get(ProofHeader); h != "" {
+		out.Proof = gostrings.Split(h, ",")
+	}
+	return out, nil
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+const (
+	// RootHeader carries the hex encoded root the client computed for the
+	// set it is uploading
+	RootHeader = "X-Set-Root"
+
+	// rootField is the multipart form field carrying the declared root
+	rootField = "root"
+
+	// TreeHeader carries the kind of tree the root of the set is computed
+	// with, either positional (the default) or sorted
+	TreeHeader = "X-Set-Tree"
+	// treeField is the multipart form field carrying the tree
+	treeField = "tree"
+
+	// TTLHeader carries how long the set should be kept for, as a duration
+	// like 720h, it can be sent with any upload
+	TTLHeader = "X-Set-TTL"
+	// ttlField is the multipart form field carrying the TTL
+	ttlField = "ttl"
+
+	// KeyHashHeader carries the hex encoded hash of the key that is needed
+	// to delete the set, it can be sent with any upload
+	KeyHashHeader = "X-Set-Key-Hash"
+	// keyHashField is the multipart form field carrying the key hash
+	keyHashField = "keyHash"
+
+	// SignatureHeader carries the hex encoded Ethereum signature of an
+	// upload, a deletion or a grant
+	SignatureHeader = "X-Signature"
+	// signatureField is the multipart form field carrying the signature
+	signatureField = "signature"
+	// KeyHeader carries the hex encoded key when deleting a set
+	KeyHeader = "X-Set-Key"
+
+	tarContentType       = "application/x-tar"
+	zstdContentType      = "application/zstd"
+	multipartContentType = "multipart/form-data"
+
+	// CompressionZstd can be requested to compress set archives
+	CompressionZstd = "zstd"
+
+	// ArchiveManifestName is the name of the manifest in set archives, it
+	// always comes first so the files can be checked as they are read
+	ArchiveManifestName = "manifest.json"
+	// ArchiveFilePrefix is the directory files are stored under in set
+	// archives, each named by its index
+	ArchiveFilePrefix = "files/"
+)
+
+// PostSet accepts a whole set in a single request, either as a tar stream
+// or as a multipart form. In both cases the files are taken in the order
+// they appear in the request, and the set's root has to be declared up
+// front, so the node can check it before accepting any of the files.
+func (c *Controller) PostSet(ctx *gin.Context) {
+	setId, err := uuid.Parse(ctx.Param("setId"))
+	if err != nil {
+		c.abort(ctx, invalidArgument("setId", err))
+		return
+	}
+
+	limits := c.service.Limits()
+	setCount := limits.MaxSetCount
+	if setCount == 0 {
+		setCount = unboundedSetCount
+	}
+	limitBody(ctx, bodyLimit(limits.MaxSetBytes, setCount))
+
+	var files [][]byte
+	switch ctx.ContentType() {
+	case tarContentType:
+		files, err = readTar(ctx.Request.Body)
+	case multipartContentType:
+		files, err = readMultipart(ctx)
+	default:
+		err = errors.Errorf("unsupported content type %q", ctx.ContentType())
+	}
+	if err != nil {
+		c.abort(ctx, bodyError("body", err, repository.ErrSetTooLarge))
+		return
+	}
+
+	rootHex := ctx.GetHeader(RootHeader)
+	if rootHex == "" {
+		rootHex = ctx.PostForm(rootField)
+	}
+	root, err := proof.Decode(rootHex)
+	if err != nil {
+		c.abort(ctx, invalidArgument(rootField, err))
+		return
+	}
+	tree, err := treeParam(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	keyHash, err := keyHashParam(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	signature, err := signatureParam(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	expiresAt, err := ttlParam(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+
+	metadata := model.FileMetadata{
+		SetId:     setId.String(),
+		Root:      root,
+		Tree:      tree,
+		KeyHash:   keyHash,
+		Signature: signature,
+		ExpiresAt: expiresAt,
+	}
+	if err := c.service.SaveSet(Principal(ctx), metadata, files); err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	ctx.JSON(
+		http.StatusOK, &PostSetResponse{
+			Success:  true,
+			SetCount: len(files),
+			Root:     proof.Encode(root),
+		},
+	)
+}
+
+// GetArchive streams a complete set as a tar archive, optionally zstd
+// compressed. The archive starts with a manifest of the leaf hashes and the
+// root, followed by every file in order.
+func (c *Controller) GetArchive(ctx *gin.Context) {
+	setId, err := uuid.Parse(ctx.Param("setId"))
+	if err != nil {
+		c.abort(ctx, invalidArgument("setId", err))
+		return
+	}
+	compression := ctx.Query("compression")
+	if compression != "" && compression != CompressionZstd {
+		c.abort(ctx, invalidArgument("compression", errors.Errorf("unsupported compression %q", compression)))
+		return
+	}
+
+	set, hashes, err := c.service.Manifest(setId)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	manifest, err := json.Marshal(manifestResponse(set, hashes))
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+
+	// from here on the response has started, so errors can only be logged
+	var w io.Writer = ctx.Writer
+	if compression == CompressionZstd {
+		ctx.Header("Content-Type", zstdContentType)
+		enc, err := zstd.NewWriter(ctx.Writer)
+		if err != nil {
+			c.abort(ctx, err)
+			return
+		}
+		defer enc.Close()
+		w = enc
+	} else {
+		ctx.Header("Content-Type", tarContentType)
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", set.SetId+".tar"))
+	ctx.Status(http.StatusOK)
+
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	if err := writeTarFile(tw, ArchiveManifestName, manifest); err != nil {
+		c.logger.Error().Err(err).Msg("failed to write archive manifest")
+		return
+	}
+	if err := c.service.WalkSet(
+		setId, func(index int, contents []byte) error {
+			return writeTarFile(tw, ArchiveFilePrefix+strconv.Itoa(index), contents)
+		},
+	); err != nil {
+		c.logger.Error().Err(err).Msg("failed to write archive")
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, contents []byte) error {
+	if err := tw.WriteHeader(
+		&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(contents)),
+			Typeflag: tar.TypeReg,
+		},
+	); err != nil {
+		return err
+	}
+	_, err := tw.Write(contents)
+	return err
+}
+
+// readTar reads the regular files of the tar stream in order
+func readTar(r io.Reader) ([][]byte, error) {
+	var files [][]byte
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read tar stream")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		file, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read tar stream")
+		}
+		files = append(files, file)
+	}
+}
+
+// treeParam reads the optional tree from the X-Set-Tree header, or the tree
+// form field
+func treeParam(ctx *gin.Context) (proof.Mode, error) {
+	tree := ctx.GetHeader(TreeHeader)
+	if tree == "" {
+		tree = ctx.PostForm(treeField)
+	}
+	mode, err := decodeTree(tree)
+	if err != nil {
+		return "", invalidArgument(treeField, err)
+	}
+	return mode, nil
+}
+
+// keyHashParam reads the optional key hash from the X-Set-Key-Hash header,
+// or the keyHash form field
+func keyHashParam(ctx *gin.Context) ([]byte, error) {
+	keyHashHex := ctx.GetHeader(KeyHashHeader)
+	if keyHashHex == "" {
+		keyHashHex = ctx.PostForm(keyHashField)
+	}
+	keyHash, err := decodeRoot(keyHashHex)
+	if err != nil {
+		return nil, invalidArgument(keyHashField, err)
+	}
+	return keyHash, nil
+}
+
+// ttlParam reads the optional TTL from the X-Set-TTL header, or the ttl
+// form field, and returns when the set expires
+func ttlParam(ctx *gin.Context) (*time.Time, error) {
+	ttl := ctx.GetHeader(TTLHeader)
+	if ttl == "" {
+		ttl = ctx.PostForm(ttlField)
+	}
+	expiresAt, err := decodeTTL(ttl)
+	if err != nil {
+		return nil, invalidArgument(ttlField, err)
+	}
+	return expiresAt, nil
+}
+
+// signatureParam reads the optional signature from the X-Signature header,
+// or the signature form field
+func signatureParam(ctx *gin.Context) ([]byte, error) {
+	signatureHex := ctx.GetHeader(SignatureHeader)
+	if signatureHex == "" {
+		signatureHex = ctx.PostForm(signatureField)
+	}
+	signature, err := decodeRoot(signatureHex)
+	if err != nil {
+		return nil, invalidArgument(signatureField, err)
+	}
+	return signature, nil
+}
+
+// readMultipart reads every file part of the form in order
+func readMultipart(ctx *gin.Context) ([][]byte, error) {
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read multipart form")
+	}
+	headers := form.File[fileField]
+	files := make([][]byte, len(headers))
+	for i, header := range headers {
+		f, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		files[i], err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
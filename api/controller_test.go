@@ -0,0 +1,1405 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/challenge"
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+	"github.com/scottrmalley/p2p-file-sharing/transparency"
+)
+
+// ControllerTestSuite runs the api.Client against the controller over
+// http, with the persistence layer mocked out
+type ControllerTestSuite struct {
+	suite.Suite
+	repo   *persistenceMock
+	server *httptest.Server
+	client *Client
+}
+
+func TestControllerTestSuite(t *testing.T) {
+	suite.Run(t, new(ControllerTestSuite))
+}
+
+func (s *ControllerTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	s.repo = newPersistenceMock()
+	controller := NewController(
+		zerolog.New(io.Discard),
+		NewService(zerolog.New(io.Discard), "node", newIdentityMock(), s.repo, s.repo, newUploadsMock()),
+	)
+
+	tonic.SetErrorHook(ErrorHook(zerolog.New(io.Discard)))
+	router := gin.New()
+	s.Require().NoError(controller.RegisterRoutes(router.Group("/api")))
+	s.server = httptest.NewServer(router)
+
+	var err error
+	s.client, err = NewClient(fmt.Sprintf("%s/api", s.server.URL))
+	s.Require().NoError(err)
+}
+
+func (s *ControllerTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *ControllerTestSuite) TestRawFiles() {
+	t := s.T()
+	t.Run(
+		"it should upload and download raw files with a valid proof", func(t *testing.T) {
+			testFiles := [][]byte{
+				[]byte("file1"),
+				[]byte("file2"),
+				[]byte("file3"),
+			}
+			setId := uuid.NewString()
+			for i, file := range testFiles {
+				out, err := s.client.PostFileRaw(setId, i, len(testFiles), nil, nil, file)
+				require.NoError(t, err)
+				require.Equal(t, proof.Encode(proof.Hash(file)), out.Hash)
+			}
+
+			root, err := proof.Root(testFiles)
+			require.NoError(t, err)
+
+			for i := range testFiles {
+				file, p, err := s.client.GetFileRaw(setId, i)
+				require.NoError(t, err)
+				require.Equal(t, testFiles[i], file)
+
+				hashes := make([][]byte, len(p.Proof))
+				for j, h := range p.Proof {
+					hashes[j], err = proof.Decode(h)
+					require.NoError(t, err)
+				}
+				valid, err := proof.Verify(file, hashes, p.Index, root)
+				require.NoError(t, err)
+				require.True(t, valid)
+			}
+		},
+	)
+
+	t.Run(
+		"it should accept multipart uploads", func(t *testing.T) {
+			setId := uuid.NewString()
+
+			body := new(bytes.Buffer)
+			w := multipart.NewWriter(body)
+			require.NoError(t, w.WriteField("setCount", "1"))
+			part, err := w.CreateFormFile("file", "file1.txt")
+			require.NoError(t, err)
+			_, err = part.Write([]byte("file1"))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			res, err := http.Post(
+				fmt.Sprintf("%s/api/sets/%s/files/0/form", s.server.URL, setId),
+				w.FormDataContentType(),
+				body,
+			)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			file, _, err := s.client.GetFileRaw(setId, 0)
+			require.NoError(t, err)
+			require.Equal(t, []byte("file1"), file)
+
+			// the first file should also be available through the json route
+			out, err := s.client.GetFile(setId, 0)
+			require.NoError(t, err)
+			require.Equal(t, proof.Encode([]byte("file1")), out.File)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestPostSet() {
+	t := s.T()
+	t.Run(
+		"it should accept a whole set as a tar stream", func(t *testing.T) {
+			testFiles := [][]byte{
+				[]byte("file1"),
+				[]byte("file2"),
+				[]byte("file3"),
+			}
+			root, err := proof.Root(testFiles)
+			require.NoError(t, err)
+
+			setId := uuid.NewString()
+			out, err := s.client.PostSet(setId, root, nil, testFiles)
+			require.NoError(t, err)
+			require.Equal(t, len(testFiles), out.SetCount)
+
+			set, err := s.client.GetSet(setId)
+			require.NoError(t, err)
+			require.True(t, set.Complete)
+			require.Equal(t, proof.Encode(root), set.Root)
+		},
+	)
+
+	t.Run(
+		"it should accept a whole set as a multipart form", func(t *testing.T) {
+			testFiles := [][]byte{
+				[]byte("file1"),
+				[]byte("file2"),
+			}
+			root, err := proof.Root(testFiles)
+			require.NoError(t, err)
+
+			body := new(bytes.Buffer)
+			w := multipart.NewWriter(body)
+			require.NoError(t, w.WriteField("root", proof.Encode(root)))
+			for i, file := range testFiles {
+				part, err := w.CreateFormFile("file", fmt.Sprintf("file%d.txt", i))
+				require.NoError(t, err)
+				_, err = part.Write(file)
+				require.NoError(t, err)
+			}
+			require.NoError(t, w.Close())
+
+			setId := uuid.NewString()
+			res, err := http.Post(fmt.Sprintf("%s/api/sets/%s", s.server.URL, setId), w.FormDataContentType(), body)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			file, _, err := s.client.GetFileRaw(setId, 1)
+			require.NoError(t, err)
+			require.Equal(t, testFiles[1], file)
+		},
+	)
+
+	t.Run(
+		"it should reject a set that does not match its root", func(t *testing.T) {
+			testFiles := [][]byte{
+				[]byte("file1"),
+				[]byte("file2"),
+			}
+			root, err := proof.Root([][]byte{[]byte("file1"), []byte("other")})
+			require.NoError(t, err)
+
+			setId := uuid.NewString()
+			_, err = s.client.PostSet(setId, root, nil, testFiles)
+			require.Error(t, err)
+
+			_, err = s.client.GetSet(setId)
+			require.Error(t, err)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestDeclaredRoot() {
+	t := s.T()
+	t.Run(
+		"it should quarantine a set that does not match its declared root", func(t *testing.T) {
+			declared, err := proof.Root([][]byte{[]byte("file1"), []byte("file2")})
+			require.NoError(t, err)
+
+			setId := uuid.NewString()
+			_, err = s.client.CreateSet(setId, 2, declared, nil)
+			require.NoError(t, err)
+
+			for i, file := range [][]byte{[]byte("file1"), []byte("other")} {
+				_, err := s.client.PostFileRaw(setId, i, 2, nil, nil, file)
+				require.NoError(t, err)
+			}
+
+			set, err := s.client.GetSet(setId)
+			require.NoError(t, err)
+			require.True(t, set.Complete)
+			require.True(t, set.Quarantined)
+			require.Equal(t, proof.Encode(declared), set.DeclaredRoot)
+
+			_, _, err = s.client.GetFileRaw(setId, 0)
+			require.Error(t, err)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestSortedTree() {
+	t := s.T()
+	testFiles := [][]byte{
+		[]byte("file1"),
+		[]byte("file2"),
+		[]byte("file3"),
+	}
+	root, err := proof.ModeSorted.Root(testFiles)
+	require.NoError(t, err)
+
+	t.Run(
+		"it should serve proofs that verify with sorted pairs", func(t *testing.T) {
+			s.client.SetTree(proof.ModeSorted)
+			defer s.client.SetTree("")
+
+			setId := uuid.NewString()
+			_, err := s.client.PostSet(setId, root, nil, testFiles)
+			require.NoError(t, err)
+
+			set, err := s.client.GetSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, string(proof.ModeSorted), set.Tree)
+			require.Equal(t, proof.Encode(root), set.Root)
+
+			for i, file := range testFiles {
+				contents, p, err := s.client.GetFileRaw(setId, i)
+				require.NoError(t, err)
+				require.Equal(t, file, contents)
+
+				hashes := make([][]byte, len(p.Proof))
+				for j, h := range p.Proof {
+					hashes[j], err = proof.Decode(h)
+					require.NoError(t, err)
+				}
+				valid, err := proof.VerifySortedProof(contents, hashes, root)
+				require.NoError(t, err)
+				require.True(t, valid)
+			}
+
+			manifest, err := s.client.GetManifest(setId)
+			require.NoError(t, err)
+			require.Equal(t, string(proof.ModeSorted), manifest.Tree)
+		},
+	)
+
+	t.Run(
+		"it should default to positional trees", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := s.client.PostSet(setId, root, nil, testFiles)
+			require.ErrorIs(t, err, ErrRootMismatch)
+
+			positional, err := proof.Root(testFiles)
+			require.NoError(t, err)
+			_, err = s.client.PostSet(setId, positional, nil, testFiles)
+			require.NoError(t, err)
+
+			set, err := s.client.GetSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, string(proof.ModePositional), set.Tree)
+		},
+	)
+
+	t.Run(
+		"it should reject an unknown tree", func(t *testing.T) {
+			s.client.SetTree("unsorted")
+			defer s.client.SetTree("")
+
+			_, err := s.client.PostFileRaw(uuid.NewString(), 0, 1, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, ErrInvalidArgument)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestAttestation() {
+	t := s.T()
+	testFiles := [][]byte{
+		[]byte("file1"),
+		[]byte("file2"),
+	}
+	root, err := proof.Root(testFiles)
+	s.Require().NoError(err)
+
+	t.Run(
+		"it should attest a complete set", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := s.client.PostSet(setId, root, nil, testFiles)
+			require.NoError(t, err)
+
+			attestation, err := s.client.GetAttestation(setId)
+			require.NoError(t, err)
+			require.Equal(t, setId, attestation.SetId)
+			require.Equal(t, len(testFiles), attestation.SetCount)
+			require.Equal(t, proof.Encode(root), attestation.Root)
+			require.Equal(t, string(proof.ModePositional), attestation.Tree)
+			require.NoError(t, attestation.Verify())
+
+			// an attestation can't be moved to another tree or root
+			attestation.Tree = string(proof.ModeSorted)
+			require.Error(t, attestation.Verify())
+			attestation.Tree = string(proof.ModePositional)
+			attestation.Root = proof.Encode(proof.Hash(root))
+			require.Error(t, attestation.Verify())
+		},
+	)
+
+	t.Run(
+		"it should not attest an incomplete set", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := s.client.PostFileRaw(setId, 0, 2, nil, nil, testFiles[0])
+			require.NoError(t, err)
+
+			_, err = s.client.GetAttestation(setId)
+			require.ErrorIs(t, err, ErrFileSetIncomplete)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestGetArchive() {
+	t := s.T()
+	testFiles := [][]byte{
+		[]byte("file1"),
+		[]byte("file2"),
+		[]byte("file3"),
+	}
+	root, err := proof.Root(testFiles)
+	s.Require().NoError(err)
+
+	setId := uuid.NewString()
+	_, err = s.client.PostSet(setId, root, nil, testFiles)
+	s.Require().NoError(err)
+
+	for _, compression := range []string{"", CompressionZstd} {
+		t.Run(
+			fmt.Sprintf("it should stream the set with its manifest (compression %q)", compression), func(t *testing.T) {
+				archive, err := s.client.GetSetArchive(setId, compression)
+				require.NoError(t, err)
+				defer archive.Close()
+
+				tr := tar.NewReader(archive)
+				header, err := tr.Next()
+				require.NoError(t, err)
+				require.Equal(t, ArchiveManifestName, header.Name)
+
+				var manifest ManifestResponse
+				require.NoError(t, json.NewDecoder(tr).Decode(&manifest))
+				require.Equal(t, proof.Encode(root), manifest.Root)
+				require.Len(t, manifest.Leaves, len(testFiles))
+
+				for i, file := range testFiles {
+					header, err := tr.Next()
+					require.NoError(t, err)
+					require.Equal(t, fmt.Sprintf("%s%d", ArchiveFilePrefix, i), header.Name)
+					contents, err := io.ReadAll(tr)
+					require.NoError(t, err)
+					require.Equal(t, file, contents)
+					require.Equal(t, proof.Encode(proof.Hash(file)), manifest.Leaves[i])
+				}
+				_, err = tr.Next()
+				require.ErrorIs(t, err, io.EOF)
+			},
+		)
+	}
+
+	t.Run(
+		"it should not stream an incomplete set", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := s.client.PostFileRaw(setId, 0, 2, nil, nil, []byte("file1"))
+			require.NoError(t, err)
+
+			_, err = s.client.GetSetArchive(setId, "")
+			require.Error(t, err)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestGetManifest() {
+	t := s.T()
+	t.Run(
+		"it should return leaf hashes that rebuild the root", func(t *testing.T) {
+			testFiles := [][]byte{
+				[]byte("file1"),
+				[]byte("file2"),
+				[]byte("file3"),
+			}
+			root, err := proof.Root(testFiles)
+			require.NoError(t, err)
+
+			setId := uuid.NewString()
+			_, err = s.client.PostSet(setId, root, nil, testFiles)
+			require.NoError(t, err)
+
+			manifest, err := s.client.GetManifest(setId)
+			require.NoError(t, err)
+			require.Equal(t, len(testFiles), manifest.SetCount)
+			require.Equal(t, proof.Encode(root), manifest.Root)
+
+			leaves := make([][]byte, len(manifest.Leaves))
+			for i, leaf := range manifest.Leaves {
+				leaves[i], err = proof.Decode(leaf)
+				require.NoError(t, err)
+			}
+			tree, err := proof.NewMerkleTreeFromHashes(leaves)
+			require.NoError(t, err)
+			require.Equal(t, root, tree.Root())
+		},
+	)
+
+	t.Run(
+		"it should not return a manifest for an incomplete set", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := s.client.PostFileRaw(setId, 0, 2, nil, nil, []byte("file1"))
+			require.NoError(t, err)
+
+			_, err = s.client.GetManifest(setId)
+			require.Error(t, err)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestGetFileByHash() {
+	t := s.T()
+	t.Run(
+		"it should find a file in every set with a valid proof", func(t *testing.T) {
+			shared := []byte("shared")
+			complete := [][]byte{[]byte("file1"), shared}
+			root, err := proof.Root(complete)
+			require.NoError(t, err)
+
+			completeId := uuid.NewString()
+			_, err = s.client.PostSet(completeId, root, nil, complete)
+			require.NoError(t, err)
+
+			incompleteId := uuid.NewString()
+			_, err = s.client.PostFileRaw(incompleteId, 0, 2, nil, nil, shared)
+			require.NoError(t, err)
+
+			out, err := s.client.GetFileByHash(proof.Hash(shared))
+			require.NoError(t, err)
+			require.Equal(t, proof.Encode(shared), out.File)
+			require.Len(t, out.Sets, 2)
+
+			for _, location := range out.Sets {
+				if location.SetId == incompleteId {
+					require.Nil(t, location.Proof)
+					continue
+				}
+				require.Equal(t, completeId, location.SetId)
+				require.Equal(t, 1, location.Index)
+				require.Equal(t, proof.Encode(root), location.Root)
+
+				hashes := make([][]byte, len(location.Proof.Proof))
+				for j, h := range location.Proof.Proof {
+					hashes[j], err = proof.Decode(h)
+					require.NoError(t, err)
+				}
+				ok, err := proof.Verify(shared, hashes, location.Proof.Index, root)
+				require.NoError(t, err)
+				require.True(t, ok)
+			}
+		},
+	)
+
+	t.Run(
+		"it should return an error for an unknown hash", func(t *testing.T) {
+			_, err := s.client.GetFileByHash(proof.Hash([]byte("unknown")))
+			require.Error(t, err)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestRawFileRanges() {
+	t := s.T()
+	testFiles := [][]byte{
+		[]byte("0123456789"),
+		[]byte("file2"),
+	}
+	root, err := proof.Root(testFiles)
+	s.Require().NoError(err)
+
+	setId := uuid.NewString()
+	_, err = s.client.PostSet(setId, root, nil, testFiles)
+	s.Require().NoError(err)
+
+	url := fmt.Sprintf("%s/api/sets/%s/files/0/raw", s.server.URL, setId)
+	etag := fmt.Sprintf("%q", proof.Encode(proof.Hash(testFiles[0])))
+
+	t.Run(
+		"it should serve partial content for range requests", func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			require.NoError(t, err)
+			req.Header.Set("Range", "bytes=2-5")
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusPartialContent, res.StatusCode)
+			require.Equal(t, "bytes 2-5/10", res.Header.Get("Content-Range"))
+			require.Equal(t, etag, res.Header.Get("ETag"))
+
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, []byte("2345"), body)
+		},
+	)
+
+	t.Run(
+		"it should return not modified for a matching etag", func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			require.NoError(t, err)
+			req.Header.Set("If-None-Match", etag)
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusNotModified, res.StatusCode)
+		},
+	)
+
+	t.Run(
+		"it should ignore the range if the file changed", func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			require.NoError(t, err)
+			req.Header.Set("Range", "bytes=2-5")
+			req.Header.Set("If-Range", `"0xother"`)
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, testFiles[0], body)
+		},
+	)
+
+	t.Run(
+		"it should answer head requests with the file size and proof", func(t *testing.T) {
+			res, err := http.Head(url)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, int64(len(testFiles[0])), res.ContentLength)
+			require.Equal(t, "0", res.Header.Get(ProofIndexHeader))
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestResumableUpload() {
+	t := s.T()
+	t.Run(
+		"it should resume an upload from the stored offset", func(t *testing.T) {
+			file := []byte("0123456789")
+			setId := uuid.NewString()
+
+			upload, err := s.client.CreateUpload(setId, 0, 1, int64(len(file)), nil, nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, int64(0), upload.Offset)
+
+			offset, err := s.client.PatchUpload(upload.Id, 0, file[:4])
+			require.NoError(t, err)
+			require.Equal(t, int64(4), offset)
+
+			// resending from the start is rejected, and the node reports
+			// where to carry on from
+			_, err = s.client.PatchUpload(upload.Id, 0, file)
+			require.Error(t, err)
+			offset, err = s.client.UploadOffset(upload.Id)
+			require.NoError(t, err)
+			require.Equal(t, int64(4), offset)
+
+			// the file is not saved until the upload is finalized
+			_, err = s.client.FinalizeUpload(upload.Id)
+			require.Error(t, err)
+
+			offset, err = s.client.PatchUpload(upload.Id, offset, file[offset:])
+			require.NoError(t, err)
+			require.Equal(t, int64(len(file)), offset)
+
+			out, err := s.client.FinalizeUpload(upload.Id)
+			require.NoError(t, err)
+			require.Equal(t, proof.Encode(proof.Hash(file)), out.Hash)
+
+			stored, _, err := s.client.GetFileRaw(setId, 0)
+			require.NoError(t, err)
+			require.Equal(t, file, stored)
+
+			_, err = s.client.UploadOffset(upload.Id)
+			require.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"it should answer a chunk at the wrong offset with a conflict", func(t *testing.T) {
+			upload, err := s.client.CreateUpload(uuid.NewString(), 0, 1, 10, nil, nil, nil)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(
+				http.MethodPatch,
+				fmt.Sprintf("%s/api/uploads/%s", s.server.URL, upload.Id),
+				bytes.NewReader([]byte("56789")),
+			)
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", offsetContentType)
+			req.Header.Set(UploadOffsetHeader, "5")
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusConflict, res.StatusCode)
+			require.Equal(t, "0", res.Header.Get(UploadOffsetHeader))
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestDeleteSet() {
+	t := s.T()
+	t.Run(
+		"it should delete a set with its key and refuse it afterwards", func(t *testing.T) {
+			key := []byte("key")
+			testFiles := [][]byte{[]byte("file1"), []byte("file2")}
+			root, err := proof.Root(testFiles)
+			require.NoError(t, err)
+
+			setId := uuid.NewString()
+			_, err = s.client.PostSet(setId, root, proof.Hash(key), testFiles)
+			require.NoError(t, err)
+
+			_, err = s.client.DeleteSet(setId, []byte("other"))
+			require.Error(t, err)
+			_, _, err = s.client.GetFileRaw(setId, 0)
+			require.NoError(t, err)
+
+			out, err := s.client.DeleteSet(setId, key)
+			require.NoError(t, err)
+			require.True(t, out.Success)
+
+			_, err = s.client.GetSet(setId)
+			require.Error(t, err)
+			_, err = s.client.PostFileRaw(setId, 0, 2, nil, nil, testFiles[0])
+			require.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"it should not delete a set uploaded without a key", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := s.client.PostFileRaw(setId, 0, 1, nil, nil, []byte("file1"))
+			require.NoError(t, err)
+
+			_, err = s.client.DeleteSet(setId, []byte("key"))
+			require.Error(t, err)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestErrors() {
+	t := s.T()
+	t.Run(
+		"it should answer with a typed error body", func(t *testing.T) {
+			res, err := http.Get(fmt.Sprintf("%s/api/sets/%s", s.server.URL, uuid.NewString()))
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+			var body Error
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			require.Equal(t, "set_not_found", body.Code)
+			require.NotEmpty(t, body.Message)
+		},
+	)
+
+	t.Run(
+		"it should report invalid arguments with the offending field", func(t *testing.T) {
+			res, err := http.Get(fmt.Sprintf("%s/api/sets/not-a-uuid/files/0/raw", s.server.URL))
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+
+			var body Error
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			require.Equal(t, "invalid_argument", body.Code)
+			require.Equal(t, "setId", body.Details["field"])
+		},
+	)
+
+	t.Run(
+		"it should decode errors into errors matching the sentinel errors", func(t *testing.T) {
+			_, err := s.client.GetSet(uuid.NewString())
+			require.ErrorIs(t, err, ErrSetNotFound)
+
+			var apiErr *Error
+			require.ErrorAs(t, err, &apiErr)
+			require.Equal(t, http.StatusNotFound, apiErr.Status)
+
+			setId := uuid.NewString()
+			_, err = s.client.PostFileRaw(setId, 0, 2, nil, nil, []byte("file1"))
+			require.NoError(t, err)
+			_, _, err = s.client.GetFileRaw(setId, 0)
+			require.ErrorIs(t, err, ErrFileSetIncomplete)
+			require.NotErrorIs(t, err, ErrSetNotFound)
+
+			keyed := uuid.NewString()
+			_, err = s.client.PostFileRaw(keyed, 0, 1, nil, proof.Hash([]byte("key")), []byte("file1"))
+			require.NoError(t, err)
+			_, err = s.client.DeleteSet(keyed, []byte("other"))
+			require.ErrorIs(t, err, repository.ErrSetKeyMismatch)
+		},
+	)
+
+	t.Run(
+		"it should answer with unavailable when peers can't be reached", func(t *testing.T) {
+			s.repo.offline = true
+			defer func() { s.repo.offline = false }()
+
+			_, err := s.client.PostFileRaw(uuid.NewString(), 0, 1, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, ErrUnavailable)
+		},
+	)
+
+	t.Run(
+		"it should not leak the message of internal errors", func(t *testing.T) {
+			status, body := errorResponse(errors.New("connection refused"))
+			require.Equal(t, http.StatusInternalServerError, status)
+			require.Equal(t, codeInternal, body.Code)
+			require.NotContains(t, body.Message, "connection refused")
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestAuth() {
+	t := s.T()
+	secret := []byte("secret")
+	auth := NewAuthenticator(map[string]string{"alice-key": "alice", "bob-key": "bob"}, secret)
+
+	controller := NewController(
+		zerolog.New(io.Discard),
+		NewService(zerolog.New(io.Discard), "node", newIdentityMock(), s.repo, s.repo, newUploadsMock()),
+	)
+	router := gin.New()
+	router.Use(auth.Middleware())
+	s.Require().NoError(controller.RegisterRoutes(router.Group("/api")))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	clientFor := func(token string) *Client {
+		client, err := NewClient(fmt.Sprintf("%s/api", server.URL))
+		s.Require().NoError(err)
+		if token != "" {
+			client.SetToken(token)
+		}
+		return client
+	}
+	alice, bob := clientFor("alice-key"), clientFor("bob-key")
+
+	t.Run(
+		"it should require credentials for writes but not for reads", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := clientFor("").PostFileRaw(setId, 0, 1, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, ErrUnauthenticated)
+			_, err = clientFor("wrong-key").PostFileRaw(setId, 0, 1, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, ErrUnauthenticated)
+
+			_, err = alice.PostFileRaw(setId, 0, 1, nil, nil, []byte("file1"))
+			require.NoError(t, err)
+			set, err := clientFor("").GetSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, "alice", set.Owner)
+		},
+	)
+
+	t.Run(
+		"it should only let the owner and its grants change the set", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := alice.CreateSet(setId, 2, nil, nil)
+			require.NoError(t, err)
+
+			_, err = bob.PostFileRaw(setId, 0, 2, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, repository.ErrNotSetOwner)
+			_, err = bob.GrantSet(setId, "bob")
+			require.ErrorIs(t, err, repository.ErrNotSetOwner)
+
+			_, err = alice.GrantSet(setId, "bob")
+			require.NoError(t, err)
+			_, err = bob.PostFileRaw(setId, 0, 2, nil, nil, []byte("file1"))
+			require.NoError(t, err)
+
+			set, err := alice.GetSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, []string{"bob"}, set.Grants)
+
+			_, err = bob.DeleteSet(setId, nil)
+			require.NoError(t, err)
+		},
+	)
+
+	t.Run(
+		"it should keep uploads to the principal that created them", func(t *testing.T) {
+			upload, err := alice.CreateUpload(uuid.NewString(), 0, 1, 5, nil, nil, nil)
+			require.NoError(t, err)
+
+			_, err = bob.PatchUpload(upload.Id, 0, []byte("file1"))
+			require.ErrorIs(t, err, repository.ErrNotSetOwner)
+			_, err = alice.PatchUpload(upload.Id, 0, []byte("file1"))
+			require.NoError(t, err)
+			_, err = alice.FinalizeUpload(upload.Id)
+			require.NoError(t, err)
+		},
+	)
+
+	t.Run(
+		"it should accept signed tokens", func(t *testing.T) {
+			token, err := SignToken(secret, "carol", time.Minute)
+			require.NoError(t, err)
+
+			setId := uuid.NewString()
+			_, err = clientFor(token).PostFileRaw(setId, 0, 1, nil, nil, []byte("file1"))
+			require.NoError(t, err)
+			set, err := clientFor("").GetSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, "carol", set.Owner)
+
+			forged, err := SignToken([]byte("other"), "alice", time.Minute)
+			require.NoError(t, err)
+			_, err = clientFor(forged).PostFileRaw(uuid.NewString(), 0, 1, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, ErrUnauthenticated)
+
+			expired, err := SignToken(secret, "carol", -time.Minute)
+			require.NoError(t, err)
+			_, err = clientFor(expired).PostFileRaw(uuid.NewString(), 0, 1, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, ErrUnauthenticated)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestSignatures() {
+	t := s.T()
+	auth := NewAuthenticator(map[string]string{"alice-key": "alice"}, nil)
+
+	controller := NewController(
+		zerolog.New(io.Discard),
+		NewService(zerolog.New(io.Discard), "node", newIdentityMock(), s.repo, s.repo, newUploadsMock()),
+	)
+	router := gin.New()
+	router.Use(auth.Middleware())
+	s.Require().NoError(controller.RegisterRoutes(router.Group("/api")))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	signerClient := func() (*Client, string) {
+		key, err := crypto.GenerateKey()
+		s.Require().NoError(err)
+		client, err := NewClient(fmt.Sprintf("%s/api", server.URL))
+		s.Require().NoError(err)
+		client.SetSigner(key)
+		return client, proof.Address(&key.PublicKey)
+	}
+	dave, daveAddress := signerClient()
+	erin, erinAddress := signerClient()
+	testFiles := [][]byte{[]byte("file1"), []byte("file2")}
+	root, err := proof.Root(testFiles)
+	s.Require().NoError(err)
+
+	t.Run(
+		"it should make the signer the owner without any credentials", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := dave.PostFileRaw(setId, 0, len(testFiles), root, nil, testFiles[0])
+			require.NoError(t, err)
+
+			set, err := dave.GetSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, daveAddress, set.Owner)
+
+			_, err = erin.PostFileRaw(setId, 1, len(testFiles), root, nil, testFiles[1])
+			require.ErrorIs(t, err, repository.ErrNotSetOwner)
+
+			_, err = dave.GrantSet(setId, erinAddress)
+			require.NoError(t, err)
+			_, err = erin.PostFileRaw(setId, 1, len(testFiles), root, nil, testFiles[1])
+			require.NoError(t, err)
+
+			_, err = dave.DeleteSet(setId, nil)
+			require.NoError(t, err)
+		},
+	)
+
+	t.Run(
+		"it should sign whole sets and resumable uploads", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := dave.PostSet(setId, root, nil, testFiles)
+			require.NoError(t, err)
+			set, err := dave.GetSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, daveAddress, set.Owner)
+
+			file := []byte("file1")
+			fileRoot, err := proof.Root([][]byte{file})
+			require.NoError(t, err)
+			uploadSet := uuid.NewString()
+			upload, err := erin.CreateUpload(uploadSet, 0, 1, int64(len(file)), proof.Hash(file), fileRoot, nil)
+			require.NoError(t, err)
+			_, err = erin.PatchUpload(upload.Id, 0, file)
+			require.NoError(t, err)
+			_, err = erin.FinalizeUpload(upload.Id)
+			require.NoError(t, err)
+			set, err = erin.GetSet(uploadSet)
+			require.NoError(t, err)
+			require.Equal(t, erinAddress, set.Owner)
+		},
+	)
+
+	t.Run(
+		"it should reject signatures that don't match the upload", func(t *testing.T) {
+			_, err := dave.PostFileRaw(uuid.NewString(), 0, 1, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, ErrInvalidArgument)
+
+			setId := uuid.NewString()
+			_, err = dave.PostFileRaw(setId, 0, len(testFiles), root, nil, testFiles[0])
+			require.NoError(t, err)
+
+			post := func(signature []byte) int {
+				req, err := http.NewRequest(
+					http.MethodPost,
+					fmt.Sprintf("%s/api/sets/%s/files/1/raw?setCount=%d", server.URL, setId, len(testFiles)),
+					bytes.NewReader(testFiles[1]),
+				)
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", "application/octet-stream")
+				req.Header.Set(RootHeader, proof.Encode(root))
+				req.Header.Set(SignatureHeader, proof.Encode(signature))
+				res, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				res.Body.Close()
+				return res.StatusCode
+			}
+			require.Equal(t, http.StatusUnauthorized, post([]byte("not a signature")))
+
+			// a signature that doesn't cover these contents recovers to some
+			// other address, which does not own the set
+			key, err := crypto.GenerateKey()
+			require.NoError(t, err)
+			signature, err := proof.Sign(proof.FileDigest(setId, len(testFiles), 1, proof.Hash([]byte("other")), root), key)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusForbidden, post(signature))
+
+			set, err := dave.GetSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, 1, set.Received)
+		},
+	)
+
+	t.Run(
+		"it should not let empty or broken signatures skip authentication", func(t *testing.T) {
+			send := func(path, contentType string, signature string, body []byte) int {
+				req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api%s", server.URL, path), bytes.NewReader(body))
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", contentType)
+				req.Header.Set(RootHeader, proof.Encode(root))
+				req.Header.Set(SignatureHeader, signature)
+				res, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				res.Body.Close()
+				return res.StatusCode
+			}
+			raw := fmt.Sprintf("/sets/%s/files/0/raw?setCount=%d", uuid.NewString(), len(testFiles))
+			body, err := json.Marshal(
+				PostFileRequest{Content: proof.Encode(testFiles[0]), SetCount: len(testFiles), Root: proof.Encode(root)},
+			)
+			require.NoError(t, err)
+			jsonPath := fmt.Sprintf("/sets/%s/files/0", uuid.NewString())
+
+			for _, signature := range []string{"0x", "", proof.Encode([]byte("not a signature"))} {
+				require.Equal(t, http.StatusUnauthorized, send(raw, "application/octet-stream", signature, testFiles[0]))
+				require.Equal(t, http.StatusUnauthorized, send(jsonPath, "application/json", signature, body))
+			}
+		},
+	)
+
+	t.Run(
+		"it should bind signed uploads to the signer", func(t *testing.T) {
+			file := []byte("file1")
+			fileRoot, err := proof.Root([][]byte{file})
+			require.NoError(t, err)
+			send := func(method, path string, signature []byte, body []byte) int {
+				req, err := http.NewRequest(method, fmt.Sprintf("%s/api%s", server.URL, path), bytes.NewReader(body))
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", offsetContentType)
+				req.Header.Set(UploadOffsetHeader, "0")
+				req.Header.Set(SignatureHeader, proof.Encode(signature))
+				res, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				res.Body.Close()
+				return res.StatusCode
+			}
+
+			// the signature is checked when the upload is created
+			anonymous, err := NewClient(fmt.Sprintf("%s/api", server.URL))
+			require.NoError(t, err)
+			_, err = anonymous.CreateUpload(uuid.NewString(), 0, 1, 5, proof.Hash(file), fileRoot, nil)
+			require.ErrorIs(t, err, ErrUnauthenticated)
+			_, err = erin.CreateUpload(uuid.NewString(), 0, 1, int64(len(file)), nil, fileRoot, nil)
+			require.ErrorIs(t, err, ErrInvalidArgument)
+
+			upload, err := erin.CreateUpload(uuid.NewString(), 0, 1, int64(len(file)), proof.Hash(file), fileRoot, nil)
+			require.NoError(t, err)
+			path := fmt.Sprintf("/uploads/%s", upload.Id)
+			require.Equal(t, http.StatusUnauthorized, send(http.MethodPatch, path, []byte{0}, file))
+			require.Equal(t, http.StatusUnauthorized, send(http.MethodHead, path, []byte{0}, nil))
+			require.Equal(t, http.StatusUnauthorized, send(http.MethodPost, path+"/finalize", []byte{0}, nil))
+
+			// a valid signature of someone else doesn't get through either
+			key, err := crypto.GenerateKey()
+			require.NoError(t, err)
+			other, err := proof.Sign(proof.FileDigest(upload.SetId, 1, 0, proof.Hash(file), fileRoot), key)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusForbidden, send(http.MethodPatch, path, other, file))
+
+			offset, err := erin.UploadOffset(upload.Id)
+			require.NoError(t, err)
+			require.Equal(t, int64(0), offset)
+
+			// the contents have to be the ones that were signed
+			_, err = erin.PatchUpload(upload.Id, 0, []byte("file2"))
+			require.NoError(t, err)
+			_, err = erin.FinalizeUpload(upload.Id)
+			require.ErrorIs(t, err, repository.ErrInvalidSignature)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestTransparencyLog() {
+	t := s.T()
+	ctx := context.Background()
+	repo := s.newRepository()
+
+	log, err := transparency.NewLog(zerolog.New(io.Discard), repo, &headDiscard{}, nil, newIdentityMock(), time.Minute)
+	s.Require().NoError(err)
+	router := gin.New()
+	s.Require().NoError(NewLogController(zerolog.New(io.Discard), log).RegisterRoutes(router.Group("/api")))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	client, err := NewClient(fmt.Sprintf("%s/api", server.URL))
+	s.Require().NoError(err)
+
+	saveSet := func() string {
+		setId := uuid.NewString()
+		s.Require().NoError(
+			repo.SaveFile(
+				model.File{
+					Metadata: model.FileMetadata{SetId: setId, SetCount: 1},
+					Contents: []byte(setId),
+				},
+			),
+		)
+		return setId
+	}
+
+	t.Run(
+		"it should report a log without a head", func(t *testing.T) {
+			_, err := client.GetLogHead()
+			require.ErrorIs(t, err, transparency.ErrNoHead)
+		},
+	)
+
+	setId := saveSet()
+	saveSet()
+	saveSet()
+	first, err := log.Publish(ctx)
+	s.Require().NoError(err)
+	// make sure the second head has a later timestamp
+	time.Sleep(2 * time.Millisecond)
+	saveSet()
+	second, err := log.Publish(ctx)
+	s.Require().NoError(err)
+
+	t.Run(
+		"it should return the signed head", func(t *testing.T) {
+			head, err := client.GetLogHead()
+			require.NoError(t, err)
+			require.NoError(t, head.Verify())
+			require.Equal(t, second.Size, head.Size)
+			require.Equal(t, proof.Encode(second.Root), head.Root)
+
+			heads, err := client.GetLogHeads()
+			require.NoError(t, err)
+			require.Len(t, heads.Heads, 1)
+			require.Empty(t, heads.Equivocations)
+		},
+	)
+
+	t.Run(
+		"it should prove a set is in the log", func(t *testing.T) {
+			inclusion, err := client.GetLogInclusion(setId)
+			require.NoError(t, err)
+			require.NoError(t, inclusion.Head.Verify())
+
+			root, err := proof.Decode(inclusion.Root)
+			require.NoError(t, err)
+			leaf := proof.LogLeafHash(proof.LogEntry(inclusion.SetId, inclusion.SetCount, root))
+			require.Equal(t, proof.Encode(leaf), inclusion.Leaf)
+			hashes, err := decodeHashes(inclusion.Proof)
+			require.NoError(t, err)
+			headRoot, err := proof.Decode(inclusion.Head.Root)
+			require.NoError(t, err)
+			require.True(t, proof.VerifyLogInclusion(leaf, inclusion.Index, inclusion.Head.Size, hashes, headRoot))
+
+			_, err = client.GetLogInclusion(uuid.NewString())
+			require.ErrorIs(t, err, repository.ErrSetNotLogged)
+		},
+	)
+
+	t.Run(
+		"it should prove the log only grew", func(t *testing.T) {
+			consistency, err := client.GetLogConsistency(first.Size, 0)
+			require.NoError(t, err)
+			require.Equal(t, second.Size, consistency.To)
+			require.Equal(t, proof.Encode(first.Root), consistency.FromRoot)
+			hashes, err := decodeHashes(consistency.Proof)
+			require.NoError(t, err)
+			require.True(t, proof.VerifyLogConsistency(first.Size, second.Size, first.Root, second.Root, hashes))
+
+			_, err = client.GetLogConsistency(second.Size+1, 0)
+			require.ErrorIs(t, err, transparency.ErrInvalidRange)
+		},
+	)
+}
+
+// challengeStub answers every challenge with the same response
+type challengeStub struct {
+	response model.ChallengeResponse
+}
+
+func (c *challengeStub) Send(context.Context, string, model.Challenge) (model.ChallengeResponse, error) {
+	return c.response, nil
+}
+
+func (c *challengeStub) Peers() []string {
+	return nil
+}
+
+func (s *ControllerTestSuite) TestPeers() {
+	t := s.T()
+	ctx := context.Background()
+	repo := s.newRepository()
+	network := &challengeStub{}
+	challenger := challenge.NewChallenger(zerolog.New(io.Discard), repo, network, time.Minute)
+
+	router := gin.New()
+	s.Require().NoError(NewPeerController(zerolog.New(io.Discard), challenger).RegisterRoutes(router.Group("/api")))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	client, err := NewClient(fmt.Sprintf("%s/api", server.URL))
+	s.Require().NoError(err)
+
+	setId := uuid.NewString()
+	s.Require().NoError(
+		repo.SaveFile(
+			model.File{
+				Metadata: model.FileMetadata{SetId: setId, SetCount: 1},
+				Contents: []byte("file1"),
+			},
+		),
+	)
+	set, err := repo.FileSet(setId)
+	s.Require().NoError(err)
+	peerId, err := peer.IDFromPrivateKey(newIdentityMock())
+	s.Require().NoError(err)
+
+	t.Run(
+		"it should report the challenges a peer failed", func(t *testing.T) {
+			network.response = model.ChallengeResponse{Error: "failed to get file: file not found"}
+			_, err := challenger.Challenge(ctx, peerId.String(), set, 0)
+			require.NoError(t, err)
+
+			peers, err := client.GetPeers()
+			require.NoError(t, err)
+			require.Len(t, peers.Peers, 1)
+			require.Equal(t, peerId.String(), peers.Peers[0].Peer)
+			require.Negative(t, peers.Peers[0].Score)
+			require.Equal(t, 1, peers.Peers[0].Failed)
+
+			challenges, err := client.GetPeerChallenges(peerId.String())
+			require.NoError(t, err)
+			require.Len(t, challenges.Challenges, 1)
+			require.False(t, challenges.Challenges[0].Passed)
+			require.Equal(t, setId, challenges.Challenges[0].SetId)
+			require.Contains(t, challenges.Challenges[0].Reason, "file not found")
+		},
+	)
+
+	t.Run(
+		"it should reject invalid peer ids", func(t *testing.T) {
+			_, err := client.GetPeerChallenges("not-a-peer")
+			require.ErrorIs(t, err, ErrInvalidArgument)
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestRetention() {
+	t := s.T()
+	ctx := context.Background()
+	repo := s.newRepository()
+	collector := repository.NewCollector(zerolog.New(io.Discard), repo, nil, repository.Retention{}, time.Minute, 10)
+
+	router := gin.New()
+	service := NewService(zerolog.New(io.Discard), "node", newIdentityMock(), s.repo, repo, newUploadsMock())
+	s.Require().NoError(NewController(zerolog.New(io.Discard), service).RegisterRoutes(router.Group("/api")))
+	s.Require().NoError(NewNodeController(zerolog.New(io.Discard), repo, collector).RegisterRoutes(router.Group("/api")))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	client, err := NewClient(fmt.Sprintf("%s/api", server.URL))
+	s.Require().NoError(err)
+
+	testFiles := [][]byte{[]byte("file1"), []byte("file2")}
+	root, err := proof.Root(testFiles)
+	s.Require().NoError(err)
+
+	t.Run(
+		"it should expire sets uploaded with a ttl", func(t *testing.T) {
+			client.SetTTL(200 * time.Millisecond)
+			defer client.SetTTL(0)
+
+			setId := uuid.NewString()
+			_, err := client.PostSet(setId, root, nil, testFiles)
+			require.NoError(t, err)
+			set, err := client.GetSet(setId)
+			require.NoError(t, err)
+			require.NotNil(t, set.ExpiresAt)
+			require.WithinDuration(t, time.Now().Add(200*time.Millisecond), *set.ExpiresAt, time.Second)
+
+			time.Sleep(300 * time.Millisecond)
+			_, err = collector.Collect(ctx)
+			require.NoError(t, err)
+
+			_, err = client.GetSet(setId)
+			require.ErrorIs(t, err, repository.ErrSetDeleted)
+			gc, err := client.GetGC()
+			require.NoError(t, err)
+			require.Equal(t, 1, gc.Runs)
+			require.Equal(t, 1, gc.Total.Expired)
+			require.Equal(t, 2, gc.Total.Files)
+			require.Equal(t, int64(10), gc.Total.Bytes)
+		},
+	)
+
+	t.Run(
+		"it should keep sets without a ttl", func(t *testing.T) {
+			setId := uuid.NewString()
+			_, err := client.PostSet(setId, root, nil, testFiles)
+			require.NoError(t, err)
+			set, err := client.GetSet(setId)
+			require.NoError(t, err)
+			require.Nil(t, set.ExpiresAt)
+		},
+	)
+
+	t.Run(
+		"it should reject invalid ttls", func(t *testing.T) {
+			for _, ttl := range []string{"soon", "-1h"} {
+				_, err := client.PostFile(
+					&PostFileRequest{
+						SetId:    uuid.NewString(),
+						Content:  proof.Encode(testFiles[0]),
+						SetCount: 1,
+						TTL:      ttl,
+					},
+				)
+				require.ErrorIs(t, err, ErrInvalidArgument)
+			}
+		},
+	)
+}
+
+func (s *ControllerTestSuite) TestLimits() {
+	t := s.T()
+	repo := s.newRepository()
+	repo.SetLimits(repository.Limits{MaxFileSize: 8, MaxSetCount: 4, MaxSetBytes: 16, Capacity: 16})
+	collector := repository.NewCollector(zerolog.New(io.Discard), repo, nil, repository.Retention{}, time.Minute, 10)
+
+	router := gin.New()
+	service := NewService(zerolog.New(io.Discard), "node", newIdentityMock(), s.repo, repo, newUploadsMock())
+	s.Require().NoError(NewController(zerolog.New(io.Discard), service).RegisterRoutes(router.Group("/api")))
+	s.Require().NoError(NewNodeController(zerolog.New(io.Discard), repo, collector).RegisterRoutes(router.Group("/api")))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	client, err := NewClient(fmt.Sprintf("%s/api", server.URL))
+	s.Require().NoError(err)
+
+	t.Run(
+		"it should refuse uploads over the limits", func(t *testing.T) {
+			// files are checked before they are published, so refusing them
+			// doesn't need any peers
+			s.repo.offline = true
+			defer func() { s.repo.offline = false }()
+
+			_, err := client.PostFileRaw(uuid.NewString(), 0, 1, nil, nil, []byte("too large"))
+			require.ErrorIs(t, err, repository.ErrFileTooLarge)
+			_, err = client.PostFileRaw(uuid.NewString(), 0, 5, nil, nil, []byte("file1"))
+			require.ErrorIs(t, err, repository.ErrSetTooLarge)
+			_, err = client.CreateUpload(uuid.NewString(), 0, 1, 9, nil, nil, nil)
+			require.ErrorIs(t, err, repository.ErrFileTooLarge)
+
+			// bodies are cut off once they are over the limits, before the
+			// files in them are looked at
+			files := [][]byte{bytes.Repeat([]byte("a"), 64<<10)}
+			root, err := proof.Root(files)
+			require.NoError(t, err)
+			_, err = client.PostSet(uuid.NewString(), root, nil, files)
+			require.ErrorIs(t, err, repository.ErrSetTooLarge)
+			_, err = client.PostFileRaw(uuid.NewString(), 0, 1, nil, nil, files[0])
+			require.ErrorIs(t, err, repository.ErrFileTooLarge)
+		},
+	)
+
+	t.Run(
+		"it should report its free capacity until it is full", func(t *testing.T) {
+			_, err := client.PostFileRaw(uuid.NewString(), 0, 1, nil, nil, []byte("file1"))
+			require.NoError(t, err)
+
+			capacity, err := client.GetCapacity()
+			require.NoError(t, err)
+			require.Equal(t, int64(16), capacity.Capacity)
+			require.Equal(t, int64(5), capacity.Used)
+			require.NotNil(t, capacity.Free)
+			require.Equal(t, int64(11), *capacity.Free)
+			require.Equal(t, int64(8), capacity.MaxFileSize)
+			require.Equal(t, 4, capacity.MaxSetCount)
+
+			files := [][]byte{[]byte("file2"), []byte("file3"), []byte("file4")}
+			root, err := proof.Root(files)
+			require.NoError(t, err)
+			_, err = client.PostSet(uuid.NewString(), root, nil, files)
+			require.ErrorIs(t, err, repository.ErrNodeFull)
+		},
+	)
+}
+
+// newRepository returns a repository on an in-memory database, for the
+// parts of the api that are backed by it directly
+func (s *ControllerTestSuite) newRepository() *repository.Files {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	s.Require().NoError(err)
+	sqlDb, err := db.DB()
+	s.Require().NoError(err)
+	sqlDb.SetMaxOpenConns(1)
+	s.T().Cleanup(func() { _ = sqlDb.Close() })
+	blobs, err := repository.NewDiskBlobStore(s.T().TempDir())
+	s.Require().NoError(err)
+	repo := repository.NewFiles(zerolog.New(io.Discard), db, blobs)
+	s.Require().NoError(repo.Migrate())
+	return repo
+}
+
+func decodeHashes(in []string) ([][]byte, error) {
+	out := make([][]byte, len(in))
+	for i, h := range in {
+		var err error
+		if out[i], err = proof.Decode(h); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
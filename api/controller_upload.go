@@ -0,0 +1,171 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+const (
+	// UploadOffsetHeader carries the offset a chunk is written at, and the
+	// current offset of an upload in responses
+	UploadOffsetHeader = "Upload-Offset"
+	// UploadLengthHeader carries the total length of an upload
+	UploadLengthHeader = "Upload-Length"
+
+	// offsetContentType is the content type of upload chunks, as in tus
+	offsetContentType = "application/offset+octet-stream"
+)
+
+// Resumable uploads loosely follow the tus protocol: an upload is created
+// with its length, chunks are sent with PATCH at the current offset, and
+// HEAD reports how far the upload got, so a client can pick up where an
+// interrupted request left off. Unlike tus, the upload has to be finalized
+// explicitly, which is when the file is saved into its set.
+
+// CreateUpload starts a new resumable upload
+func (c *Controller) CreateUpload(ctx *gin.Context, in *CreateUploadRequest) (*UploadResponse, error) {
+	setId, err := uuid.Parse(in.SetId)
+	if err != nil {
+		return nil, invalidArgument("setId", err)
+	}
+	root, err := decodeRoot(in.Root)
+	if err != nil {
+		return nil, invalidArgument("root", err)
+	}
+	tree, err := decodeTree(in.Tree)
+	if err != nil {
+		return nil, invalidArgument("tree", err)
+	}
+	keyHash, err := decodeRoot(in.KeyHash)
+	if err != nil {
+		return nil, invalidArgument("keyHash", err)
+	}
+	hash, err := decodeRoot(in.Hash)
+	if err != nil {
+		return nil, invalidArgument("hash", err)
+	}
+	signature, err := decodeRoot(in.Signature)
+	if err != nil {
+		return nil, invalidArgument("signature", err)
+	}
+	expiresAt, err := decodeTTL(in.TTL)
+	if err != nil {
+		return nil, invalidArgument("ttl", err)
+	}
+	upload, err := c.service.CreateUpload(
+		Principal(ctx),
+		model.Upload{
+			SetId:      setId.String(),
+			SetCount:   in.SetCount,
+			FileNumber: in.Index,
+			Root:       root,
+			Tree:       tree,
+			KeyHash:    keyHash,
+			Signature:  signature,
+			Hash:       hash,
+			ExpiresAt:  expiresAt,
+			Length:     in.Length,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Header("Location", fmt.Sprintf("%s/%s", ctx.FullPath(), upload.Id))
+	return uploadResponse(upload), nil
+}
+
+// HeadUpload reports the current offset of an upload in the response headers
+func (c *Controller) HeadUpload(ctx *gin.Context) {
+	signature, err := uploadSignature(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	upload, err := c.service.Upload(Principal(ctx), signature, ctx.Param("uploadId"))
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header(UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	ctx.Header(UploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	ctx.Status(http.StatusOK)
+}
+
+// PatchUpload appends a chunk to an upload. The chunk has to start at the
+// current offset, otherwise the node answers with 409 Conflict and the
+// current offset, so the client can resend from there.
+func (c *Controller) PatchUpload(ctx *gin.Context) {
+	if ctx.ContentType() != offsetContentType {
+		c.abort(ctx, invalidArgument("body", errors.Errorf("unsupported content type %q", ctx.ContentType())))
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.GetHeader(UploadOffsetHeader), 10, 64)
+	if err != nil {
+		c.abort(ctx, invalidArgument(UploadOffsetHeader, err))
+		return
+	}
+	signature, err := uploadSignature(ctx)
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+
+	next, err := c.service.AppendUpload(Principal(ctx), signature, ctx.Param("uploadId"), offset, ctx.Request.Body)
+	if errors.Is(err, repository.ErrUploadOffsetMismatch) {
+		ctx.Header(UploadOffsetHeader, strconv.FormatInt(next, 10))
+		c.abort(ctx, withDetails(err, map[string]any{"offset": next}))
+		return
+	}
+	if err != nil {
+		c.abort(ctx, err)
+		return
+	}
+	ctx.Header(UploadOffsetHeader, strconv.FormatInt(next, 10))
+	ctx.Status(http.StatusNoContent)
+}
+
+// FinalizeUpload saves a complete upload into its set
+func (c *Controller) FinalizeUpload(ctx *gin.Context, in *UploadRequest) (*PostFileResponse, error) {
+	signature, err := decodeRoot(in.Signature)
+	if err != nil {
+		return nil, invalidArgument("signature", err)
+	}
+	hash, err := c.service.FinalizeUpload(Principal(ctx), signature, in.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	return &PostFileResponse{
+		Success: true,
+		Hash:    hash,
+	}, nil
+}
+
+// uploadSignature reads the signature of a signed upload, chunks are never
+// forms so it only comes in the X-Signature header
+func uploadSignature(ctx *gin.Context) ([]byte, error) {
+	signature, err := decodeRoot(ctx.GetHeader(SignatureHeader))
+	if err != nil {
+		return nil, invalidArgument(signatureField, err)
+	}
+	return signature, nil
+}
+
+func uploadResponse(upload model.Upload) *UploadResponse {
+	return &UploadResponse{
+		Id:       upload.Id,
+		SetId:    upload.SetId,
+		Index:    upload.FileNumber,
+		SetCount: upload.SetCount,
+		Length:   upload.Length,
+		Offset:   upload.Offset,
+	}
+}
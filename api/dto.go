@@ -1,8 +1,28 @@
 package api
 
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/transparency"
+)
+
 type PostFileRequest struct {
 	Content  string `json:"content" validate:"required"`
 	SetCount int    `json:"setCount" validate:"required"`
+	// Root is the optional root the client computed for the set
+	Root string `json:"root"`
+	// Tree is the optional kind of tree the root is computed with
+	Tree string `json:"tree"`
+	// KeyHash is the optional hash of the key needed to delete the set
+	KeyHash string `json:"keyHash"`
+	// TTL is the optional time the set is kept for, eg. 720h
+	TTL string `json:"ttl"`
+	// Signature is the optional Ethereum signature over the file
+	Signature string `header:"X-Signature"`
 
 	SetId string `path:"setId"`
 	Index int    `path:"index"`
@@ -15,7 +35,7 @@ type PostFileResponse struct {
 
 type GetFileRequest struct {
 	SetId string `path:"setId" validate:"required"`
-	Index int    `path:"index" validate:"required"`
+	Index int    `path:"index" validate:"gte=0"`
 }
 
 type GetFileResponse struct {
@@ -27,3 +47,354 @@ type ProofResponse struct {
 	Proof []string `json:"proof"`
 	Index uint64   `json:"index"`
 }
+
+type GetSetRequest struct {
+	SetId string `path:"setId" validate:"required"`
+}
+
+type GetSetResponse struct {
+	SetId    string `json:"setId"`
+	SetCount int    `json:"setCount"`
+	Received int    `json:"received"`
+	// Missing lists the indices not received yet as inclusive ranges
+	Missing  []IndexRange `json:"missing"`
+	Complete bool         `json:"complete"`
+	Root     string       `json:"root,omitempty"`
+	// DeclaredRoot is the root the uploader declared, the set is
+	// quarantined if it doesn't match the root of the stored files
+	DeclaredRoot string `json:"declaredRoot,omitempty"`
+	// Tree is the kind of tree the root and proofs are computed with,
+	// sorted trees can be verified with OpenZeppelin's MerkleProof
+	Tree        string `json:"tree"`
+	Quarantined bool   `json:"quarantined"`
+	// Owner is the principal that owns the set, and Grants the principals
+	// it allowed to change the set
+	Owner  string   `json:"owner,omitempty"`
+	Grants []string `json:"grants,omitempty"`
+	// ExpiresAt is when the set is collected, if it was uploaded with a TTL
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Anchor is the transaction that anchored the root on chain, if the
+	// node anchors sets
+	Anchor *AnchorResponse `json:"anchor,omitempty"`
+}
+
+type AnchorResponse struct {
+	// Root is the root the registry holds for the set, which is the root
+	// of the set unless someone anchored a different one first
+	Root        string    `json:"root"`
+	Contract    string    `json:"contract"`
+	Transaction string    `json:"transaction"`
+	Block       uint64    `json:"block"`
+	AnchoredAt  time.Time `json:"anchoredAt"`
+	// Conflicting is set when Root is not the root of the set, in which
+	// case the anchor proves nothing about the set
+	Conflicting bool `json:"conflicting"`
+}
+
+type IndexRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+type PostSetResponse struct {
+	Success  bool   `json:"success"`
+	SetCount int    `json:"setCount"`
+	Root     string `json:"root"`
+}
+
+type CreateSetRequest struct {
+	SetCount int    `json:"setCount" validate:"required"`
+	Root     string `json:"root" validate:"required"`
+	// Tree is the optional kind of tree the root is computed with
+	Tree string `json:"tree"`
+	// KeyHash is the optional hash of the key needed to delete the set
+	KeyHash string `json:"keyHash"`
+	// TTL is the optional time the set is kept for, eg. 720h
+	TTL string `json:"ttl"`
+	// Signature is the optional Ethereum signature over the set
+	Signature string `header:"X-Signature"`
+
+	SetId string `path:"setId"`
+}
+
+type CreateSetResponse struct {
+	Success bool `json:"success"`
+}
+
+// ManifestResponse lists the ordered leaf hashes of a set, which is enough
+// to rebuild its root without downloading any of the files
+type ManifestResponse struct {
+	SetId    string   `json:"setId"`
+	SetCount int      `json:"setCount"`
+	Root     string   `json:"root"`
+	Tree     string   `json:"tree"`
+	Leaves   []string `json:"leaves"`
+}
+
+// AttestationResponse is a node's signature over the id, count, root and
+// tree of a set, made with its libp2p identity key
+type AttestationResponse struct {
+	SetId    string `json:"setId"`
+	SetCount int    `json:"setCount"`
+	Root     string `json:"root"`
+	Tree     string `json:"tree"`
+	// Node is the peer ID of the node, and PublicKey its marshalled libp2p
+	// public key, which has to match the peer ID
+	Node      string `json:"node"`
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
+// Verify checks the signature of the attestation, and that it was made by
+// the node it names
+func (a *AttestationResponse) Verify() error {
+	root, err := proof.Decode(a.Root)
+	if err != nil {
+		return errors.Wrap(err, "invalid attestation root")
+	}
+	publicKey, err := proof.Decode(a.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "invalid attestation public key")
+	}
+	signature, err := proof.Decode(a.Signature)
+	if err != nil {
+		return errors.Wrap(err, "invalid attestation signature")
+	}
+	tree, err := proof.ParseMode(a.Tree)
+	if err != nil {
+		return errors.Wrap(err, "invalid attestation tree")
+	}
+	digest := proof.AttestationDigest(a.SetId, a.SetCount, root, tree)
+	return proof.VerifyAttestation(digest, a.Node, publicKey, signature)
+}
+
+type GetFileByHashRequest struct {
+	Hash string `path:"hash" validate:"required"`
+}
+
+type GetFileByHashResponse struct {
+	Hash string `json:"hash"`
+	File string `json:"file"`
+	// Sets lists every set and index the file is stored at
+	Sets []FileLocationResponse `json:"sets"`
+}
+
+type FileLocationResponse struct {
+	SetId string `json:"setId"`
+	Index int    `json:"index"`
+	// Root and Proof are only set once the set is complete
+	Root  string         `json:"root,omitempty"`
+	Proof *ProofResponse `json:"proof,omitempty"`
+}
+
+type CreateUploadRequest struct {
+	SetId    string `json:"setId" validate:"required"`
+	Index    int    `json:"index" validate:"gte=0"`
+	SetCount int    `json:"setCount" validate:"required"`
+	// Length is the total size of the file in bytes
+	Length int64 `json:"length" validate:"gte=0"`
+	// Root is the optional root the client computed for the set
+	Root string `json:"root"`
+	// Tree is the optional kind of tree the root is computed with
+	Tree string `json:"tree"`
+	// KeyHash is the optional hash of the key needed to delete the set
+	KeyHash string `json:"keyHash"`
+	// TTL is the optional time the set is kept for, counted from when the
+	// upload is created
+	TTL string `json:"ttl"`
+	// Hash is the hash of the whole file, signed uploads have to declare it
+	// so that the signature can be checked before any contents are sent
+	Hash string `json:"hash"`
+	// Signature is the optional Ethereum signature over the file, the
+	// upload belongs to the address that signed it
+	Signature string `header:"X-Signature"`
+}
+
+type UploadRequest struct {
+	UploadId string `path:"uploadId" validate:"required"`
+	// Signature has to be sent with every request of a signed upload, it is
+	// the same signature the upload was created with
+	Signature string `header:"X-Signature"`
+}
+
+type UploadResponse struct {
+	Id       string `json:"id"`
+	SetId    string `json:"setId"`
+	Index    int    `json:"index"`
+	SetCount int    `json:"setCount"`
+	Length   int64  `json:"length"`
+	Offset   int64  `json:"offset"`
+}
+
+type DeleteSetRequest struct {
+	SetId string `path:"setId" validate:"required"`
+	Key   string `header:"X-Set-Key"`
+	// Signature is the optional Ethereum signature over the deletion
+	Signature string `header:"X-Signature"`
+}
+
+type GrantSetRequest struct {
+	SetId     string `path:"setId" validate:"required"`
+	Principal string `json:"principal" validate:"required"`
+	// Signature is the optional Ethereum signature over the grant
+	Signature string `header:"X-Signature"`
+}
+
+type GrantSetResponse struct {
+	Success bool `json:"success"`
+}
+
+type DeleteSetResponse struct {
+	Success bool `json:"success"`
+}
+
+// TreeHeadResponse is a node's signature over the size and root of its
+// transparency log
+type TreeHeadResponse struct {
+	Node      string    `json:"node"`
+	Size      uint64    `json:"size"`
+	Root      string    `json:"root"`
+	Timestamp time.Time `json:"timestamp"`
+	PublicKey string    `json:"publicKey"`
+	Signature string    `json:"signature"`
+}
+
+// Verify checks the signature of the head, and that it was made by the
+// node it names
+func (h *TreeHeadResponse) Verify() error {
+	head, err := h.toModel()
+	if err != nil {
+		return err
+	}
+	return transparency.VerifyHead(head)
+}
+
+func (h *TreeHeadResponse) toModel() (model.TreeHead, error) {
+	head := model.TreeHead{
+		Node:      h.Node,
+		Size:      h.Size,
+		Timestamp: h.Timestamp,
+	}
+	var err error
+	if head.Root, err = proof.Decode(h.Root); err != nil {
+		return model.TreeHead{}, errors.Wrap(err, "invalid head root")
+	}
+	if head.PublicKey, err = proof.Decode(h.PublicKey); err != nil {
+		return model.TreeHead{}, errors.Wrap(err, "invalid head public key")
+	}
+	if head.Signature, err = proof.Decode(h.Signature); err != nil {
+		return model.TreeHead{}, errors.Wrap(err, "invalid head signature")
+	}
+	return head, nil
+}
+
+// LogInclusionResponse proves that a set is in the node's log at the given
+// head. The leaf is the hash of the entry, which clients can recompute from
+// the set id, count and root.
+type LogInclusionResponse struct {
+	SetId    string           `json:"setId"`
+	SetCount int              `json:"setCount"`
+	Root     string           `json:"root"`
+	Index    uint64           `json:"index"`
+	Leaf     string           `json:"leaf"`
+	Proof    []string         `json:"proof"`
+	Head     TreeHeadResponse `json:"head"`
+}
+
+type LogConsistencyRequest struct {
+	From uint64 `query:"from" validate:"required"`
+	// To defaults to the size of the latest head
+	To uint64 `query:"to"`
+}
+
+// LogConsistencyResponse proves that the log of size from is a prefix of
+// the log of size to
+type LogConsistencyResponse struct {
+	From     uint64   `json:"from"`
+	To       uint64   `json:"to"`
+	FromRoot string   `json:"fromRoot"`
+	ToRoot   string   `json:"toRoot"`
+	Proof    []string `json:"proof"`
+}
+
+// LogHeadsResponse lists the latest head of every node this node knows of,
+// and the equivocations it detected
+type LogHeadsResponse struct {
+	Heads         []TreeHeadResponse     `json:"heads"`
+	Equivocations []EquivocationResponse `json:"equivocations"`
+}
+
+// EquivocationResponse holds two heads signed by the same node that can't
+// both be honest
+type EquivocationResponse struct {
+	Node       string           `json:"node"`
+	Reason     string           `json:"reason"`
+	First      TreeHeadResponse `json:"first"`
+	Second     TreeHeadResponse `json:"second"`
+	DetectedAt time.Time        `json:"detectedAt"`
+}
+
+type PeersResponse struct {
+	Peers []PeerScoreResponse `json:"peers"`
+}
+
+// PeerScoreResponse sums up the storage challenges sent to a peer, the
+// score drops a lot more for every failure than it rises for every pass
+type PeerScoreResponse struct {
+	Peer             string    `json:"peer"`
+	Score            int       `json:"score"`
+	Passed           int       `json:"passed"`
+	Failed           int       `json:"failed"`
+	LastChallengedAt time.Time `json:"lastChallengedAt"`
+}
+
+type GetPeerRequest struct {
+	PeerId string `path:"peerId" validate:"required"`
+}
+
+type ChallengesResponse struct {
+	Challenges []ChallengeResultResponse `json:"challenges"`
+}
+
+// ChallengeResultResponse is the outcome of a storage challenge, Reason
+// says why it failed
+type ChallengeResultResponse struct {
+	SetId        string    `json:"setId"`
+	Index        int       `json:"index"`
+	Passed       bool      `json:"passed"`
+	Reason       string    `json:"reason,omitempty"`
+	DurationMs   int64     `json:"durationMs"`
+	ChallengedAt time.Time `json:"challengedAt"`
+}
+
+// GCResponse sums up what garbage collection reclaimed since the node
+// started, and in its last round
+type GCResponse struct {
+	Runs    int              `json:"runs"`
+	LastRun *time.Time       `json:"lastRun,omitempty"`
+	Last    GCReportResponse `json:"last"`
+	Total   GCReportResponse `json:"total"`
+}
+
+// GCReportResponse counts the collected sets, by why they were collected,
+// the abandoned uploads, and the files, blobs and bytes they freed
+type GCReportResponse struct {
+	Expired    int   `json:"expired"`
+	Incomplete int   `json:"incomplete"`
+	Uploads    int   `json:"uploads"`
+	Files      int   `json:"files"`
+	Blobs      int   `json:"blobs"`
+	Bytes      int64 `json:"bytes"`
+}
+
+// CapacityResponse is how much a node stores against its limits, a limit
+// of zero means there is none. Free is left out on nodes without a
+// capacity.
+type CapacityResponse struct {
+	Capacity    int64  `json:"capacity"`
+	Used        int64  `json:"used"`
+	Free        *int64 `json:"free,omitempty"`
+	MaxFileSize int64  `json:"maxFileSize"`
+	MaxSetCount int    `json:"maxSetCount"`
+	MaxSetBytes int64  `json:"maxSetBytes"`
+}
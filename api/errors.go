@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/loopfz/gadgeto/tonic"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+	"github.com/scottrmalley/p2p-file-sharing/transparency"
+)
+
+var (
+	// ErrInvalidArgument is returned when a request parameter can't be
+	// parsed or is out of bounds
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrUnavailable is returned when the node can't reach its peers, the
+	// request can be retried later
+	ErrUnavailable = errors.New("node unavailable")
+)
+
+// Error is the body of every error response. The code is stable, so
+// clients can rely on it rather than on the message, and details carry
+// whatever else is needed to act on the error, eg. the offending field.
+type Error struct {
+	Status  int            `json:"-"`
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Is matches the error against the sentinel errors by code, so that
+// errors decoded by the Client can be checked with errors.Is just like
+// the errors returned by the Service
+func (e *Error) Is(target error) bool {
+	for _, kind := range errorKinds {
+		if kind.err == target {
+			return kind.code == e.Code
+		}
+	}
+	return false
+}
+
+const (
+	codeInternal   = "internal"
+	codeBadRequest = "bad_request"
+	// codeUnknown is used by the Client for error responses without an
+	// Error body, eg. responses to HEAD requests
+	codeUnknown = "unknown"
+)
+
+// errorKinds maps the domain errors to their status and code. The first
+// kind an error matches with errors.Is wins, so more specific errors have
+// to come first.
+var errorKinds = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrSetNotFound, http.StatusNotFound, "set_not_found"},
+	{ErrFileNotFound, http.StatusNotFound, "file_not_found"},
+	{repository.ErrUploadNotFound, http.StatusNotFound, "upload_not_found"},
+	{repository.ErrSetNotLogged, http.StatusNotFound, "set_not_logged"},
+	{transparency.ErrNoHead, http.StatusNotFound, "no_tree_head"},
+	{repository.ErrSetDeleted, http.StatusGone, "set_deleted"},
+	{repository.ErrSetExpired, http.StatusGone, "set_expired"},
+	{ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated"},
+	{repository.ErrInvalidSignature, http.StatusUnauthorized, "invalid_signature"},
+	{repository.ErrNotSetOwner, http.StatusForbidden, "not_set_owner"},
+	{repository.ErrSetKeyMismatch, http.StatusForbidden, "key_mismatch"},
+	{ErrFileSetIncomplete, http.StatusConflict, "set_incomplete"},
+	{ErrSetQuarantined, http.StatusConflict, "set_quarantined"},
+	{repository.ErrSetCountMismatch, http.StatusConflict, "set_count_mismatch"},
+	{repository.ErrRootConflict, http.StatusConflict, "root_conflict"},
+	{repository.ErrKeyConflict, http.StatusConflict, "key_conflict"},
+	{repository.ErrTreeConflict, http.StatusConflict, "tree_conflict"},
+	{repository.ErrOwnerConflict, http.StatusConflict, "owner_conflict"},
+	{repository.ErrFileConflict, http.StatusConflict, "file_conflict"},
+	{repository.ErrSetNotDeletable, http.StatusConflict, "set_not_deletable"},
+	{repository.ErrUploadOffsetMismatch, http.StatusConflict, "upload_offset_mismatch"},
+	{repository.ErrUploadIncomplete, http.StatusConflict, "upload_incomplete"},
+	{repository.ErrFileTooLarge, http.StatusRequestEntityTooLarge, "file_too_large"},
+	{repository.ErrSetTooLarge, http.StatusRequestEntityTooLarge, "set_too_large"},
+	{repository.ErrNodeFull, http.StatusInsufficientStorage, "node_full"},
+	{ErrRootMismatch, http.StatusUnprocessableEntity, "root_mismatch"},
+	{ErrEmptySet, http.StatusUnprocessableEntity, "empty_set"},
+	{repository.ErrIndexOutOfRange, http.StatusUnprocessableEntity, "index_out_of_range"},
+	{transparency.ErrInvalidRange, http.StatusUnprocessableEntity, "invalid_log_range"},
+	{ErrInvalidArgument, http.StatusUnprocessableEntity, "invalid_argument"},
+	{ErrUnavailable, http.StatusServiceUnavailable, "unavailable"},
+	{repository.ErrFileCorrupt, http.StatusServiceUnavailable, "file_corrupt"},
+}
+
+// kindError tags an error with one of the sentinel errors, so that it maps
+// to the right response while keeping its own message and cause
+type kindError struct {
+	kind    error
+	err     error
+	details map[string]any
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// invalidArgument reports a request parameter that could not be parsed
+func invalidArgument(field string, err error) error {
+	return &kindError{
+		kind:    ErrInvalidArgument,
+		err:     errors.Wrapf(err, "invalid %s", field),
+		details: map[string]any{"field": field},
+	}
+}
+
+// unavailable reports a failure to publish to the network
+func unavailable(err error) error {
+	return &kindError{
+		kind: ErrUnavailable,
+		err:  errors.Wrap(err, "failed to publish to peers"),
+	}
+}
+
+// withDetails attaches details to the error response, without changing
+// the kind of error
+func withDetails(err error, details map[string]any) error {
+	return &kindError{err: err, details: details}
+}
+
+// errorResponse turns any error into a status and an Error body. Errors
+// that don't match one of the known kinds are internal, and their message
+// is not passed on to the client.
+func errorResponse(err error) (int, *Error) {
+	out := &Error{
+		Status:  http.StatusInternalServerError,
+		Code:    codeInternal,
+		Message: "internal error",
+	}
+
+	var bindErr tonic.BindError
+	if errors.As(err, &bindErr) {
+		out.Status = http.StatusBadRequest
+		out.Code = codeBadRequest
+		out.Message = bindErr.Error()
+		return out.Status, out
+	}
+
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			out.Status = kind.status
+			out.Code = kind.code
+			out.Message = err.Error()
+			break
+		}
+	}
+
+	var tagged *kindError
+	for e := err; errors.As(e, &tagged); e = tagged.err {
+		for k, v := range tagged.details {
+			if out.Details == nil {
+				out.Details = make(map[string]any)
+			}
+			if _, ok := out.Details[k]; !ok {
+				out.Details[k] = v
+			}
+		}
+	}
+	return out.Status, out
+}
+
+// ErrorHook is the tonic error hook for the api, internal errors are
+// logged since the client only gets a generic message
+func ErrorHook(logger zerolog.Logger) tonic.ErrorHook {
+	return func(ctx *gin.Context, err error) (int, interface{}) {
+		status, body := errorResponse(err)
+		if status == http.StatusInternalServerError {
+			logger.Error().Err(err).Str("path", ctx.FullPath()).Msg("request failed")
+		}
+		return status, body
+	}
+}
+
+// responseError is the Client side counterpart of errorResponse
+func responseError(res *resty.Response) error {
+	return decodeError(res.StatusCode(), res.Body())
+}
+
+func decodeError(status int, body []byte) error {
+	out := new(Error)
+	if err := json.Unmarshal(body, out); err != nil || out.Code == "" {
+		out = &Error{
+			Code:    codeUnknown,
+			Message: http.StatusText(status),
+		}
+	}
+	out.Status = status
+	return out
+}
@@ -1,18 +1,55 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/pkg/errors"
 
 	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
 )
 
 type persistenceMock struct {
-	files map[string][]model.File
+	files        map[string][]model.File
+	declarations map[string]model.SetDeclaration
+	deleted      map[string]bool
+	grants       map[string][]string
+	// offline makes every write to the network fail
+	offline bool
+	// tombstonesErr makes every lookup of deleted sets fail with it
+	tombstonesErr error
+}
+
+// newIdentityMock generates a libp2p identity for the node under test
+func newIdentityMock() p2pcrypto.PrivKey {
+	key, _, err := p2pcrypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// headDiscard drops the tree heads a transparency log gossips
+type headDiscard struct{}
+
+func (*headDiscard) WriteHead(context.Context, model.HeadAnnouncement) error {
+	return nil
 }
 
 func newPersistenceMock() *persistenceMock {
 	return &persistenceMock{
-		files: make(map[string][]model.File),
+		files:        make(map[string][]model.File),
+		declarations: make(map[string]model.SetDeclaration),
+		deleted:      make(map[string]bool),
+		grants:       make(map[string][]string),
 	}
 }
 
@@ -28,11 +65,259 @@ func (p *persistenceMock) Files(setId string) ([][]byte, error) {
 	return out, nil
 }
 
+func (p *persistenceMock) OpenFile(setId string, index int) (model.FileMetadata, io.ReadSeekCloser, error) {
+	file := p.files[setId][index]
+	return file.Metadata, nopSeekCloser{bytes.NewReader(file.Contents)}, nil
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+func (p *persistenceMock) Hashes(setId string) ([][]byte, error) {
+	var out [][]byte
+	for _, file := range p.files[setId] {
+		out = append(out, proof.Hash(file.Contents))
+	}
+	return out, nil
+}
+
+func (p *persistenceMock) FileSet(setId string) (model.FileSet, error) {
+	files := p.files[setId]
+	declaration, declared := p.declarations[setId]
+	if len(files) == 0 && !declared {
+		return model.FileSet{}, repository.ErrSetNotFound
+	}
+	set := model.FileSet{
+		SetId:        setId,
+		SetCount:     declaration.SetCount,
+		Received:     len(files),
+		DeclaredRoot: declaration.Root,
+		Tree:         declaration.Tree,
+		Uploader:     declaration.Uploader,
+		Owner:        declaration.Owner,
+		Grants:       p.grants[setId],
+		CreatedAt:    time.Now(),
+	}
+	if len(files) > 0 {
+		set.SetCount = files[0].Metadata.SetCount
+		set.Uploader = files[0].Metadata.Uploader
+		if set.Owner == "" {
+			set.Owner = files[0].Metadata.Owner
+		}
+	}
+	for _, file := range files {
+		if len(file.Metadata.Root) > 0 {
+			set.DeclaredRoot = file.Metadata.Root
+		}
+		if file.Metadata.Tree != "" {
+			set.Tree = file.Metadata.Tree
+		}
+	}
+	set.Tree = set.Tree.OrDefault()
+	if set.Received == set.SetCount {
+		contents, _ := p.Files(setId)
+		root, err := set.Tree.Root(contents)
+		if err != nil {
+			return model.FileSet{}, err
+		}
+		now := time.Now()
+		set.Root = root
+		set.CompletedAt = &now
+		set.Quarantined = len(set.DeclaredRoot) > 0 && !bytes.Equal(set.DeclaredRoot, root)
+	}
+	return set, nil
+}
+
+func (p *persistenceMock) DeclareSet(declaration model.SetDeclaration) error {
+	if existing, ok := p.declarations[declaration.SetId]; ok {
+		if len(declaration.KeyHash) == 0 {
+			declaration.KeyHash = existing.KeyHash
+		}
+		if declaration.Owner == "" {
+			declaration.Owner = existing.Owner
+		}
+	}
+	p.declarations[declaration.SetId] = declaration
+	p.grants[declaration.SetId] = append(p.grants[declaration.SetId], declaration.Grants...)
+	return nil
+}
+
+func (p *persistenceMock) Indices(setId string) ([]int, error) {
+	var out []int
+	for _, file := range p.files[setId] {
+		out = append(out, file.Metadata.FileNumber)
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+func (p *persistenceMock) FilesByHash(hash string) ([]model.FileMetadata, error) {
+	var out []model.FileMetadata
+	for _, files := range p.files {
+		for _, file := range files {
+			if proof.Encode(proof.Hash(file.Contents)) == hash {
+				out = append(out, file.Metadata)
+			}
+		}
+	}
+	return out, nil
+}
+
 func (p *persistenceMock) SaveFile(file model.File) error {
+	if p.deleted[file.Metadata.SetId] {
+		return repository.ErrSetDeleted
+	}
 	p.files[file.Metadata.SetId] = append(p.files[file.Metadata.SetId], file)
 	return nil
 }
 
+func (p *persistenceMock) SaveStream(metadata model.FileMetadata, hash []byte, _ int64, contents io.Reader) error {
+	file, err := io.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(proof.Hash(file), hash) {
+		return repository.ErrHashMismatch
+	}
+	return p.SaveFile(model.File{Metadata: metadata, Contents: file})
+}
+
+func (p *persistenceMock) SaveFiles(files []model.File) error {
+	for _, file := range files {
+		if err := p.SaveFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *persistenceMock) DeleteSet(deletion model.SetDeletion) error {
+	if p.deleted[deletion.SetId] {
+		return nil
+	}
+	if set, err := p.FileSet(deletion.SetId); err == nil && set.Owner != "" {
+		if !set.Allows(deletion.Principal) {
+			return repository.ErrNotSetOwner
+		}
+		delete(p.files, deletion.SetId)
+		delete(p.declarations, deletion.SetId)
+		p.deleted[deletion.SetId] = true
+		return nil
+	}
+	files := p.files[deletion.SetId]
+	keyHash := p.declarations[deletion.SetId].KeyHash
+	if len(files) > 0 && len(files[0].Metadata.KeyHash) > 0 {
+		keyHash = files[0].Metadata.KeyHash
+	}
+	if len(keyHash) == 0 {
+		return repository.ErrSetNotDeletable
+	}
+	if !bytes.Equal(keyHash, proof.Hash(deletion.Key)) {
+		return repository.ErrSetKeyMismatch
+	}
+	delete(p.files, deletion.SetId)
+	delete(p.declarations, deletion.SetId)
+	p.deleted[deletion.SetId] = true
+	return nil
+}
+
+func (p *persistenceMock) CheckUpload(_ model.Upload) error {
+	return nil
+}
+
+func (p *persistenceMock) Limits() repository.Limits {
+	return repository.Limits{}
+}
+
+func (p *persistenceMock) Deleted(setId string) (bool, error) {
+	if p.tombstonesErr != nil {
+		return false, p.tombstonesErr
+	}
+	return p.deleted[setId], nil
+}
+
 func (p *persistenceMock) Write(_ context.Context, _ model.File) error {
+	return p.write()
+}
+
+func (p *persistenceMock) WriteBatch(_ context.Context, _ []model.File) error {
+	return p.write()
+}
+
+func (p *persistenceMock) WriteSet(_ context.Context, _ model.SetDeclaration) error {
+	return p.write()
+}
+
+func (p *persistenceMock) WriteDeletion(_ context.Context, _ model.SetDeletion) error {
+	return p.write()
+}
+
+func (p *persistenceMock) write() error {
+	if p.offline {
+		return errors.New("no peers")
+	}
+	return nil
+}
+
+type uploadsMock struct {
+	uploads  map[string]model.Upload
+	contents map[string][]byte
+}
+
+func newUploadsMock() *uploadsMock {
+	return &uploadsMock{
+		uploads:  make(map[string]model.Upload),
+		contents: make(map[string][]byte),
+	}
+}
+
+func (u *uploadsMock) CreateUpload(upload model.Upload) (model.Upload, error) {
+	upload.Id = uuid.NewString()
+	upload.CreatedAt = time.Now()
+	u.uploads[upload.Id] = upload
+	return upload, nil
+}
+
+func (u *uploadsMock) Upload(id string) (model.Upload, error) {
+	upload, ok := u.uploads[id]
+	if !ok {
+		return model.Upload{}, repository.ErrUploadNotFound
+	}
+	upload.Offset = int64(len(u.contents[id]))
+	return upload, nil
+}
+
+func (u *uploadsMock) AppendUpload(id string, offset int64, chunk io.Reader) (int64, error) {
+	upload, err := u.Upload(id)
+	if err != nil {
+		return 0, err
+	}
+	if upload.Offset != offset {
+		return upload.Offset, repository.ErrUploadOffsetMismatch
+	}
+	contents, err := io.ReadAll(io.LimitReader(chunk, upload.Length-upload.Offset))
+	u.contents[id] = append(u.contents[id], contents...)
+	return upload.Offset + int64(len(contents)), err
+}
+
+func (u *uploadsMock) OpenUpload(id string) (model.Upload, io.ReadCloser, error) {
+	upload, err := u.Upload(id)
+	if err != nil {
+		return model.Upload{}, nil, err
+	}
+	if !upload.Complete() {
+		return model.Upload{}, nil, repository.ErrUploadIncomplete
+	}
+	return upload, io.NopCloser(bytes.NewReader(u.contents[id])), nil
+}
+
+func (u *uploadsMock) DeleteUpload(id string) error {
+	delete(u.uploads, id)
+	delete(u.contents, id)
 	return nil
 }
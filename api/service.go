@@ -1,80 +1,806 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"io"
 
 	"github.com/google/uuid"
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
 	"github.com/scottrmalley/p2p-file-sharing/model"
 	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
 )
 
-var ErrFileSetIncomplete = errors.New("file set incomplete")
+var (
+	ErrRootMismatch   = errors.New("set root does not match the declared root")
+	ErrEmptySet       = errors.New("set has no files")
+	ErrSetQuarantined = errors.New("file set quarantined: its root does not match the declared root")
+
+	// the not found errors come from the persistence layer, they are
+	// repeated here so that clients of the api don't need the repository
+	ErrSetNotFound       = repository.ErrSetNotFound
+	ErrFileNotFound      = repository.ErrFileNotFound
+	ErrFileSetIncomplete = repository.ErrSetIncomplete
+)
 
 type Writer interface {
 	Write(ctx context.Context, file model.File) error
+	WriteBatch(ctx context.Context, files []model.File) error
+	WriteSet(ctx context.Context, declaration model.SetDeclaration) error
+	WriteDeletion(ctx context.Context, deletion model.SetDeletion) error
 }
 type persistence interface {
 	SaveFile(file model.File) error
+	SaveFiles(files []model.File) error
+	SaveStream(metadata model.FileMetadata, hash []byte, size int64, contents io.Reader) error
+	DeclareSet(declaration model.SetDeclaration) error
 	File(setId string, index int) (model.File, error)
-	Files(setId string) ([][]byte, error)
+	OpenFile(setId string, index int) (model.FileMetadata, io.ReadSeekCloser, error)
+	Hashes(setId string) ([][]byte, error)
+	FileSet(setId string) (model.FileSet, error)
+	Indices(setId string) ([]int, error)
+	FilesByHash(hash string) ([]model.FileMetadata, error)
+	DeleteSet(deletion model.SetDeletion) error
+	Deleted(setId string) (bool, error)
+	CheckUpload(upload model.Upload) error
+	Limits() repository.Limits
+}
+
+// uploads stages resumable uploads until they are finalized into a set
+type uploads interface {
+	CreateUpload(upload model.Upload) (model.Upload, error)
+	Upload(id string) (model.Upload, error)
+	AppendUpload(id string, offset int64, chunk io.Reader) (int64, error)
+	OpenUpload(id string) (model.Upload, io.ReadCloser, error)
+	DeleteUpload(id string) error
 }
 
 type Service struct {
 	logger zerolog.Logger
-	writer Writer
-	repo   persistence
+	// nodeId is the peer ID of this node, recorded as the uploader of
+	// files uploaded through the api
+	nodeId string
+	// identity is the libp2p key of this node, which it attests sets with
+	identity p2pcrypto.PrivKey
+	writer   Writer
+	repo     persistence
+	uploads  uploads
 }
 
-func NewService(logger zerolog.Logger, writer Writer, repo persistence) *Service {
+func NewService(
+	logger zerolog.Logger,
+	nodeId string,
+	identity p2pcrypto.PrivKey,
+	writer Writer,
+	repo persistence,
+	uploads uploads,
+) *Service {
 	return &Service{
-		logger: logger,
-		writer: writer,
-		repo:   repo,
+		logger:   logger,
+		nodeId:   nodeId,
+		identity: identity,
+		writer:   writer,
+		repo:     repo,
+		uploads:  uploads,
 	}
 }
 
-func (s *Service) SaveFile(setId uuid.UUID, index, setCount int, file []byte) (string, error) {
-	f := model.File{
-		Metadata: model.FileMetadata{
-			SetId:      setId.String(),
-			SetCount:   setCount,
-			FileNumber: index,
-		},
-		Contents: file,
-	}
-	err := s.writer.Write(context.Background(), f)
+// SaveFile stores a single file of a set, described by its metadata. The
+// root is optional, but if the client provides it, every node will check
+// the set against it once the set is complete. The key hash is optional
+// too, without it (or an owner) the set can never be deleted. The tree is
+// the kind of tree the root is computed with, and can be left empty for
+// positional sets. The uploader, owner and signer are filled in by the
+// service: the principal becomes the owner of a new set, and has to be
+// allowed to change an existing one. A signed file is saved on behalf of
+// the address that signed it instead. The set expires at ExpiresAt if the
+// file creates the set, files added to an existing set keep the expiry it
+// has. Without one the set is kept for as long as the node retains sets.
+func (s *Service) SaveFile(principal string, metadata model.FileMetadata, file []byte) (string, error) {
+	metadata, err := s.fileMetadata(principal, metadata, proof.Hash(file))
 	if err != nil {
 		return "", err
 	}
-
+	f := model.File{Metadata: metadata, Contents: file}
+	// the file is only published once it is saved, so peers never get a
+	// file this node refused
 	if err = s.repo.SaveFile(f); err != nil {
 		return "", err
 	}
+	if err = s.writer.Write(context.Background(), f); err != nil {
+		return "", unavailable(err)
+	}
 
 	return proof.Encode(proof.Hash(file)), nil
 }
 
+// fileMetadata fills in the uploader, owner and signer of a file with the
+// given hash, as described in SaveFile
+func (s *Service) fileMetadata(principal string, metadata model.FileMetadata, hash []byte) (model.FileMetadata, error) {
+	signer, err := recoverSigner(metadata.Signature, metadata.Root, func() []byte {
+		return proof.FileDigest(metadata.SetId, metadata.SetCount, metadata.FileNumber, hash, metadata.Root)
+	})
+	if err != nil {
+		return model.FileMetadata{}, err
+	}
+	principal, err = caller(principal, signer)
+	if err != nil {
+		return model.FileMetadata{}, err
+	}
+	owner, set, err := s.owner(principal, metadata.SetId)
+	if err != nil {
+		return model.FileMetadata{}, err
+	}
+	if set != nil {
+		// only the request that creates the set gives it a TTL, the files
+		// that follow carry the expiry it was given
+		metadata.ExpiresAt = set.ExpiresAt
+	}
+	metadata.Uploader = s.nodeId
+	metadata.Owner = owner
+	metadata.Signer = signer
+	return metadata, nil
+}
+
+// CreateSet declares a set before its files are uploaded, and announces it
+// to peers so every node can check the set against the declared root once
+// it is complete. The uploader, owner and signer are filled in the same way
+// as for SaveFile.
+func (s *Service) CreateSet(principal string, declaration model.SetDeclaration) error {
+	signer, err := recoverSigner(declaration.Signature, declaration.Root, func() []byte {
+		return proof.SetDigest(declaration.SetId, declaration.SetCount, declaration.Root)
+	})
+	if err != nil {
+		return err
+	}
+	principal, err = caller(principal, signer)
+	if err != nil {
+		return err
+	}
+	owner, set, err := s.owner(principal, declaration.SetId)
+	if err != nil {
+		return err
+	}
+	if set != nil {
+		declaration.ExpiresAt = set.ExpiresAt
+	}
+	declaration.Uploader = s.nodeId
+	declaration.Owner = owner
+	declaration.Signer = signer
+	if err := s.repo.DeclareSet(declaration); err != nil {
+		return err
+	}
+	if err := s.writer.WriteSet(context.Background(), declaration); err != nil {
+		return unavailable(err)
+	}
+	return nil
+}
+
+// SaveSet stores a whole set at once. The declared root is checked before
+// anything is stored, then the files are saved in a single transaction and
+// published to peers in as few messages as possible. A signed set is signed
+// once, over its root, and every file carries that signature. The set count
+// and file numbers of the metadata are taken from the files.
+func (s *Service) SaveSet(principal string, metadata model.FileMetadata, files [][]byte) error {
+	if len(files) == 0 {
+		return ErrEmptySet
+	}
+	metadata.SetCount = len(files)
+	signer, err := recoverSigner(metadata.Signature, metadata.Root, func() []byte {
+		return proof.SetDigest(metadata.SetId, metadata.SetCount, metadata.Root)
+	})
+	if err != nil {
+		return err
+	}
+	principal, err = caller(principal, signer)
+	if err != nil {
+		return err
+	}
+	owner, set, err := s.owner(principal, metadata.SetId)
+	if err != nil {
+		return err
+	}
+	if set != nil {
+		metadata.ExpiresAt = set.ExpiresAt
+	}
+	computed, err := metadata.Tree.Root(files)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computed, metadata.Root) {
+		return ErrRootMismatch
+	}
+	metadata.Uploader = s.nodeId
+	metadata.Owner = owner
+	metadata.Signer = signer
+
+	fs := make([]model.File, len(files))
+	for i, file := range files {
+		fs[i] = model.File{Metadata: metadata, Contents: file}
+		fs[i].Metadata.FileNumber = i
+	}
+	// the signature only covers the root, so peers need the proof of every
+	// file to tie its contents to it
+	if signer != "" {
+		hashes := make([][]byte, len(files))
+		for i, file := range files {
+			hashes[i] = proof.Hash(file)
+		}
+		tree, err := metadata.Tree.TreeFromHashes(hashes)
+		if err != nil {
+			return err
+		}
+		for i := range fs {
+			if fs[i].Proof, err = tree.ProofAt(uint64(i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.repo.SaveFiles(fs); err != nil {
+		return err
+	}
+	if err := s.writer.WriteBatch(context.Background(), fs); err != nil {
+		return unavailable(err)
+	}
+	return nil
+}
+
+// DeleteSet purges a set from this node and asks every peer to do the
+// same. The principal has to be allowed to change the set, or for sets
+// without an owner, the key has to match the key hash the set was uploaded
+// with. The set has to be known to this node, so that this can be checked
+// before the deletion is published. A signed deletion is made on behalf of
+// the address that signed it, and is the only kind of deletion of an owned
+// set that peers follow.
+func (s *Service) DeleteSet(principal string, setId uuid.UUID, key, signature []byte) error {
+	var signer string
+	if len(signature) > 0 {
+		var err error
+		if signer, err = proof.Recover(proof.DeletionDigest(setId.String()), signature); err != nil {
+			return errors.Wrap(repository.ErrInvalidSignature, err.Error())
+		}
+	}
+	principal, err := caller(principal, signer)
+	if err != nil {
+		return err
+	}
+	set, err := s.repo.FileSet(setId.String())
+	if err != nil {
+		if !errors.Is(err, ErrSetNotFound) {
+			return err
+		}
+		deleted, derr := s.repo.Deleted(setId.String())
+		if derr != nil {
+			return derr
+		}
+		if !deleted {
+			return err
+		}
+	}
+	deletion := model.SetDeletion{
+		SetId:     setId.String(),
+		Key:       key,
+		Principal: principal,
+		Signature: signature,
+		DeletedBy: s.nodeId,
+	}
+	if err := s.repo.DeleteSet(deletion); err != nil {
+		return err
+	}
+	// peers can only check deletions that are signed, or made with the key
+	// of a set without an owner. Deletions by an authenticated principal
+	// only apply on this node, like its grants.
+	if len(signature) == 0 {
+		if set.Owner != "" || len(key) == 0 {
+			return nil
+		}
+		deletion.Principal = ""
+	}
+	if err := s.writer.WriteDeletion(context.Background(), deletion); err != nil {
+		return unavailable(err)
+	}
+	return nil
+}
+
+// GrantSet allows another principal to add files to and delete the set.
+// Only the owner can grant. Owners that are Ethereum addresses grant by
+// signing the grant, which is announced to peers along with the set so
+// every node can check it. Peers have no way to check grants of other
+// owners, so those only apply on this node.
+func (s *Service) GrantSet(principal string, setId uuid.UUID, grantee string, signature []byte) error {
+	grantee = proof.NormalizeAddress(grantee)
+	var signer string
+	if len(signature) > 0 {
+		var err error
+		if signer, err = proof.Recover(proof.GrantDigest(setId.String(), grantee), signature); err != nil {
+			return errors.Wrap(repository.ErrInvalidSignature, err.Error())
+		}
+	}
+	principal, err := caller(principal, signer)
+	if err != nil {
+		return err
+	}
+	set, err := s.fileSet(setId.String())
+	if err != nil {
+		return err
+	}
+	if set.Owner == "" || set.Owner != principal {
+		return repository.ErrNotSetOwner
+	}
+	declaration := model.SetDeclaration{
+		SetId:    set.SetId,
+		SetCount: set.SetCount,
+		Root:     set.DeclaredRoot,
+		Tree:     set.Tree,
+		Uploader: s.nodeId,
+		Owner:    set.Owner,
+		Grants:   []string{grantee},
+	}
+	if len(signature) > 0 {
+		declaration.GrantSignatures = [][]byte{signature}
+	}
+	if err := s.repo.DeclareSet(declaration); err != nil {
+		return err
+	}
+	if len(signature) == 0 {
+		return nil
+	}
+	if err := s.writer.WriteSet(context.Background(), declaration); err != nil {
+		return unavailable(err)
+	}
+	return nil
+}
+
 func (s *Service) File(setId uuid.UUID, index int) ([]byte, [][]byte, uint64, error) {
-	file, err := s.repo.File(setId.String(), index)
+	path, _, err := s.proof(setId, index)
 	if err != nil {
 		return nil, nil, 0, err
 	}
-	files, err := s.repo.Files(setId.String())
+
+	file, err := s.repo.File(setId.String(), index)
 	if err != nil {
 		return nil, nil, 0, err
 	}
 
-	if len(files) != file.Metadata.SetCount {
-		return nil, nil, 0, ErrFileSetIncomplete
+	return file.Contents, path, uint64(index), nil
+}
+
+// RawFile is an open file, along with its hash and the proof for it
+type RawFile struct {
+	io.ReadSeekCloser
+	Hash  []byte
+	Proof [][]byte
+	Index uint64
+}
+
+// OpenFile works like File, but returns a reader for the file contents so
+// that large files can be streamed back to the client. The caller must
+// close the file.
+func (s *Service) OpenFile(setId uuid.UUID, index int) (*RawFile, error) {
+	path, leaf, err := s.proof(setId, index)
+	if err != nil {
+		return nil, err
 	}
 
-	path, position, err := proof.Proof(
-		files,
-		file.Contents,
+	_, contents, err := s.repo.OpenFile(setId.String(), index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RawFile{
+		ReadSeekCloser: contents,
+		Hash:           leaf,
+		Proof:          path,
+		Index:          uint64(index),
+	}, nil
+}
+
+// Proof returns the proof for a file without its contents
+func (s *Service) Proof(setId uuid.UUID, index int) ([][]byte, uint64, error) {
+	path, _, err := s.proof(setId, index)
+	if err != nil {
+		return nil, 0, err
+	}
+	return path, uint64(index), nil
+}
+
+// FileLocation is a set and index that a file is stored at. The root and
+// proof are only set once the set is complete and not quarantined.
+type FileLocation struct {
+	SetId string
+	Index int
+	Root  []byte
+	Proof [][]byte
+}
+
+// FileByHash looks up a file by its hash, and returns its contents along
+// with every set and index it is stored at
+func (s *Service) FileByHash(hash []byte) ([]byte, []FileLocation, error) {
+	files, err := s.repo.FilesByHash(proof.Encode(hash))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, ErrFileNotFound
+	}
+
+	file, err := s.repo.File(files[0].SetId, files[0].FileNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the same file can appear more than once in a set, so only load the
+	// hashes of each set once
+	hashes := make(map[string][][]byte)
+	locations := make([]FileLocation, len(files))
+	for i, metadata := range files {
+		locations[i] = FileLocation{
+			SetId: metadata.SetId,
+			Index: metadata.FileNumber,
+		}
+		setId, err := uuid.Parse(metadata.SetId)
+		if err != nil {
+			return nil, nil, err
+		}
+		set, err := s.completeSet(setId)
+		if errors.Is(err, ErrFileSetIncomplete) || errors.Is(err, ErrSetQuarantined) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, ok := hashes[set.SetId]; !ok {
+			if hashes[set.SetId], err = s.repo.Hashes(set.SetId); err != nil {
+				return nil, nil, err
+			}
+		}
+		path, err := set.Tree.ProofFromHashes(hashes[set.SetId], uint64(metadata.FileNumber))
+		if err != nil {
+			return nil, nil, err
+		}
+		locations[i].Root = set.Root
+		locations[i].Proof = path
+	}
+	return file.Contents, locations, nil
+}
+
+// FileSet returns the record of the set, along with the indices of the
+// files that have not been received yet
+func (s *Service) FileSet(setId uuid.UUID) (model.FileSet, []int, error) {
+	set, err := s.fileSet(setId.String())
+	if err != nil {
+		return model.FileSet{}, nil, err
+	}
+	if set.Complete() {
+		return set, nil, nil
+	}
+
+	indices, err := s.repo.Indices(setId.String())
+	if err != nil {
+		return model.FileSet{}, nil, err
+	}
+
+	// indices are sorted, so we can walk both at the same time
+	missing := make([]int, 0, set.SetCount-len(indices))
+	next := 0
+	for i := 0; i < set.SetCount; i++ {
+		if next < len(indices) && indices[next] == i {
+			next++
+			continue
+		}
+		missing = append(missing, i)
+	}
+	return set, missing, nil
+}
+
+// Attestation signs the id, count, root and tree of a complete set with the
+// node's identity key, which vouches that this node holds the set with that
+// root. Clients that didn't compute the root themselves can collect
+// attestations from several nodes before trusting it.
+func (s *Service) Attestation(setId uuid.UUID) (model.Attestation, error) {
+	set, err := s.completeSet(setId)
+	if err != nil {
+		return model.Attestation{}, err
+	}
+	node, err := peer.IDFromPrivateKey(s.identity)
+	if err != nil {
+		return model.Attestation{}, errors.Wrap(err, "failed to get node id")
+	}
+	signature, publicKey, err := proof.SignAttestation(
+		proof.AttestationDigest(set.SetId, set.SetCount, set.Root, set.Tree), s.identity,
 	)
+	if err != nil {
+		return model.Attestation{}, err
+	}
+	return model.Attestation{
+		SetId:     set.SetId,
+		SetCount:  set.SetCount,
+		Root:      set.Root,
+		Tree:      set.Tree.OrDefault(),
+		Node:      node.String(),
+		PublicKey: publicKey,
+		Signature: signature,
+	}, nil
+}
 
-	return file.Contents, path, position, nil
+// Manifest returns the record of a complete set, along with the ordered
+// leaf hashes its root is built from
+func (s *Service) Manifest(setId uuid.UUID) (model.FileSet, [][]byte, error) {
+	set, err := s.completeSet(setId)
+	if err != nil {
+		return model.FileSet{}, nil, err
+	}
+	hashes, err := s.repo.Hashes(setId.String())
+	if err != nil {
+		return model.FileSet{}, nil, err
+	}
+	return set, hashes, nil
+}
+
+// WalkSet calls fn with the contents of every file of a complete set, in
+// order. Only one file is loaded at a time.
+func (s *Service) WalkSet(setId uuid.UUID, fn func(index int, contents []byte) error) error {
+	set, err := s.completeSet(setId)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < set.SetCount; i++ {
+		file, err := s.repo.File(setId.String(), i)
+		if err != nil {
+			return err
+		}
+		if err := fn(i, file.Contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateUpload starts a resumable upload of a single file into a set. The
+// contents are sent in chunks with AppendUpload, and only saved into the
+// set by FinalizeUpload. Only the principal that created the upload can
+// carry on with it. A signed upload is signed over the hash of the whole
+// file, which it declares up front, so that it belongs to the address that
+// signed it from the start. The contents have to match the hash once they
+// are complete. The principal, id and offset of the upload are filled in by
+// the service.
+func (s *Service) CreateUpload(principal string, upload model.Upload) (model.Upload, error) {
+	if upload.FileNumber < 0 || upload.FileNumber >= upload.SetCount {
+		return model.Upload{}, errors.Wrapf(repository.ErrIndexOutOfRange, "index %d", upload.FileNumber)
+	}
+	if upload.Length < 0 {
+		return model.Upload{}, invalidArgument("length", errors.New("must not be negative"))
+	}
+	// there is no point in staging contents the node won't store, and the
+	// upload reserves its length once it is created
+	if err := s.repo.CheckUpload(upload); err != nil {
+		return model.Upload{}, err
+	}
+	if len(upload.Signature) > 0 && len(upload.Hash) == 0 {
+		return model.Upload{}, invalidArgument("hash", errors.New("signed uploads have to declare the hash of the file"))
+	}
+	signer, err := recoverSigner(upload.Signature, upload.Root, func() []byte {
+		return proof.FileDigest(upload.SetId, upload.SetCount, upload.FileNumber, upload.Hash, upload.Root)
+	})
+	if err != nil {
+		return model.Upload{}, err
+	}
+	principal, err = caller(principal, signer)
+	if err != nil {
+		return model.Upload{}, err
+	}
+	// the set is checked again when the upload is finalized, but there is
+	// no point in accepting the contents if the principal can't add them
+	if _, _, err := s.owner(principal, upload.SetId); err != nil {
+		return model.Upload{}, err
+	}
+	upload.Principal = principal
+	return s.uploads.CreateUpload(upload)
+}
+
+// Upload returns an upload with its current offset, so that clients can
+// work out where to resume. Signed uploads have to be sent the signature
+// they were created with, since their principal is the address that
+// signed them.
+func (s *Service) Upload(principal string, signature []byte, id string) (model.Upload, error) {
+	upload, err := s.uploads.Upload(id)
+	if err != nil {
+		return model.Upload{}, err
+	}
+	var signer string
+	if len(upload.Signature) > 0 {
+		signer, err = recoverSigner(signature, upload.Root, func() []byte {
+			return proof.FileDigest(upload.SetId, upload.SetCount, upload.FileNumber, upload.Hash, upload.Root)
+		})
+		if err != nil {
+			return model.Upload{}, err
+		}
+		if signer == "" {
+			return model.Upload{}, repository.ErrNotSetOwner
+		}
+	}
+	principal, err = caller(principal, signer)
+	if err != nil {
+		return model.Upload{}, err
+	}
+	if upload.Principal != principal {
+		return model.Upload{}, repository.ErrNotSetOwner
+	}
+	return upload, nil
+}
+
+// AppendUpload writes a chunk at the given offset, and returns the new
+// offset of the upload
+func (s *Service) AppendUpload(principal string, signature []byte, id string, offset int64, chunk io.Reader) (int64, error) {
+	if _, err := s.Upload(principal, signature, id); err != nil {
+		return 0, err
+	}
+	return s.uploads.AppendUpload(id, offset, chunk)
+}
+
+// FinalizeUpload saves a complete upload into its set, the same way as
+// SaveFile, and removes the staged contents. The contents are hashed and
+// then streamed into the blob store from the staged file, they are only
+// loaded to be published once the file is saved, since gossip carries them.
+func (s *Service) FinalizeUpload(principal string, signature []byte, id string) (string, error) {
+	if _, err := s.Upload(principal, signature, id); err != nil {
+		return "", err
+	}
+	upload, r, err := s.uploads.OpenUpload(id)
+	if err != nil {
+		return "", err
+	}
+	hash, size, err := proof.HashReader(r)
+	r.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to hash upload")
+	}
+	if len(upload.Hash) > 0 && !bytes.Equal(hash, upload.Hash) {
+		return "", errors.Wrap(repository.ErrInvalidSignature, "contents do not match the signed hash")
+	}
+
+	metadata, err := s.fileMetadata(
+		upload.Principal,
+		model.FileMetadata{
+			SetId:      upload.SetId,
+			SetCount:   upload.SetCount,
+			FileNumber: upload.FileNumber,
+			Root:       upload.Root,
+			Tree:       upload.Tree,
+			KeyHash:    upload.KeyHash,
+			Signature:  upload.Signature,
+			ExpiresAt:  upload.ExpiresAt,
+		},
+		hash,
+	)
+	if err != nil {
+		return "", err
+	}
+	_, r, err = s.uploads.OpenUpload(id)
+	if err != nil {
+		return "", err
+	}
+	err = s.repo.SaveStream(metadata, hash, size, r)
+	r.Close()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := s.repo.File(metadata.SetId, metadata.FileNumber)
+	if err != nil {
+		return "", err
+	}
+	// the upload is kept until the file is published, so a finalize that
+	// failed to reach peers can be retried
+	if err := s.writer.Write(context.Background(), model.File{Metadata: metadata, Contents: file.Contents}); err != nil {
+		return "", unavailable(err)
+	}
+
+	// the file is saved at this point, so failing to clean up is not worth
+	// failing the request for
+	if err := s.uploads.DeleteUpload(id); err != nil {
+		s.logger.Error().Err(err).Str("upload", id).Msg("failed to delete finalized upload")
+	}
+	return proof.Encode(hash), nil
+}
+
+// Limits returns what the node is willing to store, which request bodies
+// are bounded by
+func (s *Service) Limits() repository.Limits {
+	return s.repo.Limits()
+}
+
+// proof returns the proof for a file, along with the file's own hash
+func (s *Service) proof(setId uuid.UUID, index int) ([][]byte, []byte, error) {
+	set, err := s.completeSet(setId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if index < 0 || index >= set.SetCount {
+		return nil, nil, errors.Wrapf(repository.ErrIndexOutOfRange, "index %d", index)
+	}
+
+	hashes, err := s.repo.Hashes(setId.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := set.Tree.ProofFromHashes(hashes, uint64(index))
+	if err != nil {
+		return nil, nil, err
+	}
+	return path, hashes[index], nil
+}
+
+// completeSet returns the record of the set, as long as it is complete and
+// can be served
+func (s *Service) completeSet(setId uuid.UUID) (model.FileSet, error) {
+	set, err := s.fileSet(setId.String())
+	if err != nil {
+		return model.FileSet{}, err
+	}
+	if !set.Complete() {
+		return model.FileSet{}, ErrFileSetIncomplete
+	}
+	if set.Quarantined {
+		return model.FileSet{}, ErrSetQuarantined
+	}
+	return set, nil
+}
+
+// fileSet returns the record of the set, telling sets that were deleted
+// apart from sets that were never seen
+func (s *Service) fileSet(setId string) (model.FileSet, error) {
+	set, err := s.repo.FileSet(setId)
+	if errors.Is(err, ErrSetNotFound) {
+		deleted, derr := s.repo.Deleted(setId)
+		if derr != nil {
+			return model.FileSet{}, derr
+		}
+		if deleted {
+			return model.FileSet{}, repository.ErrSetDeleted
+		}
+	}
+	return set, err
+}
+
+// recoverSigner returns the address that signed the digest, or nothing for
+// unsigned requests. Signed uploads have to declare the root of their set,
+// since that is what ties the signature to the contents of the set.
+func recoverSigner(signature, root []byte, digest func() []byte) (string, error) {
+	if len(signature) == 0 {
+		return "", nil
+	}
+	if len(root) == 0 {
+		return "", invalidArgument("root", errors.New("signed uploads have to declare the root"))
+	}
+	signer, err := proof.Recover(digest(), signature)
+	if err != nil {
+		return "", errors.Wrap(repository.ErrInvalidSignature, err.Error())
+	}
+	return signer, nil
+}
+
+// owner returns the owner files of the set are stored with, along with the
+// set if it exists already. A new set is owned by the principal creating
+// it, while an existing set keeps its owner, as long as the principal is
+// allowed to change it.
+func (s *Service) owner(principal, setId string) (string, *model.FileSet, error) {
+	set, err := s.fileSet(setId)
+	if errors.Is(err, ErrSetNotFound) {
+		return principal, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !set.Allows(principal) {
+		return "", nil, repository.ErrNotSetOwner
+	}
+	return set.Owner, &set, nil
 }
@@ -5,15 +5,18 @@ import (
 	"testing"
 
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/scottrmalley/p2p-file-sharing/model"
 	"github.com/scottrmalley/p2p-file-sharing/proof"
 )
 
 type ServiceTestSuite struct {
 	suite.Suite
-	repo *persistenceMock
+	repo    *persistenceMock
+	uploads *uploadsMock
 }
 
 func TestServiceTestSuite(t *testing.T) {
@@ -22,6 +25,7 @@ func TestServiceTestSuite(t *testing.T) {
 
 func (s *ServiceTestSuite) SetupTest() {
 	s.repo = newPersistenceMock()
+	s.uploads = newUploadsMock()
 }
 
 func (s *ServiceTestSuite) TestFiles() {
@@ -30,8 +34,11 @@ func (s *ServiceTestSuite) TestFiles() {
 		"it should save a set", func(t *testing.T) {
 			service := NewService(
 				zerolog.New(io.Discard),
+				"node",
+				newIdentityMock(),
 				s.repo,
 				s.repo,
+				s.uploads,
 			)
 			testFiles := [][]byte{
 				[]byte("file1"),
@@ -40,9 +47,8 @@ func (s *ServiceTestSuite) TestFiles() {
 			setId := uuid.New()
 			for i, file := range testFiles {
 				_, err := service.SaveFile(
-					setId,
-					i,
-					len(testFiles),
+					"",
+					model.FileMetadata{SetId: setId.String(), SetCount: len(testFiles), FileNumber: i},
 					file,
 				)
 				s.NoError(err)
@@ -60,8 +66,11 @@ func (s *ServiceTestSuite) TestFiles() {
 		"it should retrieve the file", func(t *testing.T) {
 			service := NewService(
 				zerolog.New(io.Discard),
+				"node",
+				newIdentityMock(),
 				s.repo,
 				s.repo,
+				s.uploads,
 			)
 			testFiles := [][]byte{
 				[]byte("file1"),
@@ -70,9 +79,8 @@ func (s *ServiceTestSuite) TestFiles() {
 			setId := uuid.New()
 			for i, file := range testFiles {
 				_, err := service.SaveFile(
-					setId,
-					i,
-					len(testFiles),
+					"",
+					model.FileMetadata{SetId: setId.String(), SetCount: len(testFiles), FileNumber: i},
 					file,
 				)
 				s.NoError(err)
@@ -84,12 +92,59 @@ func (s *ServiceTestSuite) TestFiles() {
 		},
 	)
 
+	t.Run(
+		"it should not return proofs for incomplete sets", func(t *testing.T) {
+			service := NewService(
+				zerolog.New(io.Discard),
+				"node",
+				newIdentityMock(),
+				s.repo,
+				s.repo,
+				s.uploads,
+			)
+			setId := uuid.New()
+			_, err := service.SaveFile("", model.FileMetadata{SetId: setId.String(), SetCount: 2}, []byte("file1"))
+			s.NoError(err)
+
+			_, _, _, err = service.File(setId, 0)
+			s.ErrorIs(err, ErrFileSetIncomplete)
+		},
+	)
+
+	t.Run(
+		"it should report the missing indices of a set", func(t *testing.T) {
+			service := NewService(
+				zerolog.New(io.Discard),
+				"node",
+				newIdentityMock(),
+				s.repo,
+				s.repo,
+				s.uploads,
+			)
+			setId := uuid.New()
+			for _, i := range []int{3, 0, 1} {
+				_, err := service.SaveFile("", model.FileMetadata{SetId: setId.String(), SetCount: 6, FileNumber: i}, []byte("file"))
+				s.NoError(err)
+			}
+
+			set, missing, err := service.FileSet(setId)
+			s.NoError(err)
+			s.Equal(3, set.Received)
+			s.False(set.Complete())
+			s.Equal([]int{2, 4, 5}, missing)
+			s.Equal([]IndexRange{{From: 2, To: 2}, {From: 4, To: 5}}, ranges(missing))
+		},
+	)
+
 	t.Run(
 		"it should return the correct proof", func(t *testing.T) {
 			service := NewService(
 				zerolog.New(io.Discard),
+				"node",
+				newIdentityMock(),
 				s.repo,
 				s.repo,
+				s.uploads,
 			)
 			testFiles := [][]byte{
 				[]byte("file1"),
@@ -99,9 +154,8 @@ func (s *ServiceTestSuite) TestFiles() {
 			setId := uuid.New()
 			for i, file := range testFiles {
 				_, err := service.SaveFile(
-					setId,
-					i,
-					len(testFiles),
+					"",
+					model.FileMetadata{SetId: setId.String(), SetCount: len(testFiles), FileNumber: i},
 					file,
 				)
 				s.NoError(err)
@@ -119,4 +173,91 @@ func (s *ServiceTestSuite) TestFiles() {
 		},
 	)
 
+	t.Run(
+		"it should stream the file with a valid proof", func(t *testing.T) {
+			service := NewService(
+				zerolog.New(io.Discard),
+				"node",
+				newIdentityMock(),
+				s.repo,
+				s.repo,
+				s.uploads,
+			)
+			testFiles := [][]byte{
+				[]byte("file1"),
+				[]byte("file2"),
+				[]byte("file3"),
+			}
+
+			setId := uuid.New()
+			for i, file := range testFiles {
+				_, err := service.SaveFile(
+					"",
+					model.FileMetadata{SetId: setId.String(), SetCount: len(testFiles), FileNumber: i},
+					file,
+				)
+				s.NoError(err)
+			}
+
+			expectedRoot, err := proof.Root(testFiles)
+			s.NoError(err)
+
+			contents, err := service.OpenFile(setId, 2)
+			s.NoError(err)
+			defer contents.Close()
+
+			file, err := io.ReadAll(contents)
+			s.NoError(err)
+			s.Equal(testFiles[2], file)
+			s.Equal(proof.Hash(file), contents.Hash)
+
+			verified, err := proof.Verify(file, contents.Proof, contents.Index, expectedRoot)
+			s.NoError(err)
+			s.True(verified)
+		},
+	)
+
+	t.Run(
+		"it should save files before publishing them", func(t *testing.T) {
+			service := NewService(
+				zerolog.New(io.Discard),
+				"node",
+				newIdentityMock(),
+				s.repo,
+				s.repo,
+				s.uploads,
+			)
+			s.repo.offline = true
+			defer func() { s.repo.offline = false }()
+
+			setId := uuid.New()
+			_, err := service.SaveFile("", model.FileMetadata{SetId: setId.String(), SetCount: 1}, []byte("file1"))
+			s.ErrorIs(err, ErrUnavailable)
+
+			// the request can be retried, saving the same file again is fine
+			files, err := s.repo.Files(setId.String())
+			s.NoError(err)
+			s.Equal([][]byte{[]byte("file1")}, files)
+		},
+	)
+
+	t.Run(
+		"it should return errors from looking up deleted sets", func(t *testing.T) {
+			service := NewService(
+				zerolog.New(io.Discard),
+				"node",
+				newIdentityMock(),
+				s.repo,
+				s.repo,
+				s.uploads,
+			)
+			s.repo.tombstonesErr = errors.New("database is gone")
+			defer func() { s.repo.tombstonesErr = nil }()
+
+			_, _, err := service.FileSet(uuid.New())
+			s.ErrorIs(err, s.repo.tombstonesErr)
+			err = service.DeleteSet("", uuid.New(), []byte("key"), nil)
+			s.ErrorIs(err, s.repo.tombstonesErr)
+		},
+	)
 }
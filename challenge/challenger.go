@@ -0,0 +1,284 @@
+package challenge
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+const (
+	// nonceSize is the size of the nonce sent with every challenge
+	nonceSize = 32
+
+	// passReward and failPenalty move the score of a peer, failing costs a
+	// lot more than passing earns so that a peer can't hide lost files
+	// behind a good record
+	passReward  = 1
+	failPenalty = -10
+
+	// historyLimit is how many results are returned per peer
+	historyLimit = 50
+)
+
+var (
+	ErrNoPeers     = errors.New("no peers to challenge")
+	ErrNothingHeld = errors.New("no complete sets to challenge peers with")
+	ErrBadNonce    = errors.New("challenge nonce has the wrong size")
+)
+
+type persistence interface {
+	RandomCompleteSet(before time.Time) (model.FileSet, error)
+	FileSet(setId string) (model.FileSet, error)
+	File(setId string, index int) (model.File, error)
+	Hashes(setId string) ([][]byte, error)
+	RecordChallenge(result model.ChallengeResult, delta int) (model.PeerScore, error)
+	PeerScores() ([]model.PeerScore, error)
+	Challenges(peer string, limit int) ([]model.ChallengeResult, error)
+}
+
+// Network sends challenges to peers, networking.ChallengeStream
+// implements it over libp2p streams
+type Network interface {
+	Send(ctx context.Context, peer string, challenge model.Challenge) (model.ChallengeResponse, error)
+	Peers() []string
+}
+
+// Challenger checks that peers still hold the sets they replicated. Every
+// interval it picks a random peer and a random file of a set it holds
+// itself, and asks the peer to hash the file with a fresh nonce and prove
+// the file belongs to the root of the set. Since we hold the file too, we
+// can check both. Peers that fail, or don't answer, are scored down.
+type Challenger struct {
+	logger   zerolog.Logger
+	repo     persistence
+	network  Network
+	interval time.Duration
+}
+
+func NewChallenger(
+	logger zerolog.Logger,
+	repo persistence,
+	network Network,
+	interval time.Duration,
+) *Challenger {
+	return &Challenger{
+		logger:   logger,
+		repo:     repo,
+		network:  network,
+		interval: interval,
+	}
+}
+
+// Run challenges a random peer every interval, it returns a func() error in
+// order to be easily used with errgroup.Group
+func (c *Challenger) Run(ctx context.Context) func() error {
+	return func() error {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				_, err := c.ChallengeRandom(ctx)
+				if errors.Is(err, ErrNoPeers) || errors.Is(err, ErrNothingHeld) {
+					c.logger.Debug().Err(err).Msg("skipping challenge")
+					continue
+				}
+				if err != nil && ctx.Err() == nil {
+					c.logger.Error().Err(err).Msg("failed to challenge peer")
+				}
+			}
+		}
+	}
+}
+
+// ChallengeRandom challenges a random peer with a random file. Sets that
+// completed within the last interval are left out, peers may still be
+// receiving them.
+func (c *Challenger) ChallengeRandom(ctx context.Context) (model.ChallengeResult, error) {
+	peers := c.network.Peers()
+	if len(peers) == 0 {
+		return model.ChallengeResult{}, ErrNoPeers
+	}
+	set, err := c.repo.RandomCompleteSet(time.Now().Add(-c.interval))
+	if errors.Is(err, repository.ErrSetNotFound) {
+		return model.ChallengeResult{}, ErrNothingHeld
+	}
+	if err != nil {
+		return model.ChallengeResult{}, err
+	}
+	peer, err := randomInt(len(peers))
+	if err != nil {
+		return model.ChallengeResult{}, err
+	}
+	index, err := randomInt(set.SetCount)
+	if err != nil {
+		return model.ChallengeResult{}, err
+	}
+	return c.Challenge(ctx, peers[peer], set, index)
+}
+
+// Challenge asks the peer to prove it holds the file at index of the set,
+// and records the result
+func (c *Challenger) Challenge(ctx context.Context, peer string, set model.FileSet, index int) (model.ChallengeResult, error) {
+	file, err := c.repo.File(set.SetId, index)
+	if err != nil {
+		return model.ChallengeResult{}, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return model.ChallengeResult{}, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	start := time.Now()
+	response, err := c.network.Send(ctx, peer, model.Challenge{SetId: set.SetId, Index: index, Nonce: nonce})
+	if ctx.Err() != nil {
+		// we are shutting down, which is not the peer's fault
+		return model.ChallengeResult{}, ctx.Err()
+	}
+	result := model.ChallengeResult{
+		Peer:         peer,
+		SetId:        set.SetId,
+		Index:        index,
+		Duration:     time.Since(start),
+		ChallengedAt: start.UTC(),
+	}
+	missing := err == nil && unheld(response) && c.recent(set)
+	switch {
+	case err != nil:
+		result.Reason = err.Error()
+	case missing:
+		result.Reason = "peer does not hold the set: " + response.Error
+	default:
+		result.Reason = check(set, index, file.Contents, nonce, response)
+	}
+	result.Passed = result.Reason == ""
+
+	delta := passReward
+	switch {
+	case result.Passed:
+	case missing:
+		// the set may not have reached the peer yet, or the peer may have
+		// seen it deleted before we did, neither of which it can be blamed
+		// for while the set is still propagating
+		delta = 0
+		c.logger.Debug().
+			Str("peer", peer).
+			Str("set-id", set.SetId).
+			Str("reason", result.Reason).
+			Msg("peer does not hold the challenged set")
+	default:
+		delta = failPenalty
+		c.logger.Warn().
+			Str("peer", peer).
+			Str("set-id", set.SetId).
+			Int("index", index).
+			Str("reason", result.Reason).
+			Msg("peer failed storage challenge")
+	}
+	score, err := c.repo.RecordChallenge(result, delta)
+	if err != nil {
+		return model.ChallengeResult{}, err
+	}
+	c.logger.Debug().Str("peer", peer).Bool("passed", result.Passed).Int("score", score.Score).Msg("challenged peer")
+	return result, nil
+}
+
+// unheld reports whether the peer answered that it doesn't have the set at
+// all, rather than failing to prove it holds the file. Errors only cross the
+// network as text, so the answer is matched by the message of the error.
+func unheld(response model.ChallengeResponse) bool {
+	return strings.HasSuffix(response.Error, repository.ErrSetNotFound.Error()) ||
+		strings.HasSuffix(response.Error, repository.ErrSetDeleted.Error())
+}
+
+// recent reports whether the set completed within the last interval. Only
+// then can a peer be excused for not having it, older sets have had the
+// time to reach every peer.
+func (c *Challenger) recent(set model.FileSet) bool {
+	return set.CompletedAt != nil && set.CompletedAt.After(time.Now().Add(-c.interval))
+}
+
+// check returns why the response does not prove the peer holds the file,
+// or nothing if it does
+func check(set model.FileSet, index int, contents, nonce []byte, response model.ChallengeResponse) string {
+	if response.Error != "" {
+		return "peer could not answer: " + response.Error
+	}
+	if !bytes.Equal(response.Leaf, proof.Hash(contents)) {
+		return "leaf does not match the file"
+	}
+	// the leaf is the hash of the contents, which is what proofs are
+	// verified with
+	ok, err := set.Tree.Verify(contents, response.Proof, uint64(index), set.Root)
+	if err != nil || !ok {
+		return "proof does not match the set root"
+	}
+	if !bytes.Equal(response.Hash, proof.ChallengeHash(nonce, contents)) {
+		return "hash does not match the file"
+	}
+	return ""
+}
+
+// Respond answers a challenge from a peer
+func (c *Challenger) Respond(challenge model.Challenge) (model.ChallengeResponse, error) {
+	if len(challenge.Nonce) != nonceSize {
+		return model.ChallengeResponse{}, ErrBadNonce
+	}
+	set, err := c.repo.FileSet(challenge.SetId)
+	if err != nil {
+		return model.ChallengeResponse{}, err
+	}
+	if !set.Complete() || set.Quarantined {
+		return model.ChallengeResponse{}, repository.ErrSetIncomplete
+	}
+	if challenge.Index < 0 || challenge.Index >= set.SetCount {
+		return model.ChallengeResponse{}, repository.ErrIndexOutOfRange
+	}
+	file, err := c.repo.File(challenge.SetId, challenge.Index)
+	if err != nil {
+		return model.ChallengeResponse{}, err
+	}
+	hashes, err := c.repo.Hashes(challenge.SetId)
+	if err != nil {
+		return model.ChallengeResponse{}, err
+	}
+	path, err := set.Tree.ProofFromHashes(hashes, uint64(challenge.Index))
+	if err != nil {
+		return model.ChallengeResponse{}, err
+	}
+	return model.ChallengeResponse{
+		Hash:  proof.ChallengeHash(challenge.Nonce, file.Contents),
+		Leaf:  proof.Hash(file.Contents),
+		Proof: path,
+	}, nil
+}
+
+// Scores returns the score of every peer we challenged, lowest first
+func (c *Challenger) Scores() ([]model.PeerScore, error) {
+	return c.repo.PeerScores()
+}
+
+// History returns the latest results of challenging the peer, newest first
+func (c *Challenger) History(peer string) ([]model.ChallengeResult, error) {
+	return c.repo.Challenges(peer, historyLimit)
+}
+
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to pick at random")
+	}
+	return int(i.Int64()), nil
+}
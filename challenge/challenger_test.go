@@ -0,0 +1,281 @@
+package challenge
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/networking"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+type ChallengerTestSuite struct {
+	suite.Suite
+}
+
+func TestChallenger(t *testing.T) {
+	suite.Run(t, new(ChallengerTestSuite))
+}
+
+type node struct {
+	id         string
+	repo       *repository.Files
+	challenger *Challenger
+}
+
+// newNodes returns n nodes connected over an in-memory libp2p network,
+// each answering challenges from its own repository
+func (s *ChallengerTestSuite) newNodes(n int) []node {
+	mn, err := mocknet.FullMeshConnected(n)
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = mn.Close() })
+
+	nodes := make([]node, n)
+	for i, h := range mn.Hosts() {
+		stream := networking.NewChallengeStream(zerolog.New(io.Discard), h, 5*time.Second)
+		repo := s.newRepo()
+		challenger := NewChallenger(zerolog.New(io.Discard), repo, stream, time.Millisecond)
+		stream.Handle(challenger.Respond)
+		nodes[i] = node{id: h.ID().String(), repo: repo, challenger: challenger}
+	}
+	return nodes
+}
+
+func (s *ChallengerTestSuite) newRepo() *repository.Files {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	s.Require().NoError(err)
+	sqlDb, err := db.DB()
+	s.Require().NoError(err)
+	sqlDb.SetMaxOpenConns(1)
+	s.T().Cleanup(func() { _ = sqlDb.Close() })
+
+	blobs, err := repository.NewDiskBlobStore(s.T().TempDir())
+	s.Require().NoError(err)
+	repo := repository.NewFiles(zerolog.New(io.Discard), db, blobs)
+	s.Require().NoError(repo.Migrate())
+	return repo
+}
+
+func (s *ChallengerTestSuite) saveSet(setId string, files [][]byte, repos ...*repository.Files) model.FileSet {
+	for _, repo := range repos {
+		for i, contents := range files {
+			s.Require().NoError(
+				repo.SaveFile(
+					model.File{
+						Metadata: model.FileMetadata{SetId: setId, SetCount: len(files), FileNumber: i},
+						Contents: contents,
+					},
+				),
+			)
+		}
+	}
+	set, err := repos[0].FileSet(setId)
+	s.Require().NoError(err)
+	return set
+}
+
+// forged answers every challenge with the given response
+type forged struct {
+	peer     string
+	response model.ChallengeResponse
+}
+
+func (f *forged) Send(context.Context, string, model.Challenge) (model.ChallengeResponse, error) {
+	return f.response, nil
+}
+
+func (f *forged) Peers() []string {
+	return []string{f.peer}
+}
+
+func (s *ChallengerTestSuite) TestChallenge() {
+	t := s.T()
+	ctx := context.Background()
+	files := [][]byte{[]byte("file1"), []byte("file2"), []byte("file3")}
+
+	t.Run(
+		"it should pass a peer that holds the file", func(t *testing.T) {
+			nodes := s.newNodes(2)
+			set := s.saveSet(uuid.NewString(), files, nodes[0].repo, nodes[1].repo)
+
+			for index := range files {
+				result, err := nodes[0].challenger.Challenge(ctx, nodes[1].id, set, index)
+				require.NoError(t, err)
+				require.True(t, result.Passed, result.Reason)
+			}
+
+			scores, err := nodes[0].challenger.Scores()
+			require.NoError(t, err)
+			require.Len(t, scores, 1)
+			require.Equal(t, nodes[1].id, scores[0].Peer)
+			require.Equal(t, 3, scores[0].Score)
+			require.Equal(t, 3, scores[0].Passed)
+		},
+	)
+
+	t.Run(
+		"it should pass sorted sets", func(t *testing.T) {
+			nodes := s.newNodes(2)
+			setId := uuid.NewString()
+			for _, n := range nodes {
+				require.NoError(t, n.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: len(files), Tree: proof.ModeSorted}))
+			}
+			set := s.saveSet(setId, files, nodes[0].repo, nodes[1].repo)
+			require.Equal(t, proof.ModeSorted, set.Tree)
+
+			result, err := nodes[0].challenger.Challenge(ctx, nodes[1].id, set, 2)
+			require.NoError(t, err)
+			require.True(t, result.Passed, result.Reason)
+		},
+	)
+
+	t.Run(
+		"it should score down a peer that lost files of the set", func(t *testing.T) {
+			nodes := s.newNodes(2)
+			setId := uuid.NewString()
+			set := s.saveSet(setId, files, nodes[0].repo)
+			for i, contents := range files[:2] {
+				require.NoError(
+					t, nodes[1].repo.SaveFile(
+						model.File{
+							Metadata: model.FileMetadata{SetId: setId, SetCount: len(files), FileNumber: i},
+							Contents: contents,
+						},
+					),
+				)
+			}
+
+			result, err := nodes[0].challenger.Challenge(ctx, nodes[1].id, set, 0)
+			require.NoError(t, err)
+			require.False(t, result.Passed)
+			require.Contains(t, result.Reason, "peer could not answer")
+
+			history, err := nodes[0].challenger.History(nodes[1].id)
+			require.NoError(t, err)
+			require.Len(t, history, 1)
+			require.Equal(t, result.Reason, history[0].Reason)
+
+			scores, err := nodes[0].challenger.Scores()
+			require.NoError(t, err)
+			require.Equal(t, failPenalty, scores[0].Score)
+			require.Equal(t, 1, scores[0].Failed)
+		},
+	)
+
+	t.Run(
+		"it should not score down a peer that doesn't have a set that just completed", func(t *testing.T) {
+			nodes := s.newNodes(2)
+			challenger := NewChallenger(zerolog.New(io.Discard), nodes[0].repo, nodes[0].challenger.network, time.Minute)
+			set := s.saveSet(uuid.NewString(), files, nodes[0].repo)
+
+			// the set hasn't reached the peer yet
+			result, err := challenger.Challenge(ctx, nodes[1].id, set, 0)
+			require.NoError(t, err)
+			require.False(t, result.Passed)
+			require.Contains(t, result.Reason, "peer does not hold the set")
+
+			// the peer saw the set deleted before we did
+			deleted := s.saveSet(uuid.NewString(), files, nodes[0].repo)
+			network := &forged{peer: nodes[1].id, response: model.ChallengeResponse{Error: repository.ErrSetDeleted.Error()}}
+			result, err = NewChallenger(zerolog.New(io.Discard), nodes[0].repo, network, time.Minute).
+				Challenge(ctx, nodes[1].id, deleted, 0)
+			require.NoError(t, err)
+			require.False(t, result.Passed)
+
+			history, err := challenger.History(nodes[1].id)
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+
+			scores, err := challenger.Scores()
+			require.NoError(t, err)
+			require.Equal(t, 0, scores[0].Score)
+			require.Equal(t, 0, scores[0].Failed)
+		},
+	)
+
+	t.Run(
+		"it should score down a peer that doesn't have a set that completed before the last interval", func(t *testing.T) {
+			nodes := s.newNodes(2)
+			challenger := NewChallenger(zerolog.New(io.Discard), nodes[0].repo, nodes[0].challenger.network, time.Minute)
+			set := s.saveSet(uuid.NewString(), files, nodes[0].repo)
+			completedAt := time.Now().Add(-time.Hour)
+			set.CompletedAt = &completedAt
+
+			result, err := challenger.Challenge(ctx, nodes[1].id, set, 0)
+			require.NoError(t, err)
+			require.False(t, result.Passed)
+			require.Contains(t, result.Reason, "peer could not answer")
+
+			scores, err := challenger.Scores()
+			require.NoError(t, err)
+			require.Equal(t, failPenalty, scores[0].Score)
+			require.Equal(t, 1, scores[0].Failed)
+		},
+	)
+
+	t.Run(
+		"it should fail forged answers", func(t *testing.T) {
+			repo := s.newRepo()
+			set := s.saveSet(uuid.NewString(), files, repo)
+			honest, err := NewChallenger(zerolog.New(io.Discard), repo, nil, time.Minute).Respond(
+				model.Challenge{SetId: set.SetId, Index: 1, Nonce: make([]byte, nonceSize)},
+			)
+			require.NoError(t, err)
+
+			// the peer kept the hashes and proofs, but not the contents, so it
+			// can't hash them with a fresh nonce
+			network := &forged{peer: "peer", response: honest}
+			challenger := NewChallenger(zerolog.New(io.Discard), repo, network, time.Minute)
+			result, err := challenger.Challenge(ctx, "peer", set, 1)
+			require.NoError(t, err)
+			require.False(t, result.Passed)
+			require.Equal(t, "hash does not match the file", result.Reason)
+
+			// a proof for a different index
+			result, err = challenger.Challenge(ctx, "peer", set, 0)
+			require.NoError(t, err)
+			require.False(t, result.Passed)
+			require.Equal(t, "leaf does not match the file", result.Reason)
+
+			network.response.Proof = network.response.Proof[1:]
+			result, err = challenger.Challenge(ctx, "peer", set, 1)
+			require.NoError(t, err)
+			require.Equal(t, "proof does not match the set root", result.Reason)
+		},
+	)
+
+	t.Run(
+		"it should challenge random peers with complete sets", func(t *testing.T) {
+			nodes := s.newNodes(2)
+			// peers only show up once they told us they answer challenges
+			require.Eventually(
+				t, func() bool {
+					_, err := nodes[0].challenger.ChallengeRandom(ctx)
+					return errors.Is(err, ErrNothingHeld)
+				}, 5*time.Second, 10*time.Millisecond,
+			)
+
+			s.saveSet(uuid.NewString(), files, nodes[0].repo, nodes[1].repo)
+			// sets are only challenged once peers had time to receive them
+			time.Sleep(5 * time.Millisecond)
+			require.Eventually(
+				t, func() bool {
+					result, err := nodes[0].challenger.ChallengeRandom(ctx)
+					return err == nil && result.Passed && result.Peer == nodes[1].id
+				}, 5*time.Second, 50*time.Millisecond,
+			)
+		},
+	)
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"crypto/rand"
+
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 
@@ -11,6 +13,9 @@ import (
 type Persistence interface {
 	SetFileSet(setId string, root []byte, count int) error
 	FileSet(setId string) ([]byte, int, error)
+	SetKey(setId string, key []byte) error
+	Key(setId string) ([]byte, error)
+	RemoveFileSet(setId string) error
 	Sets() ([]string, error)
 }
 
@@ -32,9 +37,17 @@ func NewClient(persistence Persistence, apiClient *api.Client) *Client {
 
 // CreateSet will not assume the provided fileset is complete
 // the api will only return valid proofs once the number of files uploaded
-// matches the setCount
+// matches the setCount. The root is declared to the network, so that
+// every node checks the set against it once it is complete.
 func (c *Client) CreateSet(root []byte, setCount int) (string, error) {
 	setId := uuid.New()
+	key, err := c.newKey(setId.String())
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.apiClient.CreateSet(setId.String(), setCount, root, proof.Hash(key)); err != nil {
+		return "", err
+	}
 	if err := c.persistence.SetFileSet(setId.String(), root, setCount); err != nil {
 		return "", err
 	}
@@ -42,48 +55,43 @@ func (c *Client) CreateSet(root []byte, setCount int) (string, error) {
 }
 
 // AddFile will add a file to the file set. If the file set is complete
-// it will return an error
+// it will return an error. Large files are sent as resumable uploads, so
+// an interrupted upload doesn't have to start over.
 func (c *Client) AddFile(setId string, index int, file []byte) error {
-	_, count, err := c.persistence.FileSet(setId)
+	root, count, err := c.persistence.FileSet(setId)
 	if err != nil {
 		return err
 	}
 	if count <= index {
 		return errors.Errorf("index %d out of range for file set %s", index, setId)
 	}
-	if _, err := c.apiClient.PostFile(
-		&api.PostFileRequest{
-			SetId:    setId,
-			SetCount: count,
-			Index:    index,
-			Content:  proof.Encode(file),
-		},
-	); err != nil {
+	// send the root and key hash along with every file, so nodes that
+	// missed the set declaration still learn them
+	keyHash := c.keyHash(setId)
+	if len(file) > resumableThreshold {
+		return c.uploadFile(setId, index, count, root, keyHash, file)
+	}
+	if _, err := c.apiClient.PostFileRaw(setId, index, count, root, keyHash, file); err != nil {
 		return err
 	}
 	return nil
 }
 
-// PostFiles will assume the file set provided is complete
+// PostFiles will assume the file set provided is complete, and uploads it
+// in a single request
 func (c *Client) PostFiles(files [][]byte) (string, error) {
 	setId := uuid.New()
-	for i, file := range files {
-		if _, err := c.apiClient.PostFile(
-			&api.PostFileRequest{
-				SetId:    setId.String(),
-				SetCount: len(files),
-				Index:    i,
-				Content:  proof.Encode(file),
-			},
-		); err != nil {
-			return "", err
-		}
-	}
-
 	root, err := proof.Root(files)
 	if err != nil {
 		return "", err
 	}
+	key, err := c.newKey(setId.String())
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.apiClient.PostSet(setId.String(), root, proof.Hash(key), files); err != nil {
+		return "", err
+	}
 	if err := c.persistence.SetFileSet(setId.String(), root, len(files)); err != nil {
 		return "", err
 	}
@@ -99,18 +107,14 @@ func (c *Client) GetFile(setId string, index int) ([]byte, error) {
 	if count <= index {
 		return nil, errors.Errorf("index %d out of range for file set %s", index, setId)
 	}
-	out, err := c.apiClient.GetFile(
+	file, p, err := c.apiClient.GetFileRaw(
 		setId,
 		index,
 	)
 	if err != nil {
 		return nil, err
 	}
-	hashes, position, err := decodeProofResponse(out.Proof)
-	if err != nil {
-		return nil, err
-	}
-	file, err := proof.Decode(out.File)
+	hashes, position, err := decodeProofResponse(*p)
 	if err != nil {
 		return nil, err
 	}
@@ -122,6 +126,19 @@ func (c *Client) GetFile(setId string, index int) ([]byte, error) {
 	return file, nil
 }
 
+// DeleteSet asks the network to purge a set, using the key the set was
+// created with
+func (c *Client) DeleteSet(setId string) error {
+	key, err := c.persistence.Key(setId)
+	if err != nil {
+		return err
+	}
+	if _, err := c.apiClient.DeleteSet(setId, key); err != nil {
+		return err
+	}
+	return c.persistence.RemoveFileSet(setId)
+}
+
 func (c *Client) Sets() ([]string, error) {
 	return c.persistence.Sets()
 }
@@ -141,3 +158,25 @@ func decodeProofResponse(in api.ProofResponse) (hashes [][]byte, index uint64, e
 	}
 	return hashes, in.Index, nil
 }
+
+// newKey generates and stores the key that is needed to delete the set,
+// nodes only ever see its hash until the set is deleted
+func (c *Client) newKey(setId string) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := c.persistence.SetKey(setId, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// keyHash returns the hash of the set key, or nil if we don't hold the key
+func (c *Client) keyHash(setId string) []byte {
+	key, err := c.persistence.Key(setId)
+	if err != nil {
+		return nil
+	}
+	return proof.Hash(key)
+}
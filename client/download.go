@@ -0,0 +1,156 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/scottrmalley/p2p-file-sharing/api"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// DownloadSet downloads a whole set as a single archive and writes each file
+// to dir, named by its index. The manifest in the archive is checked against
+// the root stored in the persistence layer, and every file is checked
+// against the manifest before it is written.
+func (c *Client) DownloadSet(setId string, dir string, compression string) error {
+	root, count, err := c.persistence.FileSet(setId)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	archive, err := c.apiClient.GetSetArchive(setId, compression)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	tr := tar.NewReader(archive)
+	var leaves [][]byte
+	written := make(map[int]bool, count)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read set archive")
+		}
+
+		// the manifest always comes first, so we can check each file as
+		// soon as we read it
+		if header.Name == api.ArchiveManifestName {
+			if leaves, err = readManifest(tr, root, count); err != nil {
+				return err
+			}
+			continue
+		}
+		if leaves == nil {
+			return errors.New("set archive is missing its manifest")
+		}
+
+		index, err := strconv.Atoi(strings.TrimPrefix(header.Name, api.ArchiveFilePrefix))
+		if err != nil || !strings.HasPrefix(header.Name, api.ArchiveFilePrefix) || index < 0 || index >= count {
+			return errors.Errorf("unexpected entry %q in set archive", header.Name)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			return errors.Wrap(err, "failed to read set archive")
+		}
+		if !bytes.Equal(proof.Hash(contents), leaves[index]) {
+			return errors.Errorf("file %d does not match the set manifest", index)
+		}
+		if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(index)), contents, 0o644); err != nil {
+			return err
+		}
+		written[index] = true
+	}
+
+	if len(written) != count {
+		return errors.Errorf("set archive only contained %d of %d files", len(written), count)
+	}
+	return nil
+}
+
+// AuditSet fetches the manifest of a set from the node and rebuilds the
+// root from its leaf hashes. It returns an error if the node reports a
+// different root, or leaves that don't produce the root we stored.
+func (c *Client) AuditSet(setId string) error {
+	root, count, err := c.persistence.FileSet(setId)
+	if err != nil {
+		return err
+	}
+	manifest, err := c.apiClient.GetManifest(setId)
+	if err != nil {
+		return err
+	}
+	reported, err := proof.Decode(manifest.Root)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(reported, root) {
+		return errors.New("node reports a different root for the set")
+	}
+	_, err = checkManifest(manifest, root, count)
+	return err
+}
+
+// readManifest decodes the archive manifest, and makes sure the leaves it
+// lists actually produce the root we expect
+func readManifest(r io.Reader, root []byte, count int) ([][]byte, error) {
+	var manifest api.ManifestResponse
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to decode set manifest")
+	}
+	leaves, err := checkManifest(&manifest, root, count)
+	if err != nil {
+		return nil, err
+	}
+	return leaves, nil
+}
+
+// checkManifest rebuilds the root from the leaves in the manifest, with the
+// tree the set was uploaded with, and compares it to the root we stored
+func checkManifest(manifest *api.ManifestResponse, root []byte, count int) ([][]byte, error) {
+	if manifest.SetCount != count || len(manifest.Leaves) != count {
+		return nil, errors.Errorf("manifest lists %d files, expected %d", len(manifest.Leaves), count)
+	}
+	leaves, err := decodeHashes(manifest.Leaves)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := proof.ParseMode(manifest.Tree)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := mode.TreeFromHashes(leaves)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tree.Root(), root) {
+		return nil, errors.New("manifest does not match the stored root")
+	}
+	return leaves, nil
+}
+
+func decodeHashes(in []string) ([][]byte, error) {
+	out := make([][]byte, len(in))
+	for i, hash := range in {
+		var err error
+		out[i], err = proof.Decode(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
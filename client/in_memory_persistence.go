@@ -7,12 +7,14 @@ var ErrNotFound = errors.New("not found")
 type InMemoryPersistence struct {
 	fileSets map[string][]byte
 	setSizes map[string]int
+	setKeys  map[string][]byte
 }
 
 func NewInMemoryPersistence() *InMemoryPersistence {
 	return &InMemoryPersistence{
 		fileSets: make(map[string][]byte),
 		setSizes: make(map[string]int),
+		setKeys:  make(map[string][]byte),
 	}
 }
 
@@ -34,6 +36,26 @@ func (p *InMemoryPersistence) FileSet(setId string) ([]byte, int, error) {
 	return root, count, nil
 }
 
+func (p *InMemoryPersistence) SetKey(setId string, key []byte) error {
+	p.setKeys[setId] = key
+	return nil
+}
+
+func (p *InMemoryPersistence) Key(setId string) ([]byte, error) {
+	key, ok := p.setKeys[setId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return key, nil
+}
+
+func (p *InMemoryPersistence) RemoveFileSet(setId string) error {
+	delete(p.fileSets, setId)
+	delete(p.setSizes, setId)
+	delete(p.setKeys, setId)
+	return nil
+}
+
 func (p *InMemoryPersistence) Sets() ([]string, error) {
 	var out []string
 	for k := range p.fileSets {
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+
+	"github.com/scottrmalley/p2p-file-sharing/api"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// ErrNoQuorum is returned when not enough distinct nodes attest to the same
+// root of a set
+var ErrNoQuorum = errors.New("not enough nodes attest to the same set root")
+
+// TrustSet learns the root of a set that the client did not upload itself,
+// by collecting attestations from the nodes. Every attestation is verified,
+// and the root is only trusted, and stored like the root of a set the client
+// uploaded, once quorum distinct nodes have signed the same root, count and
+// tree.
+// Nodes that fail to answer are skipped. Sets the client already holds the
+// root of are returned as they are.
+func (c *Client) TrustSet(setId string, nodes []*api.Client, quorum int) ([]byte, error) {
+	if root, _, err := c.persistence.FileSet(setId); err == nil {
+		return root, nil
+	}
+	if quorum < 1 {
+		return nil, errors.New("quorum must be at least 1")
+	}
+
+	type claim struct {
+		root  []byte
+		count int
+		tree  proof.Mode
+		// signers holds the peer IDs that attested to the claim, so a node
+		// reached through several clients is only counted once
+		signers map[string]bool
+	}
+	var claims []*claim
+	for _, node := range nodes {
+		attestation, err := node.GetAttestation(setId)
+		if err != nil {
+			continue
+		}
+		if attestation.SetId != setId {
+			continue
+		}
+		if err := attestation.Verify(); err != nil {
+			continue
+		}
+		root, err := proof.Decode(attestation.Root)
+		if err != nil {
+			continue
+		}
+		tree, err := proof.ParseMode(attestation.Tree)
+		if err != nil {
+			continue
+		}
+
+		var match *claim
+		for _, existing := range claims {
+			if existing.count == attestation.SetCount && existing.tree == tree && bytes.Equal(existing.root, root) {
+				match = existing
+				break
+			}
+		}
+		if match == nil {
+			match = &claim{root: root, count: attestation.SetCount, tree: tree, signers: make(map[string]bool)}
+			claims = append(claims, match)
+		}
+		match.signers[attestation.Node] = true
+
+		if len(match.signers) >= quorum {
+			if err := c.persistence.SetFileSet(setId, match.root, match.count); err != nil {
+				return nil, err
+			}
+			return match.root, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrNoQuorum, "set %s", setId)
+}
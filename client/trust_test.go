@@ -0,0 +1,171 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/scottrmalley/p2p-file-sharing/api"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+type TrustTestSuite struct {
+	suite.Suite
+
+	setId string
+	root  []byte
+}
+
+func TestTrust(t *testing.T) {
+	suite.Run(t, new(TrustTestSuite))
+}
+
+func (s *TrustTestSuite) SetupTest() {
+	s.setId = uuid.NewString()
+	s.root = proof.Hash([]byte("root"))
+}
+
+// attest signs an attestation of the set with a new node key
+func (s *TrustTestSuite) attest(setCount int, root []byte, tree proof.Mode) *api.AttestationResponse {
+	key, _, err := p2pcrypto.GenerateEd25519Key(rand.Reader)
+	s.Require().NoError(err)
+	return s.attestWith(key, setCount, root, tree)
+}
+
+func (s *TrustTestSuite) attestWith(key p2pcrypto.PrivKey, setCount int, root []byte, tree proof.Mode) *api.AttestationResponse {
+	node, err := peer.IDFromPrivateKey(key)
+	s.Require().NoError(err)
+	signature, publicKey, err := proof.SignAttestation(proof.AttestationDigest(s.setId, setCount, root, tree), key)
+	s.Require().NoError(err)
+	return &api.AttestationResponse{
+		SetId:     s.setId,
+		SetCount:  setCount,
+		Root:      proof.Encode(root),
+		Tree:      string(tree),
+		Node:      node.String(),
+		PublicKey: proof.Encode(publicKey),
+		Signature: proof.Encode(signature),
+	}
+}
+
+// node serves the attestation as a node would, or fails every request if
+// there is none
+func (s *TrustTestSuite) node(attestation *api.AttestationResponse) *api.Client {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if attestation == nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(attestation)
+			},
+		),
+	)
+	s.T().Cleanup(server.Close)
+	client, err := api.NewClient(server.URL)
+	s.Require().NoError(err)
+	return client
+}
+
+func (s *TrustTestSuite) TestTrustSet() {
+	t := s.T()
+
+	t.Run(
+		"it should trust a root once the quorum attests to it", func(t *testing.T) {
+			c := NewClient(NewInMemoryPersistence(), nil)
+			nodes := []*api.Client{
+				s.node(nil),
+				s.node(s.attest(2, s.root, proof.ModePositional)),
+				s.node(s.attest(2, s.root, proof.ModePositional)),
+			}
+
+			root, err := c.TrustSet(s.setId, nodes, 2)
+			require.NoError(t, err)
+			require.Equal(t, s.root, root)
+
+			stored, count, err := c.persistence.FileSet(s.setId)
+			require.NoError(t, err)
+			require.Equal(t, s.root, stored)
+			require.Equal(t, 2, count)
+		},
+	)
+
+	t.Run(
+		"it should not trust a root short of the quorum", func(t *testing.T) {
+			c := NewClient(NewInMemoryPersistence(), nil)
+			nodes := []*api.Client{
+				s.node(s.attest(2, s.root, proof.ModePositional)),
+				s.node(nil),
+			}
+
+			_, err := c.TrustSet(s.setId, nodes, 2)
+			require.ErrorIs(t, err, ErrNoQuorum)
+
+			_, _, err = c.persistence.FileSet(s.setId)
+			require.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"it should count a node reached through several clients once", func(t *testing.T) {
+			c := NewClient(NewInMemoryPersistence(), nil)
+			key, _, err := p2pcrypto.GenerateEd25519Key(rand.Reader)
+			require.NoError(t, err)
+			attestation := s.attestWith(key, 2, s.root, proof.ModePositional)
+			nodes := []*api.Client{s.node(attestation), s.node(attestation)}
+
+			_, err = c.TrustSet(s.setId, nodes, 2)
+			require.ErrorIs(t, err, ErrNoQuorum)
+		},
+	)
+
+	t.Run(
+		"it should ignore attestations with a bad signature", func(t *testing.T) {
+			c := NewClient(NewInMemoryPersistence(), nil)
+			forged := s.attest(2, s.root, proof.ModePositional)
+			forged.Signature = s.attest(2, proof.Hash(s.root), proof.ModePositional).Signature
+			// a valid signature from a key that isn't the node's
+			borrowed := s.attest(2, s.root, proof.ModePositional)
+			borrowed.Node = s.attest(2, s.root, proof.ModePositional).Node
+			nodes := []*api.Client{
+				s.node(forged),
+				s.node(borrowed),
+				s.node(s.attest(2, s.root, proof.ModePositional)),
+			}
+
+			_, err := c.TrustSet(s.setId, nodes, 2)
+			require.ErrorIs(t, err, ErrNoQuorum)
+		},
+	)
+
+	t.Run(
+		"it should not add up attestations of conflicting sets", func(t *testing.T) {
+			c := NewClient(NewInMemoryPersistence(), nil)
+			nodes := []*api.Client{
+				s.node(s.attest(2, s.root, proof.ModePositional)),
+				s.node(s.attest(2, proof.Hash(s.root), proof.ModePositional)),
+				s.node(s.attest(3, s.root, proof.ModePositional)),
+				s.node(s.attest(2, s.root, proof.ModeSorted)),
+			}
+
+			_, err := c.TrustSet(s.setId, nodes, 2)
+			require.ErrorIs(t, err, ErrNoQuorum)
+
+			// once a second node agrees with the first, the root is trusted
+			nodes = append(nodes, s.node(s.attest(2, s.root, proof.ModePositional)))
+			root, err := c.TrustSet(s.setId, nodes, 2)
+			require.NoError(t, err)
+			require.Equal(t, s.root, root)
+		},
+	)
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+const (
+	// resumableThreshold is the file size above which AddFile switches to
+	// resumable uploads, smaller files are cheap enough to resend
+	resumableThreshold = 4 << 20
+	// uploadChunkSize is the size of each chunk of a resumable upload
+	uploadChunkSize = 1 << 20
+	// maxUploadRetries is how many times in a row a chunk may fail before
+	// the upload is given up on
+	maxUploadRetries = 5
+	uploadRetryDelay = 500 * time.Millisecond
+)
+
+// uploadFile sends a file as a resumable upload. When a chunk fails, the
+// node is asked how much of it was stored, and the upload carries on from
+// there instead of starting over.
+func (c *Client) uploadFile(setId string, index, count int, root, keyHash []byte, file []byte) error {
+	upload, err := c.apiClient.CreateUpload(setId, index, count, int64(len(file)), proof.Hash(file), root, keyHash)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	retries := 0
+	for offset < int64(len(file)) {
+		end := offset + uploadChunkSize
+		if end > int64(len(file)) {
+			end = int64(len(file))
+		}
+
+		next, err := c.apiClient.PatchUpload(upload.Id, offset, file[offset:end])
+		if err == nil {
+			offset = next
+			retries = 0
+			continue
+		}
+
+		retries++
+		if retries > maxUploadRetries {
+			return errors.Wrapf(err, "upload of file %d failed after %d retries", index, maxUploadRetries)
+		}
+		time.Sleep(uploadRetryDelay)
+
+		// part of the chunk may have made it, so resume from wherever
+		// the node got to
+		if stored, err := c.apiClient.UploadOffset(upload.Id); err == nil {
+			offset = stored
+		}
+	}
+
+	_, err = c.apiClient.FinalizeUpload(upload.Id)
+	return err
+}
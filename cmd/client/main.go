@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"math/rand"
 	"time"
@@ -65,11 +66,38 @@ func randomNodeUpload(nFiles int, clients []*client.Client) {
 		fmt.Printf("Downloaded file from node %d: %s\n", i, file)
 	}
 
+	// and check every node agrees on the whole set, not just the one file
+	fmt.Printf("Auditing set on every node\n")
+	for i, node := range clients {
+		if err := node.AuditSet(setId); err != nil {
+			fmt.Printf("audit failed on node %d: %s\n", i, err)
+			continue
+		}
+		fmt.Printf("Node %d holds the expected set\n", i)
+	}
+}
+
+// attestedDownload downloads a set with a client that never saw it being
+// uploaded, so it has to learn the root from the nodes' attestations first
+func attestedDownload(setId string, nFiles, quorum int, apiClients []*api.Client) {
+	fmt.Printf("Trusting set %s once %d nodes attest to it\n", setId, quorum)
+	node := client.NewClient(client.NewInMemoryPersistence(), apiClients[0])
+	if _, err := node.TrustSet(setId, apiClients, quorum); err != nil {
+		fmt.Printf("could not trust set: %s\n", err)
+		return
+	}
+	fileToDownload := rand.Intn(nFiles)
+	file, err := node.GetFile(setId, fileToDownload)
+	if err != nil {
+		fmt.Printf("error downloading attested file #%d: %s\n", fileToDownload, err)
+		return
+	}
+	fmt.Printf("Downloaded attested file #%d: %s\n", fileToDownload, file)
 }
 
 // singleNodeUpload will take a fileset and upload each file to a
 // single node in the network.
-func singleNodeUpload(nFiles int, clients []*client.Client) {
+func singleNodeUpload(nFiles int, clients []*client.Client) string {
 	if len(clients) == 0 {
 		panic("no clients provided")
 	}
@@ -100,6 +128,7 @@ func singleNodeUpload(nFiles int, clients []*client.Client) {
 		}
 		fmt.Printf("Downloaded file from node %d: %s\n", i, file)
 	}
+	return setId
 }
 
 func main() {
@@ -110,22 +139,38 @@ func main() {
 	// create persistence
 	persistence := client.NewInMemoryPersistence()
 
+	var signer *ecdsa.PrivateKey
+	if cfg.SigningKey != "" {
+		signer = mustResolve(proof.ParseKey(cfg.SigningKey))
+		fmt.Printf("Signing uploads as %s\n", proof.Address(&signer.PublicKey))
+	}
+
 	// create clients for each host
 	clients := make([]*client.Client, len(hostUrls))
+	apiClients := make([]*api.Client, len(hostUrls))
 	for i, hostUrl := range hostUrls {
-		clients[i] = client.NewClient(
-			persistence,
-			mustResolve(api.NewClient(fmt.Sprintf("%s/api", hostUrl))),
-		)
+		apiClient := mustResolve(api.NewClient(fmt.Sprintf("%s/api", hostUrl)))
+		if cfg.Token != "" {
+			apiClient.SetToken(cfg.Token)
+		}
+		if signer != nil {
+			apiClient.SetSigner(signer)
+		}
+		apiClients[i] = apiClient
+		clients[i] = client.NewClient(persistence, apiClient)
 	}
 
 	// for now, we have two simple test cases, one where we upload the
 	// entire fileset to a single node, and another where we upload
-	// each file to a random node.
+	// each file to a random node. The first set is then downloaded again
+	// by a client that only knows its root from the nodes' attestations.
 	fmt.Println("--- Single Node Upload ---")
-	singleNodeUpload(nFiles, clients)
+	setId := singleNodeUpload(nFiles, clients)
 
 	fmt.Println("\n--- Random Node Upload ---")
 	randomNodeUpload(nFiles, clients)
 
+	fmt.Println("\n--- Attested Download ---")
+	attestedDownload(setId, nFiles, cfg.Quorum, apiClients)
+
 }
@@ -9,6 +9,9 @@ import (
 	"time"
 
 	"github.com/braintree/manners"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gin-gonic/gin"
 	"github.com/libp2p/go-libp2p"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
@@ -16,17 +19,23 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	"github.com/loopfz/gadgeto/tonic"
-	"github.com/loopfz/gadgeto/tonic/utils/jujerr"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"golang.org/x/sync/errgroup"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"github.com/scottrmalley/p2p-file-sharing/anchor"
 	"github.com/scottrmalley/p2p-file-sharing/api"
+	"github.com/scottrmalley/p2p-file-sharing/challenge"
 	"github.com/scottrmalley/p2p-file-sharing/config"
 	"github.com/scottrmalley/p2p-file-sharing/networking"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
 	"github.com/scottrmalley/p2p-file-sharing/repository"
+	"github.com/scottrmalley/p2p-file-sharing/transparency"
 )
 
 // DiscoveryInterval is how often we re-publish our mDNS records.
@@ -48,6 +57,15 @@ func main() {
 	defer cancel()
 
 	env := config.ParseHttpEnv("SVC")
+	storageEnv := config.ParseStorageEnv("SVC")
+	databaseEnv := config.ParseDatabaseEnv("SVC")
+	authEnv := config.ParseAuthEnv("SVC")
+	anchorEnv := config.ParseAnchorEnv("SVC")
+	logEnv := config.ParseLogEnv("SVC")
+	challengeEnv := config.ParseChallengeEnv("SVC")
+	scrubEnv := config.ParseScrubEnv("SVC")
+	retentionEnv := config.ParseRetentionEnv("SVC")
+	limitsEnv := config.ParseLimitsEnv("SVC")
 	rootLogger := zerolog.New(os.Stdout).With().Timestamp().Logger()
 	if env.Debug {
 		rootLogger = rootLogger.Level(zerolog.DebugLevel)
@@ -81,22 +99,49 @@ func main() {
 		),
 	)
 
-	// initialize the file repository with an in-memory sqlite database
-	// this would later be swapped out for something more sophisticated, but
-	// for demonstration purposes it works fine
+	// initialize the file repository, by default with an in-memory sqlite
+	// database which works fine for demonstration purposes. File contents
+	// are kept out of the database in a content addressed blob store
+	db := mustResolve(newDatabase(databaseEnv))
 	repo := repository.NewFiles(
 		rootLogger.With().Str("ctx", "file-repo").Logger(),
-		mustResolve(gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})),
+		db,
+		mustResolve(newBlobStore(ctx, storageEnv)),
 	)
 
 	if err := repo.Migrate(); err != nil {
 		panic(err)
 	}
+	repo.SetLimits(
+		repository.Limits{
+			MaxFileSize: limitsEnv.MaxFileSize,
+			MaxSetCount: limitsEnv.MaxSetCount,
+			MaxSetBytes: limitsEnv.MaxSetBytes,
+			Capacity:    limitsEnv.Capacity,
+		},
+	)
+
+	// resumable uploads share the database, but stage their contents in
+	// their own directory
+	uploads := mustResolve(
+		repository.NewUploads(
+			rootLogger.With().Str("ctx", "upload-repo").Logger(),
+			db,
+			storageEnv.UploadDir,
+		),
+	)
+	if err := uploads.Migrate(); err != nil {
+		panic(err)
+	}
 
 	service := api.NewService(
 		rootLogger.With().Str("ctx", "api-service").Logger(),
+		node.ID().String(),
+		// the node attests sets with the same key its peer ID comes from
+		node.Peerstore().PrivKey(node.ID()),
 		fileTopic,
 		repo,
+		uploads,
 	)
 
 	controller := api.NewController(
@@ -104,16 +149,116 @@ func main() {
 		service,
 	)
 
+	// the transparency log gossips its heads on a topic of its own, signed
+	// with the node identity
+	headTopic := mustResolve(
+		networking.NewHeadTopic(
+			rootLogger.With().Str("ctx", "log-heads").Logger(),
+			networking.NewConnection(
+				ps,
+				node.ID(),
+			),
+		),
+	)
+	// consistency proofs peers didn't gossip are fetched over libp2p streams
+	consistencyStream := networking.NewConsistencyStream(
+		rootLogger.With().Str("ctx", "log-consistency").Logger(),
+		node,
+		logEnv.LogFetchTimeout,
+	)
+	transparencyLog := mustResolve(
+		transparency.NewLog(
+			rootLogger.With().Str("ctx", "transparency-log").Logger(),
+			repo,
+			headTopic,
+			consistencyStream,
+			node.Peerstore().PrivKey(node.ID()),
+			logEnv.LogInterval,
+		),
+	)
+	consistencyStream.Handle(transparencyLog.Consistency)
+	logController := api.NewLogController(
+		rootLogger.With().Str("ctx", "log-controller").Logger(),
+		transparencyLog,
+	)
+
+	// storage challenges go straight to a single peer over libp2p streams,
+	// and the same challenger answers the challenges of our peers
+	challengeStream := networking.NewChallengeStream(
+		rootLogger.With().Str("ctx", "challenge-stream").Logger(),
+		node,
+		challengeEnv.ChallengeTimeout,
+	)
+	challenger := challenge.NewChallenger(
+		rootLogger.With().Str("ctx", "challenger").Logger(),
+		repo,
+		challengeStream,
+		challengeEnv.ChallengeInterval,
+	)
+	challengeStream.Handle(challenger.Respond)
+	peerController := api.NewPeerController(
+		rootLogger.With().Str("ctx", "peer-controller").Logger(),
+		challenger,
+	)
+
+	// the scrubber re-fetches corrupt files from peers over libp2p streams,
+	// and serves our own files to the scrubbers of our peers
+	fileStream := networking.NewFileStream(
+		rootLogger.With().Str("ctx", "file-stream").Logger(),
+		node,
+		scrubEnv.FetchTimeout,
+	)
+	scrubber := repository.NewScrubber(
+		rootLogger.With().Str("ctx", "scrubber").Logger(),
+		repo,
+		fileStream,
+		scrubEnv.ScrubInterval,
+		scrubEnv.ScrubBatch,
+	)
+	fileStream.Handle(scrubber.Serve)
+
+	// collect expired sets, and sets that never completed
+	collector := repository.NewCollector(
+		rootLogger.With().Str("ctx", "collector").Logger(),
+		repo,
+		uploads,
+		repository.Retention{
+			Default:    retentionEnv.Retention,
+			Incomplete: retentionEnv.IncompleteTimeout,
+			Uploads:    retentionEnv.UploadTtl,
+		},
+		retentionEnv.GcInterval,
+		retentionEnv.GcBatch,
+	)
+	nodeController := api.NewNodeController(
+		rootLogger.With().Str("ctx", "node-controller").Logger(),
+		repo,
+		collector,
+	)
+
 	// stream new file sets to database
 	streamer := repository.NewStreamer(
 		rootLogger.With().Str("ctx", "streamer").Logger(),
 		repo,
 	)
 
-	router := defaultGinInit()
+	authenticator := api.NewAuthenticator(authEnv.ApiKeys, []byte(authEnv.JwtSecret))
+	if !authenticator.Enabled() {
+		rootLogger.Warn().Msg("api authentication is disabled, anyone can add files to sets without an owner")
+	}
+	router := defaultGinInit(rootLogger.With().Str("ctx", "api").Logger(), authenticator)
 	if err := controller.RegisterRoutes(router.Group("/api")); err != nil {
 		panic(err)
 	}
+	if err := logController.RegisterRoutes(router.Group("/api")); err != nil {
+		panic(err)
+	}
+	if err := peerController.RegisterRoutes(router.Group("/api")); err != nil {
+		panic(err)
+	}
+	if err := nodeController.RegisterRoutes(router.Group("/api")); err != nil {
+		panic(err)
+	}
 
 	group, groupCtx := errgroup.WithContext(ctx)
 
@@ -140,6 +285,30 @@ func main() {
 
 	// launch the streamer so it saves files reported by other peers
 	group.Go(streamer.WatchNew(groupCtx, fileTopic.Read(groupCtx)))
+	group.Go(streamer.WatchBatches(groupCtx, fileTopic.ReadBatches(groupCtx)))
+	group.Go(streamer.WatchSets(groupCtx, fileTopic.ReadSets(groupCtx)))
+	group.Go(streamer.WatchDeletions(groupCtx, fileTopic.ReadDeletions(groupCtx)))
+
+	// sign heads of our log, and check the heads of everyone else's
+	group.Go(transparencyLog.Run(groupCtx))
+	group.Go(transparencyLog.WatchHeads(groupCtx, headTopic.ReadHeads(groupCtx)))
+
+	// check that peers still hold the sets they replicated
+	group.Go(challenger.Run(groupCtx))
+
+	// check stored files for corruption, and repair them from peers
+	group.Go(scrubber.Run(groupCtx))
+
+	// delete expired sets and reclaim their space
+	group.Go(collector.Run(groupCtx))
+
+	// anchor the roots of complete sets, if there is a chain to anchor them to
+	if anchorEnv.AnchorRpcUrl != "" {
+		anchorer := mustResolve(
+			newAnchorer(ctx, rootLogger.With().Str("ctx", "anchorer").Logger(), anchorEnv, repo),
+		)
+		group.Go(anchorer.Run(groupCtx))
+	}
 
 	if err := group.Wait(); err != nil {
 		rootLogger.Fatal().Err(err).Msg("error in main")
@@ -175,10 +344,110 @@ func setupDiscovery(h host.Host) error {
 	return s.Start()
 }
 
-// defaultGinInit initializes a gin router with default middleware
-func defaultGinInit() *gin.Engine {
+// newDatabase opens the database for the configured driver
+func newDatabase(env config.DatabaseEnv) (*gorm.DB, error) {
+	switch env.DbDriver {
+	case config.DbDriverSqlite:
+		db, err := gorm.Open(sqlite.Open(env.DbDsn), &gorm.Config{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open sqlite database")
+		}
+		sqlDb, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		// sqlite only allows a single writer, and every connection to an
+		// in-memory database gets its own copy, so stick to one connection
+		sqlDb.SetMaxOpenConns(1)
+		return db, nil
+	case config.DbDriverPostgres:
+		db, err := gorm.Open(postgres.Open(env.DbDsn), &gorm.Config{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open postgres database")
+		}
+		return db, nil
+	default:
+		return nil, errors.Errorf("unknown database driver %q", env.DbDriver)
+	}
+}
+
+// newBlobStore creates the blob store for the configured backend
+func newBlobStore(ctx context.Context, env config.StorageEnv) (repository.BlobStore, error) {
+	switch env.BlobBackend {
+	case config.BlobBackendDisk:
+		return repository.NewDiskBlobStore(env.BlobDir)
+	case config.BlobBackendS3:
+		client, err := minio.New(
+			env.S3Endpoint, &minio.Options{
+				Creds:  credentials.NewStaticV4(env.S3AccessKey, env.S3SecretKey, ""),
+				Secure: env.S3UseSsl,
+				Region: env.S3Region,
+			},
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create s3 client")
+		}
+		return repository.NewS3BlobStore(ctx, client, env.S3Bucket, env.S3PartSize)
+	default:
+		return nil, errors.Errorf("unknown blob backend %q", env.BlobBackend)
+	}
+}
+
+// newAnchorer connects to the chain and binds to the registry contract,
+// deploying it first if no contract is configured
+func newAnchorer(
+	ctx context.Context,
+	logger zerolog.Logger,
+	env config.AnchorEnv,
+	repo *repository.Files,
+) (*anchor.Anchorer, error) {
+	client, err := ethclient.DialContext(ctx, env.AnchorRpcUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to anchor rpc")
+	}
+	key, err := proof.ParseKey(env.AnchorKey)
+	if err != nil {
+		return nil, err
+	}
+	chainId, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get chain id")
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(key, chainId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create transactor")
+	}
+
+	var registry *anchor.Registry
+	if env.AnchorContract != "" {
+		if !common.IsHexAddress(env.AnchorContract) {
+			return nil, errors.Errorf("invalid anchor contract address %q", env.AnchorContract)
+		}
+		bound, err := anchor.NewRegistry(common.HexToAddress(env.AnchorContract), client)
+		if err != nil {
+			return nil, err
+		}
+		registry = bound
+	} else {
+		address, tx, deployed, err := anchor.DeployRegistry(auth, client)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := bind.WaitDeployed(ctx, client, tx); err != nil {
+			return nil, errors.Wrap(err, "failed to wait for registry deployment")
+		}
+		logger.Info().Str("contract", address.Hex()).Msg("deployed registry contract, set SVC_ANCHOR_CONTRACT to reuse it")
+		registry = deployed
+	}
+	return anchor.NewAnchorer(logger, repo, client, registry, auth, env.AnchorInterval), nil
+}
+
+// defaultGinInit initializes a gin router with default middleware, and
+// makes tonic report errors with the api's error model
+func defaultGinInit(logger zerolog.Logger, authenticator *api.Authenticator) *gin.Engine {
 	router := gin.New()
 	router.Use(gin.Recovery())
-	tonic.SetErrorHook(jujerr.ErrHook)
+	router.Use(authenticator.Middleware())
+	tonic.SetErrorHook(api.ErrorHook(logger))
 	return router
 }
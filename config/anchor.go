@@ -0,0 +1,25 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// AnchorEnv configures anchoring set roots to the registry contract.
+// Anchoring is disabled unless an rpc url is set, and if no contract is
+// given the node deploys its own.
+type AnchorEnv struct {
+	AnchorRpcUrl   string        `split_words:"true"`
+	AnchorContract string        `split_words:"true"`
+	AnchorKey      string        `split_words:"true"`
+	AnchorInterval time.Duration `split_words:"true" default:"1m"`
+}
+
+func ParseAnchorEnv(prefix string) AnchorEnv {
+	var anchorConfig AnchorEnv
+	if err := envconfig.Process(prefix, &anchorConfig); err != nil {
+		panic(err)
+	}
+	return anchorConfig
+}
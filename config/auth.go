@@ -0,0 +1,20 @@
+package config
+
+import "github.com/kelseyhightower/envconfig"
+
+// AuthEnv configures api authentication. Api keys are given as
+// key:principal pairs, eg. SVC_API_KEYS=k1:alice,k2:bob, and JWTs are
+// checked against the shared HS256 secret. Authentication is disabled when
+// neither is set.
+type AuthEnv struct {
+	ApiKeys   map[string]string `split_words:"true"`
+	JwtSecret string            `split_words:"true"`
+}
+
+func ParseAuthEnv(prefix string) AuthEnv {
+	var authConfig AuthEnv
+	if err := envconfig.Process(prefix, &authConfig); err != nil {
+		panic(err)
+	}
+	return authConfig
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ChallengeEnv configures the storage challenges a node sends its peers,
+// every interval it challenges one random peer, which has until the
+// timeout to answer
+type ChallengeEnv struct {
+	ChallengeInterval time.Duration `split_words:"true" default:"1m"`
+	ChallengeTimeout  time.Duration `split_words:"true" default:"10s"`
+}
+
+func ParseChallengeEnv(prefix string) ChallengeEnv {
+	var challengeConfig ChallengeEnv
+	if err := envconfig.Process(prefix, &challengeConfig); err != nil {
+		panic(err)
+	}
+	return challengeConfig
+}
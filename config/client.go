@@ -5,6 +5,14 @@ import "github.com/kelseyhightower/envconfig"
 type ClientEnv struct {
 	N     int      `split_words:"true" required:"true" default:"1000"`
 	Hosts []string `split_words:"true" required:"true" default:"http://localhost:8080,http://localhost:8081,http://localhost:8082"`
+	// Token is the api key or JWT sent to nodes with authentication enabled
+	Token string `split_words:"true"`
+	// SigningKey is the hex encoded Ethereum private key uploads are signed
+	// with, its address then owns the uploaded sets
+	SigningKey string `split_words:"true"`
+	// Quorum is how many distinct nodes have to attest to the root of a set
+	// before the client trusts a root it did not compute itself
+	Quorum int `split_words:"true" default:"2"`
 }
 
 func ParseClientEnv(prefix string) ClientEnv {
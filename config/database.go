@@ -0,0 +1,21 @@
+package config
+
+import "github.com/kelseyhightower/envconfig"
+
+const (
+	DbDriverSqlite   = "sqlite"
+	DbDriverPostgres = "postgres"
+)
+
+type DatabaseEnv struct {
+	DbDriver string `split_words:"true" required:"true" default:"sqlite"`
+	DbDsn    string `split_words:"true" required:"true" default:"file::memory:"`
+}
+
+func ParseDatabaseEnv(prefix string) DatabaseEnv {
+	var databaseConfig DatabaseEnv
+	if err := envconfig.Process(prefix, &databaseConfig); err != nil {
+		panic(err)
+	}
+	return databaseConfig
+}
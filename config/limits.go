@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// LimitsEnv bounds what the node stores, for local uploads and files
+// gossiped by peers alike. Sizes are in bytes, zero means no limit.
+type LimitsEnv struct {
+	MaxFileSize int64 `split_words:"true" default:"0"`
+	MaxSetCount int   `split_words:"true" default:"0"`
+	MaxSetBytes int64 `split_words:"true" default:"0"`
+	Capacity    int64 `default:"0"`
+}
+
+func ParseLimitsEnv(prefix string) LimitsEnv {
+	var limitsConfig LimitsEnv
+	if err := envconfig.Process(prefix, &limitsConfig); err != nil {
+		panic(err)
+	}
+	return limitsConfig
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// LogEnv configures the transparency log, every interval the node appends
+// the sets that completed and signs a new head. Consistency proofs a peer
+// didn't gossip are fetched from it within the fetch timeout.
+type LogEnv struct {
+	LogInterval     time.Duration `split_words:"true" default:"30s"`
+	LogFetchTimeout time.Duration `split_words:"true" default:"10s"`
+}
+
+func ParseLogEnv(prefix string) LogEnv {
+	var logConfig LogEnv
+	if err := envconfig.Process(prefix, &logConfig); err != nil {
+		panic(err)
+	}
+	return logConfig
+}
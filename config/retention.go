@@ -0,0 +1,30 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// RetentionEnv configures how long the node keeps sets. Sets uploaded with
+// a TTL expire when it runs out, other sets after the default retention,
+// and sets that are still incomplete after the incomplete timeout are given
+// up on. Zero keeps sets forever. Resumable uploads that are not finalized
+// within the upload TTL are abandoned, and their staged contents deleted.
+// Every GC interval expired sets and uploads are collected, GC batch at a
+// time.
+type RetentionEnv struct {
+	Retention         time.Duration `default:"0"`
+	IncompleteTimeout time.Duration `split_words:"true" default:"24h"`
+	UploadTtl         time.Duration `split_words:"true" default:"24h"`
+	GcInterval        time.Duration `split_words:"true" default:"5m"`
+	GcBatch           int           `split_words:"true" default:"100"`
+}
+
+func ParseRetentionEnv(prefix string) RetentionEnv {
+	var retentionConfig RetentionEnv
+	if err := envconfig.Process(prefix, &retentionConfig); err != nil {
+		panic(err)
+	}
+	return retentionConfig
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ScrubEnv configures the scrubber that checks stored files for corruption,
+// every interval it re-hashes up to the batch size of files, and repairs up
+// to as many of the files it flagged
+type ScrubEnv struct {
+	ScrubInterval time.Duration `split_words:"true" default:"1m"`
+	ScrubBatch    int           `split_words:"true" default:"100"`
+	FetchTimeout  time.Duration `split_words:"true" default:"30s"`
+}
+
+func ParseScrubEnv(prefix string) ScrubEnv {
+	var scrubConfig ScrubEnv
+	if err := envconfig.Process(prefix, &scrubConfig); err != nil {
+		panic(err)
+	}
+	return scrubConfig
+}
@@ -0,0 +1,33 @@
+package config
+
+import "github.com/kelseyhightower/envconfig"
+
+const (
+	BlobBackendDisk = "disk"
+	BlobBackendS3   = "s3"
+)
+
+type StorageEnv struct {
+	BlobBackend string `split_words:"true" required:"true" default:"disk"`
+	BlobDir     string `split_words:"true" required:"true" default:"data/blobs"`
+	// UploadDir stages resumable uploads until they are finalized, it is
+	// always on local disk regardless of the blob backend
+	UploadDir string `split_words:"true" required:"true" default:"data/uploads"`
+
+	// only used by the s3 blob backend
+	S3Endpoint  string `split_words:"true"`
+	S3Bucket    string `split_words:"true" default:"blobs"`
+	S3AccessKey string `split_words:"true"`
+	S3SecretKey string `split_words:"true"`
+	S3Region    string `split_words:"true"`
+	S3UseSsl    bool   `split_words:"true" default:"true"`
+	S3PartSize  uint64 `split_words:"true" default:"16777216"`
+}
+
+func ParseStorageEnv(prefix string) StorageEnv {
+	var storageConfig StorageEnv
+	if err := envconfig.Process(prefix, &storageConfig); err != nil {
+		panic(err)
+	}
+	return storageConfig
+}
@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// Challenge asks a peer to prove it still holds a file, by hashing the
+// contents together with a nonce it could not have known in advance
+type Challenge struct {
+	SetId string `json:"set_id"`
+	Index int    `json:"index"`
+	Nonce []byte `json:"nonce"`
+}
+
+// ChallengeResponse is the answer to a Challenge, Hash is Hash(nonce ||
+// contents), and Proof proves that Leaf, the hash of the contents, belongs
+// to the root of the set. Error is set instead if the peer could not answer.
+type ChallengeResponse struct {
+	Hash  []byte   `json:"hash"`
+	Leaf  []byte   `json:"leaf"`
+	Proof [][]byte `json:"proof"`
+	Error string   `json:"error"`
+}
+
+// ChallengeResult is the outcome of challenging a peer, Reason says why it
+// failed
+type ChallengeResult struct {
+	Peer         string        `json:"peer"`
+	SetId        string        `json:"set_id"`
+	Index        int           `json:"index"`
+	Passed       bool          `json:"passed"`
+	Reason       string        `json:"reason"`
+	Duration     time.Duration `json:"duration"`
+	ChallengedAt time.Time     `json:"challenged_at"`
+}
+
+// PeerScore sums up how a peer did in the challenges we sent it
+type PeerScore struct {
+	Peer             string    `json:"peer"`
+	Score            int       `json:"score"`
+	Passed           int       `json:"passed"`
+	Failed           int       `json:"failed"`
+	LastChallengedAt time.Time `json:"last_challenged_at"`
+}
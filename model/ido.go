@@ -1,12 +1,43 @@
 package model
 
+import (
+	"time"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
 type FileMetadata struct {
 	SetId      string `json:"set_id"`
 	SetCount   int    `json:"set_count"`
 	FileNumber int    `json:"file_number"`
+	// Uploader is the peer ID of the node the file was uploaded to
+	Uploader string `json:"uploader"`
+	// Root is the root the uploader declared for the set, if known
+	Root []byte `json:"root"`
+	// Tree is the kind of tree the root is computed with, if the uploader
+	// chose one
+	Tree proof.Mode `json:"tree"`
+	// KeyHash is the hash of the key the uploader chose for the set, only
+	// whoever holds the key can delete the set
+	KeyHash []byte `json:"key_hash"`
+	// Owner is the authenticated principal that owns the set, it is empty
+	// for sets uploaded to nodes without authentication
+	Owner string `json:"owner"`
+	// Signature is the uploader's Ethereum signature over the file, or over
+	// the whole set, and Signer the address that produced it. Both are empty
+	// for unsigned uploads.
+	Signature []byte `json:"signature"`
+	Signer    string `json:"signer"`
+	// ExpiresAt is when the set expires, if the uploader gave it a TTL. It
+	// is absolute so that every node expires the set at the same time.
+	ExpiresAt *time.Time `json:"expires_at"`
 }
 
 type File struct {
 	Metadata FileMetadata `json:"metadata"`
 	Contents []byte       `json:"contents"`
+	// Proof is the proof of the file against the root of its set. It only
+	// travels with files of sets signed as a whole, whose signature covers
+	// the root but not the contents of each file.
+	Proof [][]byte `json:"proof,omitempty"`
 }
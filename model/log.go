@@ -0,0 +1,53 @@
+package model
+
+import "time"
+
+// LogEntry is the record of a complete set in a node's transparency log.
+// Entries are never removed, not even when the set is deleted.
+type LogEntry struct {
+	Index    uint64    `json:"index"`
+	SetId    string    `json:"set_id"`
+	SetCount int       `json:"set_count"`
+	Root     []byte    `json:"root"`
+	LoggedAt time.Time `json:"logged_at"`
+}
+
+// TreeHead is a node's signature over the size and root of its log at a
+// point in time, made with its libp2p identity key like an Attestation
+type TreeHead struct {
+	Node      string    `json:"node"`
+	Size      uint64    `json:"size"`
+	Root      []byte    `json:"root"`
+	Timestamp time.Time `json:"timestamp"`
+	PublicKey []byte    `json:"public_key"`
+	Signature []byte    `json:"signature"`
+}
+
+// HeadAnnouncement is a tree head as it is gossiped, along with the proof
+// that it extends the head the node announced before
+type HeadAnnouncement struct {
+	Head         TreeHead `json:"head"`
+	PreviousSize uint64   `json:"previous_size"`
+	Consistency  [][]byte `json:"consistency"`
+}
+
+// LogConsistency proves that the log of size From is a prefix of the log
+// of size To
+type LogConsistency struct {
+	From     uint64   `json:"from"`
+	To       uint64   `json:"to"`
+	FromRoot []byte   `json:"from_root"`
+	ToRoot   []byte   `json:"to_root"`
+	Proof    [][]byte `json:"proof"`
+}
+
+// Equivocation is the evidence that a node signed two heads that can't both
+// be honest, either two roots for the same size, a log that shrank, or a
+// log that was rewritten
+type Equivocation struct {
+	Node       string    `json:"node"`
+	Reason     string    `json:"reason"`
+	First      TreeHead  `json:"first"`
+	Second     TreeHead  `json:"second"`
+	DetectedAt time.Time `json:"detected_at"`
+}
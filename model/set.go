@@ -0,0 +1,112 @@
+package model
+
+import (
+	"time"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// FileSet is the record a node keeps about a set as its files arrive. The
+// root is only known once the set is complete. If the uploader declared a
+// root that does not match, the set is quarantined.
+type FileSet struct {
+	SetId        string     `json:"set_id"`
+	SetCount     int        `json:"set_count"`
+	Received     int        `json:"received"`
+	Root         []byte     `json:"root"`
+	DeclaredRoot []byte     `json:"declared_root"`
+	Tree         proof.Mode `json:"tree"`
+	Quarantined  bool       `json:"quarantined"`
+	Uploader     string     `json:"uploader"`
+	Owner        string     `json:"owner"`
+	Grants       []string   `json:"grants"`
+	CreatedAt    time.Time  `json:"created_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+	// ExpiresAt is when the set is collected, if the uploader gave it a TTL
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Anchor is set once the root has been anchored on chain
+	Anchor *Anchor `json:"anchor"`
+}
+
+// Anchor records the transaction that submitted the root of a set to the
+// registry contract, which proves the root existed by the time of its block
+type Anchor struct {
+	SetId       string    `json:"set_id"`
+	Root        []byte    `json:"root"`
+	Contract    string    `json:"contract"`
+	Transaction string    `json:"transaction"`
+	Block       uint64    `json:"block"`
+	AnchoredAt  time.Time `json:"anchored_at"`
+	// Conflicting is set when the registry holds a different root than the
+	// set has, ie. someone anchored another root for the set first
+	Conflicting bool `json:"conflicting"`
+}
+
+// Attestation is a node's signature over the id, count and root of a
+// complete set, made with its libp2p identity key. PublicKey is the
+// marshalled key, which has to match the node's peer ID.
+type Attestation struct {
+	SetId     string     `json:"set_id"`
+	SetCount  int        `json:"set_count"`
+	Root      []byte     `json:"root"`
+	Tree      proof.Mode `json:"tree"`
+	Node      string     `json:"node"`
+	PublicKey []byte     `json:"public_key"`
+	Signature []byte     `json:"signature"`
+}
+
+func (s FileSet) Complete() bool {
+	return s.CompletedAt != nil
+}
+
+// Allows reports whether the principal may add files to or delete the set.
+// Sets without an owner are open to everyone.
+func (s FileSet) Allows(principal string) bool {
+	if s.Owner == "" || s.Owner == principal {
+		return true
+	}
+	for _, grant := range s.Grants {
+		if grant == principal {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDeclaration announces a set before its files arrive, along with the
+// root the uploader computed for it
+type SetDeclaration struct {
+	SetId    string     `json:"set_id"`
+	SetCount int        `json:"set_count"`
+	Root     []byte     `json:"root"`
+	Tree     proof.Mode `json:"tree"`
+	Uploader string     `json:"uploader"`
+	KeyHash  []byte     `json:"key_hash"`
+	Owner    string     `json:"owner"`
+	// Grants are principals the owner allowed to change the set, they are
+	// added to the grants the set already has. GrantSignatures holds the
+	// owner's signature over each grant, in the same order, peers only
+	// accept grants they can check against the owner.
+	Grants          []string `json:"grants"`
+	GrantSignatures [][]byte `json:"grant_signatures"`
+	// Signature is the uploader's Ethereum signature over the set id, count
+	// and root, and Signer the address that produced it
+	Signature []byte `json:"signature"`
+	Signer    string `json:"signer"`
+	// ExpiresAt is when the set expires, if the uploader gave it a TTL
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// SetDeletion asks every node to purge a set. Sets with an owner can only be
+// deleted by the owner or a principal it granted, otherwise the key has to
+// hash to the key hash the set was uploaded with.
+type SetDeletion struct {
+	SetId string `json:"set_id"`
+	Key   []byte `json:"key"`
+	// Principal is the authenticated principal that requested the deletion,
+	// or the address that signed it
+	Principal string `json:"principal"`
+	Signature []byte `json:"signature"`
+	// DeletedBy is the peer ID of the node the deletion was requested on
+	DeletedBy string `json:"deleted_by"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// Upload is a resumable upload of a single file. The contents are staged
+// chunk by chunk until Offset reaches Length, and only then saved into the
+// set at FileNumber.
+type Upload struct {
+	Id         string     `json:"id"`
+	SetId      string     `json:"set_id"`
+	SetCount   int        `json:"set_count"`
+	FileNumber int        `json:"file_number"`
+	Root       []byte     `json:"root"`
+	Tree       proof.Mode `json:"tree"`
+	KeyHash    []byte     `json:"key_hash"`
+	// Principal is who created the upload, only they can send its chunks.
+	// It is the address that signed the upload for signed uploads.
+	Principal string `json:"principal"`
+	// Signature is the Ethereum signature the file will be saved with, over
+	// Hash, the hash the staged contents have to match once complete
+	Signature []byte `json:"signature"`
+	Hash      []byte `json:"hash"`
+	// ExpiresAt is when the set expires, it is worked out from the TTL when
+	// the upload is created
+	ExpiresAt *time.Time `json:"expires_at"`
+	Length    int64      `json:"length"`
+	Offset    int64      `json:"offset"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (u Upload) Complete() bool {
+	return u.Offset == u.Length
+}
@@ -0,0 +1,176 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// ChallengeProtocol is the libp2p protocol storage challenges are sent with
+const ChallengeProtocol = protocol.ID("/p2p-file-sharing/challenge/1.0.0")
+
+// ChallengeStream sends storage challenges to peers over direct libp2p
+// streams rather than pubsub, since each challenge is meant for a single
+// peer and needs an answer. Every challenge gets a stream of its own,
+// which carries one challengeMsg and one responseMsg.
+type ChallengeStream struct {
+	logger  zerolog.Logger
+	host    host.Host
+	timeout time.Duration
+}
+
+func NewChallengeStream(logger zerolog.Logger, host host.Host, timeout time.Duration) *ChallengeStream {
+	return &ChallengeStream{
+		logger:  logger,
+		host:    host,
+		timeout: timeout,
+	}
+}
+
+// Handle answers the challenges sent by peers with respond
+func (cs *ChallengeStream) Handle(respond func(challenge model.Challenge) (model.ChallengeResponse, error)) {
+	cs.host.SetStreamHandler(
+		ChallengeProtocol, func(s network.Stream) {
+			defer s.Close()
+			logger := cs.logger.With().Str("peer", s.Conn().RemotePeer().String()).Logger()
+			ctx, cancel := context.WithTimeout(context.Background(), cs.timeout)
+			defer cancel()
+			defer resetOnDone(ctx, s)()
+
+			var cm challengeMsg
+			if err := json.NewDecoder(s).Decode(&cm); err != nil {
+				logger.Error().Err(err).Msg("failed to decode challenge")
+				_ = s.Reset()
+				return
+			}
+			nonce, err := proof.Decode(cm.Nonce)
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to decode challenge nonce")
+				_ = s.Reset()
+				return
+			}
+
+			var out responseMsg
+			response, err := respond(model.Challenge{SetId: cm.SetId, Index: cm.Index, Nonce: nonce})
+			if err != nil {
+				logger.Debug().Err(err).Str("set-id", cm.SetId).Int("index", cm.Index).Msg("failed to answer challenge")
+				out.Error = err.Error()
+			} else {
+				out.Hash = proof.Encode(response.Hash)
+				out.Leaf = proof.Encode(response.Leaf)
+				out.Proof = make([]string, len(response.Proof))
+				for i, hash := range response.Proof {
+					out.Proof[i] = proof.Encode(hash)
+				}
+			}
+			if err := json.NewEncoder(s).Encode(&out); err != nil {
+				logger.Error().Err(err).Msg("failed to send challenge response")
+			}
+		},
+	)
+}
+
+// Send challenges the peer and waits for its answer
+func (cs *ChallengeStream) Send(ctx context.Context, peerId string, challenge model.Challenge) (model.ChallengeResponse, error) {
+	id, err := peer.Decode(peerId)
+	if err != nil {
+		return model.ChallengeResponse{}, errors.Wrap(err, "invalid peer id")
+	}
+	ctx, cancel := context.WithTimeout(ctx, cs.timeout)
+	defer cancel()
+
+	s, err := cs.host.NewStream(ctx, id, ChallengeProtocol)
+	if err != nil {
+		return model.ChallengeResponse{}, errors.Wrap(err, "failed to open challenge stream")
+	}
+	defer s.Close()
+	defer resetOnDone(ctx, s)()
+
+	if err := json.NewEncoder(s).Encode(
+		&challengeMsg{
+			SetId: challenge.SetId,
+			Index: challenge.Index,
+			Nonce: proof.Encode(challenge.Nonce),
+		},
+	); err != nil {
+		_ = s.Reset()
+		return model.ChallengeResponse{}, errors.Wrap(err, "failed to send challenge")
+	}
+	if err := s.CloseWrite(); err != nil {
+		return model.ChallengeResponse{}, errors.Wrap(err, "failed to send challenge")
+	}
+
+	var rm responseMsg
+	if err := json.NewDecoder(s).Decode(&rm); err != nil {
+		return model.ChallengeResponse{}, errors.Wrap(err, "failed to read challenge response")
+	}
+	return rm.toModel()
+}
+
+// Peers returns the connected peers that answer challenges
+func (cs *ChallengeStream) Peers() []string {
+	return protocolPeers(cs.host, ChallengeProtocol)
+}
+
+func (cs *ChallengeStream) Close() error {
+	cs.host.RemoveStreamHandler(ChallengeProtocol)
+	return nil
+}
+
+// resetOnDone resets the stream once the context is done, until the
+// returned func is called. Not every transport supports deadlines, but
+// resetting works with all of them.
+func resetOnDone(ctx context.Context, s network.Stream) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.Reset()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// protocolPeers returns the connected peers that speak the protocol
+func protocolPeers(h host.Host, proto protocol.ID) []string {
+	var out []string
+	for _, id := range h.Network().Peers() {
+		supported, err := h.Peerstore().SupportsProtocols(id, proto)
+		if err != nil || len(supported) == 0 {
+			continue
+		}
+		out = append(out, id.String())
+	}
+	return out
+}
+
+func (rm *responseMsg) toModel() (model.ChallengeResponse, error) {
+	if rm.Error != "" {
+		return model.ChallengeResponse{Error: rm.Error}, nil
+	}
+	response := model.ChallengeResponse{Proof: make([][]byte, len(rm.Proof))}
+	var err error
+	if response.Hash, err = proof.Decode(rm.Hash); err != nil {
+		return model.ChallengeResponse{}, errors.Wrap(err, "invalid challenge hash")
+	}
+	if response.Leaf, err = proof.Decode(rm.Leaf); err != nil {
+		return model.ChallengeResponse{}, errors.Wrap(err, "invalid challenge leaf")
+	}
+	for i, hash := range rm.Proof {
+		if response.Proof[i], err = proof.Decode(hash); err != nil {
+			return model.ChallengeResponse{}, errors.Wrap(err, "invalid challenge proof")
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,135 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// ConsistencyProtocol is the libp2p protocol consistency proofs of the
+// transparency log are fetched from a single peer with
+const ConsistencyProtocol = protocol.ID("/p2p-file-sharing/log-consistency/1.0.0")
+
+// ConsistencyStream fetches consistency proofs of a peer's transparency log,
+// when a head it gossiped doesn't prove consistency from the last head we
+// have of it. Like ChallengeStream, every request gets a stream of its own,
+// which carries one consistencyMsg and one consistencyResponseMsg.
+type ConsistencyStream struct {
+	logger  zerolog.Logger
+	host    host.Host
+	timeout time.Duration
+}
+
+func NewConsistencyStream(logger zerolog.Logger, host host.Host, timeout time.Duration) *ConsistencyStream {
+	return &ConsistencyStream{
+		logger:  logger,
+		host:    host,
+		timeout: timeout,
+	}
+}
+
+// Handle answers the requests of peers with serve, which returns the proof
+// that our log of size from is a prefix of our log of size to
+func (cs *ConsistencyStream) Handle(serve func(from, to uint64) (model.LogConsistency, error)) {
+	cs.host.SetStreamHandler(
+		ConsistencyProtocol, func(s network.Stream) {
+			defer s.Close()
+			logger := cs.logger.With().Str("peer", s.Conn().RemotePeer().String()).Logger()
+			ctx, cancel := context.WithTimeout(context.Background(), cs.timeout)
+			defer cancel()
+			defer resetOnDone(ctx, s)()
+
+			var cm consistencyMsg
+			if err := json.NewDecoder(s).Decode(&cm); err != nil {
+				logger.Error().Err(err).Msg("failed to decode consistency request")
+				_ = s.Reset()
+				return
+			}
+
+			var out consistencyResponseMsg
+			consistency, err := serve(cm.From, cm.To)
+			if err != nil {
+				logger.Debug().Err(err).Uint64("from", cm.From).Uint64("to", cm.To).Msg("failed to prove consistency")
+				out.Error = err.Error()
+			} else {
+				out.FromRoot = proof.Encode(consistency.FromRoot)
+				out.ToRoot = proof.Encode(consistency.ToRoot)
+				out.Proof = make([]string, len(consistency.Proof))
+				for i, hash := range consistency.Proof {
+					out.Proof[i] = proof.Encode(hash)
+				}
+			}
+			if err := json.NewEncoder(s).Encode(&out); err != nil {
+				logger.Error().Err(err).Msg("failed to send consistency proof")
+			}
+		},
+	)
+}
+
+// Fetch asks the peer to prove that its log of size from is a prefix of its
+// log of size to. The proof is only as good as the heads it is checked
+// against, the roots the peer sends along are not signed.
+func (cs *ConsistencyStream) Fetch(ctx context.Context, peerId string, from, to uint64) (model.LogConsistency, error) {
+	id, err := peer.Decode(peerId)
+	if err != nil {
+		return model.LogConsistency{}, errors.Wrap(err, "invalid peer id")
+	}
+	ctx, cancel := context.WithTimeout(ctx, cs.timeout)
+	defer cancel()
+
+	s, err := cs.host.NewStream(ctx, id, ConsistencyProtocol)
+	if err != nil {
+		return model.LogConsistency{}, errors.Wrap(err, "failed to open consistency stream")
+	}
+	defer s.Close()
+	defer resetOnDone(ctx, s)()
+
+	if err := json.NewEncoder(s).Encode(&consistencyMsg{From: from, To: to}); err != nil {
+		_ = s.Reset()
+		return model.LogConsistency{}, errors.Wrap(err, "failed to send consistency request")
+	}
+	if err := s.CloseWrite(); err != nil {
+		return model.LogConsistency{}, errors.Wrap(err, "failed to send consistency request")
+	}
+
+	var rm consistencyResponseMsg
+	if err := json.NewDecoder(s).Decode(&rm); err != nil {
+		return model.LogConsistency{}, errors.Wrap(err, "failed to read consistency proof")
+	}
+	return rm.toModel(from, to)
+}
+
+func (cs *ConsistencyStream) Close() error {
+	cs.host.RemoveStreamHandler(ConsistencyProtocol)
+	return nil
+}
+
+func (rm *consistencyResponseMsg) toModel(from, to uint64) (model.LogConsistency, error) {
+	if rm.Error != "" {
+		return model.LogConsistency{}, errors.Errorf("peer could not prove consistency: %s", rm.Error)
+	}
+	consistency := model.LogConsistency{From: from, To: to, Proof: make([][]byte, len(rm.Proof))}
+	var err error
+	if consistency.FromRoot, err = proof.Decode(rm.FromRoot); err != nil {
+		return model.LogConsistency{}, errors.Wrap(err, "invalid consistency root")
+	}
+	if consistency.ToRoot, err = proof.Decode(rm.ToRoot); err != nil {
+		return model.LogConsistency{}, errors.Wrap(err, "invalid consistency root")
+	}
+	for i, hash := range rm.Proof {
+		if consistency.Proof[i], err = proof.Decode(hash); err != nil {
+			return model.LogConsistency{}, errors.Wrap(err, "invalid consistency proof")
+		}
+	}
+	return consistency, nil
+}
@@ -0,0 +1,134 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// FetchProtocol is the libp2p protocol files are fetched from a single
+// peer with, eg. to repair a corrupt copy
+const FetchProtocol = protocol.ID("/p2p-file-sharing/fetch/1.0.0")
+
+// FileStream fetches single files from peers over direct libp2p streams.
+// Like ChallengeStream, every request gets a stream of its own, which
+// carries one fetchMsg and one fetchResponseMsg.
+type FileStream struct {
+	logger  zerolog.Logger
+	host    host.Host
+	timeout time.Duration
+}
+
+func NewFileStream(logger zerolog.Logger, host host.Host, timeout time.Duration) *FileStream {
+	return &FileStream{
+		logger:  logger,
+		host:    host,
+		timeout: timeout,
+	}
+}
+
+// Handle answers the fetches of peers with serve, which returns the
+// contents of the file and its proof
+func (fs *FileStream) Handle(serve func(setId string, index int) ([]byte, [][]byte, error)) {
+	fs.host.SetStreamHandler(
+		FetchProtocol, func(s network.Stream) {
+			defer s.Close()
+			logger := fs.logger.With().Str("peer", s.Conn().RemotePeer().String()).Logger()
+			ctx, cancel := context.WithTimeout(context.Background(), fs.timeout)
+			defer cancel()
+			defer resetOnDone(ctx, s)()
+
+			var fm fetchMsg
+			if err := json.NewDecoder(s).Decode(&fm); err != nil {
+				logger.Error().Err(err).Msg("failed to decode fetch")
+				_ = s.Reset()
+				return
+			}
+
+			var out fetchResponseMsg
+			contents, path, err := serve(fm.SetId, fm.Index)
+			if err != nil {
+				logger.Debug().Err(err).Str("set-id", fm.SetId).Int("index", fm.Index).Msg("failed to serve file")
+				out.Error = err.Error()
+			} else {
+				out.Contents = proof.Encode(contents)
+				if path != nil {
+					out.Proof = make([]string, len(path))
+					for i, hash := range path {
+						out.Proof[i] = proof.Encode(hash)
+					}
+				}
+			}
+			if err := json.NewEncoder(s).Encode(&out); err != nil {
+				logger.Error().Err(err).Msg("failed to send file")
+			}
+		},
+	)
+}
+
+// Fetch asks the peer for a file, the proof is nil if the peer doesn't hold
+// the complete set
+func (fs *FileStream) Fetch(ctx context.Context, peerId, setId string, index int) ([]byte, [][]byte, error) {
+	id, err := peer.Decode(peerId)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid peer id")
+	}
+	ctx, cancel := context.WithTimeout(ctx, fs.timeout)
+	defer cancel()
+
+	s, err := fs.host.NewStream(ctx, id, FetchProtocol)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open fetch stream")
+	}
+	defer s.Close()
+	defer resetOnDone(ctx, s)()
+
+	if err := json.NewEncoder(s).Encode(&fetchMsg{SetId: setId, Index: index}); err != nil {
+		_ = s.Reset()
+		return nil, nil, errors.Wrap(err, "failed to send fetch")
+	}
+	if err := s.CloseWrite(); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to send fetch")
+	}
+
+	var rm fetchResponseMsg
+	if err := json.NewDecoder(s).Decode(&rm); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read fetched file")
+	}
+	if rm.Error != "" {
+		return nil, nil, errors.Errorf("peer could not serve the file: %s", rm.Error)
+	}
+	contents, err := proof.Decode(rm.Contents)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid fetched contents")
+	}
+	var path [][]byte
+	if rm.Proof != nil {
+		path = make([][]byte, len(rm.Proof))
+		for i, hash := range rm.Proof {
+			if path[i], err = proof.Decode(hash); err != nil {
+				return nil, nil, errors.Wrap(err, "invalid fetched proof")
+			}
+		}
+	}
+	return contents, path, nil
+}
+
+// Peers returns the connected peers that serve files
+func (fs *FileStream) Peers() []string {
+	return protocolPeers(fs.host, FetchProtocol)
+}
+
+func (fs *FileStream) Close() error {
+	fs.host.RemoveStreamHandler(FetchProtocol)
+	return nil
+}
@@ -10,11 +10,24 @@ import (
 	"github.com/scottrmalley/p2p-file-sharing/proof"
 )
 
-const FileTopicName = "file-set"
+const (
+	FileTopicName      = "file-set"
+	FileBatchTopicName = "file-set-batch"
+	SetTopicName       = "file-set-meta"
+	DeletionTopicName  = "file-set-delete"
+
+	// maxBatchContents is roughly how much encoded file content we pack into
+	// a single batch message, which keeps us well under the default pubsub
+	// message size limit of 1MiB
+	maxBatchContents = 512 * 1024
+)
 
 type FileTopic struct {
-	mu  sync.Mutex
-	pub *IOTopic[*fileMsg]
+	mu       sync.Mutex
+	pub      *IOTopic[*fileMsg]
+	batchPub *IOTopic[*batchMsg]
+	setPub   *IOTopic[*setMsg]
+	delPub   *IOTopic[*deletionMsg]
 }
 
 func NewFileTopic(
@@ -25,8 +38,23 @@ func NewFileTopic(
 	if err != nil {
 		return nil, err
 	}
+	batchPub, err := NewIOTopic[*batchMsg](logger, connection.ps, FileBatchTopicName, connection.self)
+	if err != nil {
+		return nil, err
+	}
+	setPub, err := NewIOTopic[*setMsg](logger, connection.ps, SetTopicName, connection.self)
+	if err != nil {
+		return nil, err
+	}
+	delPub, err := NewIOTopic[*deletionMsg](logger, connection.ps, DeletionTopicName, connection.self)
+	if err != nil {
+		return nil, err
+	}
 	return &FileTopic{
-		pub: pub,
+		pub:      pub,
+		batchPub: batchPub,
+		setPub:   setPub,
+		delPub:   delPub,
 	}, nil
 }
 
@@ -39,6 +67,13 @@ func (fs *FileTopic) Write(ctx context.Context, file model.File) error {
 			SetId:      file.Metadata.SetId,
 			SetCount:   file.Metadata.SetCount,
 			FileNumber: file.Metadata.FileNumber,
+			Root:       encodeOptional(file.Metadata.Root),
+			Tree:       string(file.Metadata.Tree),
+			KeyHash:    encodeOptional(file.Metadata.KeyHash),
+			Owner:      file.Metadata.Owner,
+			Signature:  encodeOptional(file.Metadata.Signature),
+			Signer:     file.Metadata.Signer,
+			ExpiresAt:  file.Metadata.ExpiresAt,
 		},
 		Contents: proof.Encode(file.Contents),
 	}
@@ -58,11 +93,34 @@ func (fs *FileTopic) Read(ctx context.Context) <-chan model.File {
 				fs.pub.logger.Error().Err(err).Msg("failed to decode file contents")
 				continue
 			}
+			root, err := decodeOptional(fm.Metadata.Root)
+			if err != nil {
+				fs.pub.logger.Error().Err(err).Msg("failed to decode set root")
+				continue
+			}
+			keyHash, err := decodeOptional(fm.Metadata.KeyHash)
+			if err != nil {
+				fs.pub.logger.Error().Err(err).Msg("failed to decode set key hash")
+				continue
+			}
+			signature, err := decodeOptional(fm.Metadata.Signature)
+			if err != nil {
+				fs.pub.logger.Error().Err(err).Msg("failed to decode file signature")
+				continue
+			}
 			f := model.File{
 				Metadata: model.FileMetadata{
 					SetId:      fm.Metadata.SetId,
 					SetCount:   fm.Metadata.SetCount,
 					FileNumber: fm.Metadata.FileNumber,
+					Uploader:   fm.Metadata.SenderId,
+					Root:       root,
+					Tree:       proof.Mode(fm.Metadata.Tree),
+					KeyHash:    keyHash,
+					Owner:      fm.Metadata.Owner,
+					Signature:  signature,
+					Signer:     fm.Metadata.Signer,
+					ExpiresAt:  fm.Metadata.ExpiresAt,
 				},
 				Contents: content,
 			}
@@ -72,6 +130,294 @@ func (fs *FileTopic) Read(ctx context.Context) <-chan model.File {
 	return files
 }
 
+// WriteBatch publishes the files using as few messages as possible. Files
+// are packed into batch messages until they reach maxBatchContents, a file
+// larger than that is sent in a batch of its own.
+func (fs *FileTopic) WriteBatch(ctx context.Context, files []model.File) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var bm *batchMsg
+	size := 0
+	for _, file := range files {
+		contents := proof.Encode(file.Contents)
+		signature := encodeOptional(file.Metadata.Signature)
+		if bm != nil && (bm.Metadata.SetId != file.Metadata.SetId || bm.Metadata.Signature != signature || size+len(contents) > maxBatchContents) {
+			if err := fs.batchPub.Write(ctx, bm); err != nil {
+				return err
+			}
+			bm = nil
+		}
+		if bm == nil {
+			bm = &batchMsg{
+				Metadata: batchMetadata{
+					SenderId:  fs.batchPub.self.String(),
+					SetId:     file.Metadata.SetId,
+					SetCount:  file.Metadata.SetCount,
+					Root:      encodeOptional(file.Metadata.Root),
+					Tree:      string(file.Metadata.Tree),
+					KeyHash:   encodeOptional(file.Metadata.KeyHash),
+					Owner:     file.Metadata.Owner,
+					Signature: signature,
+					Signer:    file.Metadata.Signer,
+					ExpiresAt: file.Metadata.ExpiresAt,
+				},
+			}
+			size = 0
+		}
+		bf := batchFile{FileNumber: file.Metadata.FileNumber, Contents: contents}
+		for _, hash := range file.Proof {
+			bf.Proof = append(bf.Proof, proof.Encode(hash))
+		}
+		bm.Files = append(bm.Files, bf)
+		size += len(contents)
+	}
+	if bm == nil {
+		return nil
+	}
+	return fs.batchPub.Write(ctx, bm)
+}
+
+// ReadBatches works like Read, but for files published with WriteBatch.
+// Each batch is returned as a whole so that it can be saved in one go.
+func (fs *FileTopic) ReadBatches(ctx context.Context) <-chan []model.File {
+	batches := make(chan []model.File)
+	go func() {
+		defer close(batches)
+		for bm := range fs.batchPub.Read(ctx) {
+			root, err := decodeOptional(bm.Metadata.Root)
+			if err != nil {
+				fs.batchPub.logger.Error().Err(err).Msg("failed to decode set root")
+				continue
+			}
+			keyHash, err := decodeOptional(bm.Metadata.KeyHash)
+			if err != nil {
+				fs.batchPub.logger.Error().Err(err).Msg("failed to decode set key hash")
+				continue
+			}
+			signature, err := decodeOptional(bm.Metadata.Signature)
+			if err != nil {
+				fs.batchPub.logger.Error().Err(err).Msg("failed to decode batch signature")
+				continue
+			}
+			files := make([]model.File, 0, len(bm.Files))
+			for _, bf := range bm.Files {
+				content, err := proof.Decode(bf.Contents)
+				if err != nil {
+					fs.batchPub.logger.Error().Err(err).Msg("failed to decode file contents")
+					files = nil
+					break
+				}
+				hashes, err := decodeHashes(bf.Proof)
+				if err != nil {
+					fs.batchPub.logger.Error().Err(err).Msg("failed to decode file proof")
+					files = nil
+					break
+				}
+				files = append(
+					files, model.File{
+						Metadata: model.FileMetadata{
+							SetId:      bm.Metadata.SetId,
+							SetCount:   bm.Metadata.SetCount,
+							FileNumber: bf.FileNumber,
+							Uploader:   bm.Metadata.SenderId,
+							Root:       root,
+							Tree:       proof.Mode(bm.Metadata.Tree),
+							KeyHash:    keyHash,
+							Owner:      bm.Metadata.Owner,
+							Signature:  signature,
+							Signer:     bm.Metadata.Signer,
+							ExpiresAt:  bm.Metadata.ExpiresAt,
+						},
+						Contents: content,
+						Proof:    hashes,
+					},
+				)
+			}
+			if len(files) > 0 {
+				batches <- files
+			}
+		}
+	}()
+	return batches
+}
+
+// WriteSet announces a set to peers, so they know its declared root before
+// (or while) its files arrive
+func (fs *FileTopic) WriteSet(ctx context.Context, declaration model.SetDeclaration) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.setPub.Write(
+		ctx, &setMsg{
+			SenderId:        fs.setPub.self.String(),
+			SetId:           declaration.SetId,
+			SetCount:        declaration.SetCount,
+			Root:            proof.Encode(declaration.Root),
+			Tree:            string(declaration.Tree),
+			KeyHash:         encodeOptional(declaration.KeyHash),
+			Owner:           declaration.Owner,
+			Grants:          declaration.Grants,
+			GrantSignatures: encodeSignatures(declaration.GrantSignatures),
+			Signature:       encodeOptional(declaration.Signature),
+			Signer:          declaration.Signer,
+			ExpiresAt:       declaration.ExpiresAt,
+		},
+	)
+}
+
+// ReadSets returns the set declarations announced by peers
+func (fs *FileTopic) ReadSets(ctx context.Context) <-chan model.SetDeclaration {
+	declarations := make(chan model.SetDeclaration)
+	go func() {
+		defer close(declarations)
+		for sm := range fs.setPub.Read(ctx) {
+			root, err := proof.Decode(sm.Root)
+			if err != nil {
+				fs.setPub.logger.Error().Err(err).Msg("failed to decode set root")
+				continue
+			}
+			keyHash, err := decodeOptional(sm.KeyHash)
+			if err != nil {
+				fs.setPub.logger.Error().Err(err).Msg("failed to decode set key hash")
+				continue
+			}
+			signature, err := decodeOptional(sm.Signature)
+			if err != nil {
+				fs.setPub.logger.Error().Err(err).Msg("failed to decode set signature")
+				continue
+			}
+			grantSignatures, err := decodeSignatures(sm.GrantSignatures)
+			if err != nil {
+				fs.setPub.logger.Error().Err(err).Msg("failed to decode grant signatures")
+				continue
+			}
+			declarations <- model.SetDeclaration{
+				SetId:           sm.SetId,
+				SetCount:        sm.SetCount,
+				Root:            root,
+				Tree:            proof.Mode(sm.Tree),
+				Uploader:        sm.SenderId,
+				KeyHash:         keyHash,
+				Owner:           sm.Owner,
+				Grants:          sm.Grants,
+				GrantSignatures: grantSignatures,
+				Signature:       signature,
+				Signer:          sm.Signer,
+				ExpiresAt:       sm.ExpiresAt,
+			}
+		}
+	}()
+	return declarations
+}
+
+// WriteDeletion asks peers to purge a set
+func (fs *FileTopic) WriteDeletion(ctx context.Context, deletion model.SetDeletion) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.delPub.Write(
+		ctx, &deletionMsg{
+			SenderId:  fs.delPub.self.String(),
+			SetId:     deletion.SetId,
+			Key:       encodeOptional(deletion.Key),
+			Principal: deletion.Principal,
+			Signature: encodeOptional(deletion.Signature),
+		},
+	)
+}
+
+// ReadDeletions returns the set deletions requested by peers
+func (fs *FileTopic) ReadDeletions(ctx context.Context) <-chan model.SetDeletion {
+	deletions := make(chan model.SetDeletion)
+	go func() {
+		defer close(deletions)
+		for dm := range fs.delPub.Read(ctx) {
+			key, err := decodeOptional(dm.Key)
+			if err != nil {
+				fs.delPub.logger.Error().Err(err).Msg("failed to decode set key")
+				continue
+			}
+			signature, err := decodeOptional(dm.Signature)
+			if err != nil {
+				fs.delPub.logger.Error().Err(err).Msg("failed to decode deletion signature")
+				continue
+			}
+			deletions <- model.SetDeletion{
+				SetId:     dm.SetId,
+				Key:       key,
+				Principal: dm.Principal,
+				Signature: signature,
+				DeletedBy: dm.SenderId,
+			}
+		}
+	}()
+	return deletions
+}
+
 func (fs *FileTopic) Close() error {
+	if err := fs.delPub.Close(); err != nil {
+		return err
+	}
+	if err := fs.setPub.Close(); err != nil {
+		return err
+	}
+	if err := fs.batchPub.Close(); err != nil {
+		return err
+	}
 	return fs.pub.Close()
 }
+
+// encodeOptional leaves missing values empty instead of encoding them as 0x
+func encodeOptional(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	return proof.Encode(data)
+}
+
+func decodeOptional(data string) ([]byte, error) {
+	if data == "" {
+		return nil, nil
+	}
+	return proof.Decode(data)
+}
+
+func encodeSignatures(signatures [][]byte) []string {
+	if len(signatures) == 0 {
+		return nil
+	}
+	out := make([]string, len(signatures))
+	for i, signature := range signatures {
+		out[i] = encodeOptional(signature)
+	}
+	return out
+}
+
+func decodeSignatures(signatures []string) ([][]byte, error) {
+	if len(signatures) == 0 {
+		return nil, nil
+	}
+	out := make([][]byte, len(signatures))
+	for i, signature := range signatures {
+		decoded, err := decodeOptional(signature)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = decoded
+	}
+	return out, nil
+}
+
+// decodeHashes decodes a proof, a missing proof stays nil
+func decodeHashes(hashes []string) ([][]byte, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	out := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		var err error
+		if out[i], err = proof.Decode(hash); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,102 @@
+package networking
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+const HeadTopicName = "log-heads"
+
+// HeadTopic gossips the signed heads of the nodes' transparency logs. Heads
+// are signed by the node whose log they describe, so unlike the file topic
+// they can be relayed and checked by anyone.
+type HeadTopic struct {
+	pub *IOTopic[*headMsg]
+}
+
+func NewHeadTopic(
+	logger zerolog.Logger,
+	connection *Connection,
+) (*HeadTopic, error) {
+	pub, err := NewIOTopic[*headMsg](logger, connection.ps, HeadTopicName, connection.self)
+	if err != nil {
+		return nil, err
+	}
+	return &HeadTopic{pub: pub}, nil
+}
+
+// WriteHead announces a head of our log to peers
+func (ht *HeadTopic) WriteHead(ctx context.Context, announcement model.HeadAnnouncement) error {
+	head := announcement.Head
+	consistency := make([]string, len(announcement.Consistency))
+	for i, hash := range announcement.Consistency {
+		consistency[i] = proof.Encode(hash)
+	}
+	return ht.pub.Write(
+		ctx, &headMsg{
+			Node:         head.Node,
+			Size:         head.Size,
+			Root:         proof.Encode(head.Root),
+			Timestamp:    head.Timestamp.UnixMilli(),
+			PublicKey:    proof.Encode(head.PublicKey),
+			Signature:    proof.Encode(head.Signature),
+			PreviousSize: announcement.PreviousSize,
+			Consistency:  consistency,
+		},
+	)
+}
+
+// ReadHeads returns the heads announced by peers
+func (ht *HeadTopic) ReadHeads(ctx context.Context) <-chan model.HeadAnnouncement {
+	announcements := make(chan model.HeadAnnouncement)
+	go func() {
+		defer close(announcements)
+		for hm := range ht.pub.Read(ctx) {
+			announcement, err := hm.toModel()
+			if err != nil {
+				ht.pub.logger.Error().Err(err).Str("node", hm.Node).Msg("failed to decode tree head")
+				continue
+			}
+			announcements <- announcement
+		}
+	}()
+	return announcements
+}
+
+func (ht *HeadTopic) Close() error {
+	return ht.pub.Close()
+}
+
+func (hm *headMsg) toModel() (model.HeadAnnouncement, error) {
+	head := model.TreeHead{
+		Node:      hm.Node,
+		Size:      hm.Size,
+		Timestamp: time.UnixMilli(hm.Timestamp).UTC(),
+	}
+	var err error
+	if head.Root, err = proof.Decode(hm.Root); err != nil {
+		return model.HeadAnnouncement{}, err
+	}
+	if head.PublicKey, err = proof.Decode(hm.PublicKey); err != nil {
+		return model.HeadAnnouncement{}, err
+	}
+	if head.Signature, err = proof.Decode(hm.Signature); err != nil {
+		return model.HeadAnnouncement{}, err
+	}
+	consistency := make([][]byte, len(hm.Consistency))
+	for i, hash := range hm.Consistency {
+		if consistency[i], err = proof.Decode(hash); err != nil {
+			return model.HeadAnnouncement{}, err
+		}
+	}
+	return model.HeadAnnouncement{
+		Head:         head,
+		PreviousSize: hm.PreviousSize,
+		Consistency:  consistency,
+	}, nil
+}
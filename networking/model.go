@@ -1,6 +1,8 @@
 package networking
 
 import (
+	"time"
+
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
@@ -13,6 +15,16 @@ type fileMetadata struct {
 	SetId      string `json:"setId"`
 	SetCount   int    `json:"setCount"`
 	FileNumber int    `json:"fileNumber"`
+	Root       string `json:"root,omitempty"`
+	Tree       string `json:"tree,omitempty"`
+	KeyHash    string `json:"keyHash,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+	// Signature and Signer are the uploader's Ethereum signature and the
+	// address it recovers to, every node checks them before saving the file
+	Signature string `json:"signature,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+	// ExpiresAt is when every node should collect the set, if it has a TTL
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 }
 
 type fileMsg struct {
@@ -20,6 +32,124 @@ type fileMsg struct {
 	Contents string       `json:"contents"`
 }
 
+// batchMsg carries several files of the same set in a single message, so a
+// whole set can be published without one message per file
+type batchMsg struct {
+	Metadata batchMetadata `json:"metadata"`
+	Files    []batchFile   `json:"files"`
+}
+
+type batchMetadata struct {
+	SenderId string `json:"senderId"`
+	SetId    string `json:"setId"`
+	SetCount int    `json:"setCount"`
+	Root     string `json:"root,omitempty"`
+	Tree     string `json:"tree,omitempty"`
+	KeyHash  string `json:"keyHash,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	// Signature is shared by every file of the batch, which is why batches
+	// are split whenever the signature changes
+	Signature string     `json:"signature,omitempty"`
+	Signer    string     `json:"signer,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type batchFile struct {
+	FileNumber int    `json:"fileNumber"`
+	Contents   string `json:"contents"`
+	// Proof ties the contents to the signed root of sets signed as a whole
+	Proof []string `json:"proof,omitempty"`
+}
+
+// setMsg announces a set and its declared root before the files arrive
+type setMsg struct {
+	SenderId string   `json:"senderId"`
+	SetId    string   `json:"setId"`
+	SetCount int      `json:"setCount"`
+	Root     string   `json:"root"`
+	Tree     string   `json:"tree,omitempty"`
+	KeyHash  string   `json:"keyHash,omitempty"`
+	Owner    string   `json:"owner,omitempty"`
+	Grants   []string `json:"grants,omitempty"`
+	// GrantSignatures are hex encoded, one per grant
+	GrantSignatures []string   `json:"grantSignatures,omitempty"`
+	Signature       string     `json:"signature,omitempty"`
+	Signer          string     `json:"signer,omitempty"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+}
+
+// deletionMsg asks peers to purge a set. Like every pubsub message it is
+// signed by the sending peer, and either the key proves that the deletion
+// was requested by whoever uploaded the set, or the principal was
+// authenticated by the sending peer, or signed the deletion.
+type deletionMsg struct {
+	SenderId  string `json:"senderId"`
+	SetId     string `json:"setId"`
+	Key       string `json:"key,omitempty"`
+	Principal string `json:"principal,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// headMsg is a signed head of a node's transparency log. Consistency proves
+// that it extends the head of PreviousSize the node announced before, it is
+// empty for the first head and for heads that didn't grow.
+type headMsg struct {
+	Node         string   `json:"node"`
+	Size         uint64   `json:"size"`
+	Root         string   `json:"root"`
+	Timestamp    int64    `json:"timestamp"`
+	PublicKey    string   `json:"publicKey"`
+	Signature    string   `json:"signature"`
+	PreviousSize uint64   `json:"previousSize,omitempty"`
+	Consistency  []string `json:"consistency,omitempty"`
+}
+
+// challengeMsg asks a peer to prove it holds a file of a set
+type challengeMsg struct {
+	SetId string `json:"setId"`
+	Index int    `json:"index"`
+	Nonce string `json:"nonce"`
+}
+
+// responseMsg answers a challengeMsg, with an error if the peer could not
+type responseMsg struct {
+	Hash  string   `json:"hash,omitempty"`
+	Leaf  string   `json:"leaf,omitempty"`
+	Proof []string `json:"proof,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// fetchMsg asks a peer for a single file of a set
+type fetchMsg struct {
+	SetId string `json:"setId"`
+	Index int    `json:"index"`
+}
+
+// fetchResponseMsg answers a fetchMsg, the proof is null if the peer
+// doesn't hold the complete set, which is not the same as the empty proof
+// of a set with a single file
+type fetchResponseMsg struct {
+	Contents string   `json:"contents,omitempty"`
+	Proof    []string `json:"proof"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// consistencyMsg asks a peer to prove that its log of size From is a
+// prefix of its log of size To
+type consistencyMsg struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// consistencyResponseMsg answers a consistencyMsg, with an error if the
+// peer could not
+type consistencyResponseMsg struct {
+	FromRoot string   `json:"fromRoot,omitempty"`
+	ToRoot   string   `json:"toRoot,omitempty"`
+	Proof    []string `json:"proof,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
 type Connection struct {
 	ps   *pubsub.PubSub
 	self peer.ID
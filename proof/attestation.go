@@ -0,0 +1,62 @@
+package proof
+
+import (
+	"github.com/ethereum/go-ethereum/crypto"
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pkg/errors"
+)
+
+// Nodes attest to the sets they hold by signing them with their libp2p
+// identity key, so a client can tell which nodes vouched for a root. The
+// attestation carries the public key, since not every kind of peer ID
+// embeds it, and the key has to hash to the node's peer ID.
+var attestationTag = crypto.Keccak256([]byte("p2p-file-sharing/attestation"))
+
+// AttestationDigest is what a node signs for a complete set. It covers the
+// tree the root was computed with, since the same root means different files
+// under a positional and a sorted tree.
+func AttestationDigest(setId string, setCount int, root []byte, tree Mode) []byte {
+	return crypto.Keccak256(
+		attestationTag, crypto.Keccak256([]byte(setId)), word(setCount), root,
+		crypto.Keccak256([]byte(tree.OrDefault())),
+	)
+}
+
+// SignAttestation signs the digest with the node's identity key, and
+// returns the signature along with the marshalled public key
+func SignAttestation(digest []byte, key p2pcrypto.PrivKey) ([]byte, []byte, error) {
+	signature, err := key.Sign(digest)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to sign attestation")
+	}
+	publicKey, err := p2pcrypto.MarshalPublicKey(key.GetPublic())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal public key")
+	}
+	return signature, publicKey, nil
+}
+
+// VerifyAttestation checks that the signature over the digest was made by
+// the node, whose public key has to match its peer ID
+func VerifyAttestation(digest []byte, node string, publicKey, signature []byte) error {
+	id, err := peer.Decode(node)
+	if err != nil {
+		return errors.Wrap(err, "invalid node id")
+	}
+	key, err := p2pcrypto.UnmarshalPublicKey(publicKey)
+	if err != nil {
+		return errors.Wrap(err, "invalid public key")
+	}
+	if !id.MatchesPublicKey(key) {
+		return errors.New("public key does not match the node id")
+	}
+	ok, err := key.Verify(digest, signature)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify attestation")
+	}
+	if !ok {
+		return errors.New("attestation signature is invalid")
+	}
+	return nil
+}
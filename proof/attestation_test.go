@@ -0,0 +1,54 @@
+package proof
+
+import (
+	"crypto/rand"
+	"testing"
+
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *ProofTestSuite) TestAttestation() {
+	t := s.T()
+	key, _, err := p2pcrypto.GenerateEd25519Key(rand.Reader)
+	s.Require().NoError(err)
+	node, err := peer.IDFromPrivateKey(key)
+	s.Require().NoError(err)
+	digest := AttestationDigest("set", 2, Hash([]byte("root")), ModePositional)
+
+	t.Run(
+		"it should verify an attestation", func(t *testing.T) {
+			signature, publicKey, err := SignAttestation(digest, key)
+			require.NoError(t, err)
+			require.NoError(t, VerifyAttestation(digest, node.String(), publicKey, signature))
+		},
+	)
+
+	t.Run(
+		"it should reject an attestation for a different set", func(t *testing.T) {
+			signature, publicKey, err := SignAttestation(digest, key)
+			require.NoError(t, err)
+
+			other := AttestationDigest("set", 3, Hash([]byte("root")), ModePositional)
+			require.Error(t, VerifyAttestation(other, node.String(), publicKey, signature))
+
+			other = AttestationDigest("set", 2, Hash([]byte("root")), ModeSorted)
+			require.Error(t, VerifyAttestation(other, node.String(), publicKey, signature))
+
+			// positional is the default tree
+			require.Equal(t, digest, AttestationDigest("set", 2, Hash([]byte("root")), ""))
+		},
+	)
+
+	t.Run(
+		"it should reject a key that does not belong to the node", func(t *testing.T) {
+			other, _, err := p2pcrypto.GenerateEd25519Key(rand.Reader)
+			require.NoError(t, err)
+			signature, publicKey, err := SignAttestation(digest, other)
+			require.NoError(t, err)
+
+			require.Error(t, VerifyAttestation(digest, node.String(), publicKey, signature))
+		},
+	)
+}
@@ -1,6 +1,8 @@
 package proof
 
 import (
+	"io"
+
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 )
@@ -12,6 +14,17 @@ func Hash(data []byte) []byte {
 	return crypto.Keccak256(data)
 }
 
+// HashReader works like Hash, but reads the data from r so that large files
+// don't have to be loaded into memory. It also returns the length of the data.
+func HashReader(r io.Reader) ([]byte, int64, error) {
+	state := crypto.NewKeccakState()
+	n, err := io.Copy(state, r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return state.Sum(nil), n, nil
+}
+
 func Encode(data []byte) string {
 	return hexutil.Encode(data)
 }
@@ -19,3 +32,9 @@ func Encode(data []byte) string {
 func Decode(data string) ([]byte, error) {
 	return hexutil.Decode(data)
 }
+
+// ChallengeHash is how a node proves it holds the contents of a file, the
+// nonce makes sure the hash can't have been computed in advance
+func ChallengeHash(nonce, contents []byte) []byte {
+	return crypto.Keccak256(nonce, contents)
+}
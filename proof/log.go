@@ -0,0 +1,189 @@
+package proof
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+// The transparency log is a Merkle tree over every completed set, built the
+// way RFC 6962 builds certificate transparency logs rather than like
+// MerkleTree. A log only ever grows, and its tree is shaped so that the
+// tree of any earlier size is contained in it, which is what lets a
+// consistency proof show that one head extends another. Leaves and nodes
+// are hashed with different prefixes, so one can never pass for the other.
+const (
+	logLeafPrefix = 0x00
+	logNodePrefix = 0x01
+)
+
+var (
+	logEntryTag = crypto.Keccak256([]byte("p2p-file-sharing/log-entry"))
+	treeHeadTag = crypto.Keccak256([]byte("p2p-file-sharing/tree-head"))
+)
+
+// LogEntry is the data the log records for a set
+func LogEntry(setId string, setCount int, root []byte) []byte {
+	return crypto.Keccak256(logEntryTag, crypto.Keccak256([]byte(setId)), word(setCount), root)
+}
+
+// LogLeafHash is the hash of an entry as a leaf of the log
+func LogLeafHash(entry []byte) []byte {
+	return Hash(append([]byte{logLeafPrefix}, entry...))
+}
+
+// TreeHeadDigest is what a node signs for the head of its log, the
+// timestamp is in unix milliseconds
+func TreeHeadDigest(size uint64, root []byte, timestamp int64) []byte {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(timestamp))
+	return crypto.Keccak256(treeHeadTag, word(int(size)), root, ts)
+}
+
+// LogRoot returns the root of the log with the given leaf hashes, the root
+// of an empty log is the hash of nothing
+func LogRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return Hash(nil)
+	}
+	return logRoot(leaves)
+}
+
+// LogInclusionProof returns the proof that the leaf at index is part of the
+// log with the given leaves
+func LogInclusionProof(leaves [][]byte, index uint64) ([][]byte, error) {
+	if index >= uint64(len(leaves)) {
+		return nil, errors.New("index out of range")
+	}
+	return logPath(index, leaves), nil
+}
+
+// LogConsistencyProof returns the proof that the log of size m is a prefix
+// of the log with the given leaves
+func LogConsistencyProof(leaves [][]byte, m uint64) ([][]byte, error) {
+	if m == 0 || m > uint64(len(leaves)) {
+		return nil, errors.New("size out of range")
+	}
+	return logSubproof(m, leaves, true), nil
+}
+
+// VerifyLogInclusion checks that the leaf is at index in the log of the
+// given size and root
+func VerifyLogInclusion(leaf []byte, index, size uint64, proof [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	hash := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = logNode(p, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = logNode(hash, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(hash, root)
+}
+
+// VerifyLogConsistency checks that the log of size first and root firstRoot
+// is a prefix of the log of size second and root secondRoot. A node that
+// signs two heads which fail this check has equivocated.
+func VerifyLogConsistency(first, second uint64, firstRoot, secondRoot []byte, proof [][]byte) bool {
+	if first == 0 || first > second {
+		return false
+	}
+	if first == second {
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	}
+	// the first tree is a complete subtree of the second one, so its root
+	// is where the proof starts from
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = logNode(c, fr)
+			sr = logNode(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = logNode(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
+
+func logRoot(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return logNode(logRoot(leaves[:k]), logRoot(leaves[k:]))
+}
+
+func logPath(index uint64, leaves [][]byte) [][]byte {
+	if len(leaves) == 1 {
+		return nil
+	}
+	k := uint64(splitPoint(len(leaves)))
+	if index < k {
+		return append(logPath(index, leaves[:k]), logRoot(leaves[k:]))
+	}
+	return append(logPath(index-k, leaves[k:]), logRoot(leaves[:k]))
+}
+
+func logSubproof(m uint64, leaves [][]byte, complete bool) [][]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{logRoot(leaves)}
+	}
+	k := uint64(splitPoint(len(leaves)))
+	if m <= k {
+		return append(logSubproof(m, leaves[:k], complete), logRoot(leaves[k:]))
+	}
+	return append(logSubproof(m-k, leaves[k:], false), logRoot(leaves[:k]))
+}
+
+// splitPoint is the largest power of two smaller than n
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func logNode(left, right []byte) []byte {
+	return Hash(append(append([]byte{logNodePrefix}, left...), right...))
+}
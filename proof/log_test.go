@@ -0,0 +1,91 @@
+package proof
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func logLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = LogLeafHash([]byte(fmt.Sprintf("entry%d", i)))
+	}
+	return leaves
+}
+
+func (s *ProofTestSuite) TestLogProof() {
+	t := s.T()
+	leaves := logLeaves(13)
+
+	t.Run(
+		"it should build the root like RFC 6962", func(t *testing.T) {
+			a, b, c := leaves[0], leaves[1], leaves[2]
+			require.Equal(t, a, LogRoot(leaves[:1]))
+			require.Equal(t, logNode(a, b), LogRoot(leaves[:2]))
+			// the left subtree is the largest power of two
+			require.Equal(t, logNode(logNode(a, b), c), LogRoot(leaves[:3]))
+			require.Equal(t, Hash(nil), LogRoot(nil))
+		},
+	)
+
+	t.Run(
+		"it should verify inclusion at every size", func(t *testing.T) {
+			for size := 1; size <= len(leaves); size++ {
+				root := LogRoot(leaves[:size])
+				for i := 0; i < size; i++ {
+					proof, err := LogInclusionProof(leaves[:size], uint64(i))
+					require.NoError(t, err)
+					require.True(t, VerifyLogInclusion(leaves[i], uint64(i), uint64(size), proof, root), "leaf %d of %d", i, size)
+					if size > 1 {
+						require.False(t, VerifyLogInclusion(leaves[(i+1)%size], uint64(i), uint64(size), proof, root))
+					}
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"it should verify consistency between every pair of sizes", func(t *testing.T) {
+			for second := 1; second <= len(leaves); second++ {
+				secondRoot := LogRoot(leaves[:second])
+				for first := 1; first <= second; first++ {
+					proof, err := LogConsistencyProof(leaves[:second], uint64(first))
+					require.NoError(t, err)
+					firstRoot := LogRoot(leaves[:first])
+					require.True(t, VerifyLogConsistency(uint64(first), uint64(second), firstRoot, secondRoot, proof), "%d to %d", first, second)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"it should reject a rewritten log", func(t *testing.T) {
+			rewritten := logLeaves(13)
+			rewritten[2] = LogLeafHash([]byte("rewritten"))
+
+			for first := 3; first < len(leaves); first++ {
+				proof, err := LogConsistencyProof(rewritten, uint64(first))
+				require.NoError(t, err)
+				require.False(
+					t,
+					VerifyLogConsistency(uint64(first), uint64(len(leaves)), LogRoot(leaves[:first]), LogRoot(rewritten), proof),
+					"%d to %d", first, len(leaves),
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"it should reject sizes out of range", func(t *testing.T) {
+			_, err := LogInclusionProof(leaves, uint64(len(leaves)))
+			require.Error(t, err)
+			_, err = LogConsistencyProof(leaves, 0)
+			require.Error(t, err)
+			_, err = LogConsistencyProof(leaves, uint64(len(leaves)+1))
+			require.Error(t, err)
+			require.False(t, VerifyLogConsistency(5, 4, LogRoot(leaves[:5]), LogRoot(leaves[:4]), nil))
+		},
+	)
+}
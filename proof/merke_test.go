@@ -123,4 +123,43 @@ func (s *ProofTestSuite) TestProof() {
 			require.True(t, valid)
 		},
 	)
+
+	t.Run(
+		"it should build the same proof from hashes", func(t *testing.T) {
+			data := [][]byte{
+				[]byte("foo"),
+				[]byte("bar"),
+				[]byte("foo"),
+			}
+			hashes := make([][]byte, len(data))
+			for i, leaf := range data {
+				hashes[i] = Hash(leaf)
+			}
+
+			root, err := Root(data)
+			require.NoError(t, err)
+
+			// the duplicate leaf should still get the proof for its own index
+			proof, err := ProofFromHashes(hashes, 2)
+			require.NoError(t, err)
+
+			valid, err := VerifyProof(data[2], proof, 2, root)
+			require.NoError(t, err)
+			require.True(t, valid)
+		},
+	)
+
+	t.Run(
+		"it should not prove the padding of the tree", func(t *testing.T) {
+			tree, err := NewMerkleTree([][]byte{[]byte("foo"), []byte("bar"), []byte("baz")})
+			require.NoError(t, err)
+
+			_, err = tree.ProofAt(2)
+			require.NoError(t, err)
+			_, err = tree.ProofAt(3)
+			require.Error(t, err)
+			_, err = tree.ProofAt(4)
+			require.Error(t, err)
+		},
+	)
 }
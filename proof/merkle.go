@@ -23,6 +23,16 @@ func Proof(data [][]byte, leaf []byte) ([][]byte, uint64, error) {
 	return proof, pos, nil
 }
 
+// ProofFromHashes returns the proof for the leaf at the given index, using
+// the already hashed leaves of the set
+func ProofFromHashes(hashes [][]byte, index uint64) ([][]byte, error) {
+	tree, err := NewMerkleTreeFromHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+	return tree.ProofAt(index)
+}
+
 func Verify(leaf []byte, proof [][]byte, index uint64, root []byte) (bool, error) {
 	return VerifyProof(leaf, proof, index, root)
 }
@@ -0,0 +1,91 @@
+package proof
+
+import "github.com/pkg/errors"
+
+// Mode is the kind of tree a set's root is computed with. Sets are
+// positional unless they ask for a sorted tree, whose proofs can be
+// verified on chain.
+type Mode string
+
+const (
+	// ModePositional is the MerkleTree, which hashes nodes in the order of
+	// the leaves and pads the tree with zero hashes
+	ModePositional Mode = "positional"
+	// ModeSorted is the SortedMerkleTree, which is compatible with
+	// OpenZeppelin's MerkleProof
+	ModeSorted Mode = "sorted"
+)
+
+// Tree is what both kinds of tree have in common
+type Tree interface {
+	Root() []byte
+	ProofAt(index uint64) ([][]byte, error)
+}
+
+// ParseMode parses the mode of a set, where nothing means positional
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModePositional:
+		return ModePositional, nil
+	case ModeSorted:
+		return ModeSorted, nil
+	default:
+		return "", errors.Errorf("unknown tree mode %q", s)
+	}
+}
+
+// OrDefault returns the mode, or ModePositional if the mode was never set
+func (m Mode) OrDefault() Mode {
+	if m == "" {
+		return ModePositional
+	}
+	return m
+}
+
+// TreeFromHashes builds the tree of the mode from already hashed leaves
+func (m Mode) TreeFromHashes(hashes [][]byte) (Tree, error) {
+	switch m.OrDefault() {
+	case ModePositional:
+		return NewMerkleTreeFromHashes(hashes)
+	case ModeSorted:
+		return NewSortedMerkleTreeFromHashes(hashes)
+	default:
+		return nil, errors.Errorf("unknown tree mode %q", m)
+	}
+}
+
+// Root computes the root of the data with the tree of the mode
+func (m Mode) Root(data [][]byte) ([]byte, error) {
+	hashes := make([][]byte, len(data))
+	for i, leaf := range data {
+		hashes[i] = Hash(leaf)
+	}
+	tree, err := m.TreeFromHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Root(), nil
+}
+
+// ProofFromHashes returns the proof for the leaf at the given index with
+// the tree of the mode
+func (m Mode) ProofFromHashes(hashes [][]byte, index uint64) ([][]byte, error) {
+	tree, err := m.TreeFromHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+	return tree.ProofAt(index)
+}
+
+// Verify checks a proof made with the tree of the mode. Sorted proofs
+// don't need the index and ignore it, they can't tell where the leaf is.
+func (m Mode) Verify(leaf []byte, proof [][]byte, index uint64, root []byte) (bool, error) {
+	switch m.OrDefault() {
+	case ModePositional:
+		return VerifyProof(leaf, proof, index, root)
+	case ModeSorted:
+		return VerifySortedProof(leaf, proof, root)
+	default:
+		return false, errors.Errorf("unknown tree mode %q", m)
+	}
+}
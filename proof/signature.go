@@ -0,0 +1,121 @@
+package proof
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+// Uploads can be signed with an Ethereum (secp256k1) key. The signed data is
+// a digest of what is being uploaded, signed the same way as personal_sign
+// (EIP-191), so that any Ethereum wallet can produce the signatures. Each
+// kind of digest starts with its own tag, so a signature for one can never
+// be passed off as a signature for another.
+var (
+	fileTag     = crypto.Keccak256([]byte("p2p-file-sharing/file"))
+	setTag      = crypto.Keccak256([]byte("p2p-file-sharing/set"))
+	deletionTag = crypto.Keccak256([]byte("p2p-file-sharing/deletion"))
+	grantTag    = crypto.Keccak256([]byte("p2p-file-sharing/grant"))
+)
+
+const signatureLength = crypto.SignatureLength
+
+// FileDigest is what the uploader signs for a single file: where the file
+// goes, its hash and the root of the set it belongs to
+func FileDigest(setId string, setCount, index int, fileHash, root []byte) []byte {
+	return crypto.Keccak256(fileTag, crypto.Keccak256([]byte(setId)), word(setCount), word(index), fileHash, root)
+}
+
+// SetDigest is what the uploader signs for a whole set. The root commits to
+// every file of the set, so a single signature covers all of them.
+func SetDigest(setId string, setCount int, root []byte) []byte {
+	return crypto.Keccak256(setTag, crypto.Keccak256([]byte(setId)), word(setCount), root)
+}
+
+// DeletionDigest is what the owner of a set signs to delete it
+func DeletionDigest(setId string) []byte {
+	return crypto.Keccak256(deletionTag, crypto.Keccak256([]byte(setId)))
+}
+
+// GrantDigest is what the owner of a set signs to allow another principal
+// to change it
+func GrantDigest(setId, grantee string) []byte {
+	return crypto.Keccak256(grantTag, crypto.Keccak256([]byte(setId)), crypto.Keccak256([]byte(grantee)))
+}
+
+// Sign signs the digest with the key, the recovery id of the signature is
+// 27 or 28 like the signatures wallets produce
+func Sign(digest []byte, key *ecdsa.PrivateKey) ([]byte, error) {
+	signature, err := crypto.Sign(textHash(digest), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign digest")
+	}
+	signature[crypto.RecoveryIDOffset] += 27
+	return signature, nil
+}
+
+// Recover returns the checksummed address of whoever signed the digest. The
+// recovery id can either be 0/1 or 27/28.
+func Recover(digest, signature []byte) (string, error) {
+	if len(signature) != signatureLength {
+		return "", errors.Errorf("signature must be %d bytes long", signatureLength)
+	}
+	sig := make([]byte, signatureLength)
+	copy(sig, signature)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	key, err := crypto.SigToPub(textHash(digest), sig)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to recover signer")
+	}
+	return Address(key), nil
+}
+
+// ParseKey parses a hex encoded secp256k1 private key
+func ParseKey(s string) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid signing key")
+	}
+	return key, nil
+}
+
+// Address returns the checksummed address of a public key
+func Address(key *ecdsa.PublicKey) string {
+	return crypto.PubkeyToAddress(*key).Hex()
+}
+
+// IsAddress reports whether s is an Ethereum address, rather than a
+// principal authenticated with credentials
+func IsAddress(s string) bool {
+	return common.IsHexAddress(s)
+}
+
+// NormalizeAddress checksums s if it is an Ethereum address, so that
+// addresses can be compared as strings however they were written
+func NormalizeAddress(s string) string {
+	if !common.IsHexAddress(s) {
+		return s
+	}
+	return common.HexToAddress(s).Hex()
+}
+
+// textHash prefixes the data the way personal_sign does, which keeps the
+// signature from ever being valid for a transaction
+func textHash(data []byte) []byte {
+	return crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(data))), data)
+}
+
+// word encodes n as a 32 byte big endian integer, like abi.encodePacked
+// does for a uint256
+func word(n int) []byte {
+	out := make([]byte, 32)
+	binary.BigEndian.PutUint64(out[24:], uint64(n))
+	return out
+}
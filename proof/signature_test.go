@@ -0,0 +1,71 @@
+package proof
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *ProofTestSuite) TestSignature() {
+	t := s.T()
+	key, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	root := Hash([]byte("root"))
+
+	t.Run(
+		"it should recover the signer", func(t *testing.T) {
+			digest := FileDigest("set", 2, 0, Hash([]byte("file")), root)
+			signature, err := Sign(digest, key)
+			require.NoError(t, err)
+			require.Contains(t, []byte{27, 28}, signature[crypto.RecoveryIDOffset])
+
+			signer, err := Recover(digest, signature)
+			require.NoError(t, err)
+			require.Equal(t, address, signer)
+
+			// recovery ids of 0 and 1 are accepted as well
+			signature[crypto.RecoveryIDOffset] -= 27
+			signer, err = Recover(digest, signature)
+			require.NoError(t, err)
+			require.Equal(t, address, signer)
+		},
+	)
+
+	t.Run(
+		"it should not recover the signer for a different digest", func(t *testing.T) {
+			signature, err := Sign(SetDigest("set", 2, root), key)
+			require.NoError(t, err)
+
+			for _, digest := range [][]byte{
+				SetDigest("set", 3, root),
+				SetDigest("other", 2, root),
+				FileDigest("set", 2, 0, Hash([]byte("file")), root),
+				DeletionDigest("set"),
+			} {
+				signer, err := Recover(digest, signature)
+				if err == nil {
+					require.NotEqual(t, address, signer)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"it should reject malformed signatures", func(t *testing.T) {
+			_, err := Recover(DeletionDigest("set"), []byte("signature"))
+			require.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"it should normalize addresses", func(t *testing.T) {
+			require.Equal(t, address, NormalizeAddress(strings.ToLower(address)))
+			require.Equal(t, "alice", NormalizeAddress("alice"))
+			require.True(t, IsAddress(address))
+			require.False(t, IsAddress("alice"))
+		},
+	)
+}
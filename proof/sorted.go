@@ -0,0 +1,109 @@
+package proof
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// SortedMerkleTree hashes each pair of nodes in sorted order rather than by
+// position, like OpenZeppelin's MerkleProof expects. Leaves are hashed twice
+// the way OpenZeppelin's StandardMerkleTree hashes a bytes32 value, so a
+// leaf can never be mistaken for the 64 bytes of an inner node. Its proofs
+// can be checked on chain with
+//
+//	bytes32 leaf = keccak256(bytes.concat(keccak256(abi.encode(keccak256(file)))));
+//	MerkleProof.verify(proof, root, leaf)
+//
+// Since pairs are sorted, proofs don't depend on the index of the leaf, and
+// don't prove it either: a proof only shows that the file is one of the
+// leaves. A node without a sibling is carried up to the next level as it is
+// instead of being hashed with padding.
+type SortedMerkleTree struct {
+	// levels holds every level of the tree, starting with the leaves
+	levels [][][]byte
+}
+
+func NewSortedMerkleTree(data [][]byte) (*SortedMerkleTree, error) {
+	hashes := make([][]byte, len(data))
+	for i, leaf := range data {
+		hashes[i] = Hash(leaf)
+	}
+	return NewSortedMerkleTreeFromHashes(hashes)
+}
+
+// NewSortedMerkleTreeFromHashes builds the tree from the hashes of the files
+func NewSortedMerkleTreeFromHashes(hashes [][]byte) (*SortedMerkleTree, error) {
+	if len(hashes) == 0 {
+		return nil, errors.New("no leaves provided")
+	}
+
+	leaves := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		leaves[i] = sortedLeaf(hash)
+	}
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, hashPair(level[i], level[i+1]))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return &SortedMerkleTree{levels: levels}, nil
+}
+
+func (t *SortedMerkleTree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// ProofAt returns the proof for the leaf at the given index. Levels where
+// the node has no sibling are left out, so proofs can be shorter than the
+// depth of the tree.
+func (t *SortedMerkleTree) ProofAt(index uint64) ([][]byte, error) {
+	if index >= uint64(len(t.levels[0])) {
+		return nil, errors.New("index out of range")
+	}
+
+	hashes := make([][]byte, 0, len(t.levels)-1)
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < uint64(len(level)) {
+			hashes = append(hashes, level[sibling])
+		}
+		index /= 2
+	}
+	return hashes, nil
+}
+
+// VerifySortedProof checks a proof of a SortedMerkleTree, which is what
+// MerkleProof.verify does on chain. It takes no index since the proof isn't
+// bound to one, so the index a node reports along with a sorted proof (the
+// X-Proof-Index header) can't be checked against it.
+func VerifySortedProof(leaf []byte, hashes [][]byte, root []byte) (bool, error) {
+	hash := sortedLeaf(Hash(leaf))
+	for _, h := range hashes {
+		hash = hashPair(hash, h)
+	}
+	return bytes.Equal(hash, root), nil
+}
+
+// sortedLeaf is the leaf of a file hash, keccak256(keccak256(abi.encode(hash)))
+// as in StandardMerkleTree, where abi.encode of a bytes32 is the hash itself
+func sortedLeaf(hash []byte) []byte {
+	return Hash(Hash(hash))
+}
+
+// hashPair hashes the smaller node first, like OpenZeppelin's
+// Hashes.commutativeKeccak256
+func hashPair(a, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return Hash(append(append(make([]byte, 0, len(a)+len(b)), a...), b...))
+}
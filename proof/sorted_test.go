@@ -0,0 +1,187 @@
+package proof
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof/verifier"
+)
+
+// sortedLeafOf is the leaf OpenZeppelin's StandardMerkleTree computes for a
+// bytes32 value, written out separately from sortedLeaf
+func sortedLeafOf(file []byte) []byte {
+	return crypto.Keccak256(crypto.Keccak256(crypto.Keccak256(file)))
+}
+
+// sortedPair is the hashing OpenZeppelin's MerkleProof does, written out
+// separately from hashPair
+func sortedPair(a, b []byte) []byte {
+	if bytes.Compare(a, b) < 0 {
+		return crypto.Keccak256(a, b)
+	}
+	return crypto.Keccak256(b, a)
+}
+
+func (s *ProofTestSuite) TestSortedProof() {
+	t := s.T()
+	data := [][]byte{
+		[]byte("foo"),
+		[]byte("bar"),
+		[]byte("baz"),
+	}
+	foo, bar, baz := sortedLeafOf(data[0]), sortedLeafOf(data[1]), sortedLeafOf(data[2])
+
+	t.Run(
+		"it should return the root", func(t *testing.T) {
+			tree, err := NewSortedMerkleTree(data)
+			require.NoError(t, err)
+
+			// baz has no sibling, so it is carried up as it is
+			require.Equal(t, Encode(sortedPair(sortedPair(foo, bar), baz)), Encode(tree.Root()))
+		},
+	)
+
+	t.Run(
+		"it should return the root of a single leaf", func(t *testing.T) {
+			tree, err := NewSortedMerkleTree(data[:1])
+			require.NoError(t, err)
+			require.Equal(t, foo, tree.Root())
+
+			proof, err := tree.ProofAt(0)
+			require.NoError(t, err)
+			require.Empty(t, proof)
+		},
+	)
+
+	t.Run(
+		"it should return and verify the proofs", func(t *testing.T) {
+			tree, err := NewSortedMerkleTree(data)
+			require.NoError(t, err)
+
+			proof, err := tree.ProofAt(0)
+			require.NoError(t, err)
+			require.Equal(t, [][]byte{bar, baz}, proof)
+
+			// the level where baz has no sibling is skipped
+			proof, err = tree.ProofAt(2)
+			require.NoError(t, err)
+			require.Equal(t, [][]byte{sortedPair(foo, bar)}, proof)
+
+			for i, leaf := range data {
+				proof, err := tree.ProofAt(uint64(i))
+				require.NoError(t, err)
+				valid, err := VerifySortedProof(leaf, proof, tree.Root())
+				require.NoError(t, err)
+				require.True(t, valid)
+			}
+		},
+	)
+
+	t.Run(
+		"it should not verify a proof for another leaf", func(t *testing.T) {
+			tree, err := NewSortedMerkleTree(data)
+			require.NoError(t, err)
+			proof, err := tree.ProofAt(0)
+			require.NoError(t, err)
+
+			valid, err := VerifySortedProof([]byte("qux"), proof, tree.Root())
+			require.NoError(t, err)
+			require.False(t, valid)
+		},
+	)
+
+	t.Run(
+		"it should pick the tree by mode", func(t *testing.T) {
+			positional, err := Root(data)
+			require.NoError(t, err)
+
+			root, err := Mode("").Root(data)
+			require.NoError(t, err)
+			require.Equal(t, positional, root)
+
+			sorted, err := ModeSorted.Root(data)
+			require.NoError(t, err)
+			require.NotEqual(t, positional, sorted)
+
+			hashes := [][]byte{Hash(data[0]), Hash(data[1]), Hash(data[2])}
+			proof, err := ModeSorted.ProofFromHashes(hashes, 1)
+			require.NoError(t, err)
+			valid, err := ModeSorted.Verify(data[1], proof, 1, sorted)
+			require.NoError(t, err)
+			require.True(t, valid)
+
+			_, err = ParseMode("unsorted")
+			require.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"it should not take an inner node for a leaf", func(t *testing.T) {
+			tree, err := NewSortedMerkleTree(data)
+			require.NoError(t, err)
+
+			// the two children of the root hash to the root, but they aren't
+			// a file of the set
+			inner := append(append([]byte{}, sortedPair(foo, bar)...), baz...)
+			if bytes.Compare(sortedPair(foo, bar), baz) > 0 {
+				inner = append(append([]byte{}, baz...), sortedPair(foo, bar)...)
+			}
+			require.Equal(t, tree.Root(), crypto.Keccak256(inner))
+
+			valid, err := VerifySortedProof(inner, nil, tree.Root())
+			require.NoError(t, err)
+			require.False(t, valid)
+		},
+	)
+
+	t.Run(
+		"it should produce proofs OpenZeppelin's MerkleProof accepts", func(t *testing.T) {
+			key, err := crypto.GenerateKey()
+			require.NoError(t, err)
+			backend := backends.NewSimulatedBackend(
+				core.GenesisAlloc{crypto.PubkeyToAddress(key.PublicKey): {Balance: big.NewInt(1e18)}},
+				30_000_000,
+			)
+			defer backend.Close()
+			auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+			require.NoError(t, err)
+
+			_, tx, contract, err := verifier.DeploySortedProofVerifier(auth, backend)
+			require.NoError(t, err)
+			backend.Commit()
+			_, err = bind.WaitDeployed(context.Background(), backend, tx)
+			require.NoError(t, err)
+
+			files := append(data, []byte("qux"), []byte("quux"))
+			tree, err := NewSortedMerkleTree(files)
+			require.NoError(t, err)
+			root := common.BytesToHash(tree.Root())
+
+			for i, file := range files {
+				proof, err := tree.ProofAt(uint64(i))
+				require.NoError(t, err)
+				words := make([][32]byte, len(proof))
+				for j, hash := range proof {
+					words[j] = common.BytesToHash(hash)
+				}
+
+				valid, err := contract.VerifyFile(&bind.CallOpts{}, words, root, file)
+				require.NoError(t, err)
+				require.True(t, valid, "file %d", i)
+
+				valid, err = contract.VerifyFile(&bind.CallOpts{}, words, root, []byte("other"))
+				require.NoError(t, err)
+				require.False(t, valid)
+			}
+		},
+	)
+}
@@ -10,24 +10,35 @@ import (
 // MerkleTree is an implementation of a Merkle tree. Instead of copying the
 // leaves to pad trees to 2^n, it just uses zero hashes.
 type MerkleTree struct {
-	size  uint64
-	depth uint64
-	nodes [][]byte
+	// leaves is the number of leaves the tree was built from, size is that
+	// number padded to 2^depth
+	leaves uint64
+	size   uint64
+	depth  uint64
+	nodes  [][]byte
 }
 
 func NewMerkleTree(data [][]byte) (*MerkleTree, error) {
-	if len(data) == 0 {
+	hashes := make([][]byte, len(data))
+	for i, leaf := range data {
+		hashes[i] = Hash(leaf)
+	}
+	return NewMerkleTreeFromHashes(hashes)
+}
+
+// NewMerkleTreeFromHashes builds the tree from already hashed leaves, which
+// saves having to load the file contents when the hashes are stored
+func NewMerkleTreeFromHashes(hashes [][]byte) (*MerkleTree, error) {
+	if len(hashes) == 0 {
 		return nil, errors.New("no leaves provided")
 	}
 
-	depth := uint64(math.Ceil(math.Log2(float64(len(data)))))
+	depth := uint64(math.Ceil(math.Log2(float64(len(hashes)))))
 	size := uint64(math.Exp2(float64(depth)))
 	nodes := make([][]byte, 2*size-1)
 
 	// fill in the leaves
-	for i, leaf := range data {
-		nodes[i] = Hash(leaf)
-	}
+	copy(nodes, hashes)
 
 	// fill in the rest of the tree
 	pos := size
@@ -41,7 +52,7 @@ func NewMerkleTree(data [][]byte) (*MerkleTree, error) {
 		pos += nNodes / 2
 	}
 
-	return &MerkleTree{nodes: nodes, size: size, depth: depth}, nil
+	return &MerkleTree{nodes: nodes, leaves: uint64(len(hashes)), size: size, depth: depth}, nil
 }
 
 func (t *MerkleTree) Root() []byte {
@@ -53,6 +64,20 @@ func (t *MerkleTree) Proof(leaf []byte) ([][]byte, uint64, error) {
 	if err != nil {
 		return nil, 0, err
 	}
+	hashes, err := t.ProofAt(index)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hashes, index, nil
+}
+
+// ProofAt returns the proof for the leaf at the given index, which unlike
+// Proof also works when the same leaf appears more than once. The padding
+// isn't a leaf, so there are no proofs for it.
+func (t *MerkleTree) ProofAt(index uint64) ([][]byte, error) {
+	if index >= t.leaves {
+		return nil, errors.New("index out of range")
+	}
 
 	hashes := make([][]byte, t.depth)
 	pos := index
@@ -71,7 +96,7 @@ func (t *MerkleTree) Proof(leaf []byte) ([][]byte, uint64, error) {
 		pos += (nNodes - x) + x/2
 		x = x / 2
 	}
-	return hashes, index, nil
+	return hashes, nil
 }
 
 func VerifyProof(leaf []byte, hashes [][]byte, index uint64, root []byte) (bool, error) {
@@ -88,7 +113,7 @@ func VerifyProof(leaf []byte, hashes [][]byte, index uint64, root []byte) (bool,
 }
 
 func (t *MerkleTree) indexOf(leaf []byte) (uint64, error) {
-	for i := uint64(0); i < t.size; i++ {
+	for i := uint64(0); i < t.leaves; i++ {
 		if bytes.Equal(leaf, t.nodes[i]) {
 			return i, nil
 		}
@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.21;
+
+/// @dev MerkleProof.verify and the functions it uses, as in OpenZeppelin
+/// Contracts v5.0.0 (utils/cryptography/MerkleProof.sol)
+library MerkleProof {
+    function verify(bytes32[] memory proof, bytes32 root, bytes32 leaf) internal pure returns (bool) {
+        return processProof(proof, leaf) == root;
+    }
+
+    function processProof(bytes32[] memory proof, bytes32 leaf) internal pure returns (bytes32) {
+        bytes32 computedHash = leaf;
+        for (uint256 i = 0; i < proof.length; i++) {
+            computedHash = _hashPair(computedHash, proof[i]);
+        }
+        return computedHash;
+    }
+
+    function _hashPair(bytes32 a, bytes32 b) private pure returns (bytes32) {
+        return a < b ? _efficientHash(a, b) : _efficientHash(b, a);
+    }
+
+    function _efficientHash(bytes32 a, bytes32 b) private pure returns (bytes32 value) {
+        /// @solidity memory-safe-assembly
+        assembly {
+            mstore(0x00, a)
+            mstore(0x20, b)
+            value := keccak256(0x00, 0x40)
+        }
+    }
+}
+
+/// @title SortedProofVerifier
+/// @notice Checks that a file belongs to a sorted set the way a contract
+/// would, it only exists for the tests of the proof package.
+contract SortedProofVerifier {
+    function verifyFile(bytes32[] calldata proof, bytes32 root, bytes calldata file) external pure returns (bool) {
+        bytes32 leaf = keccak256(bytes.concat(keccak256(abi.encode(keccak256(file)))));
+        return MerkleProof.verify(proof, root, leaf);
+    }
+}
@@ -0,0 +1,234 @@
+// Code generated - DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package verifier
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = errors.New
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+	_ = abi.ConvertType
+)
+
+// SortedProofVerifierMetaData contains all meta data concerning the SortedProofVerifier contract.
+var SortedProofVerifierMetaData = &bind.MetaData{
+	ABI: "[{\"inputs\":[{\"internalType\":\"bytes32[]\",\"name\":\"proof\",\"type\":\"bytes32[]\"},{\"internalType\":\"bytes32\",\"name\":\"root\",\"type\":\"bytes32\"},{\"internalType\":\"bytes\",\"name\":\"file\",\"type\":\"bytes\"}],\"name\":\"verifyFile\",\"outputs\":[{\"internalType\":\"bool\",\"name\":\"\",\"type\":\"bool\"}],\"stateMutability\":\"pure\",\"type\":\"function\"}]",
+	Bin: "0x608060405234801561001057600080fd5b50610303806100206000396000f3fe608060405234801561001057600080fd5b506004361061002b5760003560e01c80636be25a9d14610030575b600080fd5b61004361003e3660046101d8565b610057565b604051901515815260200160405180910390f35b600080838360405161006a929190610280565b6040805191829003822060208301520160408051601f19818403018152828252805160209182012090830152016040516020818303038152906040528051906020012090506100ef8787808060200260200160405190810160405280939291908181526020018383602002808284376000920191909152508992508591506100fa9050565b979650505050505050565b6000826101078584610110565b14949350505050565b600081815b8451811015610155576101418286838151811061013457610134610290565b602002602001015161015d565b91508061014d816102a6565b915050610115565b509392505050565b6000818310610179576000828152602084905260409020610188565b60008381526020839052604090205b9392505050565b60008083601f8401126101a157600080fd5b50813567ffffffffffffffff8111156101b957600080fd5b6020830191508360208285010111156101d157600080fd5b9250929050565b6000806000806000606086880312156101f057600080fd5b853567ffffffffffffffff8082111561020857600080fd5b818801915088601f83011261021c57600080fd5b81358181111561022b57600080fd5b8960208260051b850101111561024057600080fd5b6020928301975095509087013593506040870135908082111561026257600080fd5b5061026f8882890161018f565b969995985093965092949392505050565b8183823760009101908152919050565b634e487b7160e01b600052603260045260246000fd5b6000600182016102c657634e487b7160e01b600052601160045260246000fd5b506001019056fea264697066735822122075d1761057a2e358b44f5e08f960310b95ba5c80fed93d3d062db310e745d8cc64736f6c63430008150033",
+}
+
+// SortedProofVerifierABI is the input ABI used to generate the binding from.
+// Deprecated: Use SortedProofVerifierMetaData.ABI instead.
+var SortedProofVerifierABI = SortedProofVerifierMetaData.ABI
+
+// SortedProofVerifierBin is the compiled bytecode used for deploying new contracts.
+// Deprecated: Use SortedProofVerifierMetaData.Bin instead.
+var SortedProofVerifierBin = SortedProofVerifierMetaData.Bin
+
+// DeploySortedProofVerifier deploys a new Ethereum contract, binding an instance of SortedProofVerifier to it.
+func DeploySortedProofVerifier(auth *bind.TransactOpts, backend bind.ContractBackend) (common.Address, *types.Transaction, *SortedProofVerifier, error) {
+	parsed, err := SortedProofVerifierMetaData.GetAbi()
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	if parsed == nil {
+		return common.Address{}, nil, nil, errors.New("GetABI returned nil")
+	}
+
+	address, tx, contract, err := bind.DeployContract(auth, *parsed, common.FromHex(SortedProofVerifierBin), backend)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &SortedProofVerifier{SortedProofVerifierCaller: SortedProofVerifierCaller{contract: contract}, SortedProofVerifierTransactor: SortedProofVerifierTransactor{contract: contract}, SortedProofVerifierFilterer: SortedProofVerifierFilterer{contract: contract}}, nil
+}
+
+// SortedProofVerifier is an auto generated Go binding around an Ethereum contract.
+type SortedProofVerifier struct {
+	SortedProofVerifierCaller     // Read-only binding to the contract
+	SortedProofVerifierTransactor // Write-only binding to the contract
+	SortedProofVerifierFilterer   // Log filterer for contract events
+}
+
+// SortedProofVerifierCaller is an auto generated read-only Go binding around an Ethereum contract.
+type SortedProofVerifierCaller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// SortedProofVerifierTransactor is an auto generated write-only Go binding around an Ethereum contract.
+type SortedProofVerifierTransactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// SortedProofVerifierFilterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type SortedProofVerifierFilterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// SortedProofVerifierSession is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type SortedProofVerifierSession struct {
+	Contract     *SortedProofVerifier // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts        // Call options to use throughout this session
+	TransactOpts bind.TransactOpts    // Transaction auth options to use throughout this session
+}
+
+// SortedProofVerifierCallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type SortedProofVerifierCallerSession struct {
+	Contract *SortedProofVerifierCaller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts              // Call options to use throughout this session
+}
+
+// SortedProofVerifierTransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type SortedProofVerifierTransactorSession struct {
+	Contract     *SortedProofVerifierTransactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts              // Transaction auth options to use throughout this session
+}
+
+// SortedProofVerifierRaw is an auto generated low-level Go binding around an Ethereum contract.
+type SortedProofVerifierRaw struct {
+	Contract *SortedProofVerifier // Generic contract binding to access the raw methods on
+}
+
+// SortedProofVerifierCallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type SortedProofVerifierCallerRaw struct {
+	Contract *SortedProofVerifierCaller // Generic read-only contract binding to access the raw methods on
+}
+
+// SortedProofVerifierTransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type SortedProofVerifierTransactorRaw struct {
+	Contract *SortedProofVerifierTransactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewSortedProofVerifier creates a new instance of SortedProofVerifier, bound to a specific deployed contract.
+func NewSortedProofVerifier(address common.Address, backend bind.ContractBackend) (*SortedProofVerifier, error) {
+	contract, err := bindSortedProofVerifier(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &SortedProofVerifier{SortedProofVerifierCaller: SortedProofVerifierCaller{contract: contract}, SortedProofVerifierTransactor: SortedProofVerifierTransactor{contract: contract}, SortedProofVerifierFilterer: SortedProofVerifierFilterer{contract: contract}}, nil
+}
+
+// NewSortedProofVerifierCaller creates a new read-only instance of SortedProofVerifier, bound to a specific deployed contract.
+func NewSortedProofVerifierCaller(address common.Address, caller bind.ContractCaller) (*SortedProofVerifierCaller, error) {
+	contract, err := bindSortedProofVerifier(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SortedProofVerifierCaller{contract: contract}, nil
+}
+
+// NewSortedProofVerifierTransactor creates a new write-only instance of SortedProofVerifier, bound to a specific deployed contract.
+func NewSortedProofVerifierTransactor(address common.Address, transactor bind.ContractTransactor) (*SortedProofVerifierTransactor, error) {
+	contract, err := bindSortedProofVerifier(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SortedProofVerifierTransactor{contract: contract}, nil
+}
+
+// NewSortedProofVerifierFilterer creates a new log filterer instance of SortedProofVerifier, bound to a specific deployed contract.
+func NewSortedProofVerifierFilterer(address common.Address, filterer bind.ContractFilterer) (*SortedProofVerifierFilterer, error) {
+	contract, err := bindSortedProofVerifier(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &SortedProofVerifierFilterer{contract: contract}, nil
+}
+
+// bindSortedProofVerifier binds a generic wrapper to an already deployed contract.
+func bindSortedProofVerifier(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := SortedProofVerifierMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, *parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_SortedProofVerifier *SortedProofVerifierRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _SortedProofVerifier.Contract.SortedProofVerifierCaller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_SortedProofVerifier *SortedProofVerifierRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _SortedProofVerifier.Contract.SortedProofVerifierTransactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_SortedProofVerifier *SortedProofVerifierRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _SortedProofVerifier.Contract.SortedProofVerifierTransactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_SortedProofVerifier *SortedProofVerifierCallerRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _SortedProofVerifier.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_SortedProofVerifier *SortedProofVerifierTransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _SortedProofVerifier.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_SortedProofVerifier *SortedProofVerifierTransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _SortedProofVerifier.Contract.contract.Transact(opts, method, params...)
+}
+
+// VerifyFile is a free data retrieval call binding the contract method 0x6be25a9d.
+//
+// Solidity: function verifyFile(bytes32[] proof, bytes32 root, bytes file) pure returns(bool)
+func (_SortedProofVerifier *SortedProofVerifierCaller) VerifyFile(opts *bind.CallOpts, proof [][32]byte, root [32]byte, file []byte) (bool, error) {
+	var out []interface{}
+	err := _SortedProofVerifier.contract.Call(opts, &out, "verifyFile", proof, root, file)
+
+	if err != nil {
+		return *new(bool), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(bool)).(*bool)
+
+	return out0, err
+
+}
+
+// VerifyFile is a free data retrieval call binding the contract method 0x6be25a9d.
+//
+// Solidity: function verifyFile(bytes32[] proof, bytes32 root, bytes file) pure returns(bool)
+func (_SortedProofVerifier *SortedProofVerifierSession) VerifyFile(proof [][32]byte, root [32]byte, file []byte) (bool, error) {
+	return _SortedProofVerifier.Contract.VerifyFile(&_SortedProofVerifier.CallOpts, proof, root, file)
+}
+
+// VerifyFile is a free data retrieval call binding the contract method 0x6be25a9d.
+//
+// Solidity: function verifyFile(bytes32[] proof, bytes32 root, bytes file) pure returns(bool)
+func (_SortedProofVerifier *SortedProofVerifierCallerSession) VerifyFile(proof [][32]byte, root [32]byte, file []byte) (bool, error) {
+	return _SortedProofVerifier.Contract.VerifyFile(&_SortedProofVerifier.CallOpts, proof, root, file)
+}
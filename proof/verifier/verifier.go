@@ -0,0 +1,10 @@
+// Package verifier binds SortedProofVerifier.sol, a contract that checks
+// sorted proofs with a copy of OpenZeppelin's MerkleProof. It is only
+// deployed by the tests of the proof package, to check sorted trees against
+// what a contract computes on chain.
+package verifier
+
+// sorted_proof_verifier.go is the binding of the contract and has to be
+// regenerated whenever the contract changes.
+//go:generate solc --evm-version paris --optimize --optimize-runs 200 --abi --bin --overwrite -o build SortedProofVerifier.sol
+//go:generate abigen --abi build/SortedProofVerifier.abi --bin build/SortedProofVerifier.bin --pkg verifier --type SortedProofVerifier --out sorted_proof_verifier.go
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// anchorModel records where the root of a set was anchored on chain. Any
+// node may have submitted it, every node records the same transaction.
+type anchorModel struct {
+	SetId       string `gorm:"primaryKey"`
+	Root        string
+	Contract    string
+	Transaction string
+	Block       uint64
+	AnchoredAt  time.Time
+	Conflicting bool
+}
+
+func (m anchorModel) toModel() (*model.Anchor, error) {
+	root, err := proof.Decode(m.Root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode anchored root")
+	}
+	return &model.Anchor{
+		SetId:       m.SetId,
+		Root:        root,
+		Contract:    m.Contract,
+		Transaction: m.Transaction,
+		Block:       m.Block,
+		AnchoredAt:  m.AnchoredAt,
+		Conflicting: m.Conflicting,
+	}, nil
+}
+
+// SaveAnchor records the anchor of a set, a set is only ever anchored once
+func (r *Files) SaveAnchor(anchor model.Anchor) error {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(
+		&anchorModel{
+			SetId:       anchor.SetId,
+			Root:        proof.Encode(anchor.Root),
+			Contract:    anchor.Contract,
+			Transaction: anchor.Transaction,
+			Block:       anchor.Block,
+			AnchoredAt:  anchor.AnchoredAt,
+			Conflicting: anchor.Conflicting,
+		},
+	)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to save anchor")
+	}
+	return nil
+}
+
+// UnanchoredSets returns complete sets that have not been anchored yet,
+// oldest first, leaving out the skipped ones. Quarantined sets are left
+// out too, their root is not the one the uploader meant.
+func (r *Files) UnanchoredSets(skip []string, limit int) ([]model.FileSet, error) {
+	query := r.db.
+		Where("completed_at IS NOT NULL AND quarantined = ?", false).
+		Where("set_id NOT IN (?)", r.db.Model(&anchorModel{}).Select("set_id"))
+	if len(skip) > 0 {
+		query = query.Where("set_id NOT IN ?", skip)
+	}
+	var sets []fileSetModel
+	if err := query.
+		Order("completed_at ASC").
+		Limit(limit).
+		Find(&sets).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get unanchored sets")
+	}
+	out := make([]model.FileSet, len(sets))
+	for i, set := range sets {
+		var err error
+		if out[i], err = set.toModel(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// anchor returns the anchor of the set, or nil if it has not been anchored
+func (r *Files) anchor(tx *gorm.DB, setId string) (*model.Anchor, error) {
+	var anchor anchorModel
+	result := tx.Where("set_id = ?", setId).Limit(1).Find(&anchor)
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "failed to get anchor")
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return anchor.toModel()
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore is a content addressed store for file contents. Blobs are keyed
+// by their FileHash, so a file that appears in several sets is only ever
+// stored once. Reference counting is handled by the Files repository, the
+// store itself only has to put, get and delete blobs.
+type BlobStore interface {
+	Put(hash string, contents []byte) error
+	// PutReader works like Put, but streams the size bytes of contents into
+	// the store. A blob is only stored if the whole reader could be read.
+	PutReader(hash string, contents io.Reader, size int64) error
+	Get(hash string) ([]byte, error)
+	// Open allows reading large blobs without loading them into memory,
+	// the reader is seekable so that byte ranges can be served. The caller
+	// is responsible for closing the reader.
+	Open(hash string) (io.ReadSeekCloser, error)
+	Delete(hash string) error
+}
+
+// checkedReader reads contents that have to match their hash and size. The
+// read that completes different contents fails instead of returning its
+// bytes, so a stream that doesn't match is never stored under the hash.
+type checkedReader struct {
+	r     io.Reader
+	hash  []byte
+	left  int64
+	state crypto.KeccakState
+}
+
+func newCheckedReader(r io.Reader, hash []byte, size int64) *checkedReader {
+	return &checkedReader{r: r, hash: hash, left: size, state: crypto.NewKeccakState()}
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	if c.left == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.state.Write(p[:n])
+	c.left -= int64(n)
+	if c.left == 0 && !bytes.Equal(c.state.Sum(nil), c.hash) {
+		return 0, ErrHashMismatch
+	}
+	if errors.Is(err, io.EOF) && c.left > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// DiskBlobStore stores blobs on the local filesystem. In order to keep
+// directories small, blobs are sharded into two levels of sub-directories
+// using the first bytes of the hash, ie. 0xabcdef... is stored under
+// ab/cd/abcdef...
+type DiskBlobStore struct {
+	root string
+}
+
+func NewDiskBlobStore(root string) (*DiskBlobStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create blob directory")
+	}
+	return &DiskBlobStore{root: root}, nil
+}
+
+func (s *DiskBlobStore) Put(hash string, contents []byte) error {
+	return s.PutReader(hash, bytes.NewReader(contents), int64(len(contents)))
+}
+
+func (s *DiskBlobStore) PutReader(hash string, contents io.Reader, size int64) error {
+	path, err := s.path(hash)
+	if err != nil {
+		return err
+	}
+
+	// blobs are immutable, so if it is already there we don't need to
+	// write it again
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrap(err, "failed to create blob shard")
+	}
+
+	// write to a temporary file first and rename it, so readers never see
+	// a partially written blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create blob")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.CopyN(tmp, contents, size); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write blob")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write blob")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "failed to write blob")
+	}
+	return nil
+}
+
+func (s *DiskBlobStore) Get(hash string) ([]byte, error) {
+	path, err := s.path(hash)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read blob")
+	}
+	return contents, nil
+}
+
+func (s *DiskBlobStore) Open(hash string) (io.ReadSeekCloser, error) {
+	path, err := s.path(hash)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+	return f, nil
+}
+
+func (s *DiskBlobStore) Delete(hash string) error {
+	path, err := s.path(hash)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "failed to delete blob")
+	}
+	return nil
+}
+
+func (s *DiskBlobStore) path(hash string) (string, error) {
+	key, err := blobKey(hash)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(shardedKey(key))), nil
+}
+
+// shardedKey returns the two level sharded layout for a blob key
+// ie. abcdef... becomes ab/cd/abcdef...
+func shardedKey(key string) string {
+	return key[0:2] + "/" + key[2:4] + "/" + key
+}
+
+// blobKey strips the hex prefix from the hash and makes sure what is left
+// is safe to use as a file or object name
+func blobKey(hash string) (string, error) {
+	key := strings.ToLower(strings.TrimPrefix(hash, "0x"))
+	if len(key) < 4 {
+		return "", errors.Errorf("invalid blob hash %q", hash)
+	}
+	for _, c := range key {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return "", errors.Errorf("invalid blob hash %q", hash)
+		}
+	}
+	return key, nil
+}
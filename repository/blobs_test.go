@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+type BlobStoreTestSuite struct {
+	suite.Suite
+}
+
+func TestBlobStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(BlobStoreTestSuite))
+}
+
+func (s *BlobStoreTestSuite) TestDiskBlobStore() {
+	t := s.T()
+	t.Run(
+		"it should shard blobs by hash", func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewDiskBlobStore(dir)
+			require.NoError(t, err)
+
+			contents := []byte("file1")
+			hash := proof.Encode(proof.Hash(contents))
+			require.NoError(t, store.Put(hash, contents))
+
+			key := hash[2:]
+			_, err = os.Stat(filepath.Join(dir, key[0:2], key[2:4], key))
+			require.NoError(t, err)
+
+			out, err := store.Get(hash)
+			require.NoError(t, err)
+			require.Equal(t, contents, out)
+		},
+	)
+
+	t.Run(
+		"it should delete blobs", func(t *testing.T) {
+			store, err := NewDiskBlobStore(t.TempDir())
+			require.NoError(t, err)
+
+			contents := []byte("file1")
+			hash := proof.Encode(proof.Hash(contents))
+			require.NoError(t, store.Put(hash, contents))
+			require.NoError(t, store.Delete(hash))
+
+			_, err = store.Get(hash)
+			require.ErrorIs(t, err, ErrBlobNotFound)
+		},
+	)
+
+	t.Run(
+		"it should reject keys that are not hashes", func(t *testing.T) {
+			store, err := NewDiskBlobStore(t.TempDir())
+			require.NoError(t, err)
+
+			require.Error(t, store.Put("0x../../etc/passwd", []byte("file1")))
+		},
+	)
+}
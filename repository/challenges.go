@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+)
+
+// Peer scores are kept within these bounds, so that a peer that passed a
+// long run of challenges still drops quickly once it starts failing, and a
+// peer that recovers doesn't stay at the bottom forever
+const (
+	MinPeerScore = -100
+	MaxPeerScore = 100
+)
+
+// challengeModel is the result of a challenge we sent to a peer
+type challengeModel struct {
+	ID           uint   `gorm:"primaryKey"`
+	Peer         string `gorm:"index"`
+	SetId        string
+	FileNumber   int
+	Passed       bool
+	Reason       string
+	Duration     time.Duration
+	ChallengedAt time.Time
+}
+
+func (m challengeModel) toModel() model.ChallengeResult {
+	return model.ChallengeResult{
+		Peer:         m.Peer,
+		SetId:        m.SetId,
+		Index:        m.FileNumber,
+		Passed:       m.Passed,
+		Reason:       m.Reason,
+		Duration:     m.Duration,
+		ChallengedAt: m.ChallengedAt,
+	}
+}
+
+// peerScoreModel is the running score of a peer
+type peerScoreModel struct {
+	Peer             string `gorm:"primaryKey"`
+	Score            int
+	Passed           int
+	Failed           int
+	LastChallengedAt time.Time
+}
+
+func (m peerScoreModel) toModel() model.PeerScore {
+	return model.PeerScore{
+		Peer:             m.Peer,
+		Score:            m.Score,
+		Passed:           m.Passed,
+		Failed:           m.Failed,
+		LastChallengedAt: m.LastChallengedAt,
+	}
+}
+
+// RandomCompleteSet returns a random complete set that completed before the
+// given time, so peers had a chance to receive it. Quarantined sets are
+// left out, and so are expired sets, which peers may have collected.
+func (r *Files) RandomCompleteSet(before time.Time) (model.FileSet, error) {
+	var set fileSetModel
+	if err := r.db.
+		Where("completed_at IS NOT NULL AND completed_at < ? AND quarantined = ?", before, false).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Order(clause.Expr{SQL: "RANDOM()"}).
+		First(&set).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.FileSet{}, ErrSetNotFound
+		}
+		return model.FileSet{}, errors.Wrap(err, "failed to get random set")
+	}
+	return set.toModel()
+}
+
+// RecordChallenge saves the result of a challenge and moves the score of
+// the peer by delta, in the same transaction so concurrent challenges of a
+// peer can't lose an update
+func (r *Files) RecordChallenge(result model.ChallengeResult, delta int) (model.PeerScore, error) {
+	var score peerScoreModel
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Create(
+				&challengeModel{
+					Peer:         result.Peer,
+					SetId:        result.SetId,
+					FileNumber:   result.Index,
+					Passed:       result.Passed,
+					Reason:       result.Reason,
+					Duration:     result.Duration,
+					ChallengedAt: result.ChallengedAt,
+				},
+			).Error; err != nil {
+				return errors.Wrap(err, "failed to save challenge")
+			}
+
+			if err := tx.Where(peerScoreModel{Peer: result.Peer}).FirstOrInit(&score).Error; err != nil {
+				return errors.Wrap(err, "failed to get peer score")
+			}
+			score.Score += delta
+			if score.Score < MinPeerScore {
+				score.Score = MinPeerScore
+			}
+			if score.Score > MaxPeerScore {
+				score.Score = MaxPeerScore
+			}
+			// results that neither pass nor cost the peer anything, like
+			// challenges for sets it doesn't have yet, only go into the
+			// history
+			if result.Passed {
+				score.Passed++
+			} else if delta < 0 {
+				score.Failed++
+			}
+			score.LastChallengedAt = result.ChallengedAt
+			if err := tx.Save(&score).Error; err != nil {
+				return errors.Wrap(err, "failed to save peer score")
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return model.PeerScore{}, err
+	}
+	return score.toModel(), nil
+}
+
+// PeerScores returns the score of every peer we challenged, lowest first
+func (r *Files) PeerScores() ([]model.PeerScore, error) {
+	var scores []peerScoreModel
+	if err := r.db.Order("score ASC, peer ASC").Find(&scores).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get peer scores")
+	}
+	out := make([]model.PeerScore, len(scores))
+	for i, score := range scores {
+		out[i] = score.toModel()
+	}
+	return out, nil
+}
+
+// Challenges returns the latest results of challenging the peer, newest
+// first
+func (r *Files) Challenges(peer string, limit int) ([]model.ChallengeResult, error) {
+	var challenges []challengeModel
+	if err := r.db.
+		Where("peer = ?", peer).
+		Order("challenged_at DESC, id DESC").
+		Limit(limit).
+		Find(&challenges).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get challenges")
+	}
+	out := make([]model.ChallengeResult, len(challenges))
+	for i, challenge := range challenges {
+		out[i] = challenge.toModel()
+	}
+	return out, nil
+}
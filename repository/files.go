@@ -1,51 +1,79 @@
 package repository
 
 import (
+	"io"
 	"sort"
 	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/scottrmalley/p2p-file-sharing/model"
 	"github.com/scottrmalley/p2p-file-sharing/proof"
 )
 
+var (
+	ErrFileNotFound = errors.New("file not found")
+	ErrFileConflict = errors.New("a different file is already stored at this index")
+	ErrFileCorrupt  = errors.New("file is corrupt and waiting to be fetched again from peers")
+	ErrHashMismatch = errors.New("file contents do not match their hash")
+)
+
+// Files stores file metadata in any database supported by gorm. Concurrent
+// writes are handled by the unique index on (set_id, file_number) and the
+// database transactions. The BlobStore is outside of those, so writing and
+// purging the same blob is serialized by a lock per hash.
 type Files struct {
 	logger zerolog.Logger
-	mu     sync.Mutex
 	db     *gorm.DB
-}
-
-type fileModel struct {
-	gorm.Model
-	SetId    string
-	FileHash string
-	Contents []byte
+	blobs  BlobStore
+	limits Limits
 
-	SetCount,
-	FileNumber int
+	mu    sync.Mutex
+	locks map[string]*blobLock
 }
 
-type fileModelstruct []fileModel
-
-func (f fileModelstruct) Len() int {
-	return len(f)
+// blobLock is only kept while someone holds or waits for it, so the locks
+// don't grow with the number of blobs
+type blobLock struct {
+	sync.Mutex
+	waiters int
 }
 
-func (f fileModelstruct) Less(i, j int) bool {
-	return f[i].FileNumber < f[j].FileNumber
+// fileModel only holds the file metadata, the contents themselves live
+// in the BlobStore and are referenced by FileHash. Files are always looked
+// up by set and index, so the unique index covers both columns in that order.
+// FileHash is indexed separately for content addressed lookups.
+//
+// Corrupt is set by the Scrubber when the contents no longer match the
+// hash, or the hashes no longer match the set root. Corrupt files are not
+// served until they have been fetched again from a peer.
+type fileModel struct {
+	gorm.Model
+	SetId      string `gorm:"uniqueIndex:idx_file_set_number"`
+	FileNumber int    `gorm:"uniqueIndex:idx_file_set_number"`
+	FileHash   string `gorm:"index"`
+	SetCount   int
+	Corrupt    bool `gorm:"index"`
 }
 
-func (f fileModelstruct) Swap(i, j int) {
-	f[i], f[j] = f[j], f[i]
+// blobModel keeps track of how many files reference a blob, so that we
+// know when it is safe to remove it from the BlobStore. Size is the length
+// of the contents, which is what the node reclaims once the blob is purged.
+type blobModel struct {
+	Hash     string `gorm:"primaryKey"`
+	RefCount int
+	Size     int64
 }
 
-func NewFiles(logger zerolog.Logger, db *gorm.DB) *Files {
+func NewFiles(logger zerolog.Logger, db *gorm.DB, blobs BlobStore) *Files {
 	return &Files{
 		logger: logger,
 		db:     db,
+		blobs:  blobs,
+		locks:  make(map[string]*blobLock),
 	}
 }
 
@@ -55,20 +83,180 @@ func (r *Files) Migrate() error {
 	if err := r.db.AutoMigrate(&fileModel{}); err != nil {
 		return errors.Wrap(err, "migration for fileModel failed")
 	}
+	if err := r.db.AutoMigrate(&blobModel{}); err != nil {
+		return errors.Wrap(err, "migration for blobModel failed")
+	}
+	if err := r.db.AutoMigrate(&fileSetModel{}); err != nil {
+		return errors.Wrap(err, "migration for fileSetModel failed")
+	}
+	if err := r.db.AutoMigrate(&tombstoneModel{}); err != nil {
+		return errors.Wrap(err, "migration for tombstoneModel failed")
+	}
+	if err := r.db.AutoMigrate(&grantModel{}); err != nil {
+		return errors.Wrap(err, "migration for grantModel failed")
+	}
+	if err := r.db.AutoMigrate(&anchorModel{}); err != nil {
+		return errors.Wrap(err, "migration for anchorModel failed")
+	}
+	if err := r.db.AutoMigrate(&logEntryModel{}); err != nil {
+		return errors.Wrap(err, "migration for logEntryModel failed")
+	}
+	if err := r.db.AutoMigrate(&treeHeadModel{}); err != nil {
+		return errors.Wrap(err, "migration for treeHeadModel failed")
+	}
+	if err := r.db.AutoMigrate(&equivocationModel{}); err != nil {
+		return errors.Wrap(err, "migration for equivocationModel failed")
+	}
+	if err := r.db.AutoMigrate(&challengeModel{}); err != nil {
+		return errors.Wrap(err, "migration for challengeModel failed")
+	}
+	if err := r.db.AutoMigrate(&peerScoreModel{}); err != nil {
+		return errors.Wrap(err, "migration for peerScoreModel failed")
+	}
+	// the quota counts what resumable uploads reserved, so the table has to
+	// exist even on nodes that don't take uploads
+	if err := r.db.AutoMigrate(&uploadModel{}); err != nil {
+		return errors.Wrap(err, "migration for uploadModel failed")
+	}
 	return nil
 }
 
+// SaveFile stores the file, unless it has already been stored. Since files
+// can reach a node more than once (eg. uploaded locally and gossiped back),
+// saving the same file twice is not an error, but saving a different file
+// at the same index is. The set record is updated in the same transaction.
 func (r *Files) SaveFile(file model.File) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	hash := proof.Encode(proof.Hash(file.Contents))
-	result := r.db.Create(
+	return r.SaveFiles([]model.File{file})
+}
+
+// SaveFiles works like SaveFile, but stores all the files in a single
+// transaction, so either all of them are saved or none are
+func (r *Files) SaveFiles(files []model.File) error {
+	pending := make([]pendingFile, len(files))
+	for i, file := range files {
+		hash, contents := proof.Encode(proof.Hash(file.Contents)), file.Contents
+		pending[i] = pendingFile{
+			metadata: file.Metadata,
+			hash:     hash,
+			size:     int64(len(contents)),
+			put: func() error {
+				return r.blobs.Put(hash, contents)
+			},
+		}
+	}
+	return r.save(pending)
+}
+
+// SaveStream works like SaveFile, but streams the contents into the
+// BlobStore instead of holding them in memory. The hash and size of the
+// contents have to be known up front, contents that don't match them are
+// not stored.
+func (r *Files) SaveStream(metadata model.FileMetadata, hash []byte, size int64, contents io.Reader) error {
+	encoded := proof.Encode(hash)
+	return r.save(
+		[]pendingFile{
+			{
+				metadata: metadata,
+				hash:     encoded,
+				size:     size,
+				put: func() error {
+					return r.blobs.PutReader(encoded, newCheckedReader(contents, hash, size), size)
+				},
+			},
+		},
+	)
+}
+
+// pendingFile is a file being saved, put writes its contents to the
+// BlobStore
+type pendingFile struct {
+	metadata model.FileMetadata
+	hash     string
+	size     int64
+	put      func() error
+}
+
+func (r *Files) save(files []pendingFile) error {
+	hashes := make([]string, len(files))
+	claims := make([]claim, len(files))
+	for i, file := range files {
+		hashes[i] = file.hash
+		claims[i] = claim{
+			setId:    file.metadata.SetId,
+			setCount: file.metadata.SetCount,
+			index:    file.metadata.FileNumber,
+			size:     file.size,
+			hash:     file.hash,
+		}
+
+		// check before writing the blobs, so we don't store contents we
+		// are about to reject
+		if err := r.checkFileSet(r.db, file.metadata); err != nil {
+			return err
+		}
+	}
+	if err := r.checkClaims(r.db, claims); err != nil {
+		return err
+	}
+
+	// blobs are content addressed, so writing the same blob twice is
+	// harmless and can happen outside the transaction. Put skips blobs that
+	// are already stored though, so they are locked until the references
+	// are committed, or a purge could remove them in between.
+	unlock := r.lockBlobs(hashes)
+	for _, file := range files {
+		if err := file.put(); err != nil {
+			unlock()
+			return errors.Wrap(err, "failed to save file contents")
+		}
+	}
+
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			// check again in the transaction in case other files were
+			// saved in the meantime
+			if err := r.checkClaims(tx, claims); err != nil {
+				return err
+			}
+			for _, file := range files {
+				if err := r.saveFile(tx, file.metadata, file.hash, file.size); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	unlock()
+	if err != nil {
+		// the blobs nothing references were only written for this save,
+		// purging them keeps a failed save from leaking contents
+		r.purgeBlobs(hashes)
+		return err
+	}
+	return nil
+}
+
+func (r *Files) saveFile(tx *gorm.DB, metadata model.FileMetadata, hash string, size int64) error {
+	// check again in the transaction in case another file of the set was
+	// saved in the meantime
+	if err := r.checkFileSet(tx, metadata); err != nil {
+		return err
+	}
+	if err := r.checkTombstone(tx, metadata.SetId, metadata.Owner, metadata.KeyHash); err != nil {
+		return err
+	}
+
+	result := tx.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "set_id"}, {Name: "file_number"}},
+			DoNothing: true,
+		},
+	).Create(
 		&fileModel{
-			SetId:      file.Metadata.SetId,
-			SetCount:   file.Metadata.SetCount,
+			SetId:      metadata.SetId,
+			SetCount:   metadata.SetCount,
 			FileHash:   hash,
-			FileNumber: file.Metadata.FileNumber,
-			Contents:   file.Contents,
+			FileNumber: metadata.FileNumber,
 		},
 	)
 
@@ -77,49 +265,271 @@ func (r *Files) SaveFile(file model.File) error {
 	}
 
 	if result.RowsAffected != 1 {
-		return errors.New("failed to save file")
+		var existing fileModel
+		if err := tx.Where(
+			"set_id = ? AND file_number = ?",
+			metadata.SetId,
+			metadata.FileNumber,
+		).First(&existing).Error; err != nil {
+			return errors.Wrap(err, "failed to save file")
+		}
+		if existing.FileHash != hash {
+			return ErrFileConflict
+		}
+		return r.receiveFile(tx, metadata, false)
 	}
 
-	return nil
+	if err := r.acquireBlob(tx, hash, size); err != nil {
+		return err
+	}
+	return r.receiveFile(tx, metadata, true)
 }
 
 func (r *Files) File(setId string, index int) (model.File, error) {
 	var file fileModel
 	result := r.db.Where("set_id = ? AND file_number = ?", setId, index).First(&file)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return model.File{}, ErrFileNotFound
+	}
 	if result.Error != nil {
 		return model.File{}, errors.Wrap(result.Error, "failed to get file")
 	}
+	if file.Corrupt {
+		return model.File{}, ErrFileCorrupt
+	}
+	contents, err := r.blobs.Get(file.FileHash)
+	if err != nil {
+		return model.File{}, errors.Wrap(err, "failed to get file contents")
+	}
 	return model.File{
 		Metadata: model.FileMetadata{
 			SetId:      file.SetId,
 			SetCount:   file.SetCount,
 			FileNumber: file.FileNumber,
 		},
-		Contents: file.Contents,
+		Contents: contents,
 	}, nil
 }
 
+// OpenFile works like File, but streams the contents from the BlobStore
+// instead of loading them into memory. The caller must close the reader.
+func (r *Files) OpenFile(setId string, index int) (model.FileMetadata, io.ReadSeekCloser, error) {
+	var file fileModel
+	result := r.db.Where("set_id = ? AND file_number = ?", setId, index).First(&file)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return model.FileMetadata{}, nil, ErrFileNotFound
+	}
+	if result.Error != nil {
+		return model.FileMetadata{}, nil, errors.Wrap(result.Error, "failed to get file")
+	}
+	if file.Corrupt {
+		return model.FileMetadata{}, nil, ErrFileCorrupt
+	}
+	contents, err := r.blobs.Open(file.FileHash)
+	if err != nil {
+		return model.FileMetadata{}, nil, errors.Wrap(err, "failed to open file contents")
+	}
+	return model.FileMetadata{
+		SetId:      file.SetId,
+		SetCount:   file.SetCount,
+		FileNumber: file.FileNumber,
+	}, contents, nil
+}
+
+// FilesByHash returns the metadata of every file with the given contents,
+// across all sets, ordered by set and index
+func (r *Files) FilesByHash(hash string) ([]model.FileMetadata, error) {
+	var files []fileModel
+	result := r.db.Where("file_hash = ?", hash).
+		Order("set_id ASC").
+		Order("file_number ASC").
+		Find(&files)
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "failed to get files by hash")
+	}
+
+	out := make([]model.FileMetadata, len(files))
+	for i, file := range files {
+		out[i] = model.FileMetadata{
+			SetId:      file.SetId,
+			SetCount:   file.SetCount,
+			FileNumber: file.FileNumber,
+		}
+	}
+	return out, nil
+}
+
+// Hashes returns the ordered leaf hashes of a set, which is enough to
+// build proofs without loading any of the file contents
+func (r *Files) Hashes(setId string) ([][]byte, error) {
+	var files []fileModel
+	result := r.db.Select("file_hash", "set_count", "file_number").
+		Where("set_id = ?", setId).
+		Order("file_number ASC").
+		Find(&files)
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "failed to get file hashes")
+	}
+	if len(files) < 1 {
+		return nil, ErrSetNotFound
+	}
+	if len(files) != files[0].SetCount {
+		return nil, ErrSetIncomplete
+	}
+
+	hashes := make([][]byte, len(files))
+	for i, file := range files {
+		hash, err := proof.Decode(file.FileHash)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode file hash")
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
 func (r *Files) Files(setId string) ([][]byte, error) {
 	var files []fileModel
 
-	// using to fileIdModel automatically selects only the fileId column
-	result := r.db.Where("set_id = ?", setId).Find(&files).Order("file_number ASC")
+	result := r.db.Where("set_id = ?", setId).Order("file_number ASC").Find(&files)
 	if result.Error != nil {
 		return nil, errors.Wrap(result.Error, "failed to get file contents")
 	}
 	if len(files) < 1 {
-		return nil, errors.New("no files found")
+		return nil, ErrSetNotFound
 	}
 	if len(files) != files[0].SetCount {
-		return nil, errors.New("incomplete file set")
+		return nil, ErrSetIncomplete
 	}
 
-	sort.Sort(fileModelstruct(files))
-
 	contents := make([][]byte, len(files))
 	for i, file := range files {
-		contents[i] = file.Contents
+		if file.Corrupt {
+			return nil, ErrFileCorrupt
+		}
+		blob, err := r.blobs.Get(file.FileHash)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get file contents")
+		}
+		contents[i] = blob
 	}
 
 	return contents, nil
 }
+
+// acquireBlob increments the reference count for a blob, creating the
+// record if this is the first file referencing it. The size is written
+// every time, which fills it in for blobs stored before it was recorded.
+func (r *Files) acquireBlob(tx *gorm.DB, hash string, size int64) error {
+	result := tx.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "hash"}},
+			DoUpdates: clause.Assignments(
+				map[string]interface{}{
+					"ref_count": gorm.Expr("blob_models.ref_count + 1"),
+					"size":      size,
+				},
+			),
+		},
+	).Create(&blobModel{Hash: hash, RefCount: 1, Size: size})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to reference blob")
+	}
+	return nil
+}
+
+// releaseBlob decrements the reference count for a blob, and removes its
+// record once nothing references it anymore. It reports whether the blob
+// is unreferenced, in which case the caller should purge it from the
+// BlobStore once the transaction is committed.
+func (r *Files) releaseBlob(tx *gorm.DB, hash string) (bool, error) {
+	result := tx.Model(&blobModel{}).
+		Where("hash = ?", hash).
+		Update("ref_count", gorm.Expr("ref_count - 1"))
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "failed to release blob")
+	}
+
+	var blob blobModel
+	if err := tx.Where("hash = ?", hash).First(&blob).Error; err != nil {
+		return false, errors.Wrap(err, "failed to release blob")
+	}
+	if blob.RefCount > 0 {
+		return false, nil
+	}
+
+	if err := tx.Delete(&blob).Error; err != nil {
+		return false, errors.Wrap(err, "failed to release blob")
+	}
+	return true, nil
+}
+
+// purgeBlobs removes released blobs from the BlobStore. A blob may have been
+// referenced again since it was released, so it is only removed if it still
+// has no record. The blob stays locked from the check to the delete, so a
+// save can't reference it in between.
+func (r *Files) purgeBlobs(hashes []string) {
+	for _, hash := range hashes {
+		r.purgeBlob(hash)
+	}
+}
+
+func (r *Files) purgeBlob(hash string) {
+	unlock := r.lockBlobs([]string{hash})
+	defer unlock()
+
+	var count int64
+	if err := r.db.Model(&blobModel{}).Where("hash = ?", hash).Count(&count).Error; err != nil {
+		r.logger.Error().Err(err).Str("hash", hash).Msg("failed to check blob before purging it")
+		return
+	}
+	if count > 0 {
+		return
+	}
+	if err := r.blobs.Delete(hash); err != nil {
+		r.logger.Error().Err(err).Str("hash", hash).Msg("failed to purge blob")
+	}
+}
+
+// lockBlobs locks the blobs of the hashes until the returned function is
+// called. The hashes are locked in order, so two saves sharing contents
+// can't deadlock.
+func (r *Files) lockBlobs(hashes []string) func() {
+	unique := make([]string, 0, len(hashes))
+	seen := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		if !seen[hash] {
+			seen[hash] = true
+			unique = append(unique, hash)
+		}
+	}
+	sort.Strings(unique)
+
+	locks := make([]*blobLock, len(unique))
+	r.mu.Lock()
+	for i, hash := range unique {
+		lock, ok := r.locks[hash]
+		if !ok {
+			lock = new(blobLock)
+			r.locks[hash] = lock
+		}
+		lock.waiters++
+		locks[i] = lock
+	}
+	r.mu.Unlock()
+
+	for _, lock := range locks {
+		lock.Lock()
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for i, lock := range locks {
+			lock.Unlock()
+			lock.waiters--
+			if lock.waiters == 0 {
+				delete(r.locks, unique[i])
+			}
+		}
+	}
+}
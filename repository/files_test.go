@@ -0,0 +1,981 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// postgresDsnEnv points the suite at a running postgres instance, eg. the
+// one started by `docker compose --profile test up postgres`
+const postgresDsnEnv = "TEST_POSTGRES_DSN"
+
+// FilesTestSuite is shared between all the databases we support, so that
+// the repository behaves the same regardless of where it is deployed
+type FilesTestSuite struct {
+	suite.Suite
+	open func() gorm.Dialector
+
+	db    *gorm.DB
+	blobs *DiskBlobStore
+	repo  *Files
+}
+
+func TestFilesSqlite(t *testing.T) {
+	suite.Run(
+		t, &FilesTestSuite{
+			open: func() gorm.Dialector {
+				return sqlite.Open("file::memory:")
+			},
+		},
+	)
+}
+
+func TestFilesPostgres(t *testing.T) {
+	dsn := os.Getenv(postgresDsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set, skipping postgres tests", postgresDsnEnv)
+	}
+	suite.Run(
+		t, &FilesTestSuite{
+			open: func() gorm.Dialector {
+				return postgres.Open(dsn)
+			},
+		},
+	)
+}
+
+func (s *FilesTestSuite) SetupTest() {
+	db, err := gorm.Open(s.open(), &gorm.Config{})
+	s.Require().NoError(err)
+	sqlDb, err := db.DB()
+	s.Require().NoError(err)
+	// in-memory sqlite databases are per connection
+	if db.Dialector.Name() == "sqlite" {
+		sqlDb.SetMaxOpenConns(1)
+	}
+
+	// start every test from empty tables
+	s.Require().NoError(db.Migrator().DropTable(&fileModel{}, &blobModel{}, &fileSetModel{}, &tombstoneModel{}, &grantModel{}, &anchorModel{}, &logEntryModel{}, &treeHeadModel{}, &equivocationModel{}, &challengeModel{}, &peerScoreModel{}, &uploadModel{}))
+
+	s.db = db
+	s.blobs, err = NewDiskBlobStore(s.T().TempDir())
+	s.Require().NoError(err)
+	s.repo = NewFiles(zerolog.New(io.Discard), db, s.blobs)
+	s.Require().NoError(s.repo.Migrate())
+}
+
+func (s *FilesTestSuite) TearDownTest() {
+	sqlDb, err := s.db.DB()
+	s.Require().NoError(err)
+	s.Require().NoError(sqlDb.Close())
+}
+
+func (s *FilesTestSuite) saveSet(files [][]byte) string {
+	setId := uuid.NewString()
+	for i, contents := range files {
+		s.Require().NoError(s.repo.SaveFile(newFile(setId, i, len(files), contents)))
+	}
+	return setId
+}
+
+func (s *FilesTestSuite) TestSaveFile() {
+	t := s.T()
+	t.Run(
+		"it should retrieve a saved file", func(t *testing.T) {
+			setId := s.saveSet([][]byte{[]byte("file1"), []byte("file2")})
+
+			file, err := s.repo.File(setId, 1)
+			require.NoError(t, err)
+			require.Equal(t, []byte("file2"), file.Contents)
+			require.Equal(t, 2, file.Metadata.SetCount)
+			require.Equal(t, 1, file.Metadata.FileNumber)
+		},
+	)
+
+	t.Run(
+		"it should ignore a file that was already saved", func(t *testing.T) {
+			setId := s.saveSet([][]byte{[]byte("file1")})
+
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 0, 1, []byte("file1"))))
+
+			var count int64
+			require.NoError(t, s.db.Model(&fileModel{}).Where("set_id = ?", setId).Count(&count).Error)
+			require.Equal(t, int64(1), count)
+		},
+	)
+
+	t.Run(
+		"it should reject a different file at the same index", func(t *testing.T) {
+			setId := s.saveSet([][]byte{[]byte("file1"), []byte("file2")})
+
+			err := s.repo.SaveFile(newFile(setId, 0, 2, []byte("other")))
+			require.ErrorIs(t, err, ErrFileConflict)
+
+			// the contents of the rejected file are not kept
+			_, err = s.blobs.Get(proof.Encode(proof.Hash([]byte("other"))))
+			require.ErrorIs(t, err, ErrBlobNotFound)
+		},
+	)
+
+	t.Run(
+		"it should handle concurrent saves", func(t *testing.T) {
+			setId := uuid.NewString()
+			n := 20
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 2*n)
+			for i := 0; i < n; i++ {
+				// save every file twice to race on the unique index
+				for j := 0; j < 2; j++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						errs <- s.repo.SaveFile(newFile(setId, i, n, []byte{byte(i)}))
+					}(i)
+				}
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				require.NoError(t, err)
+			}
+
+			files, err := s.repo.Files(setId)
+			require.NoError(t, err)
+			require.Len(t, files, n)
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, n, set.Received)
+			require.True(t, set.Complete())
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestFileSet() {
+	t := s.T()
+	t.Run(
+		"it should track the set until it is complete", func(t *testing.T) {
+			testFiles := [][]byte{[]byte("file1"), []byte("file2"), []byte("file3")}
+			setId := uuid.NewString()
+
+			file := newFile(setId, 1, len(testFiles), testFiles[1])
+			file.Metadata.Uploader = "node"
+			require.NoError(t, s.repo.SaveFile(file))
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, len(testFiles), set.SetCount)
+			require.Equal(t, 1, set.Received)
+			require.Equal(t, "node", set.Uploader)
+			require.False(t, set.Complete())
+			require.Nil(t, set.Root)
+
+			for _, i := range []int{2, 0} {
+				require.NoError(t, s.repo.SaveFile(newFile(setId, i, len(testFiles), testFiles[i])))
+			}
+
+			expectedRoot, err := proof.Root(testFiles)
+			require.NoError(t, err)
+
+			set, err = s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, len(testFiles), set.Received)
+			require.True(t, set.Complete())
+			require.Equal(t, expectedRoot, set.Root)
+		},
+	)
+
+	t.Run(
+		"it should not count duplicate files", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 0, 2, []byte("file1"))))
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 0, 2, []byte("file1"))))
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, 1, set.Received)
+			require.False(t, set.Complete())
+		},
+	)
+
+	t.Run(
+		"it should reject files that do not fit the set", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 0, 2, []byte("file1"))))
+
+			require.ErrorIs(t, s.repo.SaveFile(newFile(setId, 1, 3, []byte("file2"))), ErrSetCountMismatch)
+			require.ErrorIs(t, s.repo.SaveFile(newFile(setId, 2, 2, []byte("file3"))), ErrIndexOutOfRange)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestDeclaredRoot() {
+	t := s.T()
+	testFiles := [][]byte{[]byte("file1"), []byte("file2")}
+	root, err := proof.Root(testFiles)
+	s.Require().NoError(err)
+
+	t.Run(
+		"it should complete a set that matches its declared root", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 2, Root: root}))
+			for i, contents := range testFiles {
+				require.NoError(t, s.repo.SaveFile(newFile(setId, i, 2, contents)))
+			}
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.True(t, set.Complete())
+			require.False(t, set.Quarantined)
+			require.Equal(t, root, set.DeclaredRoot)
+		},
+	)
+
+	t.Run(
+		"it should quarantine a set that does not match the root declared with its files", func(t *testing.T) {
+			setId := uuid.NewString()
+			for i, contents := range [][]byte{[]byte("file1"), []byte("other")} {
+				file := newFile(setId, i, 2, contents)
+				file.Metadata.Root = root
+				require.NoError(t, s.repo.SaveFile(file))
+			}
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.True(t, set.Complete())
+			require.True(t, set.Quarantined)
+		},
+	)
+
+	t.Run(
+		"it should check a root declared after the set completed", func(t *testing.T) {
+			setId := s.saveSet([][]byte{[]byte("file1"), []byte("other")})
+			require.NoError(t, s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 2, Root: root}))
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.True(t, set.Quarantined)
+		},
+	)
+
+	t.Run(
+		"it should reject a second, different root", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 2, Root: root}))
+
+			err := s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 2, Root: proof.Hash(root)})
+			require.ErrorIs(t, err, ErrRootConflict)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestTree() {
+	t := s.T()
+	testFiles := [][]byte{[]byte("file1"), []byte("file2"), []byte("file3")}
+	root, err := proof.ModeSorted.Root(testFiles)
+	s.Require().NoError(err)
+
+	t.Run(
+		"it should compute the root with the tree declared for the set", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(
+				t, s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 3, Root: root, Tree: proof.ModeSorted}),
+			)
+			for i, contents := range testFiles {
+				require.NoError(t, s.repo.SaveFile(newFile(setId, i, 3, contents)))
+			}
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.False(t, set.Quarantined)
+			require.Equal(t, proof.ModeSorted, set.Tree)
+			require.Equal(t, root, set.Root)
+		},
+	)
+
+	t.Run(
+		"it should compute the root with the tree declared with the files", func(t *testing.T) {
+			setId := uuid.NewString()
+			for i, contents := range testFiles {
+				file := newFile(setId, i, 3, contents)
+				file.Metadata.Tree = proof.ModeSorted
+				require.NoError(t, s.repo.SaveFile(file))
+			}
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, root, set.Root)
+		},
+	)
+
+	t.Run(
+		"it should default to positional trees", func(t *testing.T) {
+			setId := s.saveSet(testFiles)
+			positional, err := proof.Root(testFiles)
+			require.NoError(t, err)
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, proof.ModePositional, set.Tree)
+			require.Equal(t, positional, set.Root)
+
+			// the root has already been computed as positional
+			err = s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 3, Tree: proof.ModeSorted})
+			require.ErrorIs(t, err, ErrTreeConflict)
+			require.NoError(t, s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 3, Tree: proof.ModePositional}))
+		},
+	)
+
+	t.Run(
+		"it should reject a second, different tree", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 3, Tree: proof.ModeSorted}))
+
+			file := newFile(setId, 0, 3, testFiles[0])
+			file.Metadata.Tree = proof.ModePositional
+			require.ErrorIs(t, s.repo.SaveFile(file), ErrTreeConflict)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestSaveFiles() {
+	t := s.T()
+	t.Run(
+		"it should save a whole set in one go", func(t *testing.T) {
+			testFiles := [][]byte{[]byte("file1"), []byte("file2")}
+			setId := uuid.NewString()
+			files := make([]model.File, len(testFiles))
+			for i, contents := range testFiles {
+				files[i] = newFile(setId, i, len(testFiles), contents)
+			}
+			require.NoError(t, s.repo.SaveFiles(files))
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.True(t, set.Complete())
+		},
+	)
+
+	t.Run(
+		"it should save none of the files if one is rejected", func(t *testing.T) {
+			setId := s.saveSet([][]byte{[]byte("file1")})
+
+			err := s.repo.SaveFiles(
+				[]model.File{
+					newFile(setId, 0, 1, []byte("file1")),
+					newFile(setId+"-other", 0, 1, []byte("file2")),
+					newFile(setId, 0, 1, []byte("conflict")),
+				},
+			)
+			require.ErrorIs(t, err, ErrFileConflict)
+
+			_, err = s.repo.FileSet(setId + "-other")
+			require.Error(t, err)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestSaveStream() {
+	t := s.T()
+	t.Run(
+		"it should stream the contents into the blob store", func(t *testing.T) {
+			contents := []byte("streamed")
+			file := newFile(uuid.NewString(), 0, 1, contents)
+			err := s.repo.SaveStream(file.Metadata, proof.Hash(contents), int64(len(contents)), bytes.NewReader(contents))
+			require.NoError(t, err)
+
+			saved, err := s.repo.File(file.Metadata.SetId, 0)
+			require.NoError(t, err)
+			require.Equal(t, contents, saved.Contents)
+		},
+	)
+
+	t.Run(
+		"it should not store contents that don't match their hash or size", func(t *testing.T) {
+			hash := proof.Hash([]byte("expected"))
+			for _, contents := range [][]byte{[]byte("tampered"), []byte("expect")} {
+				file := newFile(uuid.NewString(), 0, 1, contents)
+				err := s.repo.SaveStream(file.Metadata, hash, int64(len("expected")), bytes.NewReader(contents))
+				require.Error(t, err)
+
+				_, err = s.blobs.Get(proof.Encode(hash))
+				require.ErrorIs(t, err, ErrBlobNotFound)
+				_, err = s.repo.FileSet(file.Metadata.SetId)
+				require.Error(t, err)
+			}
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestFiles() {
+	t := s.T()
+	t.Run(
+		"it should return the contents in order", func(t *testing.T) {
+			testFiles := [][]byte{[]byte("file1"), []byte("file2"), []byte("file3")}
+			setId := uuid.NewString()
+			// save them out of order
+			for _, i := range []int{2, 0, 1} {
+				require.NoError(t, s.repo.SaveFile(newFile(setId, i, len(testFiles), testFiles[i])))
+			}
+
+			files, err := s.repo.Files(setId)
+			require.NoError(t, err)
+			require.Equal(t, testFiles, files)
+
+			hashes, err := s.repo.Hashes(setId)
+			require.NoError(t, err)
+			for i, file := range testFiles {
+				require.Equal(t, proof.Hash(file), hashes[i])
+			}
+		},
+	)
+
+	t.Run(
+		"it should not return incomplete sets", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 0, 2, []byte("file1"))))
+
+			_, err := s.repo.Files(setId)
+			require.ErrorIs(t, err, ErrSetIncomplete)
+			_, err = s.repo.Hashes(setId)
+			require.ErrorIs(t, err, ErrSetIncomplete)
+		},
+	)
+
+	t.Run(
+		"it should report sets and files that are not stored", func(t *testing.T) {
+			setId := s.saveSet([][]byte{[]byte("file1")})
+
+			_, err := s.repo.FileSet(uuid.NewString())
+			require.ErrorIs(t, err, ErrSetNotFound)
+			_, err = s.repo.Hashes(uuid.NewString())
+			require.ErrorIs(t, err, ErrSetNotFound)
+			_, err = s.repo.File(setId, 1)
+			require.ErrorIs(t, err, ErrFileNotFound)
+			_, _, err = s.repo.OpenFile(uuid.NewString(), 0)
+			require.ErrorIs(t, err, ErrFileNotFound)
+		},
+	)
+
+	t.Run(
+		"it should stream file contents", func(t *testing.T) {
+			setId := s.saveSet([][]byte{[]byte("file1")})
+
+			metadata, r, err := s.repo.OpenFile(setId, 0)
+			require.NoError(t, err)
+			defer r.Close()
+
+			contents, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, []byte("file1"), contents)
+			require.Equal(t, setId, metadata.SetId)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestReferenceCounting() {
+	t := s.T()
+	t.Run(
+		"it should store identical files once and release them when unreferenced", func(t *testing.T) {
+			contents := []byte("shared")
+			hash := proof.Encode(proof.Hash(contents))
+			s.saveSet([][]byte{contents})
+			s.saveSet([][]byte{contents})
+
+			var blob blobModel
+			require.NoError(t, s.db.Where("hash = ?", hash).First(&blob).Error)
+			require.Equal(t, 2, blob.RefCount)
+
+			unreferenced, err := s.repo.releaseBlob(s.db, hash)
+			require.NoError(t, err)
+			require.False(t, unreferenced)
+
+			unreferenced, err = s.repo.releaseBlob(s.db, hash)
+			require.NoError(t, err)
+			require.True(t, unreferenced)
+
+			_, err = s.blobs.Get(hash)
+			require.NoError(t, err)
+			s.repo.purgeBlobs([]string{hash})
+			_, err = s.blobs.Get(hash)
+			require.ErrorIs(t, err, ErrBlobNotFound)
+		},
+	)
+
+	t.Run(
+		"it should not purge a blob while a save referencing it is in progress", func(t *testing.T) {
+			contents := []byte("purging")
+			hash := proof.Encode(proof.Hash(contents))
+			require.NoError(t, s.blobs.Put(hash, contents))
+
+			// a save found the blob already stored and hasn't committed yet
+			unlock := s.repo.lockBlobs([]string{hash})
+			purged := make(chan struct{})
+			go func() {
+				defer close(purged)
+				s.repo.purgeBlobs([]string{hash})
+			}()
+
+			select {
+			case <-purged:
+				t.Fatal("blob purged while it was locked")
+			case <-time.After(100 * time.Millisecond):
+			}
+			require.NoError(t, s.repo.acquireBlob(s.db, hash, int64(len(contents))))
+			unlock()
+			<-purged
+
+			_, err := s.blobs.Get(hash)
+			require.NoError(t, err)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestFilesByHash() {
+	t := s.T()
+	t.Run(
+		"it should find every set and index a file is stored at", func(t *testing.T) {
+			contents := []byte("shared")
+			first := s.saveSet([][]byte{[]byte("file1"), contents})
+			second := s.saveSet([][]byte{contents, []byte("file2"), contents})
+
+			files, err := s.repo.FilesByHash(proof.Encode(proof.Hash(contents)))
+			require.NoError(t, err)
+			require.Len(t, files, 3)
+
+			found := make(map[string][]int)
+			for _, file := range files {
+				found[file.SetId] = append(found[file.SetId], file.FileNumber)
+			}
+			require.Equal(t, []int{1}, found[first])
+			require.Equal(t, []int{0, 2}, found[second])
+		},
+	)
+
+	t.Run(
+		"it should return nothing for an unknown hash", func(t *testing.T) {
+			files, err := s.repo.FilesByHash(proof.Encode(proof.Hash([]byte("unknown"))))
+			require.NoError(t, err)
+			require.Empty(t, files)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestDeleteSet() {
+	t := s.T()
+	key := []byte("key")
+	saveKeyedSet := func(files [][]byte) string {
+		setId := uuid.NewString()
+		for i, contents := range files {
+			file := newFile(setId, i, len(files), contents)
+			file.Metadata.KeyHash = proof.Hash(key)
+			s.Require().NoError(s.repo.SaveFile(file))
+		}
+		return setId
+	}
+
+	t.Run(
+		"it should purge the set and refuse its files afterwards", func(t *testing.T) {
+			contents := [][]byte{[]byte("delete1"), []byte("delete2")}
+			setId := saveKeyedSet(contents)
+
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: key, DeletedBy: "node"}))
+
+			_, err := s.repo.FileSet(setId)
+			require.Error(t, err)
+			deleted, err := s.repo.Deleted(setId)
+			require.NoError(t, err)
+			require.True(t, deleted)
+			for _, file := range contents {
+				_, err = s.blobs.Get(proof.Encode(proof.Hash(file)))
+				require.ErrorIs(t, err, ErrBlobNotFound)
+			}
+
+			err = s.repo.SaveFile(newFile(setId, 0, 2, contents[0]))
+			require.ErrorIs(t, err, ErrSetDeleted)
+			err = s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 2})
+			require.ErrorIs(t, err, ErrSetDeleted)
+
+			// the deletion is gossiped back to us, which is fine
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: key}))
+		},
+	)
+
+	t.Run(
+		"it should keep blobs still referenced by other sets", func(t *testing.T) {
+			shared := []byte("shared-delete")
+			setId := saveKeyedSet([][]byte{shared})
+			s.saveSet([][]byte{shared})
+
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: key}))
+			_, err := s.blobs.Get(proof.Encode(proof.Hash(shared)))
+			require.NoError(t, err)
+		},
+	)
+
+	t.Run(
+		"it should refuse the wrong key", func(t *testing.T) {
+			setId := saveKeyedSet([][]byte{[]byte("file1")})
+
+			err := s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: []byte("other")})
+			require.ErrorIs(t, err, ErrSetKeyMismatch)
+			_, err = s.repo.FileSet(setId)
+			require.NoError(t, err)
+		},
+	)
+
+	t.Run(
+		"it should refuse to delete a set uploaded without a key", func(t *testing.T) {
+			setId := s.saveSet([][]byte{[]byte("file1")})
+
+			err := s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: key})
+			require.ErrorIs(t, err, ErrSetNotDeletable)
+		},
+	)
+
+	t.Run(
+		"it should refuse a different key for an existing set", func(t *testing.T) {
+			setId := saveKeyedSet([][]byte{[]byte("file1"), []byte("file2")})
+
+			file := newFile(setId, 1, 2, []byte("file2"))
+			file.Metadata.KeyHash = proof.Hash([]byte("other"))
+			require.ErrorIs(t, s.repo.SaveFile(file), ErrKeyConflict)
+		},
+	)
+
+	t.Run(
+		"it should remember deletions of sets it has not seen yet", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: key}))
+
+			file := newFile(setId, 0, 1, []byte("late"))
+			file.Metadata.KeyHash = proof.Hash(key)
+			err := s.repo.SaveFile(file)
+			require.ErrorIs(t, err, ErrSetDeleted)
+		},
+	)
+
+	t.Run(
+		"it should drop deletions of unseen sets that don't match the set", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: []byte("mallory")}))
+			deleted, err := s.repo.Deleted(setId)
+			require.NoError(t, err)
+			require.False(t, deleted)
+
+			file := newFile(setId, 0, 1, []byte("late"))
+			file.Metadata.KeyHash = proof.Hash(key)
+			require.NoError(t, s.repo.SaveFile(file))
+
+			// the set can be deleted with its own key afterwards
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: key}))
+			deleted, err = s.repo.Deleted(setId)
+			require.NoError(t, err)
+			require.True(t, deleted)
+		},
+	)
+
+	t.Run(
+		"it should keep deletions of unseen sets when a save that doesn't match them fails", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: []byte("mallory")}))
+
+			s.repo.SetLimits(Limits{Capacity: 1})
+			defer s.repo.SetLimits(Limits{})
+			file := newFile(setId, 0, 1, []byte("late"))
+			file.Metadata.KeyHash = proof.Hash(key)
+			require.ErrorIs(t, s.repo.SaveFile(file), ErrNodeFull)
+
+			var count int64
+			require.NoError(t, s.db.Model(&tombstoneModel{}).Where("set_id = ?", setId).Count(&count).Error)
+			require.Equal(t, int64(1), count)
+		},
+	)
+
+	t.Run(
+		"it should not let a deletion of an unseen set take over an owned set", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Principal: "mallory"}))
+
+			file := newFile(setId, 0, 1, []byte("late"))
+			file.Metadata.Owner = "alice"
+			require.NoError(t, s.repo.SaveFile(file))
+
+			err := s.repo.DeleteSet(model.SetDeletion{SetId: setId, Principal: "mallory"})
+			require.ErrorIs(t, err, ErrNotSetOwner)
+			_, err = s.repo.FileSet(setId)
+			require.NoError(t, err)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestOwnership() {
+	t := s.T()
+	saveOwnedSet := func(owner string, files [][]byte) string {
+		setId := uuid.NewString()
+		for i, contents := range files {
+			file := newFile(setId, i, len(files), contents)
+			file.Metadata.Owner = owner
+			s.Require().NoError(s.repo.SaveFile(file))
+		}
+		return setId
+	}
+
+	t.Run(
+		"it should record the owner of the first file", func(t *testing.T) {
+			setId := saveOwnedSet("alice", [][]byte{[]byte("owned1")})
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, "alice", set.Owner)
+			require.True(t, set.Allows("alice"))
+			require.False(t, set.Allows("bob"))
+
+			file := newFile(uuid.NewString(), 0, 2, []byte("owned2"))
+			file.Metadata.Owner = "alice"
+			require.NoError(t, s.repo.SaveFile(file))
+			file = newFile(file.Metadata.SetId, 1, 2, []byte("owned3"))
+			file.Metadata.Owner = "bob"
+			require.ErrorIs(t, s.repo.SaveFile(file), ErrOwnerConflict)
+		},
+	)
+
+	t.Run(
+		"it should refuse files and declarations of an owned set without its owner", func(t *testing.T) {
+			setId := uuid.NewString()
+			file := newFile(setId, 0, 2, []byte("owned4"))
+			file.Metadata.Owner = "alice"
+			require.NoError(t, s.repo.SaveFile(file))
+
+			err := s.repo.SaveFile(newFile(setId, 1, 2, []byte("unowned")))
+			require.ErrorIs(t, err, ErrNotSetOwner)
+			err = s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 2, Grants: []string{"mallory"}})
+			require.ErrorIs(t, err, ErrNotSetOwner)
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, 1, set.Received)
+			require.Empty(t, set.Grants)
+		},
+	)
+
+	t.Run(
+		"it should add grants declared for the set", func(t *testing.T) {
+			setId := saveOwnedSet("alice", [][]byte{[]byte("granted1")})
+
+			for _, grants := range [][]string{{"bob"}, {"carol", "bob"}} {
+				require.NoError(
+					t, s.repo.DeclareSet(
+						model.SetDeclaration{SetId: setId, SetCount: 1, Owner: "alice", Grants: grants},
+					),
+				)
+			}
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, []string{"bob", "carol"}, set.Grants)
+			require.True(t, set.Allows("carol"))
+		},
+	)
+
+	t.Run(
+		"it should only let the owner and its grants delete the set", func(t *testing.T) {
+			setId := saveOwnedSet("alice", [][]byte{[]byte("owned-delete1")})
+			require.NoError(
+				t, s.repo.DeclareSet(
+					model.SetDeclaration{SetId: setId, SetCount: 1, Owner: "alice", Grants: []string{"bob"}},
+				),
+			)
+
+			err := s.repo.DeleteSet(model.SetDeletion{SetId: setId, Principal: "mallory"})
+			require.ErrorIs(t, err, ErrNotSetOwner)
+			err = s.repo.DeleteSet(model.SetDeletion{SetId: setId, Key: []byte("key")})
+			require.ErrorIs(t, err, ErrNotSetOwner)
+
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Principal: "bob"}))
+			deleted, err := s.repo.Deleted(setId)
+			require.NoError(t, err)
+			require.True(t, deleted)
+
+			require.NoError(t, s.repo.DeleteSet(model.SetDeletion{SetId: setId, Principal: "alice"}))
+			err = s.repo.DeleteSet(model.SetDeletion{SetId: setId, Principal: "mallory"})
+			require.ErrorIs(t, err, ErrNotSetOwner)
+		},
+	)
+}
+
+func (s *FilesTestSuite) TestSignatures() {
+	t := s.T()
+	key, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	signer := proof.Address(&key.PublicKey)
+	contents := [][]byte{[]byte("signed1"), []byte("signed2")}
+	root, err := proof.Root(contents)
+	s.Require().NoError(err)
+
+	signedFile := func(setId string, index int, digest []byte) model.File {
+		file := newFile(setId, index, len(contents), contents[index])
+		file.Metadata.Root = root
+		file.Metadata.Owner = signer
+		file.Metadata.Signer = signer
+		file.Metadata.Signature, err = proof.Sign(digest, key)
+		require.NoError(t, err)
+		return file
+	}
+
+	t.Run(
+		"it should verify files signed on their own or through their set", func(t *testing.T) {
+			setId := uuid.NewString()
+			file := signedFile(setId, 0, proof.FileDigest(setId, len(contents), 0, proof.Hash(contents[0]), root))
+			require.NoError(t, VerifyFile(file))
+			require.NoError(t, VerifyFile(newFile(setId, 0, 1, []byte("unsigned"))))
+
+			// files of a set signed as a whole need their proof against the
+			// signed root, the signature alone doesn't cover their contents
+			hashes := [][]byte{proof.Hash(contents[0]), proof.Hash(contents[1])}
+			path, err := proof.ModePositional.ProofFromHashes(hashes, 1)
+			require.NoError(t, err)
+			throughSet := signedFile(setId, 1, proof.SetDigest(setId, len(contents), root))
+			require.ErrorIs(t, VerifyFile(throughSet), ErrInvalidSignature)
+			throughSet.Proof = path
+			require.NoError(t, VerifyFile(throughSet))
+			forged := throughSet
+			forged.Contents = []byte("forged")
+			require.ErrorIs(t, VerifyFile(forged), ErrInvalidSignature)
+
+			tampered := file
+			tampered.Contents = []byte("tampered")
+			require.ErrorIs(t, VerifyFile(tampered), ErrInvalidSignature)
+
+			impostor := file
+			impostor.Metadata.Signer = "0x0000000000000000000000000000000000000001"
+			require.ErrorIs(t, VerifyFile(impostor), ErrInvalidSignature)
+		},
+	)
+
+	t.Run(
+		"it should refuse unsigned changes to sets owned by an address", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.SaveFile(signedFile(setId, 0, proof.SetDigest(setId, len(contents), root))))
+
+			unsigned := newFile(setId, 1, len(contents), contents[1])
+			unsigned.Metadata.Owner = signer
+			require.ErrorIs(t, s.repo.SaveFile(unsigned), ErrNotSetOwner)
+			err := s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: len(contents), Owner: signer, Tree: proof.ModeSorted})
+			require.ErrorIs(t, err, ErrNotSetOwner)
+			err = s.repo.DeleteSet(model.SetDeletion{SetId: setId, Principal: signer})
+			require.ErrorIs(t, err, ErrNotSetOwner)
+
+			// grants the owner signed get through, but nothing else does
+			grantee := "0x0000000000000000000000000000000000000002"
+			signature, err := proof.Sign(proof.GrantDigest(setId, grantee), key)
+			require.NoError(t, err)
+			require.NoError(
+				t, s.repo.DeclareSet(
+					model.SetDeclaration{
+						SetId:           setId,
+						SetCount:        len(contents),
+						Owner:           signer,
+						Tree:            proof.ModeSorted,
+						Grants:          []string{grantee},
+						GrantSignatures: [][]byte{signature},
+					},
+				),
+			)
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Equal(t, []string{grantee}, set.Grants)
+			require.Equal(t, proof.ModePositional, set.Tree)
+			require.Equal(t, 1, set.Received)
+		},
+	)
+
+	t.Run(
+		"it should only accept grants the owner signed", func(t *testing.T) {
+			setId := uuid.NewString()
+			grantee := "0x0000000000000000000000000000000000000002"
+			declaration := model.SetDeclaration{SetId: setId, SetCount: 1, Owner: signer, Grants: []string{grantee}}
+			require.ErrorIs(t, VerifyDeclaration(declaration), ErrInvalidSignature)
+
+			signature, err := proof.Sign(proof.GrantDigest(setId, grantee), key)
+			require.NoError(t, err)
+			declaration.GrantSignatures = [][]byte{signature}
+			require.NoError(t, VerifyDeclaration(declaration))
+
+			// the signature doesn't carry over to another grantee or owner
+			other := declaration
+			other.Grants = []string{"0x0000000000000000000000000000000000000003"}
+			require.ErrorIs(t, VerifyDeclaration(other), ErrInvalidSignature)
+			other = declaration
+			other.Owner = "alice"
+			require.ErrorIs(t, VerifyDeclaration(other), ErrInvalidSignature)
+		},
+	)
+
+	t.Run(
+		"it should verify signed deletions", func(t *testing.T) {
+			setId := uuid.NewString()
+			signature, err := proof.Sign(proof.DeletionDigest(setId), key)
+			require.NoError(t, err)
+			deletion := model.SetDeletion{SetId: setId, Principal: signer, Signature: signature}
+			require.NoError(t, VerifyDeletion(deletion))
+
+			deletion.SetId = uuid.NewString()
+			require.ErrorIs(t, VerifyDeletion(deletion), ErrInvalidSignature)
+
+			// a peer can't claim to delete on behalf of the owner without
+			// its signature, only with the key of the set
+			unsigned := model.SetDeletion{SetId: setId, Principal: "alice"}
+			require.ErrorIs(t, VerifyDeletion(unsigned), ErrInvalidSignature)
+			require.NoError(t, VerifyDeletion(model.SetDeletion{SetId: setId, Key: []byte("key")}))
+		},
+	)
+
+	t.Run(
+		"it should only save signed files from the owner and its grants", func(t *testing.T) {
+			setId := uuid.NewString()
+			owned := newFile(setId, 0, len(contents), contents[0])
+			owned.Metadata.Owner = "alice"
+			require.NoError(t, s.repo.SaveFile(owned))
+
+			file := signedFile(setId, 1, proof.SetDigest(setId, len(contents), root))
+			file.Metadata.Owner = "alice"
+			require.ErrorIs(t, s.repo.SaveFile(file), ErrNotSetOwner)
+
+			require.NoError(
+				t, s.repo.DeclareSet(
+					model.SetDeclaration{SetId: setId, SetCount: len(contents), Owner: "alice", Grants: []string{signer}},
+				),
+			)
+			require.NoError(t, s.repo.SaveFile(file))
+		},
+	)
+}
+
+func newFile(setId string, index, setCount int, contents []byte) model.File {
+	return model.File{
+		Metadata: model.FileMetadata{
+			SetId:      setId,
+			SetCount:   setCount,
+			FileNumber: index,
+		},
+		Contents: contents,
+	}
+}
@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+var (
+	ErrFileTooLarge = errors.New("file is larger than the node accepts")
+	ErrSetTooLarge  = errors.New("set is larger than the node accepts")
+	ErrNodeFull     = errors.New("node does not have the capacity left to store the files")
+)
+
+// Limits bound what a node is willing to store, so that a single client
+// can't fill its disk. MaxSetBytes counts every file of the set, Capacity
+// counts every blob once, however many files share it. Zero means no limit.
+type Limits struct {
+	MaxFileSize int64
+	MaxSetCount int
+	MaxSetBytes int64
+	Capacity    int64
+}
+
+// Check refuses a file of the given size in a set of setCount files, before
+// anything about the node's storage is known. A size of zero only checks
+// the set count.
+func (l Limits) Check(setCount int, size int64) error {
+	if l.MaxSetCount > 0 && setCount > l.MaxSetCount {
+		return errors.Wrapf(ErrSetTooLarge, "%d files, at most %d", setCount, l.MaxSetCount)
+	}
+	if l.MaxFileSize > 0 && size > l.MaxFileSize {
+		return errors.Wrapf(ErrFileTooLarge, "%d bytes, at most %d", size, l.MaxFileSize)
+	}
+	if l.MaxSetBytes > 0 && size > l.MaxSetBytes {
+		return errors.Wrapf(ErrSetTooLarge, "%d bytes, at most %d", size, l.MaxSetBytes)
+	}
+	if l.Capacity > 0 && size > l.Capacity {
+		return errors.Wrapf(ErrNodeFull, "%d bytes, the node holds at most %d", size, l.Capacity)
+	}
+	return nil
+}
+
+// Capacity is how much the node stores against its limits, including what
+// resumable uploads in progress reserved. Free is only meaningful if the
+// node has a capacity.
+type Capacity struct {
+	Limits Limits
+	Used   int64
+	Free   int64
+}
+
+// SetLimits bounds what the repository stores from then on, it has to be
+// called before any file is saved
+func (r *Files) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// Limits returns the limits of the repository
+func (r *Files) Limits() Limits {
+	return r.limits
+}
+
+// Capacity returns how much the node stores, and how much more it can take
+func (r *Files) Capacity() (Capacity, error) {
+	used, err := r.used(r.db)
+	if err != nil {
+		return Capacity{}, err
+	}
+	out := Capacity{Limits: r.limits, Used: used}
+	if r.limits.Capacity > 0 && used < r.limits.Capacity {
+		out.Free = r.limits.Capacity - used
+	}
+	return out, nil
+}
+
+// claim is the space a file takes once it is saved. The hash is left empty
+// for uploads that don't declare it, their contents are counted as new.
+type claim struct {
+	setId    string
+	setCount int
+	index    int
+	size     int64
+	hash     string
+}
+
+// slot is a file of a set, whatever its contents
+type slot struct {
+	setId string
+	index int
+}
+
+// CheckQuota refuses files that would take the set or the node over its
+// limits. Files that are already stored take up no more space, so saving
+// them again is never refused.
+func (r *Files) CheckQuota(files []model.File) error {
+	return r.checkQuota(r.db, files)
+}
+
+// CheckUpload refuses a resumable upload whose declared length would take
+// the set or the node over its limits, along with what other uploads in
+// progress reserved. Once created, the upload reserves its length until it
+// is finalized or abandoned.
+func (r *Files) CheckUpload(upload model.Upload) error {
+	return r.checkClaims(
+		r.db, []claim{
+			{
+				setId:    upload.SetId,
+				setCount: upload.SetCount,
+				index:    upload.FileNumber,
+				size:     upload.Length,
+				hash:     encodeOptional(upload.Hash),
+			},
+		},
+	)
+}
+
+func (r *Files) checkQuota(tx *gorm.DB, files []model.File) error {
+	claims := make([]claim, len(files))
+	for i, file := range files {
+		claims[i] = claim{
+			setId:    file.Metadata.SetId,
+			setCount: file.Metadata.SetCount,
+			index:    file.Metadata.FileNumber,
+			size:     int64(len(file.Contents)),
+			hash:     proof.Encode(proof.Hash(file.Contents)),
+		}
+	}
+	return r.checkClaims(tx, claims)
+}
+
+func (r *Files) checkClaims(tx *gorm.DB, claims []claim) error {
+	for _, c := range claims {
+		if err := r.limits.Check(c.setCount, c.size); err != nil {
+			return err
+		}
+	}
+	if r.limits.MaxSetBytes == 0 && r.limits.Capacity == 0 {
+		return nil
+	}
+
+	// the uploads of the files being checked are what is being saved, so
+	// they don't reserve anything on top of them
+	claimed := make(map[slot]bool, len(claims))
+	for _, c := range claims {
+		claimed[slot{c.setId, c.index}] = true
+	}
+	reserved, err := r.reserved(tx, claimed)
+	if err != nil {
+		return err
+	}
+
+	setBytes := make(map[string]int64)
+	stored := make(map[string]map[int]bool)
+	newBlobs := make(map[string]bool)
+	var newBytes int64
+	for _, c := range claims {
+		if _, ok := stored[c.setId]; !ok {
+			indices, err := r.indices(tx, c.setId)
+			if err != nil {
+				return err
+			}
+			stored[c.setId] = make(map[int]bool, len(indices))
+			for _, index := range indices {
+				stored[c.setId][index] = true
+			}
+			if setBytes[c.setId], err = r.setBytes(tx, c.setId); err != nil {
+				return err
+			}
+			setBytes[c.setId] += reserved.sets[c.setId]
+		}
+		if stored[c.setId][c.index] {
+			continue
+		}
+		stored[c.setId][c.index] = true
+
+		setBytes[c.setId] += c.size
+		if r.limits.MaxSetBytes > 0 && setBytes[c.setId] > r.limits.MaxSetBytes {
+			return errors.Wrapf(ErrSetTooLarge, "%d bytes, at most %d", setBytes[c.setId], r.limits.MaxSetBytes)
+		}
+
+		if c.hash == "" {
+			newBytes += c.size
+			continue
+		}
+		if newBlobs[c.hash] {
+			continue
+		}
+		var count int64
+		if err := tx.Model(&blobModel{}).Where("hash = ?", c.hash).Count(&count).Error; err != nil {
+			return errors.Wrap(err, "failed to get blob")
+		}
+		if count == 0 {
+			newBlobs[c.hash] = true
+			newBytes += c.size
+		}
+	}
+	if r.limits.Capacity == 0 || newBytes == 0 {
+		return nil
+	}
+
+	used, err := r.stored(tx)
+	if err != nil {
+		return err
+	}
+	used += reserved.total
+	if used+newBytes > r.limits.Capacity {
+		return errors.Wrapf(ErrNodeFull, "%d bytes more, %d of %d used", newBytes, used, r.limits.Capacity)
+	}
+	return nil
+}
+
+// used sums up the size of every stored blob, and what resumable uploads
+// in progress reserved
+func (r *Files) used(tx *gorm.DB) (int64, error) {
+	used, err := r.stored(tx)
+	if err != nil {
+		return 0, err
+	}
+	reserved, err := r.reserved(tx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return used + reserved.total, nil
+}
+
+// stored sums up the size of every stored blob
+func (r *Files) stored(tx *gorm.DB) (int64, error) {
+	var used int64
+	if err := tx.Model(&blobModel{}).Select("COALESCE(SUM(size), 0)").Scan(&used).Error; err != nil {
+		return 0, errors.Wrap(err, "failed to get used capacity")
+	}
+	return used, nil
+}
+
+// reservations are the lengths uploads in progress declared, in total and
+// per set
+type reservations struct {
+	total int64
+	sets  map[string]int64
+}
+
+// reserved sums up the declared length of every upload that is not
+// finalized yet, leaving out the uploads of the given slots. The uploads
+// are staged next to the files, in the same database.
+func (r *Files) reserved(tx *gorm.DB, skip map[slot]bool) (reservations, error) {
+	var uploads []uploadModel
+	if err := tx.Select("set_id", "file_number", "length").Find(&uploads).Error; err != nil {
+		return reservations{}, errors.Wrap(err, "failed to get uploads")
+	}
+	out := reservations{sets: make(map[string]int64)}
+	for _, upload := range uploads {
+		if skip[slot{upload.SetId, upload.FileNumber}] {
+			continue
+		}
+		out.total += upload.Length
+		out.sets[upload.SetId] += upload.Length
+	}
+	return out, nil
+}
+
+// setBytes sums up the size of every file stored for the set
+func (r *Files) setBytes(tx *gorm.DB, setId string) (int64, error) {
+	var size int64
+	if err := tx.Model(&fileModel{}).
+		Joins("JOIN blob_models ON blob_models.hash = file_models.file_hash").
+		Where("file_models.set_id = ?", setId).
+		Select("COALESCE(SUM(blob_models.size), 0)").
+		Scan(&size).Error; err != nil {
+		return 0, errors.Wrap(err, "failed to get set size")
+	}
+	return size, nil
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+)
+
+func (s *FilesTestSuite) TestLimits() {
+	t := s.T()
+
+	t.Run(
+		"it should refuse files and sets over the limits", func(t *testing.T) {
+			s.repo.SetLimits(Limits{MaxFileSize: 5, MaxSetCount: 2})
+
+			err := s.repo.SaveFile(newFile(uuid.NewString(), 0, 1, []byte("too large")))
+			require.ErrorIs(t, err, ErrFileTooLarge)
+			err = s.repo.SaveFile(newFile(uuid.NewString(), 0, 3, []byte("file")))
+			require.ErrorIs(t, err, ErrSetTooLarge)
+			err = s.repo.DeclareSet(model.SetDeclaration{SetId: uuid.NewString(), SetCount: 3})
+			require.ErrorIs(t, err, ErrSetTooLarge)
+
+			s.saveSet([][]byte{[]byte("file1"), []byte("file2")})
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should refuse files that take a set over its bytes", func(t *testing.T) {
+			s.repo.SetLimits(Limits{MaxSetBytes: 10})
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 0, 3, []byte("file1"))))
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 1, 3, []byte("file2"))))
+
+			err := s.repo.SaveFile(newFile(setId, 2, 3, []byte("file3")))
+			require.ErrorIs(t, err, ErrSetTooLarge)
+			// files already stored take no more space
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 1, 3, []byte("file2"))))
+
+			err = s.repo.SaveFiles(
+				[]model.File{
+					newFile(uuid.NewString(), 0, 3, []byte("file1")),
+					newFile(uuid.NewString(), 1, 3, []byte("file2")),
+				},
+			)
+			require.NoError(t, err)
+			batchSet := uuid.NewString()
+			err = s.repo.SaveFiles(
+				[]model.File{
+					newFile(batchSet, 0, 3, []byte("file1")),
+					newFile(batchSet, 1, 3, []byte("file2")),
+					newFile(batchSet, 2, 3, []byte("file3")),
+				},
+			)
+			require.ErrorIs(t, err, ErrSetTooLarge)
+			indices, err := s.repo.Indices(batchSet)
+			require.NoError(t, err)
+			require.Empty(t, indices)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should refuse files once the node is full", func(t *testing.T) {
+			s.repo.SetLimits(Limits{Capacity: 12})
+			s.saveSet([][]byte{[]byte("file1"), []byte("file2")})
+
+			capacity, err := s.repo.Capacity()
+			require.NoError(t, err)
+			require.Equal(t, int64(10), capacity.Used)
+			require.Equal(t, int64(2), capacity.Free)
+
+			err = s.repo.SaveFile(newFile(uuid.NewString(), 0, 1, []byte("file3")))
+			require.ErrorIs(t, err, ErrNodeFull)
+			err = s.repo.CheckQuota([]model.File{newFile(uuid.NewString(), 0, 1, []byte("file3"))})
+			require.ErrorIs(t, err, ErrNodeFull)
+
+			// contents the node already stores are shared, and cost nothing
+			require.NoError(t, s.repo.SaveFile(newFile(uuid.NewString(), 0, 1, []byte("file1"))))
+			require.NoError(t, s.repo.SaveFile(newFile(uuid.NewString(), 0, 1, []byte("ab"))))
+
+			capacity, err = s.repo.Capacity()
+			require.NoError(t, err)
+			require.Equal(t, int64(12), capacity.Used)
+			require.Equal(t, int64(0), capacity.Free)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should reserve the length of uploads in progress", func(t *testing.T) {
+			s.repo.SetLimits(Limits{MaxSetBytes: 10, Capacity: 12})
+			uploads, err := NewUploads(zerolog.New(io.Discard), s.db, t.TempDir())
+			require.NoError(t, err)
+
+			setId := uuid.NewString()
+			upload := model.Upload{SetId: setId, SetCount: 2, FileNumber: 0, Length: 8}
+			require.NoError(t, s.repo.CheckUpload(upload))
+			_, err = uploads.CreateUpload(upload)
+			require.NoError(t, err)
+
+			capacity, err := s.repo.Capacity()
+			require.NoError(t, err)
+			require.Equal(t, int64(8), capacity.Used)
+
+			// neither other uploads nor files fit next to the reservation
+			err = s.repo.CheckUpload(model.Upload{SetId: uuid.NewString(), SetCount: 1, Length: 5})
+			require.ErrorIs(t, err, ErrNodeFull)
+			err = s.repo.SaveFile(newFile(uuid.NewString(), 0, 1, []byte("file1")))
+			require.ErrorIs(t, err, ErrNodeFull)
+			err = s.repo.SaveFile(newFile(setId, 1, 2, []byte("abc")))
+			require.ErrorIs(t, err, ErrSetTooLarge)
+
+			// the file of the upload itself takes the space it reserved
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 0, 2, []byte("contents"))))
+		},
+	)
+}
@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+var ErrSetNotLogged = errors.New("set is not in the log")
+
+// logEntryModel is an entry of the node's transparency log. Positions are
+// assigned in order without gaps, and entries outlive the set they record,
+// so deleting a set never changes the log.
+type logEntryModel struct {
+	Position uint64 `gorm:"primaryKey;autoIncrement:false"`
+	SetId    string `gorm:"uniqueIndex"`
+	SetCount int
+	Root     string
+	LoggedAt time.Time
+}
+
+func (m logEntryModel) toModel() (model.LogEntry, error) {
+	root, err := proof.Decode(m.Root)
+	if err != nil {
+		return model.LogEntry{}, errors.Wrap(err, "failed to decode logged root")
+	}
+	return model.LogEntry{
+		Index:    m.Position,
+		SetId:    m.SetId,
+		SetCount: m.SetCount,
+		Root:     root,
+		LoggedAt: m.LoggedAt,
+	}, nil
+}
+
+// headColumns is a signed tree head, it is embedded wherever one is stored
+type headColumns struct {
+	Size      uint64
+	Root      string
+	Timestamp int64
+	PublicKey string
+	Signature string
+}
+
+func newHeadColumns(head model.TreeHead) headColumns {
+	return headColumns{
+		Size:      head.Size,
+		Root:      proof.Encode(head.Root),
+		Timestamp: head.Timestamp.UnixMilli(),
+		PublicKey: proof.Encode(head.PublicKey),
+		Signature: proof.Encode(head.Signature),
+	}
+}
+
+func (m headColumns) toModel(node string) (model.TreeHead, error) {
+	head := model.TreeHead{
+		Node:      node,
+		Size:      m.Size,
+		Timestamp: time.UnixMilli(m.Timestamp).UTC(),
+	}
+	var err error
+	if head.Root, err = proof.Decode(m.Root); err != nil {
+		return model.TreeHead{}, errors.Wrap(err, "failed to decode head root")
+	}
+	if head.PublicKey, err = proof.Decode(m.PublicKey); err != nil {
+		return model.TreeHead{}, errors.Wrap(err, "failed to decode head public key")
+	}
+	if head.Signature, err = proof.Decode(m.Signature); err != nil {
+		return model.TreeHead{}, errors.Wrap(err, "failed to decode head signature")
+	}
+	return head, nil
+}
+
+// treeHeadModel is the latest head a node signed, both our own and those
+// gossiped by peers
+type treeHeadModel struct {
+	Node string      `gorm:"primaryKey"`
+	Head headColumns `gorm:"embedded"`
+}
+
+// equivocationModel keeps both heads a node signed that contradict each
+// other, which is all anyone needs to check the node misbehaved
+type equivocationModel struct {
+	ID         uint   `gorm:"primaryKey"`
+	Node       string `gorm:"index"`
+	Reason     string
+	First      headColumns `gorm:"embedded;embeddedPrefix:first_"`
+	Second     headColumns `gorm:"embedded;embeddedPrefix:second_"`
+	DetectedAt time.Time
+}
+
+func (m equivocationModel) toModel() (model.Equivocation, error) {
+	first, err := m.First.toModel(m.Node)
+	if err != nil {
+		return model.Equivocation{}, err
+	}
+	second, err := m.Second.toModel(m.Node)
+	if err != nil {
+		return model.Equivocation{}, err
+	}
+	return model.Equivocation{
+		Node:       m.Node,
+		Reason:     m.Reason,
+		First:      first,
+		Second:     second,
+		DetectedAt: m.DetectedAt,
+	}, nil
+}
+
+// AppendLog adds complete sets that are not in the log yet, in the order
+// they completed, and returns the new entries. Quarantined sets are left
+// out, like they are from anchoring.
+func (r *Files) AppendLog(limit int) ([]model.LogEntry, error) {
+	var out []model.LogEntry
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			var size int64
+			if err := tx.Model(&logEntryModel{}).Count(&size).Error; err != nil {
+				return errors.Wrap(err, "failed to get log size")
+			}
+			var sets []fileSetModel
+			if err := tx.
+				Where("completed_at IS NOT NULL AND quarantined = ?", false).
+				Where("set_id NOT IN (?)", tx.Model(&logEntryModel{}).Select("set_id")).
+				Order("completed_at ASC, set_id ASC").
+				Limit(limit).
+				Find(&sets).Error; err != nil {
+				return errors.Wrap(err, "failed to get unlogged sets")
+			}
+
+			now := time.Now().UTC()
+			for i, set := range sets {
+				entry := logEntryModel{
+					Position: uint64(size) + uint64(i),
+					SetId:    set.SetId,
+					SetCount: set.SetCount,
+					Root:     set.Root,
+					LoggedAt: now,
+				}
+				if err := tx.Create(&entry).Error; err != nil {
+					return errors.Wrap(err, "failed to append to log")
+				}
+				logged, err := entry.toModel()
+				if err != nil {
+					return err
+				}
+				out = append(out, logged)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LogEntries returns the whole log in order
+func (r *Files) LogEntries() ([]model.LogEntry, error) {
+	var entries []logEntryModel
+	if err := r.db.Order("position ASC").Find(&entries).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get log entries")
+	}
+	out := make([]model.LogEntry, len(entries))
+	for i, entry := range entries {
+		var err error
+		if out[i], err = entry.toModel(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// LogEntry returns the log entry of the set
+func (r *Files) LogEntry(setId string) (model.LogEntry, error) {
+	var entry logEntryModel
+	if err := r.db.Where("set_id = ?", setId).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.LogEntry{}, ErrSetNotLogged
+		}
+		return model.LogEntry{}, errors.Wrap(err, "failed to get log entry")
+	}
+	return entry.toModel()
+}
+
+// SaveTreeHead stores the head as the latest one of its node
+func (r *Files) SaveTreeHead(head model.TreeHead) error {
+	result := r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(
+		&treeHeadModel{
+			Node: head.Node,
+			Head: newHeadColumns(head),
+		},
+	)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to save tree head")
+	}
+	return nil
+}
+
+// TreeHead returns the latest head of the node, or nil if we haven't seen
+// one yet
+func (r *Files) TreeHead(node string) (*model.TreeHead, error) {
+	var head treeHeadModel
+	result := r.db.Where("node = ?", node).Limit(1).Find(&head)
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "failed to get tree head")
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	out, err := head.Head.toModel(head.Node)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// TreeHeads returns the latest head of every node we know of
+func (r *Files) TreeHeads() ([]model.TreeHead, error) {
+	var heads []treeHeadModel
+	if err := r.db.Order("node ASC").Find(&heads).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get tree heads")
+	}
+	out := make([]model.TreeHead, len(heads))
+	for i, head := range heads {
+		var err error
+		if out[i], err = head.Head.toModel(head.Node); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// SaveEquivocation records the evidence that a node equivocated
+func (r *Files) SaveEquivocation(equivocation model.Equivocation) error {
+	if err := r.db.Create(
+		&equivocationModel{
+			Node:       equivocation.Node,
+			Reason:     equivocation.Reason,
+			First:      newHeadColumns(equivocation.First),
+			Second:     newHeadColumns(equivocation.Second),
+			DetectedAt: equivocation.DetectedAt,
+		},
+	).Error; err != nil {
+		return errors.Wrap(err, "failed to save equivocation")
+	}
+	return nil
+}
+
+// Equivocations returns every equivocation detected so far, oldest first
+func (r *Files) Equivocations() ([]model.Equivocation, error) {
+	var equivocations []equivocationModel
+	if err := r.db.Order("id ASC").Find(&equivocations).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get equivocations")
+	}
+	out := make([]model.Equivocation, len(equivocations))
+	for i, equivocation := range equivocations {
+		var err error
+		if out[i], err = equivocation.toModel(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
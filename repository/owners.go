@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOwnerConflict = errors.New("a different owner has already been recorded for the set")
+	ErrNotSetOwner   = errors.New("only the owner of the set, or a principal it granted, can change it")
+)
+
+// grantModel records a principal the owner of a set allowed to add files
+// to or delete the set. Grants are only ever added, so that they can be
+// gossiped in any order and every node ends up with the same grants.
+type grantModel struct {
+	SetId     string `gorm:"primaryKey"`
+	Principal string `gorm:"primaryKey"`
+}
+
+// declareOwner records the owner of the set. The owner is whoever uploaded
+// the first file, and a set only ever has one owner. Every file and
+// declaration of an owned set has to carry its owner, so that files peers
+// relay without one can't be slipped into the set.
+func (r *Files) declareOwner(tx *gorm.DB, set fileSetModel, owner string) error {
+	if set.Owner == owner {
+		return nil
+	}
+	if owner == "" {
+		return ErrNotSetOwner
+	}
+	if set.Owner != "" {
+		return ErrOwnerConflict
+	}
+	if err := tx.Model(&fileSetModel{}).Where("set_id = ?", set.SetId).Update("owner", owner).Error; err != nil {
+		return errors.Wrap(err, "failed to declare set owner")
+	}
+	return nil
+}
+
+// declareGrants adds grants to the set. Grants are only recorded for sets
+// with an owner, sets without one are open to everyone anyway.
+func (r *Files) declareGrants(tx *gorm.DB, setId string, grants []string) error {
+	for _, principal := range grants {
+		if principal == "" {
+			continue
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(
+			&grantModel{
+				SetId:     setId,
+				Principal: principal,
+			},
+		)
+		if result.Error != nil {
+			return errors.Wrap(result.Error, "failed to grant set")
+		}
+	}
+	return nil
+}
+
+// grants returns the principals granted on the set, in alphabetical order
+func (r *Files) grants(tx *gorm.DB, setId string) ([]string, error) {
+	var grants []string
+	if err := tx.Model(&grantModel{}).
+		Where("set_id = ?", setId).
+		Order("principal ASC").
+		Pluck("principal", &grants).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get set grants")
+	}
+	return grants, nil
+}
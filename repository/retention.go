@@ -0,0 +1,336 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+var ErrSetExpired = errors.New("file set has expired")
+
+const (
+	// ReasonExpired is why sets past their TTL, or the default retention,
+	// are collected
+	ReasonExpired = "expired"
+	// ReasonIncomplete is why sets that did not complete in time are
+	// collected
+	ReasonIncomplete = "incomplete"
+)
+
+// Retention decides how long a node keeps sets. Sets uploaded with a TTL
+// are kept until they expire, other sets for Default after they were first
+// seen. Sets that are still incomplete Incomplete after they were first
+// seen are given up on, and resumable uploads that are not finalized
+// Uploads after they were created are abandoned. Zero keeps them forever.
+type Retention struct {
+	Default    time.Duration
+	Incomplete time.Duration
+	Uploads    time.Duration
+}
+
+// reason returns why the set should be collected at now, or nothing if it
+// should be kept
+func (r Retention) reason(set fileSetModel, now time.Time) string {
+	if set.ExpiresAt != nil && !now.Before(*set.ExpiresAt) {
+		return ReasonExpired
+	}
+	if r.Incomplete > 0 && set.CompletedAt == nil && !now.Before(set.CreatedAt.Add(r.Incomplete)) {
+		return ReasonIncomplete
+	}
+	if r.Default > 0 && set.ExpiresAt == nil && !now.Before(set.CreatedAt.Add(r.Default)) {
+		return ReasonExpired
+	}
+	return ""
+}
+
+// GCReport sums up what garbage collection reclaimed, Bytes counts both
+// the purged blobs and the staged contents of abandoned uploads
+type GCReport struct {
+	Expired    int
+	Incomplete int
+	Uploads    int
+	Files      int
+	Blobs      int
+	Bytes      int64
+}
+
+func (r *GCReport) add(other GCReport) {
+	r.Expired += other.Expired
+	r.Incomplete += other.Incomplete
+	r.Uploads += other.Uploads
+	r.Files += other.Files
+	r.Blobs += other.Blobs
+	r.Bytes += other.Bytes
+}
+
+// GCStats are the totals of every round since the node started, along with
+// the last round
+type GCStats struct {
+	Runs    int
+	LastRun *time.Time
+	Last    GCReport
+	Total   GCReport
+}
+
+// Collector deletes expired sets in the background and reclaims the space
+// their files took. Collected sets get a tombstone like deleted sets, so
+// files of the set still travelling through the network are not stored
+// again. Expiry is not gossiped, every node collects sets on its own.
+// Abandoned uploads are collected along with them.
+type Collector struct {
+	logger    zerolog.Logger
+	files     *Files
+	uploads   *Uploads
+	retention Retention
+	interval  time.Duration
+	batchSize int
+
+	mu    sync.Mutex
+	stats GCStats
+}
+
+func NewCollector(
+	logger zerolog.Logger,
+	files *Files,
+	uploads *Uploads,
+	retention Retention,
+	interval time.Duration,
+	batchSize int,
+) *Collector {
+	return &Collector{
+		logger:    logger,
+		files:     files,
+		uploads:   uploads,
+		retention: retention,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Run collects expired sets every interval, it returns a func() error in
+// order to be easily used with errgroup.Group
+func (c *Collector) Run(ctx context.Context) func() error {
+	return func() error {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				report, err := c.Collect(ctx)
+				if err != nil && ctx.Err() == nil {
+					c.logger.Error().Err(err).Msg("failed to collect expired sets")
+					continue
+				}
+				if report.Expired > 0 || report.Incomplete > 0 || report.Uploads > 0 {
+					c.logger.Info().
+						Int("expired", report.Expired).
+						Int("incomplete", report.Incomplete).
+						Int("uploads", report.Uploads).
+						Int("files", report.Files).
+						Int64("bytes", report.Bytes).
+						Msg("collected sets")
+				}
+			}
+		}
+	}
+}
+
+// Collect deletes every set that expired by now, and every abandoned
+// upload, batchSize at a time
+func (c *Collector) Collect(ctx context.Context) (GCReport, error) {
+	return c.collect(ctx, time.Now())
+}
+
+func (c *Collector) collect(ctx context.Context, now time.Time) (GCReport, error) {
+	var report GCReport
+	defer c.record(now, &report)
+
+	if err := c.collectSets(ctx, now, &report); err != nil {
+		return report, err
+	}
+	return report, c.collectUploads(ctx, now, &report)
+}
+
+func (c *Collector) collectSets(ctx context.Context, now time.Time, report *GCReport) error {
+
+	// sets that can't be collected are skipped by the next batches, so a
+	// set that keeps failing doesn't stop the others from being collected
+	var skip []string
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		sets, err := c.files.expiredSets(c.retention, now, skip, c.batchSize)
+		if err != nil {
+			return err
+		}
+		for _, set := range sets {
+			collected, err := c.files.expireSet(set.SetId, c.retention, now)
+			if err != nil {
+				c.logger.Error().Err(err).Str("set-id", set.SetId).Msg("failed to collect set")
+				skip = append(skip, set.SetId)
+				continue
+			}
+			report.add(collected)
+		}
+		if len(sets) < c.batchSize {
+			return nil
+		}
+	}
+}
+
+func (c *Collector) collectUploads(ctx context.Context, now time.Time, report *GCReport) error {
+	if c.uploads == nil || c.retention.Uploads == 0 {
+		return nil
+	}
+	var skip []string
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		ids, err := c.uploads.staleUploads(now.Add(-c.retention.Uploads), skip, c.batchSize)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			staged, err := c.uploads.deleteUpload(id)
+			if err != nil {
+				c.logger.Error().Err(err).Str("upload", id).Msg("failed to collect upload")
+				skip = append(skip, id)
+				continue
+			}
+			report.Uploads++
+			report.Bytes += staged
+		}
+		if len(ids) < c.batchSize {
+			return nil
+		}
+	}
+}
+
+func (c *Collector) record(now time.Time, report *GCReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Runs++
+	c.stats.LastRun = &now
+	c.stats.Last = *report
+	c.stats.Total.add(*report)
+}
+
+// Stats returns what the collector reclaimed since the node started
+func (c *Collector) Stats() GCStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// expiredSets returns up to limit sets the retention collects at now. The
+// query only narrows the sets down, expireSet decides for each of them.
+func (r *Files) expiredSets(retention Retention, now time.Time, skip []string, limit int) ([]fileSetModel, error) {
+	expired := r.db.Where("expires_at <= ?", now)
+	if retention.Incomplete > 0 {
+		expired = expired.Or("completed_at IS NULL AND created_at <= ?", now.Add(-retention.Incomplete))
+	}
+	if retention.Default > 0 {
+		expired = expired.Or("expires_at IS NULL AND created_at <= ?", now.Add(-retention.Default))
+	}
+
+	query := r.db.Where(expired)
+	if len(skip) > 0 {
+		query = query.Where("set_id NOT IN ?", skip)
+	}
+	var sets []fileSetModel
+	if err := query.Order("set_id ASC").Limit(limit).Find(&sets).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get expired sets")
+	}
+	return sets, nil
+}
+
+// expireSet purges the set if the retention collects it at now, and writes
+// its tombstone. The set is checked again in the transaction, since it may
+// have completed since it was picked.
+func (r *Files) expireSet(setId string, retention Retention, now time.Time) (GCReport, error) {
+	var report GCReport
+	var released []string
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			var set fileSetModel
+			result := tx.Where("set_id = ?", setId).Limit(1).Find(&set)
+			if result.Error != nil {
+				return errors.Wrap(result.Error, "failed to get file set")
+			}
+			if result.RowsAffected == 0 {
+				return nil
+			}
+			reason := retention.reason(set, now)
+			if reason == "" {
+				return nil
+			}
+
+			purged, err := r.purgeSet(tx, setId)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(
+				&tombstoneModel{
+					SetId:     setId,
+					KeyHash:   set.KeyHash,
+					Owner:     set.Owner,
+					DeletedAt: now,
+					Expired:   true,
+				},
+			).Error; err != nil {
+				return errors.Wrap(err, "failed to write tombstone")
+			}
+
+			if reason == ReasonIncomplete {
+				report.Incomplete++
+			} else {
+				report.Expired++
+			}
+			report.Files = purged.files
+			report.Blobs = len(purged.released)
+			report.Bytes = purged.bytes
+			released = purged.released
+			return nil
+		},
+	)
+	if err != nil {
+		return GCReport{}, err
+	}
+	r.purgeBlobs(released)
+	return report, nil
+}
+
+// checkExpiry refuses files and declarations of sets that already expired
+func checkExpiry(expiresAt *time.Time) error {
+	if expiresAt != nil && !time.Now().Before(*expiresAt) {
+		return ErrSetExpired
+	}
+	return nil
+}
+
+// declareExpiry stores when the set expires. Only the file or declaration
+// that creates the set can give it a TTL, so that later uploads can't move
+// the expiry of a set they were granted access to, and a signed one only if
+// the owner signed it rather than one of its grants.
+func (r *Files) declareExpiry(tx *gorm.DB, set fileSetModel, created bool, owner, signer string, expiresAt *time.Time) error {
+	if expiresAt == nil || !created || set.ExpiresAt != nil {
+		return nil
+	}
+	if signer != "" && proof.NormalizeAddress(signer) != proof.NormalizeAddress(owner) {
+		return nil
+	}
+	if err := tx.Model(&fileSetModel{}).Where("set_id = ?", set.SetId).Update("expires_at", *expiresAt).Error; err != nil {
+		return errors.Wrap(err, "failed to declare set expiry")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+func (s *FilesTestSuite) TestRetention() {
+	t := s.T()
+	ctx := context.Background()
+	files := [][]byte{[]byte("file1"), []byte("file2")}
+	expiring := func(setId string, index, setCount int, contents []byte, expiresAt time.Time) model.File {
+		file := newFile(setId, index, setCount, contents)
+		file.Metadata.ExpiresAt = &expiresAt
+		return file
+	}
+	newCollector := func(retention Retention) *Collector {
+		return NewCollector(zerolog.New(io.Discard), s.repo, nil, retention, time.Minute, 2)
+	}
+
+	t.Run(
+		"it should keep the first expiry a set is given", func(t *testing.T) {
+			setId := uuid.NewString()
+			first := time.Now().Add(time.Hour).UTC()
+			require.NoError(t, s.repo.SaveFile(expiring(setId, 0, 2, files[0], first)))
+			require.NoError(t, s.repo.SaveFile(expiring(setId, 1, 2, files[1], first.Add(time.Minute))))
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.NotNil(t, set.ExpiresAt)
+			require.WithinDuration(t, first, *set.ExpiresAt, time.Millisecond)
+		},
+	)
+
+	t.Run(
+		"it should only take the expiry from what creates the set", func(t *testing.T) {
+			setId := uuid.NewString()
+			require.NoError(t, s.repo.SaveFile(newFile(setId, 0, 2, files[0])))
+
+			expiresAt := time.Now().Add(time.Hour)
+			require.NoError(t, s.repo.SaveFile(expiring(setId, 1, 2, files[1], expiresAt)))
+			require.NoError(
+				t, s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 2, ExpiresAt: &expiresAt}),
+			)
+
+			set, err := s.repo.FileSet(setId)
+			require.NoError(t, err)
+			require.Nil(t, set.ExpiresAt)
+		},
+	)
+
+	t.Run(
+		"it should not store sets that already expired", func(t *testing.T) {
+			setId := uuid.NewString()
+			err := s.repo.SaveFile(expiring(setId, 0, 1, files[0], time.Now().Add(-time.Second)))
+			require.ErrorIs(t, err, ErrSetExpired)
+
+			expiresAt := time.Now().Add(-time.Second)
+			err = s.repo.DeclareSet(model.SetDeclaration{SetId: setId, SetCount: 1, ExpiresAt: &expiresAt})
+			require.ErrorIs(t, err, ErrSetExpired)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should collect sets once their ttl runs out", func(t *testing.T) {
+			setId := uuid.NewString()
+			expiresAt := time.Now().Add(time.Hour)
+			for i, contents := range files {
+				require.NoError(t, s.repo.SaveFile(expiring(setId, i, len(files), contents, expiresAt)))
+			}
+			kept := s.saveSet([][]byte{[]byte("kept")})
+			collector := newCollector(Retention{})
+
+			report, err := collector.collect(ctx, time.Now())
+			require.NoError(t, err)
+			require.Equal(t, GCReport{}, report)
+
+			report, err = collector.collect(ctx, expiresAt)
+			require.NoError(t, err)
+			require.Equal(t, GCReport{Expired: 1, Files: 2, Blobs: 2, Bytes: 10}, report)
+
+			_, err = s.repo.FileSet(setId)
+			require.ErrorIs(t, err, ErrSetNotFound)
+			deleted, err := s.repo.Deleted(setId)
+			require.NoError(t, err)
+			require.True(t, deleted)
+			_, err = s.blobs.Get(proof.Encode(proof.Hash(files[0])))
+			require.ErrorIs(t, err, ErrBlobNotFound)
+
+			// late files of the set are not stored again
+			err = s.repo.SaveFile(newFile(setId, 0, len(files), files[0]))
+			require.ErrorIs(t, err, ErrSetDeleted)
+
+			_, err = s.repo.FileSet(kept)
+			require.NoError(t, err)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should collect other sets after the default retention", func(t *testing.T) {
+			setId := s.saveSet(files)
+			expiresAt := time.Now().Add(72 * time.Hour)
+			withTTL := uuid.NewString()
+			require.NoError(t, s.repo.SaveFile(expiring(withTTL, 0, 1, []byte("ttl"), expiresAt)))
+			collector := newCollector(Retention{Default: 24 * time.Hour})
+
+			report, err := collector.collect(ctx, time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			require.Equal(t, 0, report.Expired)
+
+			// the ttl of a set overrides the default retention
+			report, err = collector.collect(ctx, time.Now().Add(25*time.Hour))
+			require.NoError(t, err)
+			require.Equal(t, 1, report.Expired)
+			_, err = s.repo.FileSet(setId)
+			require.ErrorIs(t, err, ErrSetNotFound)
+			_, err = s.repo.FileSet(withTTL)
+			require.NoError(t, err)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should give up on sets that don't complete in time", func(t *testing.T) {
+			incomplete := uuid.NewString()
+			require.NoError(t, s.repo.SaveFile(newFile(incomplete, 0, 2, files[0])))
+			complete := s.saveSet(files)
+			collector := newCollector(Retention{Incomplete: time.Hour})
+
+			report, err := collector.collect(ctx, time.Now().Add(2*time.Hour))
+			require.NoError(t, err)
+			require.Equal(t, GCReport{Incomplete: 1, Files: 1}, report)
+
+			// the blob is still referenced by the complete set
+			file, err := s.repo.File(complete, 0)
+			require.NoError(t, err)
+			require.Equal(t, files[0], file.Contents)
+			_, err = s.repo.FileSet(incomplete)
+			require.ErrorIs(t, err, ErrSetNotFound)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should collect every expired set in batches and keep the totals", func(t *testing.T) {
+			expiresAt := time.Now().Add(time.Minute)
+			for i := 0; i < 5; i++ {
+				require.NoError(t, s.repo.SaveFile(expiring(uuid.NewString(), 0, 1, []byte{byte(i)}, expiresAt)))
+			}
+			collector := newCollector(Retention{})
+
+			report, err := collector.collect(ctx, expiresAt)
+			require.NoError(t, err)
+			require.Equal(t, 5, report.Expired)
+			require.Equal(t, int64(5), report.Bytes)
+
+			_, err = collector.collect(ctx, expiresAt)
+			require.NoError(t, err)
+			stats := collector.Stats()
+			require.Equal(t, 2, stats.Runs)
+			require.Equal(t, GCReport{}, stats.Last)
+			require.Equal(t, 5, stats.Total.Expired)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should collect uploads that were abandoned", func(t *testing.T) {
+			uploads, err := NewUploads(zerolog.New(io.Discard), s.db, t.TempDir())
+			require.NoError(t, err)
+			require.NoError(t, uploads.Migrate())
+			upload, err := uploads.CreateUpload(model.Upload{SetId: uuid.NewString(), SetCount: 1, Length: 10})
+			require.NoError(t, err)
+			_, err = uploads.AppendUpload(upload.Id, 0, bytes.NewReader([]byte("01234")))
+			require.NoError(t, err)
+			collector := NewCollector(zerolog.New(io.Discard), s.repo, uploads, Retention{Uploads: time.Hour}, time.Minute, 2)
+
+			report, err := collector.collect(ctx, time.Now())
+			require.NoError(t, err)
+			require.Equal(t, GCReport{}, report)
+
+			report, err = collector.collect(ctx, time.Now().Add(2*time.Hour))
+			require.NoError(t, err)
+			require.Equal(t, GCReport{Uploads: 1, Bytes: 5}, report)
+			_, err = uploads.Upload(upload.Id)
+			require.ErrorIs(t, err, ErrUploadNotFound)
+		},
+	)
+}
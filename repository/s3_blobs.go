@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+)
+
+// minPartSize is the smallest part size S3 accepts for multipart uploads
+const minPartSize = 5 * 1024 * 1024
+
+// S3BlobStore stores blobs in an S3 compatible object store. Objects use the
+// same sharded layout as the DiskBlobStore. Blobs larger than the part size
+// are sent as multipart uploads.
+type S3BlobStore struct {
+	client   *minio.Client
+	bucket   string
+	partSize uint64
+}
+
+func NewS3BlobStore(ctx context.Context, client *minio.Client, bucket string, partSize uint64) (*S3BlobStore, error) {
+	if partSize < minPartSize {
+		return nil, errors.Errorf("part size must be at least %d bytes", minPartSize)
+	}
+
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check blob bucket")
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, errors.Wrap(err, "failed to create blob bucket")
+		}
+	}
+
+	return &S3BlobStore{
+		client:   client,
+		bucket:   bucket,
+		partSize: partSize,
+	}, nil
+}
+
+func (s *S3BlobStore) Put(hash string, contents []byte) error {
+	return s.PutReader(hash, bytes.NewReader(contents), int64(len(contents)))
+}
+
+func (s *S3BlobStore) PutReader(hash string, contents io.Reader, size int64) error {
+	key, err := s.key(hash)
+	if err != nil {
+		return err
+	}
+
+	// blobs are immutable, so if it is already there we don't need to
+	// upload it again
+	if _, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{}); err == nil {
+		return nil
+	} else if !isNoSuchKey(err) {
+		return errors.Wrap(err, "failed to check blob")
+	}
+
+	_, err = s.client.PutObject(
+		context.Background(),
+		s.bucket,
+		key,
+		contents,
+		size,
+		minio.PutObjectOptions{
+			ContentType: "application/octet-stream",
+			PartSize:    s.partSize,
+		},
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to upload blob")
+	}
+	return nil
+}
+
+func (s *S3BlobStore) Get(hash string) ([]byte, error) {
+	r, err := s.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read blob")
+	}
+	return contents, nil
+}
+
+func (s *S3BlobStore) Open(hash string) (io.ReadSeekCloser, error) {
+	key, err := s.key(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+
+	// GetObject is lazy, so stat the object to find out whether it exists
+	// before handing the reader back
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if isNoSuchKey(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+	return obj, nil
+}
+
+func (s *S3BlobStore) Delete(hash string) error {
+	key, err := s.key(hash)
+	if err != nil {
+		return err
+	}
+	if err := s.client.RemoveObject(context.Background(), s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return errors.Wrap(err, "failed to delete blob")
+	}
+	return nil
+}
+
+func (s *S3BlobStore) key(hash string) (string, error) {
+	key, err := blobKey(hash)
+	if err != nil {
+		return "", err
+	}
+	return shardedKey(key), nil
+}
+
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
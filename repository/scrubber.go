@@ -0,0 +1,444 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// Fetcher gets files from peers, networking.FileStream implements it over
+// libp2p streams. The proof is only there if the peer holds the complete
+// set.
+type Fetcher interface {
+	Peers() []string
+	Fetch(ctx context.Context, peer, setId string, index int) ([]byte, [][]byte, error)
+}
+
+// ScrubReport sums up a round of scrubbing
+type ScrubReport struct {
+	Sets     int
+	Files    int
+	Corrupt  int
+	Repaired int
+}
+
+// Scrubber goes over every stored file in the background, and checks that
+// the contents still hash to FileHash and that the hashes of complete sets
+// still produce the set root. Files that don't are flagged as corrupt and
+// fetched again from peers, every fetched file has to prove it belongs to
+// the set root at its own position before it replaces ours. Each round
+// reads at most batchSize files, so scrubbing a large node, or a single
+// large set, is spread out over many intervals.
+type Scrubber struct {
+	logger    zerolog.Logger
+	files     *Files
+	fetcher   Fetcher
+	interval  time.Duration
+	batchSize int
+
+	// cursor is the last set scrubbed, or the set being scrubbed if next is
+	// past its first file, the next round picks up from there
+	cursor string
+	next   int
+}
+
+func NewScrubber(
+	logger zerolog.Logger,
+	files *Files,
+	fetcher Fetcher,
+	interval time.Duration,
+	batchSize int,
+) *Scrubber {
+	return &Scrubber{
+		logger:    logger,
+		files:     files,
+		fetcher:   fetcher,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Run scrubs a batch of files every interval, it returns a func() error in
+// order to be easily used with errgroup.Group
+func (s *Scrubber) Run(ctx context.Context) func() error {
+	return func() error {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				report, err := s.Scrub(ctx)
+				if err != nil && ctx.Err() == nil {
+					s.logger.Error().Err(err).Msg("failed to scrub files")
+					continue
+				}
+				if report.Corrupt > 0 || report.Repaired > 0 {
+					s.logger.Info().
+						Int("files", report.Files).
+						Int("corrupt", report.Corrupt).
+						Int("repaired", report.Repaired).
+						Msg("scrubbed files")
+				}
+			}
+		}
+	}
+}
+
+// Scrub checks the next batch of files, and then tries to repair every file
+// flagged as corrupt, including those that could not be repaired before
+func (s *Scrubber) Scrub(ctx context.Context) (ScrubReport, error) {
+	var report ScrubReport
+	for report.Files < s.batchSize {
+		var set fileSetModel
+		query := s.files.db.Where("set_id > ?", s.cursor)
+		if s.next > 0 {
+			query = s.files.db.Where("set_id = ?", s.cursor)
+		}
+		result := query.Order("set_id ASC").Limit(1).Find(&set)
+		if result.Error != nil {
+			return report, errors.Wrap(result.Error, "failed to get set to scrub")
+		}
+		if result.RowsAffected == 0 && s.next > 0 {
+			// the set went away while we were scrubbing it
+			s.next = 0
+			continue
+		}
+		if result.RowsAffected == 0 {
+			// start over in the next round
+			s.cursor = ""
+			break
+		}
+		checked, corrupt, next, err := s.scrubSet(set, s.next, s.batchSize-report.Files)
+		if err != nil {
+			return report, err
+		}
+		s.cursor, s.next = set.SetId, next
+		if next == 0 {
+			report.Sets++
+		}
+		report.Files += checked
+		report.Corrupt += corrupt
+	}
+
+	var corrupt []fileModel
+	if err := s.files.db.Where("corrupt = ?", true).Order("id ASC").Limit(s.batchSize).Find(&corrupt).Error; err != nil {
+		return report, errors.Wrap(err, "failed to get corrupt files")
+	}
+	// a file is checked against the hashes of the other files of its set,
+	// so once a file is repaired, files of the same set that were refused
+	// because of it get another try
+	for len(corrupt) > 0 {
+		var refused []fileModel
+		for _, file := range corrupt {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			repaired, err := s.repair(ctx, file)
+			if err != nil {
+				s.logger.Error().Err(err).Str("set-id", file.SetId).Int("index", file.FileNumber).Msg("failed to repair file")
+				continue
+			}
+			if repaired {
+				report.Repaired++
+				continue
+			}
+			refused = append(refused, file)
+		}
+		if len(refused) == len(corrupt) {
+			break
+		}
+		corrupt = refused
+	}
+	return report, nil
+}
+
+// scrubSet checks at most limit files of the set, starting at file number
+// from. It returns how many files it checked, how many it newly flagged as
+// corrupt, and the file number to continue from, which is 0 once the whole
+// set was scrubbed.
+func (s *Scrubber) scrubSet(set fileSetModel, from, limit int) (int, int, int, error) {
+	// one more file than the limit tells whether the set is done
+	var files []fileModel
+	err := s.files.db.Where("set_id = ? AND file_number >= ?", set.SetId, from).
+		Order("file_number ASC").
+		Limit(limit + 1).
+		Find(&files).Error
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "failed to get files to scrub")
+	}
+	more := len(files) > limit
+	if more {
+		files = files[:limit]
+	}
+
+	flagged := 0
+	for _, file := range files {
+		if file.Corrupt {
+			continue
+		}
+		reason, err := s.checkContents(file)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if reason != "" {
+			if err := s.flag(file, reason); err != nil {
+				return 0, 0, 0, err
+			}
+			flagged++
+		}
+	}
+	if more {
+		return len(files), flagged, files[len(files)-1].FileNumber + 1, nil
+	}
+
+	rooted, err := s.checkRoot(set)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return len(files), flagged + rooted, 0, nil
+}
+
+// checkRoot checks that the hashes of a complete set still produce its root,
+// and returns how many files it flagged as corrupt. Only the hashes are
+// read, not the contents, so the whole set is checked at once.
+func (s *Scrubber) checkRoot(set fileSetModel) (int, error) {
+	var files []fileModel
+	err := s.files.db.Select("id", "set_id", "file_number", "file_hash", "corrupt").
+		Where("set_id = ?", set.SetId).
+		Order("file_number ASC").
+		Find(&files).Error
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get file hashes")
+	}
+
+	// a hash that no longer produces the root can't be pinned on a single
+	// file, so every file of the set has to be fetched again
+	if set.CompletedAt == nil || set.Quarantined || len(files) != set.SetCount {
+		return 0, nil
+	}
+	leaves := make([][]byte, len(files))
+	for i, file := range files {
+		leaf, err := proof.Decode(file.FileHash)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to decode file hash")
+		}
+		leaves[i] = leaf
+	}
+	tree, err := proof.Mode(set.Tree).TreeFromHashes(leaves)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to compute set root")
+	}
+	if proof.Encode(tree.Root()) == set.Root {
+		return 0, nil
+	}
+	flagged := 0
+	for _, file := range files {
+		if file.Corrupt {
+			continue
+		}
+		if err := s.flag(file, "hashes do not match the set root"); err != nil {
+			return 0, err
+		}
+		flagged++
+	}
+	return flagged, nil
+}
+
+// checkContents returns why the contents of the file don't match its hash,
+// or nothing if they do
+func (s *Scrubber) checkContents(file fileModel) (string, error) {
+	contents, err := s.files.blobs.Get(file.FileHash)
+	if errors.Is(err, ErrBlobNotFound) {
+		return "contents are missing", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get file contents")
+	}
+	if proof.Encode(proof.Hash(contents)) != file.FileHash {
+		return "contents do not match the file hash", nil
+	}
+	return "", nil
+}
+
+func (s *Scrubber) flag(file fileModel, reason string) error {
+	s.logger.Warn().
+		Str("set-id", file.SetId).
+		Int("index", file.FileNumber).
+		Str("reason", reason).
+		Msg("file is corrupt")
+	if err := s.files.db.Model(&fileModel{}).Where("id = ?", file.ID).Update("corrupt", true).Error; err != nil {
+		return errors.Wrap(err, "failed to flag corrupt file")
+	}
+	return nil
+}
+
+// repair fetches the file from peers until one of them sends contents that
+// belong to the set, and replaces ours with it. It reports whether the file
+// was repaired, a file no peer could provide stays flagged for the next
+// round.
+func (s *Scrubber) repair(ctx context.Context, file fileModel) (bool, error) {
+	var set fileSetModel
+	if err := s.files.db.Where("set_id = ?", file.SetId).First(&set).Error; err != nil {
+		return false, errors.Wrap(err, "failed to get file set")
+	}
+	setModel, err := set.toModel()
+	if err != nil {
+		return false, err
+	}
+	var hashes [][]byte
+	if setModel.Complete() && !setModel.Quarantined {
+		if hashes, err = s.files.Hashes(file.SetId); err != nil {
+			return false, err
+		}
+	}
+
+	for _, peer := range s.fetcher.Peers() {
+		contents, path, err := s.fetcher.Fetch(ctx, peer, file.SetId, file.FileNumber)
+		if err != nil {
+			s.logger.Debug().Err(err).Str("peer", peer).Msg("failed to fetch file")
+			continue
+		}
+		if !belongs(setModel, file, hashes, contents, path) {
+			s.logger.Warn().
+				Str("peer", peer).
+				Str("set-id", file.SetId).
+				Int("index", file.FileNumber).
+				Msg("peer sent a file that does not belong to the set")
+			continue
+		}
+		if err := s.replace(file, contents); err != nil {
+			return false, err
+		}
+		s.logger.Info().
+			Str("peer", peer).
+			Str("set-id", file.SetId).
+			Int("index", file.FileNumber).
+			Msg("repaired corrupt file")
+		return true, nil
+	}
+	return false, nil
+}
+
+// belongs checks fetched contents against the root of a complete set, or
+// against the hash we stored while the set is incomplete. The proof the peer
+// sent doesn't tie the contents to the position of the file in a sorted
+// tree, so the contents also have to produce the root at the position of
+// the file, along with the stored hashes of the other files.
+func belongs(set model.FileSet, file fileModel, hashes [][]byte, contents []byte, path [][]byte) bool {
+	if !set.Complete() || set.Quarantined {
+		return proof.Encode(proof.Hash(contents)) == file.FileHash
+	}
+	if path == nil {
+		return false
+	}
+	if ok, err := set.Tree.Verify(contents, path, uint64(file.FileNumber), set.Root); err != nil || !ok {
+		return false
+	}
+	siblings, err := set.Tree.ProofFromHashes(hashes, uint64(file.FileNumber))
+	if err != nil {
+		return false
+	}
+	ok, err := set.Tree.Verify(contents, siblings, uint64(file.FileNumber), set.Root)
+	return err == nil && ok
+}
+
+// replace stores the fetched contents for the file. If they hash to what we
+// had, only the blob was damaged and is written again, otherwise the hash
+// of the file was wrong and the file moves to the blob of the new contents.
+func (s *Scrubber) replace(file fileModel, contents []byte) error {
+	hash := proof.Encode(proof.Hash(contents))
+	unlock := s.files.lockBlobs([]string{hash})
+	if hash == file.FileHash {
+		// blobs are never overwritten, so the damaged one has to go first
+		if err := s.files.blobs.Delete(hash); err != nil {
+			unlock()
+			return err
+		}
+	}
+	if err := s.files.blobs.Put(hash, contents); err != nil {
+		unlock()
+		return errors.Wrap(err, "failed to save file contents")
+	}
+
+	var released []string
+	err := s.files.db.Transaction(
+		func(tx *gorm.DB) error {
+			if hash != file.FileHash {
+				if err := s.files.acquireBlob(tx, hash, int64(len(contents))); err != nil {
+					return err
+				}
+				unreferenced, err := s.files.releaseBlob(tx, file.FileHash)
+				if err != nil {
+					return err
+				}
+				if unreferenced {
+					released = append(released, file.FileHash)
+				}
+			}
+			if err := tx.Model(&fileModel{}).
+				Where("id = ?", file.ID).
+				Updates(map[string]interface{}{"file_hash": hash, "corrupt": false}).Error; err != nil {
+				return errors.Wrap(err, "failed to repair file")
+			}
+			return nil
+		},
+	)
+	unlock()
+	if err != nil {
+		return err
+	}
+	s.files.purgeBlobs(released)
+	return nil
+}
+
+// Serve returns a file to a peer that is repairing its copy, along with the
+// proof against the set root if the set is complete. Our own copy is
+// checked first, so a corrupt file is never passed on.
+func (s *Scrubber) Serve(setId string, index int) ([]byte, [][]byte, error) {
+	var file fileModel
+	if err := s.files.db.Where("set_id = ? AND file_number = ?", setId, index).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrFileNotFound
+		}
+		return nil, nil, errors.Wrap(err, "failed to get file")
+	}
+	if file.Corrupt {
+		return nil, nil, ErrFileCorrupt
+	}
+	contents, err := s.files.blobs.Get(file.FileHash)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get file contents")
+	}
+	if proof.Encode(proof.Hash(contents)) != file.FileHash {
+		return nil, nil, ErrFileCorrupt
+	}
+
+	set, err := s.files.FileSet(setId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !set.Complete() || set.Quarantined {
+		return contents, nil, nil
+	}
+	hashes, err := s.files.Hashes(setId)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := set.Tree.ProofFromHashes(hashes, uint64(index))
+	if err != nil {
+		return nil, nil, err
+	}
+	if path == nil {
+		// the proof of a single file set is empty, but it is still a proof
+		path = [][]byte{}
+	}
+	return contents, path, nil
+}
@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+// peerFetcher fetches files straight from the scrubbers of other nodes
+type peerFetcher struct {
+	peers map[string]*Scrubber
+	// tamper changes the contents peers send, if set
+	tamper func([]byte) []byte
+	// swap changes which file of the set peers send, if set
+	swap func(int) int
+}
+
+func (f *peerFetcher) Peers() []string {
+	var out []string
+	for peer := range f.peers {
+		out = append(out, peer)
+	}
+	return out
+}
+
+func (f *peerFetcher) Fetch(_ context.Context, peer, setId string, index int) ([]byte, [][]byte, error) {
+	scrubber, ok := f.peers[peer]
+	if !ok {
+		return nil, nil, errors.New("unknown peer")
+	}
+	if f.swap != nil {
+		index = f.swap(index)
+	}
+	contents, path, err := scrubber.Serve(setId, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.tamper != nil {
+		contents = f.tamper(contents)
+	}
+	return contents, path, nil
+}
+
+// newPeer returns the repository of another node, on its own database
+func (s *FilesTestSuite) newPeer() *Files {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	s.Require().NoError(err)
+	sqlDb, err := db.DB()
+	s.Require().NoError(err)
+	sqlDb.SetMaxOpenConns(1)
+	s.T().Cleanup(func() { _ = sqlDb.Close() })
+	blobs, err := NewDiskBlobStore(s.T().TempDir())
+	s.Require().NoError(err)
+	peer := NewFiles(zerolog.New(io.Discard), db, blobs)
+	s.Require().NoError(peer.Migrate())
+	return peer
+}
+
+func (s *FilesTestSuite) corruptBlob(contents []byte) {
+	path, err := s.blobs.path(proof.Encode(proof.Hash(contents)))
+	s.Require().NoError(err)
+	s.Require().NoError(os.WriteFile(path, []byte("bit rot"), 0o644))
+}
+
+func (s *FilesTestSuite) TestScrubber() {
+	t := s.T()
+	ctx := context.Background()
+	files := [][]byte{[]byte("file1"), []byte("file2"), []byte("file3")}
+
+	newScrubber := func(batchSize int) (*Scrubber, *peerFetcher, *Files) {
+		peer := s.newPeer()
+		fetcher := &peerFetcher{
+			peers: map[string]*Scrubber{"peer": NewScrubber(zerolog.New(io.Discard), peer, nil, time.Minute, batchSize)},
+		}
+		return NewScrubber(zerolog.New(io.Discard), s.repo, fetcher, time.Minute, batchSize), fetcher, peer
+	}
+	saveBoth := func(peer *Files) string {
+		setId := s.saveSet(files)
+		for i, contents := range files {
+			s.Require().NoError(peer.SaveFile(newFile(setId, i, len(files), contents)))
+		}
+		return setId
+	}
+
+	t.Run(
+		"it should leave intact files alone", func(t *testing.T) {
+			scrubber, _, peer := newScrubber(100)
+			saveBoth(peer)
+
+			report, err := scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, ScrubReport{Sets: 1, Files: 3}, report)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should repair a damaged blob from a peer", func(t *testing.T) {
+			scrubber, fetcher, peer := newScrubber(100)
+			setId := saveBoth(peer)
+			s.corruptBlob(files[1])
+
+			// without peers the file is flagged and no longer served
+			peers := fetcher.peers
+			fetcher.peers = nil
+			report, err := scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, report.Corrupt)
+			require.Equal(t, 0, report.Repaired)
+			_, err = s.repo.File(setId, 1)
+			require.ErrorIs(t, err, ErrFileCorrupt)
+			_, _, err = scrubber.Serve(setId, 1)
+			require.ErrorIs(t, err, ErrFileCorrupt)
+
+			fetcher.peers = peers
+			report, err = scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 0, report.Corrupt)
+			require.Equal(t, 1, report.Repaired)
+			file, err := s.repo.File(setId, 1)
+			require.NoError(t, err)
+			require.Equal(t, files[1], file.Contents)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should repair a missing blob", func(t *testing.T) {
+			scrubber, _, peer := newScrubber(100)
+			setId := saveBoth(peer)
+			s.Require().NoError(s.blobs.Delete(proof.Encode(proof.Hash(files[0]))))
+
+			report, err := scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, report.Corrupt)
+			require.Equal(t, 1, report.Repaired)
+			file, err := s.repo.File(setId, 0)
+			require.NoError(t, err)
+			require.Equal(t, files[0], file.Contents)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should repair a hash that no longer matches the root", func(t *testing.T) {
+			scrubber, _, peer := newScrubber(100)
+			setId := saveBoth(peer)
+
+			// an edit of the database that points the file at other contents
+			edited := []byte("edited")
+			editedHash := proof.Encode(proof.Hash(edited))
+			s.Require().NoError(s.blobs.Put(editedHash, edited))
+			s.Require().NoError(s.db.Create(&blobModel{Hash: editedHash, RefCount: 1}).Error)
+			s.Require().NoError(s.db.Model(&fileModel{}).Where("set_id = ? AND file_number = ?", setId, 2).Update("file_hash", editedHash).Error)
+			s.Require().NoError(s.db.Model(&blobModel{}).Where("hash = ?", proof.Encode(proof.Hash(files[2]))).Update("ref_count", 0).Error)
+
+			report, err := scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			// which file was edited can't be told, so the whole set is fetched
+			require.Equal(t, 3, report.Corrupt)
+			require.Equal(t, 3, report.Repaired)
+
+			hashes, err := s.repo.Hashes(setId)
+			require.NoError(t, err)
+			require.Equal(t, proof.Hash(files[2]), hashes[2])
+			file, err := s.repo.File(setId, 2)
+			require.NoError(t, err)
+			require.Equal(t, files[2], file.Contents)
+
+			// the edited contents are no longer referenced
+			_, err = s.blobs.Get(editedHash)
+			require.ErrorIs(t, err, ErrBlobNotFound)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should not accept contents that don't belong to the set", func(t *testing.T) {
+			scrubber, fetcher, peer := newScrubber(100)
+			setId := saveBoth(peer)
+			s.corruptBlob(files[1])
+			fetcher.tamper = func([]byte) []byte { return []byte("tampered") }
+
+			report, err := scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, report.Corrupt)
+			require.Equal(t, 0, report.Repaired)
+			_, err = s.repo.File(setId, 1)
+			require.ErrorIs(t, err, ErrFileCorrupt)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should not accept another file of a sorted set", func(t *testing.T) {
+			scrubber, fetcher, peer := newScrubber(100)
+			setId := uuid.NewString()
+			declaration := model.SetDeclaration{SetId: setId, SetCount: len(files), Tree: proof.ModeSorted}
+			s.Require().NoError(s.repo.DeclareSet(declaration))
+			s.Require().NoError(peer.DeclareSet(declaration))
+			for i, contents := range files {
+				s.Require().NoError(s.repo.SaveFile(newFile(setId, i, len(files), contents)))
+				s.Require().NoError(peer.SaveFile(newFile(setId, i, len(files), contents)))
+			}
+			s.corruptBlob(files[1])
+			// the first file comes with a valid sorted proof, which doesn't
+			// say where the file goes
+			fetcher.swap = func(int) int { return 0 }
+
+			report, err := scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, report.Corrupt)
+			require.Equal(t, 0, report.Repaired)
+			_, err = s.repo.File(setId, 1)
+			require.ErrorIs(t, err, ErrFileCorrupt)
+
+			fetcher.swap = nil
+			report, err = scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, report.Repaired)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should spread a large set over rounds", func(t *testing.T) {
+			scrubber, _, peer := newScrubber(2)
+			saveBoth(peer)
+			s.corruptBlob(files[2])
+
+			report, err := scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, ScrubReport{Sets: 0, Files: 2}, report)
+
+			report, err = scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, ScrubReport{Sets: 1, Files: 1, Corrupt: 1, Repaired: 1}, report)
+		},
+	)
+
+	s.SetupTest()
+	t.Run(
+		"it should spread sets over rounds", func(t *testing.T) {
+			scrubber, _, peer := newScrubber(3)
+			saveBoth(peer)
+			saveBoth(peer)
+
+			for i := 0; i < 2; i++ {
+				report, err := scrubber.Scrub(ctx)
+				require.NoError(t, err)
+				require.Equal(t, ScrubReport{Sets: 1, Files: 3}, report)
+			}
+			// every set was scrubbed, so the next round starts over
+			report, err := scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 0, report.Sets)
+			report, err = scrubber.Scrub(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, report.Sets)
+		},
+	)
+}
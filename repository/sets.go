@@ -0,0 +1,407 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+var (
+	ErrSetNotFound      = errors.New("file set not found")
+	ErrSetIncomplete    = errors.New("file set incomplete")
+	ErrSetCountMismatch = errors.New("set count does not match the existing set")
+	ErrIndexOutOfRange  = errors.New("file index out of range for set")
+	ErrRootConflict     = errors.New("a different root has already been declared for the set")
+	ErrKeyConflict      = errors.New("a different key has already been registered for the set")
+	ErrTreeConflict     = errors.New("a different tree mode has already been declared for the set")
+)
+
+// fileSetModel is updated as each file of a set arrives, so that we know
+// whether a set is complete without having to count its files. Once the
+// last file lands, the root is computed and stored alongside it, and
+// compared to the root the uploader declared, if we know it. Sets that
+// don't match are quarantined. The root is computed with the tree the
+// uploader chose for the set, which is positional unless declared.
+// ExpiresAt is only set for sets uploaded with a TTL, the Collector works
+// out when other sets expire from its own retention.
+type fileSetModel struct {
+	SetId        string `gorm:"primaryKey"`
+	SetCount     int
+	Received     int
+	Root         string
+	DeclaredRoot string
+	Tree         string
+	Quarantined  bool
+	Uploader     string
+	KeyHash      string
+	Owner        string
+	CreatedAt    time.Time
+	CompletedAt  *time.Time
+	ExpiresAt    *time.Time `gorm:"index"`
+}
+
+func (m fileSetModel) toModel() (model.FileSet, error) {
+	set := model.FileSet{
+		SetId:       m.SetId,
+		SetCount:    m.SetCount,
+		Received:    m.Received,
+		Tree:        proof.Mode(m.Tree).OrDefault(),
+		Quarantined: m.Quarantined,
+		Uploader:    m.Uploader,
+		Owner:       m.Owner,
+		CreatedAt:   m.CreatedAt,
+		CompletedAt: m.CompletedAt,
+		ExpiresAt:   m.ExpiresAt,
+	}
+	var err error
+	if set.Root, err = decodeOptional(m.Root); err != nil {
+		return model.FileSet{}, errors.Wrap(err, "failed to decode set root")
+	}
+	if set.DeclaredRoot, err = decodeOptional(m.DeclaredRoot); err != nil {
+		return model.FileSet{}, errors.Wrap(err, "failed to decode declared set root")
+	}
+	return set, nil
+}
+
+// FileSet returns the record of the set, along with its grants and anchor
+func (r *Files) FileSet(setId string) (model.FileSet, error) {
+	var set fileSetModel
+	if err := r.db.Where("set_id = ?", setId).First(&set).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.FileSet{}, ErrSetNotFound
+		}
+		return model.FileSet{}, errors.Wrap(err, "failed to get file set")
+	}
+	out, err := set.toModel()
+	if err != nil {
+		return model.FileSet{}, err
+	}
+	if out.Grants, err = r.grants(r.db, setId); err != nil {
+		return model.FileSet{}, err
+	}
+	if out.Anchor, err = r.anchor(r.db, setId); err != nil {
+		return model.FileSet{}, err
+	}
+	return out, nil
+}
+
+// DeclareSet records a set before (or while) its files arrive, along with
+// the root the uploader computed for it. If the set is already complete,
+// the root is checked straight away. Declarations are also how the owner of
+// a set grants other principals access to it.
+func (r *Files) DeclareSet(declaration model.SetDeclaration) error {
+	if declaration.SetCount < 1 {
+		return ErrIndexOutOfRange
+	}
+	if err := r.limits.Check(declaration.SetCount, 0); err != nil {
+		return err
+	}
+	return r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := r.checkTombstone(tx, declaration.SetId, declaration.Owner, declaration.KeyHash); err != nil {
+				return err
+			}
+			if err := checkExpiry(declaration.ExpiresAt); err != nil {
+				return err
+			}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(
+				&fileSetModel{
+					SetId:    declaration.SetId,
+					SetCount: declaration.SetCount,
+					Uploader: declaration.Uploader,
+				},
+			)
+			if result.Error != nil {
+				return errors.Wrap(result.Error, "failed to declare file set")
+			}
+			created := result.RowsAffected == 1
+
+			var set fileSetModel
+			if err := tx.Where("set_id = ?", declaration.SetId).First(&set).Error; err != nil {
+				return errors.Wrap(err, "failed to get file set")
+			}
+			if set.SetCount != declaration.SetCount {
+				return ErrSetCountMismatch
+			}
+			if err := r.declareOwner(tx, set, declaration.Owner); err != nil {
+				return err
+			}
+			// grants carry the owner's signature of their own, and are all
+			// that a declaration the owner did not sign can change
+			if declaration.Signer == "" && len(declaration.GrantSignatures) > 0 && proof.IsAddress(declaration.Owner) {
+				return r.declareGrants(tx, set.SetId, declaration.Grants)
+			}
+			if err := r.checkSigner(tx, set.SetId, declaration.Signer); err != nil {
+				return err
+			}
+			if err := r.declareKey(tx, set, declaration.KeyHash); err != nil {
+				return err
+			}
+			if err := r.declareTree(tx, set, declaration.Tree); err != nil {
+				return err
+			}
+			if err := r.declareExpiry(tx, set, created, declaration.Owner, declaration.Signer, declaration.ExpiresAt); err != nil {
+				return err
+			}
+			if set.Owner != "" || declaration.Owner != "" {
+				if err := r.declareGrants(tx, set.SetId, declaration.Grants); err != nil {
+					return err
+				}
+			}
+			return r.declareRoot(tx, set, declaration.Root)
+		},
+	)
+}
+
+// Indices returns the indices of the files received so far for the set,
+// in ascending order
+func (r *Files) Indices(setId string) ([]int, error) {
+	return r.indices(r.db, setId)
+}
+
+func (r *Files) indices(tx *gorm.DB, setId string) ([]int, error) {
+	var indices []int
+	if err := tx.Model(&fileModel{}).
+		Where("set_id = ?", setId).
+		Order("file_number ASC").
+		Pluck("file_number", &indices).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get file indices")
+	}
+	return indices, nil
+}
+
+// checkFileSet makes sure the file fits into the set it claims to belong to.
+// It doesn't write anything, provisional tombstones are dropped by saveFile.
+func (r *Files) checkFileSet(tx *gorm.DB, metadata model.FileMetadata) error {
+	if metadata.FileNumber < 0 || metadata.FileNumber >= metadata.SetCount {
+		return ErrIndexOutOfRange
+	}
+	if _, err := unmatchedTombstone(tx, metadata.SetId, metadata.Owner, metadata.KeyHash); err != nil {
+		return err
+	}
+	if err := checkExpiry(metadata.ExpiresAt); err != nil {
+		return err
+	}
+
+	var set fileSetModel
+	result := tx.Where("set_id = ?", metadata.SetId).Limit(1).Find(&set)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to get file set")
+	}
+	if result.RowsAffected == 1 && set.SetCount != metadata.SetCount {
+		return ErrSetCountMismatch
+	}
+	return nil
+}
+
+// receiveFile records the file against its set. New files are counted, and
+// complete the set if they were the last one missing. Any file can carry
+// the declared root of its set.
+func (r *Files) receiveFile(tx *gorm.DB, metadata model.FileMetadata, isNew bool) error {
+	created := false
+	if isNew {
+		var existing int64
+		if err := tx.Model(&fileSetModel{}).Where("set_id = ?", metadata.SetId).Count(&existing).Error; err != nil {
+			return errors.Wrap(err, "failed to get file set")
+		}
+		created = existing == 0
+		result := tx.Clauses(
+			clause.OnConflict{
+				Columns:   []clause.Column{{Name: "set_id"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"received": gorm.Expr("file_set_models.received + 1")}),
+			},
+		).Create(
+			&fileSetModel{
+				SetId:    metadata.SetId,
+				SetCount: metadata.SetCount,
+				Received: 1,
+				Uploader: metadata.Uploader,
+			},
+		)
+		if result.Error != nil {
+			return errors.Wrap(result.Error, "failed to update file set")
+		}
+	}
+
+	var set fileSetModel
+	if err := tx.Where("set_id = ?", metadata.SetId).First(&set).Error; err != nil {
+		return errors.Wrap(err, "failed to get file set")
+	}
+	if err := r.declareKey(tx, set, metadata.KeyHash); err != nil {
+		return err
+	}
+	if err := r.declareTree(tx, set, metadata.Tree); err != nil {
+		return err
+	}
+	if metadata.Tree != "" {
+		set.Tree = string(metadata.Tree)
+	}
+	if err := r.declareOwner(tx, set, metadata.Owner); err != nil {
+		return err
+	}
+	if err := r.checkSigner(tx, set.SetId, metadata.Signer); err != nil {
+		return err
+	}
+	if err := r.declareExpiry(tx, set, created, metadata.Owner, metadata.Signer, metadata.ExpiresAt); err != nil {
+		return err
+	}
+	if len(metadata.Root) > 0 {
+		if err := r.declareRoot(tx, set, metadata.Root); err != nil {
+			return err
+		}
+		set.DeclaredRoot = proof.Encode(metadata.Root)
+	}
+
+	if set.Received < set.SetCount || set.CompletedAt != nil {
+		return nil
+	}
+	return r.completeSet(tx, set)
+}
+
+// declareRoot stores the declared root of the set. A set only ever has one
+// declared root, and if the set is already complete it is checked
+// against the computed root.
+func (r *Files) declareRoot(tx *gorm.DB, set fileSetModel, root []byte) error {
+	if len(root) == 0 {
+		return nil
+	}
+	declared := proof.Encode(root)
+	if set.DeclaredRoot == declared {
+		return nil
+	}
+	if set.DeclaredRoot != "" {
+		return ErrRootConflict
+	}
+
+	updates := map[string]interface{}{"declared_root": declared}
+	if set.CompletedAt != nil && set.Root != declared {
+		updates["quarantined"] = true
+		r.logQuarantine(set.SetId, set.Root, declared)
+	}
+	if err := tx.Model(&fileSetModel{}).Where("set_id = ?", set.SetId).Updates(updates).Error; err != nil {
+		return errors.Wrap(err, "failed to declare set root")
+	}
+	return nil
+}
+
+// declareKey stores the hash of the key that authorises deleting the set.
+// Like the root, a set only ever has one key.
+func (r *Files) declareKey(tx *gorm.DB, set fileSetModel, keyHash []byte) error {
+	if len(keyHash) == 0 {
+		return nil
+	}
+	declared := proof.Encode(keyHash)
+	if set.KeyHash == declared {
+		return nil
+	}
+	if set.KeyHash != "" {
+		return ErrKeyConflict
+	}
+	if err := tx.Model(&fileSetModel{}).Where("set_id = ?", set.SetId).Update("key_hash", declared).Error; err != nil {
+		return errors.Wrap(err, "failed to declare set key")
+	}
+	return nil
+}
+
+// declareTree stores the kind of tree the root of the set is computed with.
+// It can only be declared before the set is complete, since the root of a
+// complete set has already been computed as positional.
+func (r *Files) declareTree(tx *gorm.DB, set fileSetModel, mode proof.Mode) error {
+	if mode == "" || string(mode) == set.Tree {
+		return nil
+	}
+	if _, err := proof.ParseMode(string(mode)); err != nil {
+		return err
+	}
+	if set.Tree != "" {
+		return ErrTreeConflict
+	}
+	if set.CompletedAt != nil {
+		if mode == proof.ModePositional {
+			return nil
+		}
+		return ErrTreeConflict
+	}
+	if err := tx.Model(&fileSetModel{}).Where("set_id = ?", set.SetId).Update("tree", string(mode)).Error; err != nil {
+		return errors.Wrap(err, "failed to declare set tree")
+	}
+	return nil
+}
+
+// completeSet computes the root from the stored file hashes and marks
+// the set as complete. If the root doesn't match the declared root, the
+// set is quarantined.
+func (r *Files) completeSet(tx *gorm.DB, set fileSetModel) error {
+	var hashes []string
+	if err := tx.Model(&fileModel{}).
+		Where("set_id = ?", set.SetId).
+		Order("file_number ASC").
+		Pluck("file_hash", &hashes).Error; err != nil {
+		return errors.Wrap(err, "failed to get file hashes")
+	}
+
+	leaves := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		leaf, err := proof.Decode(hash)
+		if err != nil {
+			return errors.Wrap(err, "failed to decode file hash")
+		}
+		leaves[i] = leaf
+	}
+	tree, err := proof.Mode(set.Tree).TreeFromHashes(leaves)
+	if err != nil {
+		return errors.Wrap(err, "failed to compute set root")
+	}
+	root := proof.Encode(tree.Root())
+
+	quarantined := set.DeclaredRoot != "" && set.DeclaredRoot != root
+	if quarantined {
+		r.logQuarantine(set.SetId, root, set.DeclaredRoot)
+	}
+
+	now := time.Now()
+	if err := tx.Model(&fileSetModel{}).
+		Where("set_id = ?", set.SetId).
+		Updates(
+			map[string]interface{}{
+				"root":         root,
+				"completed_at": now,
+				"quarantined":  quarantined,
+			},
+		).Error; err != nil {
+		return errors.Wrap(err, "failed to complete file set")
+	}
+
+	r.logger.Info().
+		Str("set-id", set.SetId).
+		Int("set-count", set.SetCount).
+		Msg("file set complete")
+	return nil
+}
+
+func (r *Files) logQuarantine(setId, root, declared string) {
+	r.logger.Warn().
+		Str("set-id", setId).
+		Str("root", root).
+		Str("declared-root", declared).
+		Msg("set root does not match the declared root, quarantining set")
+}
+
+func encodeOptional(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return proof.Encode(b)
+}
+
+func decodeOptional(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return proof.Decode(s)
+}
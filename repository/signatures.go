@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// VerifyFile checks the Ethereum signature of a file. A file is either
+// signed on its own, or through the root of its set when the whole set was
+// signed at once. A signature over the root says nothing about the contents
+// of the file, so those files have to carry their proof against the signed
+// root, and are checked against it before they are saved. Unsigned files
+// pass, they are only as trustworthy as the peer that sent them, and are
+// refused for sets owned by an address when they are saved.
+func VerifyFile(file model.File) error {
+	metadata := file.Metadata
+	if len(metadata.Signature) == 0 && metadata.Signer == "" {
+		return nil
+	}
+	if len(metadata.Root) == 0 {
+		return errors.Wrap(ErrInvalidSignature, "signed files have to carry the root of their set")
+	}
+	digest := proof.FileDigest(metadata.SetId, metadata.SetCount, metadata.FileNumber, proof.Hash(file.Contents), metadata.Root)
+	err := verify(digest, metadata.Signature, metadata.Signer)
+	if err == nil || len(file.Proof) == 0 {
+		return err
+	}
+	if err := verify(proof.SetDigest(metadata.SetId, metadata.SetCount, metadata.Root), metadata.Signature, metadata.Signer); err != nil {
+		return err
+	}
+	ok, err := metadata.Tree.Verify(file.Contents, file.Proof, uint64(metadata.FileNumber), metadata.Root)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, err.Error())
+	}
+	if !ok {
+		return errors.Wrap(ErrInvalidSignature, "file is not part of the signed root")
+	}
+	return nil
+}
+
+// VerifyDeclaration checks the Ethereum signature of a set declaration, and
+// that the owner signed each of its grants. Peers can't check grants made
+// by owners that aren't Ethereum addresses, so those are never accepted.
+func VerifyDeclaration(declaration model.SetDeclaration) error {
+	if err := verifyGrants(declaration); err != nil {
+		return err
+	}
+	if len(declaration.Signature) == 0 && declaration.Signer == "" {
+		return nil
+	}
+	return verify(proof.SetDigest(declaration.SetId, declaration.SetCount, declaration.Root), declaration.Signature, declaration.Signer)
+}
+
+func verifyGrants(declaration model.SetDeclaration) error {
+	if len(declaration.Grants) == 0 {
+		return nil
+	}
+	if len(declaration.GrantSignatures) != len(declaration.Grants) {
+		return errors.Wrap(ErrInvalidSignature, "every grant has to be signed by the owner")
+	}
+	for i, grantee := range declaration.Grants {
+		digest := proof.GrantDigest(declaration.SetId, grantee)
+		if err := verify(digest, declaration.GrantSignatures[i], declaration.Owner); err != nil {
+			return errors.Wrapf(err, "grant to %s", grantee)
+		}
+	}
+	return nil
+}
+
+// VerifyDeletion checks the Ethereum signature of a deletion, the principal
+// of a signed deletion is the address that signed it. Peers can't check who
+// made an unsigned deletion, so those can only be made with the key of the
+// set, and never on behalf of a principal.
+func VerifyDeletion(deletion model.SetDeletion) error {
+	if len(deletion.Signature) == 0 {
+		if deletion.Principal != "" {
+			return errors.Wrap(ErrInvalidSignature, "deletions made by a principal have to be signed")
+		}
+		return nil
+	}
+	return verify(proof.DeletionDigest(deletion.SetId), deletion.Signature, deletion.Principal)
+}
+
+// verify checks that the signature over the digest was produced by signer
+func verify(digest, signature []byte, signer string) error {
+	recovered, err := proof.Recover(digest, signature)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, err.Error())
+	}
+	if recovered != proof.NormalizeAddress(signer) {
+		return errors.Wrapf(ErrInvalidSignature, "signed by %s, not %s", recovered, signer)
+	}
+	return nil
+}
+
+// checkSigner makes sure the address that signed a file or declaration is
+// allowed to change the set, so that a valid signature from anyone but the
+// owner and its grants is not enough to add to a set. Sets owned by an
+// Ethereum address only take signed files and declarations, since the
+// signature is the only proof peers have that the owner sent them.
+func (r *Files) checkSigner(tx *gorm.DB, setId, signer string) error {
+	var set fileSetModel
+	if err := tx.Where("set_id = ?", setId).First(&set).Error; err != nil {
+		return errors.Wrap(err, "failed to get file set")
+	}
+	if signer == "" {
+		if proof.IsAddress(set.Owner) {
+			return errors.Wrap(ErrNotSetOwner, "sets owned by an address have to be changed with a signature")
+		}
+		return nil
+	}
+	grants, err := r.grants(tx, setId)
+	if err != nil {
+		return err
+	}
+	if !(model.FileSet{Owner: set.Owner, Grants: grants}).Allows(signer) {
+		return ErrNotSetOwner
+	}
+	return nil
+}
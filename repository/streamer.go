@@ -10,10 +10,17 @@ import (
 
 type persistence interface {
 	SaveFile(file model.File) error
+	SaveFiles(files []model.File) error
+	DeclareSet(declaration model.SetDeclaration) error
+	DeleteSet(deletion model.SetDeletion) error
+	CheckQuota(files []model.File) error
 }
 
 // Streamer is responsible for watching new files as they are read from the
-// file topic and saving them to the persistence layer
+// file topic and saving them to the persistence layer. Signed messages are
+// verified before anything is saved, and dropped if the signature does not
+// hold up. Files that would take a set or the node over its limits are
+// dropped too.
 type Streamer struct {
 	logger zerolog.Logger
 
@@ -40,6 +47,14 @@ func (s *Streamer) WatchNew(ctx context.Context, files <-chan model.File) func()
 					Int("file-number", file.Metadata.FileNumber).
 					Str("set-id", file.Metadata.SetId).
 					Msg("received file")
+				if err := VerifyFile(file); err != nil {
+					s.logger.Warn().Err(err).Str("set-id", file.Metadata.SetId).Msg("dropping file")
+					continue
+				}
+				if err := s.repo.CheckQuota([]model.File{file}); err != nil {
+					s.logger.Warn().Err(err).Str("set-id", file.Metadata.SetId).Msg("dropping file over quota")
+					continue
+				}
 				if err := s.repo.SaveFile(file); err != nil {
 					s.logger.Error().Err(err).Msg("failed to save file")
 				}
@@ -47,3 +62,99 @@ func (s *Streamer) WatchNew(ctx context.Context, files <-chan model.File) func()
 		}
 	}
 }
+
+// WatchBatches works like WatchNew, but for batches of files, which are
+// saved in a single transaction
+func (s *Streamer) WatchBatches(ctx context.Context, batches <-chan []model.File) func() error {
+	return func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case files := <-batches:
+				if len(files) == 0 {
+					continue
+				}
+				s.logger.Debug().
+					Int("files", len(files)).
+					Str("set-id", files[0].Metadata.SetId).
+					Msg("received file batch")
+				if err := verifyFiles(files); err != nil {
+					s.logger.Warn().Err(err).Str("set-id", files[0].Metadata.SetId).Msg("dropping file batch")
+					continue
+				}
+				if err := s.repo.CheckQuota(files); err != nil {
+					s.logger.Warn().Err(err).Str("set-id", files[0].Metadata.SetId).Msg("dropping file batch over quota")
+					continue
+				}
+				if err := s.repo.SaveFiles(files); err != nil {
+					s.logger.Error().Err(err).Msg("failed to save file batch")
+				}
+			}
+		}
+	}
+}
+
+// WatchSets saves the set declarations announced by peers
+func (s *Streamer) WatchSets(ctx context.Context, declarations <-chan model.SetDeclaration) func() error {
+	return func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case declaration, ok := <-declarations:
+				if !ok {
+					return nil
+				}
+				s.logger.Debug().
+					Str("set-id", declaration.SetId).
+					Msg("received set declaration")
+				if err := VerifyDeclaration(declaration); err != nil {
+					s.logger.Warn().Err(err).Str("set-id", declaration.SetId).Msg("dropping set declaration")
+					continue
+				}
+				if err := s.repo.DeclareSet(declaration); err != nil {
+					s.logger.Error().Err(err).Msg("failed to declare set")
+				}
+			}
+		}
+	}
+}
+
+// WatchDeletions purges the sets that peers asked to delete
+func (s *Streamer) WatchDeletions(ctx context.Context, deletions <-chan model.SetDeletion) func() error {
+	return func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case deletion, ok := <-deletions:
+				if !ok {
+					return nil
+				}
+				s.logger.Debug().
+					Str("set-id", deletion.SetId).
+					Str("deleted-by", deletion.DeletedBy).
+					Msg("received set deletion")
+				if err := VerifyDeletion(deletion); err != nil {
+					s.logger.Warn().Err(err).Str("set-id", deletion.SetId).Msg("dropping set deletion")
+					continue
+				}
+				if err := s.repo.DeleteSet(deletion); err != nil {
+					s.logger.Error().Err(err).Msg("failed to delete set")
+				}
+			}
+		}
+	}
+}
+
+// verifyFiles verifies every file of a batch, a batch is only saved if all
+// of its files are valid
+func verifyFiles(files []model.File) error {
+	for _, file := range files {
+		if err := VerifyFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+var (
+	ErrSetDeleted      = errors.New("file set has been deleted")
+	ErrSetKeyMismatch  = errors.New("key does not match the set key")
+	ErrSetNotDeletable = errors.New("file set was uploaded without a key and cannot be deleted")
+)
+
+// tombstoneModel remembers that a set was deleted, so that files of the set
+// still travelling through the network are not stored again. Sets collected
+// by the Collector are Expired, and have no DeletedBy. Tombstones of sets
+// the node had not seen yet are Provisional, since there was nothing to
+// check the deletion against.
+type tombstoneModel struct {
+	SetId       string `gorm:"primaryKey"`
+	KeyHash     string
+	Owner       string
+	DeletedBy   string
+	DeletedAt   time.Time
+	Expired     bool
+	Provisional bool
+}
+
+// purgedSet sums up what purging a set removed, the released blobs are no
+// longer referenced and have to be purged once the transaction is committed
+type purgedSet struct {
+	files    int
+	released []string
+	bytes    int64
+}
+
+// DeleteSet purges a set and writes its tombstone. Sets with an owner can
+// only be deleted by the owner or a principal it granted, for other sets
+// the key has to match the key hash the set was uploaded with. A node may
+// hear about a deletion before the set itself, in which case there is
+// nothing to check against. The tombstone is then only provisional, it
+// keeps out files of the set that carry the owner or key hash of the
+// deletion, and is dropped as soon as the set shows up with different ones,
+// so that a peer can't block sets it doesn't own. Deleting a set twice is
+// not an error, since deletions
+// are gossiped back to the node that published them. The grants of the set
+// are kept along with the tombstone, so that repeated deletions are checked
+// the same way.
+func (r *Files) DeleteSet(deletion model.SetDeletion) error {
+	keyHash := ""
+	if len(deletion.Key) > 0 {
+		keyHash = proof.Encode(proof.Hash(deletion.Key))
+	}
+	owner := deletion.Principal
+	provisional := true
+
+	var released []string
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			var tombstone tombstoneModel
+			result := tx.Where("set_id = ?", deletion.SetId).Limit(1).Find(&tombstone)
+			if result.Error != nil {
+				return errors.Wrap(result.Error, "failed to get tombstone")
+			}
+			if result.RowsAffected == 1 {
+				return r.authorizeDeletion(tx, deletion, tombstone.Owner, tombstone.KeyHash)
+			}
+
+			var set fileSetModel
+			result = tx.Where("set_id = ?", deletion.SetId).Limit(1).Find(&set)
+			if result.Error != nil {
+				return errors.Wrap(result.Error, "failed to get file set")
+			}
+			if result.RowsAffected == 1 {
+				if err := r.authorizeDeletion(tx, deletion, set.Owner, set.KeyHash); err != nil {
+					return err
+				}
+				owner, keyHash = set.Owner, set.KeyHash
+				provisional = false
+			}
+
+			purged, err := r.purgeSet(tx, deletion.SetId)
+			if err != nil {
+				return err
+			}
+			released = purged.released
+
+			if err := tx.Create(
+				&tombstoneModel{
+					SetId:       deletion.SetId,
+					KeyHash:     keyHash,
+					Owner:       owner,
+					DeletedBy:   deletion.DeletedBy,
+					DeletedAt:   time.Now(),
+					Provisional: provisional,
+				},
+			).Error; err != nil {
+				return errors.Wrap(err, "failed to write tombstone")
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	r.logger.Info().
+		Str("set-id", deletion.SetId).
+		Str("deleted-by", deletion.DeletedBy).
+		Int("released-blobs", len(released)).
+		Msg("file set deleted")
+
+	// the blobs are only removed once the transaction went through, so a
+	// rollback never leaves files pointing at missing contents
+	r.purgeBlobs(released)
+	return nil
+}
+
+// purgeSet removes the files, record and anchor of a set, and releases its
+// blobs. The grants are left alone, they are kept with the tombstone.
+func (r *Files) purgeSet(tx *gorm.DB, setId string) (purgedSet, error) {
+	var hashes []string
+	if err := tx.Model(&fileModel{}).
+		Where("set_id = ?", setId).
+		Pluck("file_hash", &hashes).Error; err != nil {
+		return purgedSet{}, errors.Wrap(err, "failed to get file hashes")
+	}
+	// the sizes have to be read before the blobs are released, since
+	// releasing the last reference removes the record
+	var blobs []blobModel
+	if len(hashes) > 0 {
+		if err := tx.Where("hash IN ?", hashes).Find(&blobs).Error; err != nil {
+			return purgedSet{}, errors.Wrap(err, "failed to get blobs")
+		}
+	}
+	sizes := make(map[string]int64, len(blobs))
+	for _, blob := range blobs {
+		sizes[blob.Hash] = blob.Size
+	}
+
+	if err := tx.Unscoped().Where("set_id = ?", setId).Delete(&fileModel{}).Error; err != nil {
+		return purgedSet{}, errors.Wrap(err, "failed to delete files")
+	}
+	purged := purgedSet{files: len(hashes)}
+	for _, hash := range hashes {
+		unreferenced, err := r.releaseBlob(tx, hash)
+		if err != nil {
+			return purgedSet{}, err
+		}
+		if unreferenced {
+			purged.released = append(purged.released, hash)
+			purged.bytes += sizes[hash]
+		}
+	}
+
+	if err := tx.Where("set_id = ?", setId).Delete(&fileSetModel{}).Error; err != nil {
+		return purgedSet{}, errors.Wrap(err, "failed to delete file set")
+	}
+	if err := tx.Where("set_id = ?", setId).Delete(&anchorModel{}).Error; err != nil {
+		return purgedSet{}, errors.Wrap(err, "failed to delete anchor")
+	}
+	return purged, nil
+}
+
+// authorizeDeletion checks the deletion against the owner of the set, or
+// against its key hash for sets without an owner. Like any other change,
+// deleting a set owned by an address takes a signature.
+func (r *Files) authorizeDeletion(tx *gorm.DB, deletion model.SetDeletion, owner, keyHash string) error {
+	if proof.IsAddress(owner) && len(deletion.Signature) == 0 {
+		return errors.Wrap(ErrNotSetOwner, "sets owned by an address have to be deleted with a signature")
+	}
+	if owner != "" {
+		grants, err := r.grants(tx, deletion.SetId)
+		if err != nil {
+			return err
+		}
+		set := model.FileSet{Owner: owner, Grants: grants}
+		if deletion.Principal == "" || !set.Allows(deletion.Principal) {
+			return ErrNotSetOwner
+		}
+		return nil
+	}
+	if keyHash == "" {
+		return ErrSetNotDeletable
+	}
+	if len(deletion.Key) == 0 || proof.Encode(proof.Hash(deletion.Key)) != keyHash {
+		return ErrSetKeyMismatch
+	}
+	return nil
+}
+
+// Deleted reports whether the set has been deleted, provisional tombstones
+// don't count since the deletion was never checked
+func (r *Files) Deleted(setId string) (bool, error) {
+	var count int64
+	if err := r.db.Model(&tombstoneModel{}).
+		Where("set_id = ? AND provisional = ?", setId, false).
+		Count(&count).Error; err != nil {
+		return false, errors.Wrap(err, "failed to get tombstone")
+	}
+	return count > 0, nil
+}
+
+// checkTombstone refuses anything for a set that has been deleted. A
+// provisional tombstone only refuses files and declarations that carry the
+// owner or key hash of its deletion, any other one shows the deletion
+// wasn't made by whoever controls the set, and drops the tombstone. It has
+// to run in the transaction that saves what it checked, so the tombstone is
+// only dropped if the save goes through.
+func (r *Files) checkTombstone(tx *gorm.DB, setId, owner string, keyHash []byte) error {
+	tombstone, err := unmatchedTombstone(tx, setId, owner, keyHash)
+	if err != nil || tombstone == nil {
+		return err
+	}
+	if err := tx.Where("set_id = ?", setId).Delete(&tombstoneModel{}).Error; err != nil {
+		return errors.Wrap(err, "failed to drop provisional tombstone")
+	}
+	r.logger.Warn().
+		Str("set-id", setId).
+		Str("deleted-by", tombstone.DeletedBy).
+		Msg("dropping deletion the set does not match")
+	return nil
+}
+
+// unmatchedTombstone works like checkTombstone, but only returns the
+// provisional tombstone to drop instead of dropping it, so it can check
+// files before the transaction that saves them.
+func unmatchedTombstone(db *gorm.DB, setId, owner string, keyHash []byte) (*tombstoneModel, error) {
+	var tombstone tombstoneModel
+	result := db.Where("set_id = ?", setId).Limit(1).Find(&tombstone)
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "failed to get tombstone")
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	if !tombstone.Provisional {
+		return nil, ErrSetDeleted
+	}
+	if tombstone.Owner != "" && tombstone.Owner == owner {
+		return nil, ErrSetDeleted
+	}
+	if tombstone.KeyHash != "" && len(keyHash) > 0 && tombstone.KeyHash == proof.Encode(keyHash) {
+		return nil, ErrSetDeleted
+	}
+	return &tombstone, nil
+}
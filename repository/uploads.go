@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match the stored offset")
+	ErrUploadIncomplete     = errors.New("upload incomplete")
+)
+
+// Uploads stages resumable uploads until they are complete. The upload
+// metadata is kept in the database, while the contents are appended to a
+// staging file per upload. The size of the staging file is the upload's
+// offset, so whatever was written before a connection dropped is kept and
+// the client can carry on from there.
+type Uploads struct {
+	logger zerolog.Logger
+	db     *gorm.DB
+	dir    string
+
+	// appends to the same upload have to be serialized, so that two
+	// clients can't both write at the same offset
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+type uploadModel struct {
+	Id         string `gorm:"primaryKey"`
+	SetId      string
+	SetCount   int
+	FileNumber int
+	Root       string
+	Tree       string
+	KeyHash    string
+	Principal  string
+	Signature  string
+	Hash       string
+	ExpiresAt  *time.Time
+	Length     int64
+	CreatedAt  time.Time
+}
+
+func NewUploads(logger zerolog.Logger, db *gorm.DB, dir string) (*Uploads, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create upload directory")
+	}
+	return &Uploads{
+		logger: logger,
+		db:     db,
+		dir:    dir,
+		locks:  make(map[string]*sync.Mutex),
+	}, nil
+}
+
+func (r *Uploads) Migrate() error {
+	r.logger.Info().Msg("applying upload table migrations")
+
+	if err := r.db.AutoMigrate(&uploadModel{}); err != nil {
+		return errors.Wrap(err, "migration for uploadModel failed")
+	}
+	return nil
+}
+
+// CreateUpload starts a new upload session, and returns it with its id
+func (r *Uploads) CreateUpload(upload model.Upload) (model.Upload, error) {
+	upload.Id = uuid.NewString()
+	upload.Offset = 0
+	upload.CreatedAt = time.Now()
+
+	f, err := os.Create(r.path(upload.Id))
+	if err != nil {
+		return model.Upload{}, errors.Wrap(err, "failed to create upload")
+	}
+	if err := f.Close(); err != nil {
+		return model.Upload{}, errors.Wrap(err, "failed to create upload")
+	}
+
+	result := r.db.Create(
+		&uploadModel{
+			Id:         upload.Id,
+			SetId:      upload.SetId,
+			SetCount:   upload.SetCount,
+			FileNumber: upload.FileNumber,
+			Root:       encodeOptional(upload.Root),
+			Tree:       string(upload.Tree),
+			KeyHash:    encodeOptional(upload.KeyHash),
+			Principal:  upload.Principal,
+			Signature:  encodeOptional(upload.Signature),
+			Hash:       encodeOptional(upload.Hash),
+			ExpiresAt:  upload.ExpiresAt,
+			Length:     upload.Length,
+			CreatedAt:  upload.CreatedAt,
+		},
+	)
+	if result.Error != nil {
+		os.Remove(r.path(upload.Id))
+		return model.Upload{}, errors.Wrap(result.Error, "failed to create upload")
+	}
+	return upload, nil
+}
+
+// Upload returns an upload session, with its current offset
+func (r *Uploads) Upload(id string) (model.Upload, error) {
+	var upload uploadModel
+	result := r.db.Where("id = ?", id).Limit(1).Find(&upload)
+	if result.Error != nil {
+		return model.Upload{}, errors.Wrap(result.Error, "failed to get upload")
+	}
+	if result.RowsAffected == 0 {
+		return model.Upload{}, ErrUploadNotFound
+	}
+
+	info, err := os.Stat(r.path(id))
+	if err != nil {
+		return model.Upload{}, errors.Wrap(err, "failed to get upload offset")
+	}
+	return upload.toModel(info.Size())
+}
+
+// AppendUpload writes the chunk at the given offset, which has to match
+// the current offset of the upload. Anything beyond the declared length is
+// ignored. It returns the new offset, even if the chunk was only partially
+// written.
+func (r *Uploads) AppendUpload(id string, offset int64, chunk io.Reader) (int64, error) {
+	lock, err := r.lock(id)
+	if err != nil {
+		return 0, err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := r.Upload(id)
+	if err != nil {
+		return 0, err
+	}
+	if upload.Offset != offset {
+		return upload.Offset, ErrUploadOffsetMismatch
+	}
+
+	f, err := os.OpenFile(r.path(id), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return upload.Offset, errors.Wrap(err, "failed to open upload")
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(chunk, upload.Length-upload.Offset))
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return upload.Offset + n, errors.Wrap(copyErr, "failed to write upload")
+	}
+	return upload.Offset + n, nil
+}
+
+// OpenUpload returns the staged contents of a complete upload. The caller
+// must close the reader.
+func (r *Uploads) OpenUpload(id string) (model.Upload, io.ReadCloser, error) {
+	upload, err := r.Upload(id)
+	if err != nil {
+		return model.Upload{}, nil, err
+	}
+	if !upload.Complete() {
+		return model.Upload{}, nil, ErrUploadIncomplete
+	}
+	f, err := os.Open(r.path(id))
+	if err != nil {
+		return model.Upload{}, nil, errors.Wrap(err, "failed to open upload")
+	}
+	return upload, f, nil
+}
+
+// DeleteUpload removes an upload session and its staged contents, removing
+// an upload that doesn't exist does nothing
+func (r *Uploads) DeleteUpload(id string) error {
+	_, err := r.deleteUpload(id)
+	return err
+}
+
+// deleteUpload removes the upload, and returns how many bytes were staged
+func (r *Uploads) deleteUpload(id string) (int64, error) {
+	lock, err := r.lock(id)
+	if errors.Is(err, ErrUploadNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	var staged int64
+	if info, err := os.Stat(r.path(id)); err == nil {
+		staged = info.Size()
+	}
+	if err := r.db.Where("id = ?", id).Delete(&uploadModel{}).Error; err != nil {
+		return 0, errors.Wrap(err, "failed to delete upload")
+	}
+	if err := os.Remove(r.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, errors.Wrap(err, "failed to delete upload")
+	}
+
+	r.mu.Lock()
+	delete(r.locks, id)
+	r.mu.Unlock()
+	return staged, nil
+}
+
+// staleUploads returns up to limit uploads created before the given time,
+// finalized uploads are deleted so these were abandoned
+func (r *Uploads) staleUploads(before time.Time, skip []string, limit int) ([]string, error) {
+	query := r.db.Model(&uploadModel{}).Where("created_at <= ?", before)
+	if len(skip) > 0 {
+		query = query.Where("id NOT IN ?", skip)
+	}
+	var ids []string
+	if err := query.Order("id ASC").Limit(limit).Pluck("id", &ids).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get stale uploads")
+	}
+	return ids, nil
+}
+
+// lock returns the lock of an upload. Only uploads that exist get one, so
+// that requests for made up ids don't leave locks behind.
+func (r *Uploads) lock(id string) (*sync.Mutex, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lock, ok := r.locks[id]; ok {
+		return lock, nil
+	}
+	var count int64
+	if err := r.db.Model(&uploadModel{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get upload")
+	}
+	if count == 0 {
+		return nil, ErrUploadNotFound
+	}
+	lock := new(sync.Mutex)
+	r.locks[id] = lock
+	return lock, nil
+}
+
+// path only ever receives ids that we generated, but parse them anyway so
+// that a crafted id can never escape the upload directory
+func (r *Uploads) path(id string) string {
+	if _, err := uuid.Parse(id); err != nil {
+		return filepath.Join(r.dir, "invalid")
+	}
+	return filepath.Join(r.dir, id)
+}
+
+func (m *uploadModel) toModel(offset int64) (model.Upload, error) {
+	root, err := decodeOptional(m.Root)
+	if err != nil {
+		return model.Upload{}, err
+	}
+	keyHash, err := decodeOptional(m.KeyHash)
+	if err != nil {
+		return model.Upload{}, err
+	}
+	signature, err := decodeOptional(m.Signature)
+	if err != nil {
+		return model.Upload{}, err
+	}
+	hash, err := decodeOptional(m.Hash)
+	if err != nil {
+		return model.Upload{}, err
+	}
+	return model.Upload{
+		Id:         m.Id,
+		SetId:      m.SetId,
+		SetCount:   m.SetCount,
+		FileNumber: m.FileNumber,
+		Root:       root,
+		Tree:       proof.Mode(m.Tree),
+		KeyHash:    keyHash,
+		Principal:  m.Principal,
+		Signature:  signature,
+		Hash:       hash,
+		ExpiresAt:  m.ExpiresAt,
+		Length:     m.Length,
+		Offset:     offset,
+		CreatedAt:  m.CreatedAt,
+	}, nil
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+)
+
+type UploadsTestSuite struct {
+	suite.Suite
+	repo *Uploads
+}
+
+func TestUploadsTestSuite(t *testing.T) {
+	suite.Run(t, new(UploadsTestSuite))
+}
+
+func (s *UploadsTestSuite) SetupTest() {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	s.Require().NoError(err)
+	sqlDb, err := db.DB()
+	s.Require().NoError(err)
+	sqlDb.SetMaxOpenConns(1)
+	s.Require().NoError(db.Migrator().DropTable(&uploadModel{}))
+
+	s.repo, err = NewUploads(zerolog.New(io.Discard), db, s.T().TempDir())
+	s.Require().NoError(err)
+	s.Require().NoError(s.repo.Migrate())
+}
+
+func (s *UploadsTestSuite) newUpload(length int64) model.Upload {
+	upload, err := s.repo.CreateUpload(
+		model.Upload{
+			SetId:      uuid.NewString(),
+			SetCount:   1,
+			FileNumber: 0,
+			Length:     length,
+		},
+	)
+	s.Require().NoError(err)
+	return upload
+}
+
+func (s *UploadsTestSuite) TestAppendUpload() {
+	t := s.T()
+	t.Run(
+		"it should stage chunks until the upload is complete", func(t *testing.T) {
+			upload := s.newUpload(10)
+
+			offset, err := s.repo.AppendUpload(upload.Id, 0, bytes.NewReader([]byte("01234")))
+			require.NoError(t, err)
+			require.Equal(t, int64(5), offset)
+
+			_, _, err = s.repo.OpenUpload(upload.Id)
+			require.ErrorIs(t, err, ErrUploadIncomplete)
+
+			offset, err = s.repo.AppendUpload(upload.Id, 5, bytes.NewReader([]byte("56789")))
+			require.NoError(t, err)
+			require.Equal(t, int64(10), offset)
+
+			_, r, err := s.repo.OpenUpload(upload.Id)
+			require.NoError(t, err)
+			defer r.Close()
+			contents, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, []byte("0123456789"), contents)
+		},
+	)
+
+	t.Run(
+		"it should reject chunks at the wrong offset", func(t *testing.T) {
+			upload := s.newUpload(10)
+
+			_, err := s.repo.AppendUpload(upload.Id, 0, bytes.NewReader([]byte("01234")))
+			require.NoError(t, err)
+
+			offset, err := s.repo.AppendUpload(upload.Id, 0, bytes.NewReader([]byte("01234")))
+			require.ErrorIs(t, err, ErrUploadOffsetMismatch)
+			require.Equal(t, int64(5), offset)
+		},
+	)
+
+	t.Run(
+		"it should keep a partially written chunk", func(t *testing.T) {
+			upload := s.newUpload(10)
+
+			offset, err := s.repo.AppendUpload(upload.Id, 0, io.MultiReader(bytes.NewReader([]byte("012")), errReader{}))
+			require.Error(t, err)
+			require.Equal(t, int64(3), offset)
+
+			stored, err := s.repo.Upload(upload.Id)
+			require.NoError(t, err)
+			require.Equal(t, int64(3), stored.Offset)
+		},
+	)
+
+	t.Run(
+		"it should not write past the declared length", func(t *testing.T) {
+			upload := s.newUpload(4)
+
+			offset, err := s.repo.AppendUpload(upload.Id, 0, bytes.NewReader([]byte("0123456789")))
+			require.NoError(t, err)
+			require.Equal(t, int64(4), offset)
+		},
+	)
+}
+
+func (s *UploadsTestSuite) TestDeleteUpload() {
+	t := s.T()
+	t.Run(
+		"it should remove the upload and its contents", func(t *testing.T) {
+			upload := s.newUpload(10)
+			require.NoError(t, s.repo.DeleteUpload(upload.Id))
+
+			_, err := s.repo.Upload(upload.Id)
+			require.ErrorIs(t, err, ErrUploadNotFound)
+			require.Empty(t, s.repo.locks)
+		},
+	)
+
+	t.Run(
+		"it should not keep locks for uploads that don't exist", func(t *testing.T) {
+			_, err := s.repo.AppendUpload(uuid.NewString(), 0, bytes.NewReader([]byte("01234")))
+			require.ErrorIs(t, err, ErrUploadNotFound)
+			require.NoError(t, s.repo.DeleteUpload(uuid.NewString()))
+			require.Empty(t, s.repo.locks)
+		},
+	)
+}
+
+// errReader simulates a connection dropping mid chunk
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
@@ -0,0 +1,392 @@
+package transparency
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+// batchSize is how many sets are appended to the log per query
+const batchSize = 100
+
+var (
+	ErrNoHead       = errors.New("the log has no signed head yet")
+	ErrInvalidHead  = errors.New("tree head signature is invalid")
+	ErrInvalidRange = errors.New("log sizes are out of range")
+)
+
+// Reasons a pair of heads proves that a node equivocated
+const (
+	ReasonForked       = "two roots for the same size"
+	ReasonShrank       = "log shrank"
+	ReasonInconsistent = "heads are not consistent"
+)
+
+type persistence interface {
+	AppendLog(limit int) ([]model.LogEntry, error)
+	LogEntries() ([]model.LogEntry, error)
+	LogEntry(setId string) (model.LogEntry, error)
+	SaveTreeHead(head model.TreeHead) error
+	TreeHead(node string) (*model.TreeHead, error)
+	TreeHeads() ([]model.TreeHead, error)
+	SaveEquivocation(equivocation model.Equivocation) error
+	Equivocations() ([]model.Equivocation, error)
+}
+
+type headWriter interface {
+	WriteHead(ctx context.Context, announcement model.HeadAnnouncement) error
+}
+
+// consistencyFetcher asks a node for the proof that its log of size from is
+// a prefix of its log of size to
+type consistencyFetcher interface {
+	Fetch(ctx context.Context, node string, from, to uint64) (model.LogConsistency, error)
+}
+
+// Log is a node's transparency log, an append-only Merkle tree over the
+// roots of every set that completed on the node. The node periodically
+// signs the head of its log and gossips it, along with the proof that it
+// extends the head before it. Peers keep the latest head of every node,
+// and record an equivocation when a node signs heads that contradict each
+// other, which is how a node showing different histories to different
+// peers gets caught.
+type Log struct {
+	logger   zerolog.Logger
+	repo     persistence
+	writer   headWriter
+	fetcher  consistencyFetcher
+	identity p2pcrypto.PrivKey
+	node     string
+	interval time.Duration
+}
+
+func NewLog(
+	logger zerolog.Logger,
+	repo persistence,
+	writer headWriter,
+	fetcher consistencyFetcher,
+	identity p2pcrypto.PrivKey,
+	interval time.Duration,
+) (*Log, error) {
+	id, err := peer.IDFromPrivateKey(identity)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive node id")
+	}
+	return &Log{
+		logger:   logger,
+		repo:     repo,
+		writer:   writer,
+		fetcher:  fetcher,
+		identity: identity,
+		node:     id.String(),
+		interval: interval,
+	}, nil
+}
+
+// Run publishes a new head every interval, it returns a func() error in
+// order to be easily used with errgroup.Group
+func (l *Log) Run(ctx context.Context) func() error {
+	return func() error {
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if _, err := l.Publish(ctx); err != nil && ctx.Err() == nil {
+					l.logger.Error().Err(err).Msg("failed to publish tree head")
+				}
+			}
+		}
+	}
+}
+
+// WatchHeads checks the heads gossiped by peers, it returns a func() error
+// in order to be easily used with errgroup.Group
+func (l *Log) WatchHeads(ctx context.Context, announcements <-chan model.HeadAnnouncement) func() error {
+	return func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case announcement, ok := <-announcements:
+				if !ok {
+					return nil
+				}
+				if _, err := l.ReceiveHead(ctx, announcement); err != nil {
+					l.logger.Error().Err(err).Str("node", announcement.Head.Node).Msg("failed to check tree head")
+				}
+			}
+		}
+	}
+}
+
+// Publish appends the sets that completed since the last head, signs the
+// new head and gossips it. A head is signed every time, even if the log
+// didn't grow, so peers can tell the node is still there.
+func (l *Log) Publish(ctx context.Context) (model.TreeHead, error) {
+	for {
+		appended, err := l.repo.AppendLog(batchSize)
+		if err != nil {
+			return model.TreeHead{}, err
+		}
+		if len(appended) < batchSize {
+			break
+		}
+	}
+	leaves, err := l.leaves()
+	if err != nil {
+		return model.TreeHead{}, err
+	}
+	previous, err := l.repo.TreeHead(l.node)
+	if err != nil {
+		return model.TreeHead{}, err
+	}
+
+	announcement := model.HeadAnnouncement{}
+	if previous != nil && previous.Size > 0 && previous.Size < uint64(len(leaves)) {
+		announcement.PreviousSize = previous.Size
+		if announcement.Consistency, err = proof.LogConsistencyProof(leaves, previous.Size); err != nil {
+			return model.TreeHead{}, err
+		}
+	}
+	announcement.Head, err = l.sign(uint64(len(leaves)), proof.LogRoot(leaves))
+	if err != nil {
+		return model.TreeHead{}, err
+	}
+	if err := l.repo.SaveTreeHead(announcement.Head); err != nil {
+		return model.TreeHead{}, err
+	}
+	l.logger.Debug().Uint64("size", announcement.Head.Size).Msg("publishing tree head")
+	if err := l.writer.WriteHead(ctx, announcement); err != nil {
+		return model.TreeHead{}, errors.Wrap(err, "failed to gossip tree head")
+	}
+	return announcement.Head, nil
+}
+
+// ReceiveHead checks a head gossiped by a peer against the latest head we
+// have of the same node, and keeps it if it is newer. It returns the
+// equivocation if the two heads contradict each other, in which case the
+// new head is not kept. If we missed the heads in between, the announcement
+// proves consistency from a head we don't have, so the proof from ours is
+// fetched from the node, and the head is only kept once it verifies.
+func (l *Log) ReceiveHead(ctx context.Context, announcement model.HeadAnnouncement) (*model.Equivocation, error) {
+	head := announcement.Head
+	if err := VerifyHead(head); err != nil {
+		return nil, err
+	}
+	if head.Node == l.node {
+		// our own head relayed back to us
+		return nil, nil
+	}
+	latest, err := l.repo.TreeHead(head.Node)
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, l.repo.SaveTreeHead(head)
+	}
+	if missed(*latest, announcement) {
+		consistency, err := l.fetcher.Fetch(ctx, head.Node, latest.Size, head.Size)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch consistency from %d to %d", latest.Size, head.Size)
+		}
+		announcement.PreviousSize = latest.Size
+		announcement.Consistency = consistency.Proof
+	}
+
+	if reason := contradiction(*latest, announcement); reason != "" {
+		equivocation := model.Equivocation{
+			Node:       head.Node,
+			Reason:     reason,
+			First:      *latest,
+			Second:     head,
+			DetectedAt: time.Now().UTC(),
+		}
+		l.logger.Warn().
+			Str("node", head.Node).
+			Str("reason", reason).
+			Uint64("first-size", latest.Size).
+			Uint64("second-size", head.Size).
+			Msg("node equivocated")
+		if err := l.repo.SaveEquivocation(equivocation); err != nil {
+			return nil, err
+		}
+		return &equivocation, nil
+	}
+	if head.Timestamp.After(latest.Timestamp) {
+		return nil, l.repo.SaveTreeHead(head)
+	}
+	return nil, nil
+}
+
+// missed reports whether the announced head grew the log from a head we
+// don't have, so it doesn't prove consistency from the latest head we do
+// have. Logs grow from empty without a proof.
+func missed(latest model.TreeHead, announcement model.HeadAnnouncement) bool {
+	head := announcement.Head
+	return latest.Size > 0 && head.Size > latest.Size &&
+		!latest.Timestamp.After(head.Timestamp) &&
+		announcement.PreviousSize != latest.Size
+}
+
+// contradiction returns why the announced head can't follow the latest
+// head of the node, or nothing if it can. Heads are ordered by their signed
+// timestamp, a log may only grow over time, and a grown log has to prove
+// that it still contains the old one.
+func contradiction(latest model.TreeHead, announcement model.HeadAnnouncement) string {
+	head := announcement.Head
+	switch {
+	case head.Size == latest.Size:
+		if !bytes.Equal(head.Root, latest.Root) {
+			return ReasonForked
+		}
+	case head.Timestamp.After(latest.Timestamp) && head.Size < latest.Size,
+		latest.Timestamp.After(head.Timestamp) && latest.Size < head.Size:
+		return ReasonShrank
+	case head.Size > latest.Size && latest.Size > 0:
+		if announcement.PreviousSize != latest.Size ||
+			!proof.VerifyLogConsistency(latest.Size, head.Size, latest.Root, head.Root, announcement.Consistency) {
+			return ReasonInconsistent
+		}
+	}
+	return ""
+}
+
+// VerifyHead checks that the head was signed by the node it claims to be
+// from
+func VerifyHead(head model.TreeHead) error {
+	digest := proof.TreeHeadDigest(head.Size, head.Root, head.Timestamp.UnixMilli())
+	if err := proof.VerifyAttestation(digest, head.Node, head.PublicKey, head.Signature); err != nil {
+		return errors.Wrap(ErrInvalidHead, err.Error())
+	}
+	return nil
+}
+
+// Head returns the latest head this node signed
+func (l *Log) Head() (model.TreeHead, error) {
+	head, err := l.repo.TreeHead(l.node)
+	if err != nil {
+		return model.TreeHead{}, err
+	}
+	if head == nil {
+		return model.TreeHead{}, ErrNoHead
+	}
+	return *head, nil
+}
+
+// Inclusion returns the log entry of the set and the proof that it is part
+// of the log at the latest signed head. Sets appended since then are not
+// logged as far as anyone else can tell, so they are reported as such.
+func (l *Log) Inclusion(setId string) (model.LogEntry, model.TreeHead, [][]byte, error) {
+	head, err := l.Head()
+	if err != nil {
+		return model.LogEntry{}, model.TreeHead{}, nil, err
+	}
+	entry, err := l.repo.LogEntry(setId)
+	if err != nil {
+		return model.LogEntry{}, model.TreeHead{}, nil, err
+	}
+	if entry.Index >= head.Size {
+		return model.LogEntry{}, model.TreeHead{}, nil, repository.ErrSetNotLogged
+	}
+	leaves, err := l.leaves()
+	if err != nil {
+		return model.LogEntry{}, model.TreeHead{}, nil, err
+	}
+	hashes, err := proof.LogInclusionProof(leaves[:head.Size], entry.Index)
+	if err != nil {
+		return model.LogEntry{}, model.TreeHead{}, nil, err
+	}
+	return entry, head, hashes, nil
+}
+
+// Consistency returns the proof that the log of size from is a prefix of
+// the log of size to, neither of which may be past the latest signed head.
+// A to of 0 stands for the latest signed head.
+func (l *Log) Consistency(from, to uint64) (model.LogConsistency, error) {
+	head, err := l.Head()
+	if err != nil {
+		return model.LogConsistency{}, err
+	}
+	if to == 0 {
+		to = head.Size
+	}
+	if from == 0 || from > to || to > head.Size {
+		return model.LogConsistency{}, ErrInvalidRange
+	}
+	leaves, err := l.leaves()
+	if err != nil {
+		return model.LogConsistency{}, err
+	}
+	hashes, err := proof.LogConsistencyProof(leaves[:to], from)
+	if err != nil {
+		return model.LogConsistency{}, err
+	}
+	return model.LogConsistency{
+		From:     from,
+		To:       to,
+		FromRoot: proof.LogRoot(leaves[:from]),
+		ToRoot:   proof.LogRoot(leaves[:to]),
+		Proof:    hashes,
+	}, nil
+}
+
+// PeerHeads returns the latest head of every node we know of, including
+// our own, and every equivocation detected so far
+func (l *Log) PeerHeads() ([]model.TreeHead, []model.Equivocation, error) {
+	heads, err := l.repo.TreeHeads()
+	if err != nil {
+		return nil, nil, err
+	}
+	equivocations, err := l.repo.Equivocations()
+	if err != nil {
+		return nil, nil, err
+	}
+	return heads, equivocations, nil
+}
+
+func (l *Log) sign(size uint64, root []byte) (model.TreeHead, error) {
+	// the signed timestamp only has millisecond precision
+	timestamp := time.UnixMilli(time.Now().UnixMilli()).UTC()
+	signature, publicKey, err := proof.SignAttestation(proof.TreeHeadDigest(size, root, timestamp.UnixMilli()), l.identity)
+	if err != nil {
+		return model.TreeHead{}, err
+	}
+	return model.TreeHead{
+		Node:      l.node,
+		Size:      size,
+		Root:      root,
+		Timestamp: timestamp,
+		PublicKey: publicKey,
+		Signature: signature,
+	}, nil
+}
+
+func (l *Log) leaves() ([][]byte, error) {
+	entries, err := l.repo.LogEntries()
+	if err != nil {
+		return nil, err
+	}
+	leaves := make([][]byte, len(entries))
+	for i, entry := range entries {
+		leaves[i] = LeafHash(entry)
+	}
+	return leaves, nil
+}
+
+// LeafHash is the hash of the entry as a leaf of the log, clients use it
+// to check inclusion proofs
+func LeafHash(entry model.LogEntry) []byte {
+	return proof.LogLeafHash(proof.LogEntry(entry.SetId, entry.SetCount, entry.Root))
+}
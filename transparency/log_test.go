@@ -0,0 +1,396 @@
+package transparency
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/scottrmalley/p2p-file-sharing/model"
+	"github.com/scottrmalley/p2p-file-sharing/proof"
+	"github.com/scottrmalley/p2p-file-sharing/repository"
+)
+
+type LogTestSuite struct {
+	suite.Suite
+
+	// directory reaches the log of every node the tests created
+	directory logDirectory
+}
+
+func TestLog(t *testing.T) {
+	suite.Run(t, new(LogTestSuite))
+}
+
+// headRecorder keeps the heads a node gossiped, instead of sending them
+type headRecorder struct {
+	announcements []model.HeadAnnouncement
+}
+
+func (r *headRecorder) WriteHead(_ context.Context, announcement model.HeadAnnouncement) error {
+	r.announcements = append(r.announcements, announcement)
+	return nil
+}
+
+func (r *headRecorder) last() model.HeadAnnouncement {
+	return r.announcements[len(r.announcements)-1]
+}
+
+// logDirectory fetches consistency proofs straight from the logs of the
+// nodes, nodes that aren't in it are unreachable
+type logDirectory map[string]*Log
+
+func (d logDirectory) Fetch(_ context.Context, node string, from, to uint64) (model.LogConsistency, error) {
+	log, ok := d[node]
+	if !ok {
+		return model.LogConsistency{}, errors.New("node is unreachable")
+	}
+	return log.Consistency(from, to)
+}
+
+// newNode returns the repository, log and gossiped heads of a node with the
+// given identity
+func (s *LogTestSuite) newNode(identity p2pcrypto.PrivKey) (*repository.Files, *Log, *headRecorder) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	s.Require().NoError(err)
+	sqlDb, err := db.DB()
+	s.Require().NoError(err)
+	sqlDb.SetMaxOpenConns(1)
+	s.T().Cleanup(func() { _ = sqlDb.Close() })
+
+	blobs, err := repository.NewDiskBlobStore(s.T().TempDir())
+	s.Require().NoError(err)
+	repo := repository.NewFiles(zerolog.New(io.Discard), db, blobs)
+	s.Require().NoError(repo.Migrate())
+
+	if s.directory == nil {
+		s.directory = make(logDirectory)
+	}
+	recorder := &headRecorder{}
+	log, err := NewLog(zerolog.New(io.Discard), repo, recorder, s.directory, identity, time.Minute)
+	s.Require().NoError(err)
+	// a node with the same identity as an earlier one takes its place
+	s.directory[log.node] = log
+	return repo, log, recorder
+}
+
+func (s *LogTestSuite) newIdentity() p2pcrypto.PrivKey {
+	key, _, err := p2pcrypto.GenerateEd25519Key(rand.Reader)
+	s.Require().NoError(err)
+	return key
+}
+
+func (s *LogTestSuite) saveSet(repo *repository.Files, contents ...string) string {
+	setId := uuid.NewString()
+	for i, c := range contents {
+		s.Require().NoError(
+			repo.SaveFile(
+				model.File{
+					Metadata: model.FileMetadata{SetId: setId, SetCount: len(contents), FileNumber: i},
+					Contents: []byte(c),
+				},
+			),
+		)
+	}
+	return setId
+}
+
+// publish waits for the clock to move on, so heads never share a timestamp
+func (s *LogTestSuite) publish(log *Log) model.TreeHead {
+	time.Sleep(2 * time.Millisecond)
+	head, err := log.Publish(context.Background())
+	s.Require().NoError(err)
+	return head
+}
+
+func (s *LogTestSuite) TestPublish() {
+	t := s.T()
+
+	t.Run(
+		"it should sign heads over the complete sets", func(t *testing.T) {
+			repo, log, recorder := s.newNode(s.newIdentity())
+			first := s.saveSet(repo, "file1", "file2")
+			s.saveSet(repo, "file3")
+			// incomplete sets are not logged
+			s.Require().NoError(
+				repo.SaveFile(
+					model.File{
+						Metadata: model.FileMetadata{SetId: uuid.NewString(), SetCount: 2, FileNumber: 0},
+						Contents: []byte("file6"),
+					},
+				),
+			)
+
+			head := s.publish(log)
+			require.Equal(t, uint64(2), head.Size)
+			require.NoError(t, VerifyHead(head))
+			require.Len(t, recorder.announcements, 1)
+			require.Equal(t, head, recorder.last().Head)
+
+			entry, proved, hashes, err := log.Inclusion(first)
+			require.NoError(t, err)
+			require.Equal(t, uint64(0), entry.Index)
+			require.Equal(t, head, proved)
+			require.True(t, proof.VerifyLogInclusion(LeafHash(entry), entry.Index, head.Size, hashes, head.Root))
+		},
+	)
+
+	t.Run(
+		"it should only prove inclusion in signed heads", func(t *testing.T) {
+			repo, log, _ := s.newNode(s.newIdentity())
+			setId := s.saveSet(repo, "file1")
+			_, _, _, err := log.Inclusion(setId)
+			require.ErrorIs(t, err, ErrNoHead)
+
+			s.publish(log)
+			later := s.saveSet(repo, "file2")
+			_, _, _, err = log.Inclusion(later)
+			require.ErrorIs(t, err, repository.ErrSetNotLogged)
+		},
+	)
+
+	t.Run(
+		"it should prove consistency between heads", func(t *testing.T) {
+			repo, log, recorder := s.newNode(s.newIdentity())
+			s.saveSet(repo, "file1")
+			s.saveSet(repo, "file2")
+			s.saveSet(repo, "file3")
+			first := s.publish(log)
+			s.saveSet(repo, "file4")
+			s.saveSet(repo, "file5")
+			second := s.publish(log)
+
+			announcement := recorder.last()
+			require.Equal(t, first.Size, announcement.PreviousSize)
+			require.True(t, proof.VerifyLogConsistency(first.Size, second.Size, first.Root, second.Root, announcement.Consistency))
+
+			consistency, err := log.Consistency(first.Size, 0)
+			require.NoError(t, err)
+			require.Equal(t, second.Size, consistency.To)
+			require.Equal(t, first.Root, consistency.FromRoot)
+			require.Equal(t, second.Root, consistency.ToRoot)
+			require.True(t, proof.VerifyLogConsistency(first.Size, second.Size, first.Root, second.Root, consistency.Proof))
+
+			_, err = log.Consistency(first.Size, second.Size+1)
+			require.ErrorIs(t, err, ErrInvalidRange)
+		},
+	)
+
+	t.Run(
+		"it should keep deleted sets in the log", func(t *testing.T) {
+			repo, log, _ := s.newNode(s.newIdentity())
+			setId := uuid.NewString()
+			s.Require().NoError(
+				repo.SaveFile(
+					model.File{
+						Metadata: model.FileMetadata{SetId: setId, SetCount: 1, Owner: "owner"},
+						Contents: []byte("file1"),
+					},
+				),
+			)
+			head := s.publish(log)
+			require.NoError(t, repo.DeleteSet(model.SetDeletion{SetId: setId, Principal: "owner"}))
+
+			next := s.publish(log)
+			require.Equal(t, head.Size, next.Size)
+			require.Equal(t, head.Root, next.Root)
+			_, _, _, err := log.Inclusion(setId)
+			require.NoError(t, err)
+		},
+	)
+}
+
+func (s *LogTestSuite) TestReceiveHead() {
+	t := s.T()
+
+	t.Run(
+		"it should accept the heads of an honest node", func(t *testing.T) {
+			_, peer, _ := s.newNode(s.newIdentity())
+			repo, log, recorder := s.newNode(s.newIdentity())
+			for i := 0; i < 3; i++ {
+				s.saveSet(repo, uuid.NewString())
+				s.publish(log)
+				equivocation, err := peer.ReceiveHead(context.Background(), recorder.last())
+				require.NoError(t, err)
+				require.Nil(t, equivocation)
+			}
+
+			heads, equivocations, err := peer.PeerHeads()
+			require.NoError(t, err)
+			require.Empty(t, equivocations)
+			require.Len(t, heads, 1)
+			require.Equal(t, recorder.last().Head, heads[0])
+		},
+	)
+
+	t.Run(
+		"it should detect two roots for the same size", func(t *testing.T) {
+			_, peer, _ := s.newNode(s.newIdentity())
+			// the same identity shows two different logs
+			identity := s.newIdentity()
+			repo, log, recorder := s.newNode(identity)
+			forkRepo, fork, forkRecorder := s.newNode(identity)
+			s.saveSet(repo, "file1")
+			s.saveSet(forkRepo, "file2")
+
+			s.publish(log)
+			s.publish(fork)
+			_, err := peer.ReceiveHead(context.Background(), recorder.last())
+			require.NoError(t, err)
+			equivocation, err := peer.ReceiveHead(context.Background(), forkRecorder.last())
+			require.NoError(t, err)
+			require.NotNil(t, equivocation)
+			require.Equal(t, ReasonForked, equivocation.Reason)
+
+			_, equivocations, err := peer.PeerHeads()
+			require.NoError(t, err)
+			require.Len(t, equivocations, 1)
+			require.NoError(t, VerifyHead(equivocations[0].First))
+			require.NoError(t, VerifyHead(equivocations[0].Second))
+		},
+	)
+
+	t.Run(
+		"it should detect a rewritten log", func(t *testing.T) {
+			_, peer, _ := s.newNode(s.newIdentity())
+			identity := s.newIdentity()
+			repo, log, recorder := s.newNode(identity)
+			forkRepo, fork, forkRecorder := s.newNode(identity)
+			s.saveSet(repo, "file1")
+			s.saveSet(forkRepo, "file2")
+			s.publish(log)
+			s.publish(fork)
+			s.saveSet(forkRepo, "file3")
+			s.publish(fork)
+
+			_, err := peer.ReceiveHead(context.Background(), recorder.last())
+			require.NoError(t, err)
+			// the fork proves consistency from its own head of the same size
+			equivocation, err := peer.ReceiveHead(context.Background(), forkRecorder.last())
+			require.NoError(t, err)
+			require.NotNil(t, equivocation)
+			require.Equal(t, ReasonInconsistent, equivocation.Reason)
+		},
+	)
+
+	t.Run(
+		"it should detect a log that shrank", func(t *testing.T) {
+			_, peer, _ := s.newNode(s.newIdentity())
+			identity := s.newIdentity()
+			repo, log, recorder := s.newNode(identity)
+			_, fork, forkRecorder := s.newNode(identity)
+			s.saveSet(repo, "file1")
+			s.saveSet(repo, "file2")
+			s.publish(log)
+			s.publish(fork)
+
+			_, err := peer.ReceiveHead(context.Background(), recorder.last())
+			require.NoError(t, err)
+			equivocation, err := peer.ReceiveHead(context.Background(), forkRecorder.last())
+			require.NoError(t, err)
+			require.NotNil(t, equivocation)
+			require.Equal(t, ReasonShrank, equivocation.Reason)
+		},
+	)
+
+	t.Run(
+		"it should fetch the proof for heads it missed", func(t *testing.T) {
+			_, peer, _ := s.newNode(s.newIdentity())
+			repo, log, recorder := s.newNode(s.newIdentity())
+			s.saveSet(repo, "file1")
+			s.publish(log)
+			_, err := peer.ReceiveHead(context.Background(), recorder.last())
+			require.NoError(t, err)
+
+			// the peer misses the heads of size 2 and 3, so the head of
+			// size 4 proves consistency from a head it doesn't have
+			for i := 0; i < 3; i++ {
+				s.saveSet(repo, uuid.NewString())
+				s.publish(log)
+			}
+			require.Equal(t, uint64(3), recorder.last().PreviousSize)
+			equivocation, err := peer.ReceiveHead(context.Background(), recorder.last())
+			require.NoError(t, err)
+			require.Nil(t, equivocation)
+
+			heads, _, err := peer.PeerHeads()
+			require.NoError(t, err)
+			require.Contains(t, heads, recorder.last().Head)
+		},
+	)
+
+	t.Run(
+		"it should not keep heads it missed the proof for", func(t *testing.T) {
+			_, peer, _ := s.newNode(s.newIdentity())
+			repo, log, recorder := s.newNode(s.newIdentity())
+			s.saveSet(repo, "file1")
+			first := s.publish(log)
+			_, err := peer.ReceiveHead(context.Background(), recorder.last())
+			require.NoError(t, err)
+
+			s.saveSet(repo, "file2")
+			s.publish(log)
+			s.saveSet(repo, "file3")
+			s.publish(log)
+			delete(s.directory, log.node)
+			_, err = peer.ReceiveHead(context.Background(), recorder.last())
+			require.Error(t, err)
+
+			heads, equivocations, err := peer.PeerHeads()
+			require.NoError(t, err)
+			require.Empty(t, equivocations)
+			require.Contains(t, heads, first)
+			require.NotContains(t, heads, recorder.last().Head)
+		},
+	)
+
+	t.Run(
+		"it should detect a rewritten log across heads it missed", func(t *testing.T) {
+			_, peer, _ := s.newNode(s.newIdentity())
+			identity := s.newIdentity()
+			repo, log, recorder := s.newNode(identity)
+			forkRepo, fork, forkRecorder := s.newNode(identity)
+			s.saveSet(repo, "file1")
+			s.publish(log)
+			_, err := peer.ReceiveHead(context.Background(), recorder.last())
+			require.NoError(t, err)
+
+			// the peer only sees the last head of the fork, which proves
+			// consistency from a fork head it never saw
+			s.saveSet(forkRepo, "file2")
+			s.publish(fork)
+			s.saveSet(forkRepo, "file3")
+			s.publish(fork)
+			s.saveSet(forkRepo, "file4")
+			s.publish(fork)
+			equivocation, err := peer.ReceiveHead(context.Background(), forkRecorder.last())
+			require.NoError(t, err)
+			require.NotNil(t, equivocation)
+			require.Equal(t, ReasonInconsistent, equivocation.Reason)
+		},
+	)
+
+	t.Run(
+		"it should reject heads with an invalid signature", func(t *testing.T) {
+			_, peer, _ := s.newNode(s.newIdentity())
+			repo, log, recorder := s.newNode(s.newIdentity())
+			s.saveSet(repo, "file1")
+			s.publish(log)
+
+			announcement := recorder.last()
+			announcement.Head.Root = proof.Hash([]byte("forged"))
+			_, err := peer.ReceiveHead(context.Background(), announcement)
+			require.ErrorIs(t, err, ErrInvalidHead)
+		},
+	)
+}